@@ -0,0 +1,108 @@
+package golf
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// How long data can wait in a batchWriter's buffer before it's written
+const batchWriterInterval = 10 * time.Millisecond
+
+// A batchWriter buffers small writes to w so they can be written together with
+// fewer syscalls. The buffer is written when it fills up, once data has been
+// waiting in it for batchWriterInterval, or when it's flushed. Unlike a
+// bufio.Writer it can still be used after a write fails, the data that failed
+// to write is dropped.
+type batchWriter struct {
+	w        io.Writer
+	size     int
+	interval time.Duration
+	onError  func(error)
+
+	buffMutex sync.Mutex
+	buff      []byte
+	timer     *time.Timer
+	// Set by discard, after which nothing is written
+	discarded bool
+}
+
+func newBatchWriter(w io.Writer, size int, onError func(error)) *batchWriter {
+	return &batchWriter{
+		w:        w,
+		size:     size,
+		interval: batchWriterInterval,
+		onError:  onError,
+		buff:     make([]byte, 0, size),
+	}
+}
+
+func (bw *batchWriter) Write(p []byte) (int, error) {
+	bw.buffMutex.Lock()
+	defer bw.buffMutex.Unlock()
+
+	if bw.discarded {
+		return 0, errBatchWriterDiscarded
+	}
+	if len(bw.buff)+len(p) > bw.size {
+		err := bw.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Anything too big for the buffer is written right away
+	if len(p) >= bw.size {
+		return bw.w.Write(p)
+	}
+
+	bw.buff = append(bw.buff, p...)
+	if bw.timer == nil {
+		bw.timer = time.AfterFunc(bw.interval, bw.timedFlush)
+	}
+	return len(p), nil
+}
+
+// Write everything that's buffered to the underlying io.Writer
+func (bw *batchWriter) Flush() error {
+	bw.buffMutex.Lock()
+	defer bw.buffMutex.Unlock()
+
+	return bw.flush()
+}
+
+func (bw *batchWriter) flush() error {
+	if bw.timer != nil {
+		bw.timer.Stop()
+		bw.timer = nil
+	}
+	if len(bw.buff) == 0 {
+		return nil
+	}
+
+	_, err := bw.w.Write(bw.buff)
+	bw.buff = bw.buff[:0]
+	return err
+}
+
+func (bw *batchWriter) timedFlush() {
+	err := bw.Flush()
+	if err != nil && bw.onError != nil {
+		bw.onError(err)
+	}
+}
+
+// Drop everything that's buffered without writing it and stop a timed flush
+// that's waiting, refusing any writes after it, for when the underlying
+// io.Writer has been closed
+func (bw *batchWriter) discard() {
+	bw.buffMutex.Lock()
+	defer bw.buffMutex.Unlock()
+
+	if bw.timer != nil {
+		bw.timer.Stop()
+		bw.timer = nil
+	}
+	bw.buff = bw.buff[:0]
+	bw.discarded = true
+}
@@ -0,0 +1,124 @@
+package golf
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestBatchWriter(t sweet.T) {
+	lw := &lockedWriter{}
+	bw := newBatchWriter(lw, 16, nil)
+	// Don't let the timer write anything while testing
+	bw.interval = time.Hour
+
+	n, err := bw.Write([]byte("12345"))
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(5))
+	bw.Write([]byte("67890"))
+	Expect(lw.written).To(BeEmpty())
+
+	// Filling the buffer writes what's already buffered first
+	bw.Write([]byte("abcdefgh"))
+	Expect(lw.written).To(Equal([][]byte{[]byte("1234567890")}))
+
+	Expect(bw.Flush()).To(BeNil())
+	Expect(lw.written).To(HaveLen(2))
+	Expect(lw.written[1]).To(Equal([]byte("abcdefgh")))
+
+	// Nothing to write
+	Expect(bw.Flush()).To(BeNil())
+	Expect(lw.written).To(HaveLen(2))
+
+	// Too big for the buffer
+	bw.Write([]byte("0123456789abcdefghij"))
+	Expect(lw.written).To(HaveLen(3))
+}
+
+func (s *GolfSuite) TestBatchWriterTimedFlush(t sweet.T) {
+	lw := &lockedWriter{}
+	bw := newBatchWriter(lw, 1024, nil)
+	bw.Write([]byte("waiting"))
+
+	Eventually(func() int {
+		lw.writtenMutex.Lock()
+		defer lw.writtenMutex.Unlock()
+		return len(lw.written)
+	}).Should(Equal(1))
+}
+
+func (s *GolfSuite) TestBatchWriterDiscard(t sweet.T) {
+	lw := &lockedWriter{}
+	bw := newBatchWriter(lw, 1024, nil)
+	bw.Write([]byte("waiting"))
+	bw.discard()
+
+	// The timed flush doesn't write anything once it's discarded
+	Consistently(func() int {
+		lw.writtenMutex.Lock()
+		defer lw.writtenMutex.Unlock()
+		return len(lw.written)
+	}, "50ms").Should(Equal(0))
+
+	_, err := bw.Write([]byte("after"))
+	Expect(err).To(Equal(errBatchWriterDiscarded))
+	Expect(bw.Flush()).To(BeNil())
+	Expect(lw.written).To(BeEmpty())
+}
+
+func (s *GolfSuite) TestBatchWriterError(t sweet.T) {
+	writeErr := errors.New("write failed")
+	fw := newFailWriter(0, writeErr)
+	errs := make(chan error, 1)
+	bw := newBatchWriter(fw, 1024, func(err error) {
+		errs <- err
+	})
+
+	bw.Write([]byte("timed"))
+	Eventually(errs).Should(Receive(Equal(writeErr)))
+
+	// It can still be used after the error
+	bw.interval = time.Hour
+	bw.Write([]byte("flushed"))
+	Expect(bw.Flush()).To(Equal(writeErr))
+	Expect(bw.buff).To(BeEmpty())
+}
+
+func (s *GolfSuite) TestUseTcpBuffered(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		TCPBufferSize: 4096,
+	})
+
+	client, server := net.Pipe()
+	err := c.Use(client, "tcp")
+	Expect(err).To(BeNil())
+	Expect(c.batchw).ToNot(BeNil())
+
+	received := make(chan []byte, 10)
+	go func() {
+		r := bufio.NewReader(server)
+		for {
+			data, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			received <- data
+		}
+	}()
+
+	for idx := 0; idx < 10; idx++ {
+		c.Infof("message %d", idx)
+	}
+	Expect(c.Flush()).To(BeNil())
+	for idx := 0; idx < 10; idx++ {
+		Eventually(received, time.Second).Should(Receive())
+	}
+
+	Expect(c.Close()).To(BeNil())
+	Expect(c.batchw).To(BeNil())
+}
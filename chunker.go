@@ -1,19 +1,58 @@
 package golf
 
 import (
+	crand "crypto/rand"
 	"errors"
 	"io"
 	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// A chunker buffers a message and writes it to w as GELF chunks when flushed.
+// It's safe to use from multiple goroutines, but data written by different
+// goroutines between flushes ends up in the same message so each message must
+// still be written and flushed by one goroutine at a time.
 type chunker struct {
 	chunkSize int
-	buff      []byte
 	w         io.Writer
+	// Generates the id for each message, a random id is used if it's nil
+	idFunc func() [8]byte
+	// Where random ids are read from if idFunc is nil, or nil to use
+	// secureRand
+	rand io.Reader
+	// crypto/rand, unless it's replaced by a test. If reading from it
+	// fails the id is read from fallbackRand instead, and the first time
+	// it happens ErrRandFallback is reported with reportErr.
+	secureRand     io.Reader
+	reportErr      func(error)
+	warnedFallback bool
+	// Called with the number of chunks each message is split into, if
+	// it's set
+	onChunks func(count int)
+	// Called with the number of chunks a message needs before it's written
+	// when it needs more than one, if it's set. If it returns an error the
+	// message is discarded without writing anything and Flush returns it.
+	checkChunks func(count int) error
+
+	buffMutex sync.Mutex
+	buff      []byte
+	// Scratch space for each chunk's header and data as it's written
+	chunkBuff []byte
 }
 
+// The most chunks a message can be split into, servers discard messages with
+// more
+const MAX_CHUNKS = 128
+
+// The buffer for a message is reused for the next message, unless it had to
+// grow larger than this for a very large message
+const maxRetainedChunkerBuff = 64 * 1024
+
 func newChunker(w io.Writer, chunkSize int) (*chunker, error) {
 	if chunkSize < 13 {
 		return nil, ErrChunkTooSmall
@@ -22,68 +61,174 @@ func newChunker(w io.Writer, chunkSize int) (*chunker, error) {
 	c := &chunker{
 		chunkSize: chunkSize,
 		buff:      make([]byte, 0),
+		chunkBuff: make([]byte, chunkSize),
 		w:         w,
+
+		secureRand: crand.Reader,
 	}
 
 	return c, nil
 }
 
+// Random ids for chunked messages when crypto/rand fails, which can happen on
+// some locked down platforms. Ids only need to be different from the other
+// messages the server is putting back together at the same time so they
+// don't need to be cryptographically random.
+var fallbackRand = &lockedReader{
+	r: rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())<<32)),
+}
+
+// Change the chunk size used for the next message flushed
+func (c *chunker) setChunkSize(chunkSize int) {
+	c.buffMutex.Lock()
+	defer c.buffMutex.Unlock()
+
+	c.chunkSize = chunkSize
+	c.chunkBuff = make([]byte, chunkSize)
+}
+
 func (c *chunker) reset() {
-	c.buff = make([]byte, 0)
+	c.buffMutex.Lock()
+	defer c.buffMutex.Unlock()
+
+	c.resetBuff()
+}
+func (c *chunker) resetBuff() {
+	if cap(c.buff) > maxRetainedChunkerBuff {
+		c.buff = make([]byte, 0)
+		return
+	}
+	c.buff = c.buff[:0]
 }
 func (c *chunker) Write(p []byte) (int, error) {
+	c.buffMutex.Lock()
+	defer c.buffMutex.Unlock()
+
 	c.buff = append(c.buff, p...)
 	return len(p), nil
 }
 
+// Write all of the buffered data to the underlying io.Writer as chunks.
+// Nothing is written if there's no data buffered. The buffer is always reset,
+// even if writing a chunk fails.
 func (c *chunker) Flush() error {
-	idFull, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	c.buffMutex.Lock()
+	defer c.buffMutex.Unlock()
+
+	if len(c.buff) == 0 {
+		return nil
+	}
+	if c.checkChunks != nil {
+		if count := c.chunkCount(len(c.buff)); count > 1 {
+			err := c.checkChunks(count)
+			if err != nil {
+				c.resetBuff()
+				return err
+			}
+		}
 	}
 
-	idBytes, err := idFull.MarshalBinary()
+	if c.idFunc != nil {
+		id := c.idFunc()
+		return c.flushWithId(id[:])
+	}
+
+	if c.rand != nil {
+		var id [8]byte
+		_, err := io.ReadFull(c.rand, id[:])
+		if err != nil {
+			c.resetBuff()
+			return err
+		}
+		return c.flushWithId(id[:])
+	}
+
+	idFull, err := uuid.NewRandomFromReader(c.secureRand)
 	if err != nil {
-		return err
+		if !c.warnedFallback && c.reportErr != nil {
+			c.reportErr(ErrRandFallback)
+		}
+		c.warnedFallback = true
+
+		var id [8]byte
+		io.ReadFull(fallbackRand, id[:])
+		return c.flushWithId(id[:])
 	}
-	err = c.flushWithId(idBytes[0:8])
-	return err
+
+	return c.flushWithId(idFull[0:8])
+}
+
+// A lockedReader is an io.Reader that can be read from by multiple goroutines,
+// for a source like a *math/rand.Rand that isn't safe for concurrent use
+type lockedReader struct {
+	readMutex sync.Mutex
+	r         io.Reader
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	lr.readMutex.Lock()
+	defer lr.readMutex.Unlock()
+
+	return lr.r.Read(p)
 }
 
 func (c *chunker) flushWithId(id []byte) error {
 	if len(id) < 8 || len(id) > 8 {
 		return errors.New("id length must be equal to 8")
 	}
+	defer c.resetBuff()
 
 	offset := 0
 	buffLen := len(c.buff)
 	chunkSize := c.chunkSize - 12
 
-	// Reusing this buffer may cause problems with duplicate data being sent
-	// if the data isn't written to something else by the io.Writer before
-	// the chunk's data is updated.
-	chunkBuff := make([]byte, c.chunkSize)
-	copy(chunkBuff[0:2], []byte{0x1e, 0x0f})
+	// The same buffer is used for every chunk, io.Writers aren't allowed
+	// to keep the data they're given so it can be overwritten once each
+	// chunk is written
+	chunkBuff := c.chunkBuff
+	chunkBuff[0] = 0x1e
+	chunkBuff[1] = 0x0f
 	copy(chunkBuff[2:10], id)
 
-	totalChunks := int(math.Ceil(float64(buffLen) / float64(chunkSize)))
+	totalChunks := c.chunkCount(buffLen)
+	if c.onChunks != nil {
+		c.onChunks(totalChunks)
+	}
+	chunkBuff[10] = 0
 	chunkBuff[11] = byte(totalChunks)
 
 	for {
 		left := buffLen - offset
 		if left > chunkSize {
 			copy(chunkBuff[12:], c.buff[offset:offset+chunkSize])
-			c.w.Write(chunkBuff)
+			_, err := c.w.Write(chunkBuff)
+			if err != nil {
+				return chunkErr(int(chunkBuff[10]), totalChunks, err)
+			}
 		} else {
 			copy(chunkBuff[12:], c.buff[offset:offset+left])
-			c.w.Write(chunkBuff[0 : left+12])
-			break
+			_, err := c.w.Write(chunkBuff[0 : left+12])
+			if err != nil {
+				return chunkErr(int(chunkBuff[10]), totalChunks, err)
+			}
+			return nil
 		}
 
 		offset += chunkSize
 		chunkBuff[10] += 1
 	}
+}
 
-	c.reset()
-	return nil
+// Get the number of chunks a message of size bytes is split into
+func (c *chunker) chunkCount(size int) int {
+	return int(math.Ceil(float64(size) / float64(c.chunkSize-12)))
+}
+
+// Get the error for failing to write a chunk after 'sent' of the message's
+// chunks were written, which is a PartialSendError if any of them were
+func chunkErr(sent int, total int, err error) error {
+	if sent == 0 {
+		return err
+	}
+	return &PartialSendError{Sent: sent, Total: total, Err: err}
 }
@@ -1,7 +1,10 @@
 package golf
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"testing"
 
 	"github.com/aphistic/sweet"
 	. "github.com/onsi/gomega"
@@ -73,3 +76,135 @@ func (s *ChunkerSuite) TestChunkerFlushIdLength(t sweet.T) {
 	err = chnk.flushWithId([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
 	Expect(err).To(Equal(fmt.Errorf("id length must be equal to 8")))
 }
+
+func (s *ChunkerSuite) TestChunkerFlushEmpty(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 13)
+
+	err := chnk.Flush()
+	Expect(err).To(BeNil())
+	err = chnk.Flush()
+	Expect(err).To(BeNil())
+
+	Expect(w.Written).To(HaveLen(0))
+}
+
+func (s *ChunkerSuite) TestChunkerFlushWriteError(t sweet.T) {
+	writeErr := errors.New("write failed")
+	w := newFailWriter(2, writeErr)
+	chnk, _ := newChunker(w, 13)
+
+	chnk.Write([]byte{1, 2, 3, 4, 5})
+	err := chnk.Flush()
+	Expect(err).To(Equal(&PartialSendError{Sent: 2, Total: 5, Err: writeErr}))
+	Expect(w.Written).To(HaveLen(2))
+
+	// The failed message shouldn't be left in the buffer
+	Expect(chnk.buff).To(HaveLen(0))
+}
+
+func (s *ChunkerSuite) TestChunkerFlushFirstWriteError(t sweet.T) {
+	writeErr := errors.New("write failed")
+	w := newFailWriter(0, writeErr)
+	chnk, _ := newChunker(w, 13)
+
+	// Nothing was sent so it isn't a partial send
+	chnk.Write([]byte{1, 2, 3, 4, 5})
+	err := chnk.Flush()
+	Expect(err).To(Equal(writeErr))
+}
+
+func BenchmarkChunkerFlush(b *testing.B) {
+	chnk, _ := newChunker(discardWriter{}, 1420)
+	data := bytes.Repeat([]byte("0123456789"), 500)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		chnk.Write(data)
+		chnk.Flush()
+	}
+}
+
+func (s *ChunkerSuite) TestChunkerFlushIdFunc(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 13)
+	next := byte(0)
+	chnk.idFunc = func() [8]byte {
+		next++
+		return [8]byte{next, 2, 3, 4, 5, 6, 7, 8}
+	}
+
+	chnk.Write([]byte{1, 2})
+	Expect(chnk.Flush()).To(BeNil())
+	chnk.Write([]byte{3})
+	Expect(chnk.Flush()).To(BeNil())
+
+	Expect(w.Written).To(Equal([][]byte{
+		{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 0, 2, 1},
+		{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 2},
+		{0x1e, 0x0f, 2, 2, 3, 4, 5, 6, 7, 8, 0, 1, 3},
+	}))
+}
+
+func (s *ChunkerSuite) TestChunkerFlushRand(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 13)
+	chnk.rand = bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+
+	chnk.Write([]byte{1})
+	Expect(chnk.Flush()).To(BeNil())
+	Expect(w.Written).To(Equal([][]byte{
+		{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 0, 1, 1},
+	}))
+
+	// Running out of randomness fails the message instead of reusing an id
+	w.reset()
+	chnk.Write([]byte{2})
+	Expect(chnk.Flush()).ToNot(BeNil())
+	Expect(w.Written).To(BeEmpty())
+	Expect(chnk.buff).To(BeEmpty())
+}
+
+func (s *ChunkerSuite) TestChunkerFlushRandFallback(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 13)
+	chnk.secureRand = failReader{}
+	reported := make([]error, 0)
+	chnk.reportErr = func(err error) {
+		reported = append(reported, err)
+	}
+
+	chnk.Write([]byte{1})
+	Expect(chnk.Flush()).To(BeNil())
+	chnk.Write([]byte{2})
+	Expect(chnk.Flush()).To(BeNil())
+
+	Expect(w.Written).To(HaveLen(2))
+	Expect(w.Written[0][12]).To(Equal(byte(1)))
+	Expect(w.Written[0][2:10]).ToNot(Equal(w.Written[1][2:10]))
+	Expect(reported).To(Equal([]error{ErrRandFallback}))
+}
+
+// An io.Reader that always fails
+type failReader struct{}
+
+func (fr failReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+func (s *ChunkerSuite) TestChunkerSetChunkSize(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 13)
+
+	chnk.Write([]byte{1, 2})
+	chnk.Flush()
+	Expect(w.Written).To(HaveLen(2))
+
+	w.reset()
+	chnk.setChunkSize(14)
+	chnk.Write([]byte{1, 2})
+	chnk.Flush()
+	Expect(w.Written).To(HaveLen(1))
+	Expect(w.Written[0][12:]).To(Equal([]byte{1, 2}))
+}
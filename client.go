@@ -1,15 +1,23 @@
 package golf
 
 import (
+	"bytes"
 	"compress/gzip"
-	"compress/zlib"
-	"errors"
+	"context"
+	"encoding/binary"
 	"io"
+	"math/rand"
 	"net"
 	"net/url"
 	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 )
 
@@ -18,41 +26,758 @@ const (
 	COMP_NONE = iota // No compression
 	COMP_GZIP        // gzip compression
 	COMP_ZLIB        // zlib compression
+	COMP_AUTO        // gzip compression, unless it would make the message larger
+)
+
+// A size bucket of ClientConfig.CompressionBuckets
+type CompressionBucket struct {
+	MinBytes    int // Length of the shortest serialized message in the bucket
+	Compression int // Compression to send the bucket's messages with
+}
+
+type compressionBucketsBySize []CompressionBucket
+
+func (b compressionBucketsBySize) Len() int           { return len(b) }
+func (b compressionBucketsBySize) Less(i, j int) bool { return b[i].MinBytes < b[j].MinBytes }
+func (b compressionBucketsBySize) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Framing used to delimit GELF messages sent over tcp. Length prefixed
+// frames let messages be compressed like they are over udp, but they aren't
+// part of the GELF spec so they need a receiver that expects them, and one
+// that expects the checksum for TCP_FRAME_CHECKSUM. The checksum is the IEEE
+// CRC-32 of the message as it was sent, after compressing it.
+const (
+	TCP_FRAME_NULL     = iota // Null byte delimited, as the GELF spec defines
+	TCP_FRAME_LENGTH          // Prefixed with a 4 byte big-endian length
+	TCP_FRAME_CHECKSUM        // Length prefixed and followed by a 4 byte big-endian CRC-32
+)
+
+// What to do with a message that has an additional field which can't be
+// encoded as JSON, such as a channel or a func
+const (
+	FIELD_ERR_DROP_FIELD   = iota // Send it with a placeholder in place of the field
+	FIELD_ERR_DROP_MESSAGE        // Don't send it
+	FIELD_ERR_FAIL_FAST           // Return the error when it's queued
+)
+
+// Which value is sent when more than one source of additional fields has a
+// field with the same name, see ClientConfig.FieldCollisions. The sources are
+// added in order: the client's default fields (ResourceAttributes, then
+// AddProcessFields), the fields from SetErrorFields, the message's Logger's
+// attrs, the caller and goroutine, the message's Attrs, which include the
+// fields from QueueMsgContext's context but always win over them, and then
+// its fields added with AddFieldForLevels. The tenant and sequence number
+// fields always override the others, and either way each field is only sent
+// once.
+const (
+	FIELD_COLLISION_LAST_WINS  = iota // The source added last wins
+	FIELD_COLLISION_FIRST_WINS        // The source added first wins
+)
+
+// Types an additional field can be sent as, see ClientConfig.FieldTypes.
+// Values are converted whatever their Go type, numbers are formatted as
+// strings and strings are parsed as numbers. A value that can't be
+// converted, such as "abc" for FIELD_TYPE_INT or 1.5 which would lose its
+// fraction, leaves the field out of the message and reports the error to
+// Errors(). Fields with a nil value are left as they are.
+const (
+	FIELD_TYPE_STRING = iota // A JSON string
+	FIELD_TYPE_INT           // A JSON number without a fraction
+	FIELD_TYPE_FLOAT         // A JSON number
+)
+
+// The path MTU assumed for UDP connections when one isn't configured
+const DEFAULT_MTU = 1500
+
+// The largest datagram sent over UDP when MaxDatagramBytes isn't configured,
+// which is the largest chunk Graylog accepts
+const DEFAULT_MAX_DATAGRAM_BYTES = 8192
+
+// Size of the IP and UDP headers added to each chunk sent over UDP
+const (
+	udp4HeaderSize = 20 + 8
+	udp6HeaderSize = 40 + 8
 )
 
 type Client struct {
 	hostname string
 
-	conn net.Conn
+	conn          net.Conn
+	scheme        string
+	batchw        *batchWriter
+	sink          Sink
+	connMutex     sync.Mutex
+	connectedChan chan int
+	// Set to 1 once the client has given up reconnecting to the server
+	failed int32
+	// Set while the client is being drained or closed. Queueing a message
+	// holds a read lock of acceptMutex from checking it until the message
+	// is queued, so once it's set with the write lock held every message
+	// that was accepted has been queued.
+	closing     bool
+	acceptMutex sync.RWMutex
+
+	sinks      []Sink
+	senderWg   sync.WaitGroup
+	senderQuit chan int
+	// Closed by Rebind to stop the senders without waiting for the queue
+	// to be empty
+	senderStop chan int
+	// Held by Rebind and Close so they don't run at the same time
+	rebindMutex sync.Mutex
 
-	chnk *chunker
+	// Fields added from the context by QueueMsgContext
+	contextMutex  sync.RWMutex
+	contextFields []contextField
 
-	queue      []*Message
-	queueMutex sync.Mutex
+	queue       []*Message
+	queueMutex  sync.Mutex
+	queueSignal chan int
+	// Number of messages queued that haven't finished sending yet, and a
+	// condition signalled when messages finish
+	pending  int
+	sentCond *sync.Cond
+	// Set by Pause to stop the senders taking messages from the queue
+	paused bool
+	// Set while the queue is at or above QueueHighWaterMark, and when the
+	// client last warned about it
+	aboveHighWater bool
+	highWaterWarn  time.Time
+	// Set while the client is closing, so messages waiting for room in the
+	// queue for BackpressureBlock or QUEUE_BLOCK give up with ErrClosing
+	// instead of stopping it closing when the queue isn't being sent,
+	// such as while it's paused or before it's connected
+	stopWaiting bool
 
-	msgChan  chan *Message
-	queueCtl chan int
-	sendCtl  chan int
+	overflow *overflowQueue
+	// The write-ahead log of queued messages, if WALPath is set
+	wal *writeAheadLog
 
-	gz *sync.Pool
-	zz *sync.Pool
+	errChan chan error
+	stats   *clientStats
+	// Channels added with Subscribe, which errors are also reported to
+	subscribersMutex sync.RWMutex
+	subscribers      []chan error
+	// Attrs added to every message the client sends
+	defaultAttrs map[string]interface{}
+	// Attrs added to messages at errorLevel or more severe, set with
+	// SetErrorFields
+	errorFieldsMutex sync.RWMutex
+	errorFields      map[string]interface{}
+	errorLevel       int
+	// The last messages sent, if RecentMessagesSize is set
+	recent *recentRing
+	// Holds a value for each confirmed message being sent, if MaxInFlight
+	// is set
+	inFlight chan struct{}
+	// Limits the bytes sent each second, if BytesPerSecond is set
+	limiter *byteLimiter
+	// Suppresses repeated messages, if RepeatWindow is set
+	repeats *repeatFilter
+	// RandSource, shared by the senders, if it's set
+	randSource io.Reader
+	// Held while writing to the FallbackWriter
+	fallbackMutex sync.Mutex
+	// Held while writing to the CloseExportWriter
+	exportMutex sync.Mutex
+	// Closed to stop the heartbeat, and closed by the heartbeat once it's
+	// stopped
+	heartbeatQuit chan int
+	heartbeatDone chan int
+	// The same for probing idle tcp connections
+	idleProbeQuit chan int
+	idleProbeDone chan int
 
-	config ClientConfig
+	config      ClientConfig
+	configMutex sync.RWMutex
+	// The compression asked for by the config, SetCompression or a Dial
+	// URI, which config.Compression goes back to when the client switches
+	// from a connection that can't be compressed to one that can
+	compression int
 }
 
 // Configuration used when creating a server instance
 type ClientConfig struct {
-	ChunkSize   int // The data size for each chunk sent to the server
-	Compression int // Compression to use for messagec.
+	ChunkSize        int // The data size for each chunk sent to the server
+	Compression      int // Compression to use for messagec.
+	CompressionLevel int // Compression level from 1 (fastest) to 9 (best), or 0 for the default level
+	MTU              int // The path MTU to the server for UDP (DEFAULT_MTU if 0)
+
+	// Compression to send each message with by its serialized size,
+	// instead of Compression, such as sending small messages uncompressed
+	// where compressing them costs more than it saves. A message uses the
+	// bucket with the largest MinBytes it's at least as long as, and
+	// messages shorter than every bucket use Compression. It only applies
+	// to connections that can be compressed, and SetCompression doesn't
+	// change it. Stats.SentByBucket counts the messages sent by each one.
+	CompressionBuckets []CompressionBucket
+
+	// Largest datagram that can be sent to the server over udp, or 0 for
+	// DEFAULT_MAX_DATAGRAM_BYTES. Some networks silently drop datagrams
+	// above a size, so rather than sending chunks that won't arrive, Dial
+	// and Use return ErrDatagramTooLarge if ChunkSize is larger than this,
+	// and so does SetChunkSize. An AutoChunkSize larger than it is lowered
+	// to it.
+	MaxDatagramBytes int
+
+	// Set ChunkSize for udp connections to the largest size that fits in
+	// the MTU, leaving room for the IP and UDP headers, when the client
+	// connects. MTU is used if it's set, otherwise it's the MTU of the
+	// network interface the connection is sent from. A router between the
+	// client and the server can have a smaller MTU than the interface, in
+	// which case MTU should be set to it. ChunkSize is left as it's
+	// configured if the interface can't be found.
+	AutoChunkSize bool
+
+	// Include the file and line messages were queued from in the "_file"
+	// and "_line" attributes. This has a cost for each message queued so
+	// it's best left off where performance matters.
+	IncludeCaller bool
+	// Include the ID of the goroutine messages were queued from in the
+	// GOROUTINE_ATTR ("_goroutine") attribute, to tell apart messages
+	// logged concurrently. Go doesn't expose goroutine IDs so it's parsed
+	// from the goroutine's stack trace, which is slow enough that it should
+	// only be used while debugging. It's best effort and the attribute is
+	// left out if the ID can't be found.
+	IncludeGoroutineID bool
+	// Number of extra stack frames to skip when finding the caller, for
+	// code that wraps the client's logging functions
+	CallerSkip int
+
+	// Number of goroutines sending messages from the queue for udp
+	// connections (1 if 0). With more than one sender messages are no
+	// longer guaranteed to be sent in the order they were queued. Messages
+	// sent over tcp are always sent by a single goroutine because they
+	// share a single stream.
+	SenderConcurrency int
+
+	// Called with each message before it's serialized and sent, returning
+	// the message to send in its place. The message can be modified or
+	// replaced, to redact or add information for example, or nil can be
+	// returned to drop it. It's called from the sender goroutines so it
+	// must be safe to call concurrently if SenderConcurrency is used.
+	Transform func(*Message) *Message
+
+	// Number of times to try reconnecting a dialed tcp connection after
+	// writing to it fails before giving up, or 0 to keep trying forever.
+	// Once the client gives up ErrReconnectFailed is reported to Errors(),
+	// the senders are stopped and Connected() returns false. Any messages
+	// still in the queue are dropped, and queueing any more messages
+	// returns ErrReconnectFailed until the client is closed and connected
+	// again.
+	MaxReconnectAttempts int
+
+	// A Sink that messages are sent to instead when they fail to send, such
+	// as a FileSink to spool them to disk while the server can't be
+	// reached. Errors from the fallback are reported to Errors() along with
+	// the original error.
+	FallbackSink Sink
+
+	// Called when a message sent over udp needs to be split into chunks,
+	// with the message and the number of chunks, to decide what to do
+	// with it since a chunked message is lost if any of its chunks are.
+	// It returns CHUNKING_SEND to send it as usual, CHUNKING_DROP to drop
+	// it or CHUNKING_FALLBACK to send it to the FallbackSink instead, such
+	// as one for a tcp connection (it's dropped if there isn't one). It's
+	// called from the sender goroutines after the message is compressed.
+	OnChunking func(msg *Message, chunks int) int
+
+	// Largest number of messages that can be queued waiting to be sent, or
+	// 0 for no limit. Once the queue is full any more messages are
+	// overflowed to disk if OverflowDir is set, otherwise they're dropped
+	// and ErrQueueFull is returned when queueing them.
+	MaxQueueSize int
+	// Make queueing a message wait while the queue is full, until the
+	// senders have made room for it, instead of overflowing or dropping
+	// it, so producers are slowed to the rate messages can be sent rather
+	// than losing them. It has no effect without a MaxQueueSize. Logging
+	// can then take as long as sending a message does, or block
+	// indefinitely while the client isn't connected, is paused or can't
+	// reach the server, so it's best for producers that can afford to
+	// wait. Messages are dropped without waiting once the client gives up
+	// reconnecting, and ones still waiting when it's closed or aborted
+	// give up with ErrClosing.
+	BackpressureBlock bool
+	// What to do with a message queued while the queue is full, one of the
+	// QUEUE_* constants. With QUEUE_DROP_NEWEST, the default, it's
+	// overflowed or dropped as described for MaxQueueSize. QUEUE_BLOCK
+	// waits for room the same as BackpressureBlock. QUEUE_DROP_OLDEST drops
+	// the oldest message waiting in the queue to make room for it, keeping
+	// the most recent messages like a ring buffer, unless every message
+	// counted in the queue is already being sent. Every strategy uses the
+	// same queue so Flush, Close and Stats work the same with each of them.
+	// It has no effect without a MaxQueueSize.
+	QueueStrategy int
+	// Send messages from the goroutine queueing them, once they've been
+	// through the same checks and defaults as queued messages, instead of
+	// queueing them for the sender goroutines, so they've been sent by the
+	// time QueueMsg or the logging function returns. It's meant for tests
+	// and tools where waiting for each message is better than sending them
+	// in the background. Messages are queued as usual while the client
+	// isn't connected or is paused. A Sink given to UseSink must be safe to
+	// use concurrently, the same as with SendMsg.
+	Synchronous bool
+
+	// Called with each message that's dropped without being sent, saying
+	// why, so every dropped message can be accounted for such as in an
+	// audit trail. It's called synchronously from whichever goroutine
+	// dropped the message, which can be the one queueing it or one of the
+	// senders, so it must be safe to call concurrently and it holds up
+	// sending while it runs. The message mustn't be kept or modified after
+	// it returns. Messages that fail to send aren't dropped, they're
+	// reported to Errors() instead.
+	DropAuditFunc func(record DropRecord)
+
+	// Called with how long each message took to serialize, compress and
+	// write, once it's been sent or has failed to, to find whether the
+	// server or compression is what's slowing sending down. The time is
+	// only measured when it's set. It's called synchronously by the
+	// senders so it must be safe to call concurrently, and it holds up
+	// sending while it runs. The message mustn't be kept or modified after
+	// it returns.
+	OnSendTiming func(timing SendTiming)
+
+	// Called with the IDs (see Message.ID) of each batch of messages once
+	// it's sent, or nil to not confirm batches. It's called concurrently by
+	// the senders, holding up sending, and mustn't keep the slice.
+	OnBatchSent func(ids []uint64)
+
+	// Directory to write messages to when the queue is full. The messages
+	// are sent once everything in the queue has been sent, before any
+	// messages queued after that. They're kept in the directory when the client is closed so
+	// any that weren't sent are picked up by the next client using it.
+	// Messages queued with QueueMsgWithResult are never overflowed.
+	OverflowDir string
+	// Largest number of bytes of messages kept in the overflow, with the
+	// oldest messages removed to make room for new ones, or 0 for no
+	// limit. Messages are removed in groups of around an eighth of this.
+	OverflowMaxBytes int64
+	// Longest time messages are kept in the overflow before they're removed
+	// without being sent, or 0 to keep them until they're sent
+	OverflowMaxAge time.Duration
+	// Sync messages written to the overflow to disk so they aren't lost if
+	// the machine crashes, at the cost of some disk throughput. Messages
+	// written together are synced together, at most 100ms after they're
+	// written, so a crash can still lose the last few messages.
+	FsyncOnOverflow bool
+
+	// File to keep a write-ahead log of queued messages in, so the ones
+	// that haven't been sent when the process stops, such as after a
+	// crash, can be queued again with RecoverWAL when it starts again.
+	// Each message is written to the WAL as it's queued and removed once
+	// it's sent, overflowed or dropped, so accepting a message costs a
+	// serialization and a write. Messages that fail to send or are still
+	// queued when the client gives up reconnecting, is aborted or is
+	// closed stay in the WAL, and recovered messages can be sent more
+	// than once if the process stops between sending them and removing
+	// them. The file is left behind when the client is closed.
+	WALPath string
+	// Largest number of bytes of messages kept in the WAL, or 0 for no
+	// limit. Messages queued once it's full are still sent but they
+	// aren't written to the WAL, and ErrWALFull is reported for them.
+	WALMaxBytes int64
+	// Sync the WAL to disk so the messages in it survive the machine
+	// crashing rather than only the process, at the cost of a sync for
+	// every 100ms of messages queued. Without it a crash can lose
+	// whatever the operating system hadn't written out yet, with it a
+	// crash can still lose the last 100ms of messages.
+	FsyncWAL bool
+
+	// Longest time after a message's Timestamp that it's still sent, or 0
+	// to always send it. Messages older than this when they're taken from
+	// the queue, such as after waiting out an outage, are dropped with
+	// ErrMessageExpired and counted in both Expired and Dropped in the
+	// client's Stats. Messages can have their own limit set with
+	// Message.SetMaxAge instead.
+	MaxAge time.Duration
+
+	// Treat problems that are normally ignored or only reported to
+	// Errors() as errors, for debugging an integration. Dial returns
+	// ErrUnknownCompression for an unknown "compress" value, Use returns
+	// ErrChunkExceedsMTU instead of reporting it, Dial returns
+	// ErrUDPUnreachable when UDPProbeTimeout is set and the probe is
+	// refused, messages fail to send
+	// with ErrCompressionLevel instead of falling back to the default
+	// compression level, and ErrMessageDropped is reported for every
+	// message dropped by Transform, for being too old in the overflow or
+	// after giving up reconnecting.
+	StrictMode bool
+
+	// Turn on tcp keep-alives for connections opened by Dial, including
+	// when reconnecting, so one dropped by a NAT while idle is noticed
+	// sooner. KeepAlivePeriod is the time between them, or 0 for the OS's
+	// default. Without KeepAlive Go's defaults are left alone.
+	KeepAlive       bool
+	KeepAlivePeriod time.Duration
+
+	// DSCP value (0 to 63) to mark the packets sent to the server with so
+	// they can be prioritized by the network, or 0 to leave them unmarked.
+	// It's only set on connections opened by Dial, and it's ignored on
+	// platforms that don't support setting it.
+	DSCP int
+
+	// How long Dial waits for a udp server to refuse a probe, to warn
+	// about a wrong port or nothing listening at startup instead of
+	// losing every message, or 0 to not probe. Dial succeeds for udp even
+	// if nothing's listening, so it sends an empty datagram and waits this
+	// long for the icmp "port unreachable" reply the connected socket
+	// reports. If it's refused ErrUDPUnreachable is reported to Errors()
+	// and logged, but Dial still succeeds since a server that's down can
+	// be started later. Servers never reply to the probe, so Dial always
+	// takes this long for a server that's listening, and a firewall that
+	// drops the icmp reply hides that nothing is.
+	UDPProbeTimeout time.Duration
+
+	// Local address, such as "0.0.0.0:5140", to send from instead of one
+	// picked by the OS, for firewalls that only allow traffic from a fixed
+	// source port. It's only used by Dial, which returns
+	// ErrInvalidLocalAddr if it isn't a valid address for the URI's
+	// network, or the error binding to it if that fails, such as when
+	// something else is using the port. The same address is used when
+	// reconnecting.
+	LocalAddr string
+
+	// Logger for the client's own diagnostics, such as connecting,
+	// reconnecting and dropping messages, or nil to not log them. This is
+	// meant for people operating the client, errors are still reported to
+	// Errors() whether or not it's set. A *log.Logger can be used.
+	InternalLogger InternalLogger
+
+	// Size of a buffer for messages sent over tcp, so they can be written
+	// to the connection together instead of with a write for each one, or
+	// 0 to write each one as it's sent. Buffered messages are written when
+	// the buffer is full, after they've waited 10ms, or when the client is
+	// flushed or closed. Messages count as sent once they're in the buffer,
+	// so an error writing the buffer is only reported to Errors().
+	TCPBufferSize int
+
+	// Number each message sent by the client in its SEQUENCE_ATTR
+	// ("_seq") attribute, starting from 1. Messages are numbered as
+	// they're sent, after Transform, so a gap in the numbers seen by the
+	// server means messages were lost on the way to it. With more than one
+	// sender the messages can reach the server out of order.
+	AddSequenceNumbers bool
+
+	// Largest size in bytes of the string value of any additional field,
+	// or 0 for no limit. Longer values are truncated to fit, ending with
+	// "...", and the message's FIELD_TRUNCATED_ATTR ("_field_truncated")
+	// attribute is set to true.
+	MaxFieldBytes int
+
+	// Largest size in bytes of a []byte attribute added with AddField,
+	// before it's base64 encoded, or 0 for no limit. Larger attributes are
+	// left out of the message and its FIELD_TRUNCATED_ATTR
+	// ("_field_truncated") attribute is set to true.
+	MaxBinaryFieldBytes int
+
+	// Largest number of additional fields sent with a message, or 0 for no
+	// limit, to protect servers like Graylog and Elasticsearch from a
+	// caller that adds an unbounded number of different fields. The fields
+	// are sorted by name and those after the first MaxFields are left out,
+	// so the same message always keeps the same fields, and the message's
+	// FIELDS_TRUNCATED_ATTR ("_fields_truncated") attribute is set to the
+	// number left out. It's applied after AllowFields and DenyFields, and
+	// the fields left out are counted in FieldsTruncated in the client's
+	// Stats.
+	MaxFields int
+
+	// Send integer additional fields too large for a float64 to hold
+	// exactly, those more than 2^53 from 0, as strings of their decimal
+	// digits instead of JSON numbers, for servers like Graylog and
+	// Elasticsearch that can read them as float64 and lose precision, such
+	// as with nanosecond timestamps or 64 bit IDs. Only fields with an
+	// integer type (int, int64, uint64 and so on) are affected, including
+	// the ones added by the client such as "_seq", and smaller values are
+	// still sent as numbers. The standard GELF fields are never affected.
+	LargeIntsAsStrings bool
+
+	// Add the process ID and the time the process started to every message
+	// in the PID_ATTR ("_pid") and PROCESS_START_ATTR ("_process_start")
+	// attributes, to tell apart messages from before and after a restart.
+	// The start time is an RFC 3339 timestamp of when the golf package was
+	// initialized, which is close to when the process started. Attributes
+	// set on the message or its Logger override them.
+	AddProcessFields bool
+
+	// OpenTelemetry resource attributes, such as "service.name", to add to
+	// every message as default fields, or none if it's empty. Dots in their
+	// names are sent as underscores unless KeepResourceDots is set.
+	ResourceAttributes map[string]interface{}
+	KeepResourceDots   bool
+
+	// Number of messages in the queue to warn at, before it's full and
+	// messages start being dropped, or 0 to not warn. When the queue
+	// reaches it ErrQueueHighWaterMark is reported to Errors() and logged
+	// to the InternalLogger. It's only reported again once the queue has
+	// gone back below the mark, and at most once a second.
+	QueueHighWaterMark int
+
+	// Framing for messages sent over tcp, one of the TCP_FRAME_* constants,
+	// TCP_FRAME_NULL by default. Any other framing allows compression.
+	TCPFraming int
+	// Byte written after each message sent over tcp with TCP_FRAME_NULL,
+	// the null byte GELF uses by default, such as '\n' for gateways that
+	// expect newline delimited JSON instead. Standard GELF servers only
+	// accept the null byte. It has to be a control character (below 0x20)
+	// since those are always escaped in JSON and can't appear inside a
+	// message, otherwise NewClientWithConfig returns ErrInvalidDelimiter.
+	TCPDelimiter byte
+
+	// Generates the 8 byte message id sent in each chunk of a chunked
+	// message, instead of the random ids used by default. Every message
+	// needs a different id, the server can't put messages back together
+	// if their chunks have the same id. It's called from the sender
+	// goroutines so it must be safe to call concurrently if
+	// SenderConcurrency is used.
+	ChunkIDFunc func() [8]byte
+	// Where the random ids for chunked messages are read from when
+	// ChunkIDFunc isn't set, instead of crypto/rand, and the randomness
+	// for SampleRates, instead of math/rand, such as a *math/rand.Rand
+	// with a fixed seed to make them reproducible in tests. It's read from
+	// by one goroutine at a time so it doesn't need to be safe for
+	// concurrent use. A source that's predictable makes it
+	// easy to guess future ids, so it shouldn't be used in production.
+	RandSource io.Reader
+
+	// Number of the most recently sent messages to keep in memory for
+	// RecentMessages, or 0 to not keep any. Keeping them has a small cost
+	// for each message sent so it's off by default.
+	RecentMessagesSize int
+
+	// Prefix added to the name of every additional field sent, after the
+	// leading underscore, so fields from different services sharing a
+	// server don't collide. With a prefix of "svc_" a "user" field is sent
+	// as "_svc_user". Fields that already start with the prefix aren't
+	// prefixed again, and the standard GELF fields are never prefixed.
+	FieldPrefix string
+	// Changes the name of every additional field sent, such as SnakeCaseKey
+	// to send "userId" and "user-id" both as "user_id" so dashboards see
+	// consistent names, or nil to send them as they are. It's given the
+	// name after FieldPrefix is added, without the leading underscore,
+	// which is added back to what it returns. If two fields end up with
+	// the same name only one of them is sent. The standard GELF fields are
+	// never changed.
+	FieldKeyTransform func(name string) string
+
+	// path.Match patterns, such as "user_*", for the only additional fields
+	// sent and for fields never sent, matched against the names they were
+	// added with. Both are empty by default, letting every field through.
+	AllowFields []string
+	DenyFields  []string
+
+	// The chance of sending messages of each level, from 0 to 1, for
+	// keeping a sample of verbose levels such as {LEVEL_INFO: 0.1,
+	// LEVEL_DBG: 0.01} to send 10% of info messages and 1% of debug
+	// messages. Levels that aren't in the map use DefaultSampleRate, or
+	// are all sent if it's 0, so MinLevel should be used to drop every
+	// message of a level. Messages are sampled when they're queued, using
+	// RandSource if it's set, and the ones that aren't sent are counted in
+	// both Dropped and SampledOut in the client's Stats.
+	SampleRates       map[int]float64
+	DefaultSampleRate float64
+
+	// The least severe level to send, such as LEVEL_WARN to only send
+	// warnings and anything more severe. Messages with a less severe
+	// level (a higher number, since LEVEL_EMERG is 0) are dropped when
+	// they're queued, and counted in Stats().Dropped. Since LEVEL_EMERG is
+	// 0 it's the same as not filtering messages, every level is sent.
+	MinLevel int
+
+	// Added to the current time when it's used as the timestamp of a
+	// message queued without one, to correct for a host's clock being off
+	// by a known amount. Messages queued with a timestamp aren't changed.
+	// It's a stopgap for a host with a skewed clock, not a substitute for
+	// keeping its clock in sync.
+	ClockOffset time.Duration
+	// Called to get the current time instead of time.Now when it's used
+	// as the timestamp of a message queued without one, and to tell how
+	// old messages are for MaxAge and DropOlderThan, so timestamps can
+	// come from a clock kept in step with other services, such as one
+	// corrected by its offset from an NTP server, to order messages across
+	// them. ClockOffset is still added to the times it returns, and
+	// messages queued with a timestamp aren't changed. It's called from
+	// the goroutines queueing and sending messages so it must be safe to
+	// call concurrently.
+	Clock func() time.Time
+	// Leave the timestamp out of messages queued without one instead of
+	// using the current time, so the server stamps them with the time it
+	// receives them rather than trusting the host's clock. Messages queued
+	// with a timestamp, or given one by TimestampFunc, are still sent with
+	// it. Messages without a timestamp are never too old for MaxAge,
+	// DropOlderThan or OverflowMaxAge.
+	NoTimestamp bool
+
+	// Called with each message queued without a Timestamp, or with a zero
+	// one, to get the time to use for it, such as a time parsed from one
+	// of its attributes when forwarding events that have their own time.
+	// If it returns the zero time the current time is used, the same as
+	// when it isn't set.
+	// ClockOffset isn't applied to the times it returns. It's called from
+	// the goroutine queueing the message.
+	TimestampFunc func(msg *Message) time.Time
+
+	// A writer that messages which can't be delivered are written to as
+	// human readable text, one line for each message, such as os.Stderr so
+	// they aren't lost entirely while the server is down. It's used for
+	// messages that fail to send (after FallbackSink, if there is one),
+	// that don't fit in the queue or overflow, and that are dropped after
+	// giving up reconnecting.
+	FallbackWriter io.Writer
+
+	// A writer that messages which fail to send while the client is
+	// closing are written to as GELF JSON, one message on each line, so a
+	// backlog that couldn't be sent because the server was down at
+	// shutdown can be replayed later instead of being lost. Messages
+	// dropped while closing after giving up reconnecting are written too.
+	// Close returns the number written in its CloseError's Exported. It's
+	// used after FallbackSink, any messages it accepts are never exported.
+	CloseExportWriter io.Writer
+
+	// How often to queue a heartbeat message while the client is connected,
+	// or 0 to not send any, so the server can alert when they stop
+	// arriving because the process or the path to the server has died.
+	// Heartbeats are LEVEL_INFO messages with HeartbeatMessage as their
+	// short message (DEFAULT_HEARTBEAT_MESSAGE if it's empty) and with
+	// HeartbeatFields as their attributes.
+	HeartbeatInterval time.Duration
+	HeartbeatMessage  string
+	HeartbeatFields   map[string]interface{}
+
+	// Whether to queue a marker message as soon as the client is
+	// connected and another when Close is called, before the queue is
+	// drained, for an audit trail of when each process's logging started
+	// and stopped. They're LEVEL_INFO messages with StartedMessage and
+	// StoppingMessage as their short messages (DEFAULT_STARTED_MESSAGE and
+	// DEFAULT_STOPPING_MESSAGE if they're empty) and with LifecycleFields
+	// as their attributes.
+	LifecycleEvents bool
+	StartedMessage  string
+	StoppingMessage string
+	LifecycleFields map[string]interface{}
+
+	// How long to suppress messages queued with the same level and short
+	// message as the one queued before them, or 0 to send them all, to cut
+	// the noise from an error repeated in a tight loop. The window starts
+	// with the first of them. Once a different message is queued, the
+	// window ends or the client is closed, the last message suppressed is
+	// sent with its SUPPRESSED_COUNT_ATTR ("_suppressed_count") attribute
+	// set to the number that were, like syslog's "last message repeated N
+	// times". Suppressed messages are counted in Dropped in the client's
+	// Stats. Messages sent with SendMsg aren't checked.
+	RepeatWindow time.Duration
+
+	// How long a tcp connection can go without a message being written to
+	// it before an empty frame is written to probe it, or 0 to never probe
+	// it, so a dead server is found and reconnected to before the next
+	// message needs sending rather than when it does. With TCP_FRAME_NULL
+	// the frame is just the delimiter, otherwise it's a zero length prefix
+	// (and checksum). Unlike the OS's tcp keep-alive the probe is data the
+	// server has to read, so it can only be used with servers that ignore
+	// empty frames.
+	IdleTimeout time.Duration
+
+	// Encodes messages in another format instead of GELF, such as
+	// JSONLinesSerializer or CEFSerializer, for sending them somewhere
+	// other than a GELF server, or nil to send GELF. The message it's
+	// given has all of the fields it would be sent with as GELF in its
+	// Attrs, including ones from its Logger and the rest of the config.
+	// It's ignored for the syslog+udp scheme, and messages are still
+	// chunked and compressed for udp the same as GELF.
+	Serializer Serializer
+
+	// How many times to try serializing a message before giving up on it
+	// and reporting the error, 1 if it's 0. Most serialization errors,
+	// such as a field that can't be encoded, fail the same way every time
+	// so retrying doesn't help, but an error from a Serializer or a field's
+	// MarshalJSON that depends on something outside the message might not.
+	SerializeAttempts int
+
+	// What to do with messages that have a field which can't be encoded as
+	// JSON, FIELD_ERR_DROP_FIELD by default. With FIELD_ERR_DROP_FIELD the
+	// field's value is replaced with a placeholder string saying what its
+	// type was and the rest of the message is sent as usual. With
+	// FIELD_ERR_DROP_MESSAGE the message isn't sent at all. Either way the
+	// error is reported to Errors(). With FIELD_ERR_FAIL_FAST the fields
+	// are checked when the message is queued and QueueMsg, QueueMsgs or
+	// the logging function returns the error instead of queueing it.
+	UnencodableFields int
+
+	// Which source's value is sent for an additional field more than one
+	// source has, one of the FIELD_COLLISION_* constants. The default,
+	// FIELD_COLLISION_LAST_WINS, lets each source override the ones before.
+	FieldCollisions int
+
+	// The FIELD_TYPE_* to always send additional fields as, by the name
+	// they were added with, so Graylog doesn't reject a field for changing
+	// type. Fields not in it, every field by default, are sent as they are.
+	FieldTypes map[string]int
+
+	// A template for the full message of messages that are sent without
+	// one, such as "user {{.user_id}} did {{.action}}", so a readable full
+	// message can be built from structured fields without formatting it
+	// at every call site. It's executed with a map of the message's
+	// additional fields by name, after AllowFields and DenyFields are
+	// applied, along with its "short_message", "host" and "level" unless
+	// there's an additional field with the same name. A field the message
+	// doesn't have renders as "<no value>" unless the template's
+	// missingkey option is set. If executing it fails the error is
+	// reported to Errors() and the message is sent without a full message.
+	FullMessageTemplate *template.Template
+
+	// Create each sender's compression writer for the current Compression
+	// and CompressionLevel when the client connects, instead of when the
+	// first message is sent, so the first message doesn't wait for it.
+	// Changing the compression or its level later still creates a new
+	// writer the first time it's used.
+	PrewarmCompression bool
+
+	// Largest number of messages sent with QueueMsgWithResult, SendMsg and
+	// SendMsgRetry that can be waiting to be sent at once, or 0 for no
+	// limit. Calls past the limit wait for one of the others to finish,
+	// for up to MaxInFlightWait if it's set, after which they give up with
+	// ErrTooManyInFlight. Messages queued any other way aren't limited.
+	MaxInFlight     int
+	MaxInFlightWait time.Duration
+
+	// Largest number of bytes of messages to send each second, or 0 for no
+	// limit, for metered or shared links. Messages are measured after
+	// they're serialized, before they're compressed, and can be sent in
+	// bursts of up to BytesPerSecondBurst bytes (BytesPerSecond if it's 0)
+	// after the client has been quiet. Messages past the limit wait until
+	// they can be sent, holding up the messages queued after them, or with
+	// DropThrottled they're dropped with ErrThrottled instead. Either way
+	// they're counted in Throttled in the client's Stats.
+	BytesPerSecond      int
+	BytesPerSecondBurst int
+	DropThrottled       bool
+
+	// A channel that each message sent is also delivered to, decoded from
+	// what was sent with all of its fields as ParseMessage returns them,
+	// for tests and components that watch the client's messages in the
+	// same process. Messages are delivered whether or not sending them
+	// succeeds. The senders never wait for the channel, if it's full the
+	// message isn't delivered to it and it's counted in DeliverDropped in
+	// the client's Stats, so it should be buffered. Messages sent with the
+	// syslog+udp scheme or a Serializer are delivered as they would've
+	// been sent as GELF.
+	DeliverChan chan<- *Message
+}
+
+// The time the package was initialized, used as the time the process started
+var processStart = time.Now()
+
+// An InternalLogger logs human readable diagnostics about the client itself
+type InternalLogger interface {
+	Printf(format string, v ...interface{})
 }
 
 /*
 Create a new Client instance with the default values for ClientConfig:
 
- {
-	ChunkSize: 1420,
-	Compression: COMP_GZIP,
- }
+	 {
+		ChunkSize: 1420,
+		Compression: COMP_GZIP,
+	 }
 */
 func NewClient() (*Client, error) {
 	cc := ClientConfig{
@@ -62,15 +787,35 @@ func NewClient() (*Client, error) {
 	return NewClientWithConfig(cc)
 }
 
-// Create a new Client instance with the given ClientConfig
+// Create a new Client instance with the given ClientConfig. Returns
+// path.ErrBadPattern if any of its AllowFields or DenyFields are malformed.
 func NewClientWithConfig(config ClientConfig) (*Client, error) {
 	c := &Client{
 		config: config,
 		queue:  make([]*Message, 0),
 
-		msgChan:  make(chan *Message, 500),
-		queueCtl: make(chan int),
-		sendCtl:  make(chan int),
+		queueSignal: make(chan int, 1),
+
+		connectedChan: make(chan int),
+
+		errChan: make(chan error, 100),
+		stats:   &clientStats{},
+
+		compression: config.Compression,
+	}
+	c.sentCond = sync.NewCond(&c.queueMutex)
+
+	if len(config.CompressionBuckets) > 0 {
+		c.config.CompressionBuckets = append([]CompressionBucket{}, config.CompressionBuckets...)
+		sort.Sort(compressionBucketsBySize(c.config.CompressionBuckets))
+		for _, bucket := range c.config.CompressionBuckets {
+			switch bucket.Compression {
+			case COMP_NONE, COMP_GZIP, COMP_ZLIB, COMP_AUTO:
+			default:
+				return nil, ErrUnknownCompression
+			}
+		}
+		c.stats.sentByBucket = make([]uint64, len(config.CompressionBuckets))
 	}
 
 	host, err := os.Hostname()
@@ -79,201 +824,2208 @@ func NewClientWithConfig(config ClientConfig) (*Client, error) {
 	}
 	c.hostname = host
 
+	if config.TCPDelimiter >= 0x20 {
+		return nil, ErrInvalidDelimiter
+	}
+	for _, patterns := range [][]string{config.AllowFields, config.DenyFields} {
+		for _, pattern := range patterns {
+			_, err = path.Match(pattern, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if config.RecentMessagesSize > 0 {
+		c.recent = newRecentRing(config.RecentMessagesSize)
+	}
+	if config.MaxInFlight > 0 {
+		c.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	if config.RandSource != nil {
+		c.randSource = &lockedReader{r: config.RandSource}
+	}
+	if config.BytesPerSecond > 0 {
+		c.limiter = newByteLimiter(config.BytesPerSecond, config.BytesPerSecondBurst)
+	}
+	if config.RepeatWindow > 0 {
+		c.repeats = newRepeatFilter(config.RepeatWindow)
+	}
+
+	if len(config.ResourceAttributes) > 0 {
+		c.defaultAttrs = make(map[string]interface{}, len(config.ResourceAttributes)+2)
+		for name, val := range config.ResourceAttributes {
+			if field := resourceFieldName(name, config.KeepResourceDots); field != "" {
+				c.defaultAttrs[field] = val
+			}
+		}
+	}
+	if config.AddProcessFields {
+		if c.defaultAttrs == nil {
+			c.defaultAttrs = make(map[string]interface{}, 2)
+		}
+		c.defaultAttrs[PID_ATTR] = os.Getpid()
+		c.defaultAttrs[PROCESS_START_ATTR] = processStart.Format(time.RFC3339Nano)
+	}
+
+	if config.OverflowDir != "" {
+		c.overflow, err = newOverflowQueue(config.OverflowDir, config.OverflowMaxBytes, config.OverflowMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		c.overflow.fsync = config.FsyncOnOverflow
+		c.overflow.reportErr = c.reportErr
+	}
+	if config.WALPath != "" {
+		c.wal, err = openWAL(config.WALPath, config.WALMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.wal.fsync = config.FsyncWAL
+		c.wal.reportErr = c.reportErr
+	}
+
 	return c, nil
 }
 
-// Connect to a GELF server at the given URI.
+// Connect to a GELF server at the given URI. A "syslog+udp" URI connects to a
+// syslog server instead, see Use. A "gelf+srv" URI such as
+// "gelf+srv://_gelf._udp.example.com" looks the server up with that DNS SRV
+// record, connecting over udp or tcp depending on the record's name, and
+// looks it up again whenever it reconnects.
 func (c *Client) Dial(uri string) error {
-	parsedUri, err := url.Parse(uri)
+	conn, scheme, compression, err := c.dial(context.Background(), uri)
 	if err != nil {
 		return err
 	}
 
-	if !strings.Contains(parsedUri.Host, ":") {
-		parsedUri.Host = parsedUri.Host + ":12201"
+	err = c.use(conn, scheme, compression)
+	if err != nil {
+		conn.Close()
+		return err
 	}
 
-	switch parsedUri.Scheme {
-	case "udp":
-	case "tcp":
-	default:
-		return errors.New("Unsupported scheme provided")
+	return nil
+}
+
+// Connect to the server at uri, returning the connection, the scheme to
+// use it with and the compression set by its "compress" query, or -1 if it
+// doesn't set one
+func (c *Client) dial(ctx context.Context, uri string) (net.Conn, string, int, error) {
+	target, err := parseURI(uri)
+	if err != nil {
+		return nil, "", -1, err
+	}
+
+	if target.unknownCompression && c.config.StrictMode {
+		return nil, "", -1, ErrUnknownCompression
 	}
 
-	switch parsedUri.Query().Get("compress") {
-	case "none":
-		c.config.Compression = COMP_NONE
-	case "zlib":
-		c.config.Compression = COMP_ZLIB
-	case "gzip":
-		c.config.Compression = COMP_GZIP
+	if c.config.DSCP < 0 || c.config.DSCP > 63 {
+		return nil, "", -1, ErrInvalidDSCP
 	}
 
-	conn, err := net.Dial(parsedUri.Scheme, parsedUri.Host)
+	conn, err := c.dialTarget(ctx, target)
 	if err != nil {
-		return err
+		return nil, "", -1, err
+	}
+	if target.transport == "udp" && c.config.UDPProbeTimeout > 0 && !probeUDP(conn, c.config.UDPProbeTimeout) {
+		if c.config.StrictMode {
+			conn.Close()
+			return nil, "", -1, ErrUDPUnreachable
+		}
+		c.logf("golf: nothing is listening at udp %s, messages sent to it will be lost", conn.RemoteAddr())
+		c.reportErr(ErrUDPUnreachable)
 	}
-	c.conn = conn
 
-	c.chnk, err = newChunker(c.conn, c.config.ChunkSize)
+	// Reconnect tcp connections if they're dropped, there's no connection
+	// to lose for udp but its socket is redialed when a write is refused
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return c.dialTarget(ctx, target)
+	}
+	if target.transport == "tcp" {
+		rc := newReconnConn(conn, dial, c.config.MaxReconnectAttempts)
+		rc.onReconnect = c.stats.reconnected
+		rc.logf = c.logf
+		conn = rc
+	} else if target.transport == "udp" {
+		rc := newRedialConn(conn, dial)
+		rc.onRedial = c.stats.reconnected
+		rc.logf = c.logf
+		conn = rc
+	}
+
+	return conn, target.scheme, target.compression, nil
+}
+
+func (c *Client) dialTarget(ctx context.Context, target *dialURI) (net.Conn, error) {
+	if target.srvName != "" {
+		return c.dialSRV(ctx, target)
+	}
+
+	var dialer net.Dialer
+	if c.config.LocalAddr != "" {
+		localAddr, err := resolveLocalAddr(target.network, c.config.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = localAddr
+	}
+	conn, err := dialer.DialContext(ctx, target.network, target.address)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if c.config.DSCP > 0 {
+		err = setDSCP(conn, c.config.DSCP)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && c.config.KeepAlive {
+		err = setKeepAlive(tcpConn, c.config.KeepAlivePeriod)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
+	return conn, nil
+}
 
-	c.gz = &sync.Pool{
-		New: func() interface{} {
-			gz, _ := gzip.NewWriterLevel(c.chnk, gzip.DefaultCompression)
-			return gz
-		},
+// Turn on keep-alives for conn, every 'period' if it isn't 0
+func setKeepAlive(conn *net.TCPConn, period time.Duration) error {
+	err := conn.SetKeepAlive(true)
+	if err != nil || period <= 0 {
+		return err
 	}
+	return conn.SetKeepAlivePeriod(period)
+}
 
-	c.zz = &sync.Pool{
-		New: func() interface{} {
-			zz, _ := zlib.NewWriterLevel(c.chnk, zlib.DefaultCompression)
-			return zz
-		},
+// Resolve the LocalAddr for dialing network, returning ErrInvalidLocalAddr if
+// it can't be
+func resolveLocalAddr(network string, addr string) (net.Addr, error) {
+	var localAddr net.Addr
+	var err error
+	if strings.HasPrefix(network, "tcp") {
+		localAddr, err = net.ResolveTCPAddr(network, addr)
+	} else {
+		localAddr, err = net.ResolveUDPAddr(network, addr)
+	}
+	if err != nil {
+		return nil, ErrInvalidLocalAddr
 	}
+	return localAddr, nil
+}
+
+// Get the name of the field to send the resource attribute 'name' as, with
+// the characters GELF doesn't allow in field names replaced by underscores,
+// and dots too unless keepDots is set
+func resourceFieldName(name string, keepDots bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r == '.' && keepDots:
+			return r
+		}
+		return '_'
+	}, name)
+}
 
-	go c.queueReceiver()
-	go c.msgSender()
+// A URI given to Dial, parsed by parseURI
+type dialURI struct {
+	scheme    string
+	transport string
+	// The network and address to dial
+	network string
+	address string
+	// The SRV record the address is looked up with, for SRV_SCHEME
+	srvName string
+	// The compression from the "compress" query, or -1 if there isn't
+	// one, and whether it was set to something unknown
+	compression        int
+	unknownCompression bool
+}
 
+// Check that uri can be used with Dial without connecting to it, such as to
+// report a bad URI in a config file as early as possible. Returns
+// ErrUnsupportedScheme for an unsupported scheme, ErrMissingHost if there's no
+// host, ErrInvalidPort for a port that isn't a number from 1 to 65535 and
+// ErrUnknownCompression for an unknown "compress" query. Dial ignores an
+// unknown "compress" value unless StrictMode is set, but ValidateURI always
+// reports it since it's almost always a mistake.
+func ValidateURI(uri string) error {
+	target, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+	if target.unknownCompression {
+		return ErrUnknownCompression
+	}
 	return nil
 }
 
-// Close the connection to the server. This call will block until all the
-// currently queued messages for the client are sent.
-func (c *Client) Close() error {
-	if c.conn == nil {
-		// Already shut down so it doesn't need to run again
-		return nil
+func parseURI(uri string) (*dialURI, error) {
+	parsedUri, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *dialURI
+	if parsedUri.Scheme == SRV_SCHEME {
+		target, err = parseSRVURI(parsedUri)
+	} else {
+		target, err = parseAddressURI(parsedUri)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// First quit the queue and wait for it to respond
-	// that it's quit
-	c.queueCtl <- 1
-	for {
-		quitVal := <-c.queueCtl
-		if quitVal == 2 {
-			break
+	target.compression = -1
+	if name := parsedUri.Query().Get("compress"); name != "" {
+		compression, ok := compressionNames[name]
+		if ok {
+			target.compression = compression
+		} else {
+			target.unknownCompression = true
 		}
-		c.queueCtl <- quitVal
 	}
 
-	// Then quit the sender and wait for it to respond
-	// that it's quit
-	c.sendCtl <- 1
-	for {
-		quitVal := <-c.sendCtl
-		if quitVal == 2 {
-			break
+	return target, nil
+}
+
+// Parse a Dial URI with the address of the server in it
+func parseAddressURI(parsedUri *url.URL) (*dialURI, error) {
+	transport := schemeTransport(parsedUri.Scheme)
+	if transport == "" {
+		return nil, ErrUnsupportedScheme
+	}
+	if parsedUri.Host == "" {
+		return nil, ErrMissingHost
+	}
+
+	address := parsedUri.Host
+	if !strings.Contains(address, ":") || strings.HasSuffix(address, "]") {
+		if transport == "syslog" {
+			address = address + ":514"
+		} else {
+			address = address + ":12201"
 		}
-		c.sendCtl <- quitVal
 	}
+	port, err := strconv.Atoi(address[strings.LastIndex(address, ":")+1:])
+	if err != nil || port < 1 || port > 65535 {
+		return nil, ErrInvalidPort
+	}
+
+	return &dialURI{
+		scheme:    parsedUri.Scheme,
+		transport: transport,
+		network:   schemeNetwork(parsedUri.Scheme),
+		address:   address,
+	}, nil
+}
+
+// Switch a running client to the server at uri without losing any messages,
+// such as to move to a new receiver without restarting. The new server is
+// connected to before anything is changed, so if it can't be reached, or ctx
+// is done first, the error is returned and the client carries on sending to
+// the server it was already using. Otherwise the messages already being sent
+// are finished on the old connection, the rest of the queue is sent to the
+// new one and the old connection is closed. The client's config, including its
+// compression unless uri sets it, is kept, and compression turned off for a
+// connection that can't be compressed is turned back on for one that can. A client using a Sink can be
+// switched to a server too, in which case the sink is closed if it implements
+// io.Closer. Returns ErrNotConnected if the client isn't running.
+func (c *Client) Rebind(ctx context.Context, uri string) error {
+	c.rebindMutex.Lock()
+	defer c.rebindMutex.Unlock()
 
-	err := c.conn.Close()
+	c.connMutex.Lock()
+	running := c.conn != nil || c.sink != nil
+	c.connMutex.Unlock()
+	if !running {
+		return ErrNotConnected
+	}
+
+	conn, scheme, compression, err := c.dial(ctx, uri)
 	if err != nil {
 		return err
 	}
-	c.conn = nil
+	transport := schemeTransport(scheme)
+	sinks, batchw, sinkCfg, err := c.newSinks(conn, transport, compression)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The senders finish the batch they're sending and leave everything
+	// else in the queue for the new ones, which the config is only
+	// switched over for once they've stopped
+	close(c.senderStop)
+	c.senderWg.Wait()
+
+	c.connMutex.Lock()
+	oldConn, oldBatchw, oldSink := c.conn, c.batchw, c.sink
+	c.conn = conn
+	c.scheme = transport
+	c.batchw = batchw
+	c.sink = nil
+	c.sinks = sinks
+	c.connMutex.Unlock()
+	c.useSinkConfig(sinkCfg)
+	atomic.StoreInt32(&c.failed, 0)
+	c.startSenders(sinks, 1)
+	c.logf("golf: switched to %s over %s", conn.RemoteAddr(), transport)
+
+	if oldBatchw != nil {
+		err = oldBatchw.Flush()
+		if err != nil {
+			c.reportErr(err)
+		}
+	}
+	if oldConn != nil {
+		if rc, ok := oldConn.(*reconnConn); ok {
+			rc.stop()
+		}
+		err = oldConn.Close()
+	} else if closer, ok := oldSink.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if err != nil {
+		c.reportErr(err)
+	}
 
 	return nil
 }
 
-// Queue the given message at the end of the message queue
-func (c *Client) QueueMsg(msg *Message) error {
-	if msg.Timestamp == nil {
-		curTime := time.Now()
-		msg.Timestamp = &curTime
+// Use an already established connection to the GELF server instead of having
+// the client dial one itself. The scheme ("udp" or "tcp", or one of their
+// udp4/udp6/tcp4/tcp6 variants) is the one the connection would have been
+// dialed with and decides how messages are written to conn: chunked for udp,
+// or null byte delimited for tcp. Compression isn't
+// supported by GELF over tcp so messages sent over tcp are never compressed,
+// unless TCPFraming is something other than TCP_FRAME_NULL.
+//
+// With a "syslog+udp" scheme (or syslog+udp4/syslog+udp6) messages are sent as
+// RFC 5424 syslog messages instead of GELF, for servers that only accept
+// syslog. Each message is sent in a single datagram without being chunked or
+// compressed, with its additional fields sent as structured data.
+//
+// The client takes ownership of conn and will close it when the client is
+// closed. Returns ErrAlreadyConnected if the client is already connected or
+// using a Sink, use Rebind to switch servers without closing it.
+func (c *Client) Use(conn net.Conn, scheme string) error {
+	return c.use(conn, scheme, -1)
+}
+
+// Use conn with the compression set by a Dial URI, or -1 to keep the
+// client's
+func (c *Client) use(conn net.Conn, scheme string, compression int) error {
+	c.connMutex.Lock()
+	connected := c.conn != nil || c.sink != nil
+	c.connMutex.Unlock()
+	if connected {
+		return ErrAlreadyConnected
 	}
 
-	c.msgChan <- msg
+	transport := schemeTransport(scheme)
+	sinks, batchw, sinkCfg, err := c.newSinks(conn, transport, compression)
+	if err != nil {
+		return err
+	}
+
+	c.connMutex.Lock()
+	if c.conn != nil || c.sink != nil {
+		c.connMutex.Unlock()
+		return ErrAlreadyConnected
+	}
+	c.conn = conn
+	c.scheme = transport
+	c.batchw = batchw
+	c.sinks = sinks
+	close(c.connectedChan)
+	c.connMutex.Unlock()
+	c.useSinkConfig(sinkCfg)
+	c.logf("golf: connected to %s over %s", conn.RemoteAddr(), transport)
+
+	// Messages are sent to the connection one at a time so any errors
+	// are for a single message
+	c.start(sinks, 1)
+
 	return nil
 }
 
-func (c *Client) queueReceiver() {
-	for {
-		select {
-		case msg := <-c.msgChan:
-			c.queueMutex.Lock()
-			c.queue = append(c.queue, msg)
-			c.queueMutex.Unlock()
-		case quitVal := <-c.queueCtl:
-			if quitVal == 1 {
-				// Don't quit if there are still
-				// messages in the channel
-				if len(c.msgChan) > 0 {
-					c.queueCtl <- 1
-					continue
-				}
-				c.queueCtl <- 2
-				return
-			}
+// The compression and chunk size a client switches to when it starts using
+// the sinks newSinks created for a connection
+type sinkConfig struct {
+	compression int
+	// The compression asked for, which is compression unless the
+	// connection can't be compressed
+	wantCompression int
+	// The chunk size picked for AutoChunkSize, or 0 to keep the client's
+	chunkSize int
+}
+
+// Create the senders for a connection using the transport, along with the
+// batchWriter they write to if TCPBufferSize is set and the config to use
+// with them. The compression is the one set by a Dial URI, or -1 for the
+// client's. Nothing about the client is changed until the senders are
+// installed and the config is passed to useSinkConfig.
+func (c *Client) newSinks(conn net.Conn, transport string, compression int) ([]Sink, *batchWriter, sinkConfig, error) {
+	var cfg sinkConfig
+	numSenders := 1
+	switch transport {
+	case "udp", "syslog":
+		if c.config.SenderConcurrency > 1 {
+			numSenders = c.config.SenderConcurrency
 		}
+	case "tcp":
+	default:
+		return nil, nil, cfg, ErrUnsupportedScheme
 	}
-}
 
-func (c *Client) msgSender() {
-	var msg *Message
-	for {
-		c.queueMutex.Lock()
-		if len(c.queue) > 0 {
-			msg, c.queue = c.queue[0], c.queue[1:]
-			c.queueMutex.Unlock()
+	c.configMutex.RLock()
+	if compression < 0 {
+		compression = c.compression
+	}
+	chunkSize := c.config.ChunkSize
+	c.configMutex.RUnlock()
+	cfg.compression = compression
+	cfg.wantCompression = compression
+	if !c.canCompress(transport) {
+		cfg.compression = COMP_NONE
+	}
 
-			data, err := generateMsgJson(msg)
-			if err != nil {
-				// TODO Not sure what to do at this point? Fail the
-				// message silently?
-				// Might be able to add an error channel that the
-				// user can watch for errors
-				continue
-			}
-			err = c.writeMsg(data, c.conn, c.config.Compression)
-			if err != nil {
-				// TODO Same as above...
-			}
-		} else {
-			c.queueMutex.Unlock()
-			time.Sleep(1 * time.Second)
-
-			select {
-			case quitVal := <-c.sendCtl:
-				if quitVal == 1 {
-					c.queueMutex.Lock()
-					if len(c.queue) > 0 {
-						c.queueMutex.Unlock()
-						c.sendCtl <- 1
-						continue
-					}
-					c.queueMutex.Unlock()
-					c.sendCtl <- 2
-					return
-				}
-			default:
-			}
+	var w io.Writer = conn
+	var batchw *batchWriter
+	if transport == "tcp" && c.config.TCPBufferSize > 0 {
+		batchw = newBatchWriter(conn, c.config.TCPBufferSize, c.reportErr)
+		w = batchw
+	}
+
+	// A chunk size set from the interface's MTU fits in that rather than
+	// the configured one
+	autoSized := false
+	if transport == "udp" && c.config.AutoChunkSize {
+		size := c.autoChunkSize(conn)
+		if size > c.maxDatagramBytes() {
+			size = c.maxDatagramBytes()
+		}
+		if size > 0 {
+			c.logf("golf: using a chunk size of %d", size)
+			chunkSize = size
+			cfg.chunkSize = size
+			autoSized = true
+		}
+	}
+
+	if transport == "udp" && chunkSize > c.maxDatagramBytes() {
+		return nil, nil, cfg, ErrDatagramTooLarge
+	}
+
+	sinks := make([]Sink, numSenders)
+	for idx := range sinks {
+		s, err := c.newSender(w, transport, chunkSize)
+		if err != nil {
+			return nil, nil, cfg, err
+		}
+		sinks[idx] = s
+	}
+
+	if transport == "udp" && !autoSized && chunkSize > c.maxChunkSize(conn) {
+		if c.config.StrictMode {
+			return nil, nil, cfg, ErrChunkExceedsMTU
 		}
+		c.logf("golf: chunk size %d is larger than the MTU allows (%d), chunks will be fragmented",
+			chunkSize, c.maxChunkSize(conn))
+		c.reportErr(ErrChunkExceedsMTU)
 	}
+
+	return sinks, batchw, cfg, nil
 }
 
-func (c *Client) writeMsg(data string, w io.Writer, compression int) error {
-	defer c.chnk.Flush()
+// Switch the client's config to the one its new sinks were created for by
+// newSinks, once they've replaced the old ones
+func (c *Client) useSinkConfig(cfg sinkConfig) {
+	c.configMutex.Lock()
+	c.compression = cfg.wantCompression
+	c.config.Compression = cfg.compression
+	if cfg.chunkSize > 0 {
+		c.config.ChunkSize = cfg.chunkSize
+	}
+	c.configMutex.Unlock()
+}
 
-	switch compression {
-	case COMP_GZIP:
-		gz := c.gz.Get().(*gzip.Writer)
-		gz.Write([]byte(data))
-		gz.Close()
-		gz.Reset(c.chnk)
-		c.gz.Put(gz)
-	case COMP_ZLIB:
-		zz := c.zz.Get().(*zlib.Writer)
-		zz.Write([]byte(data))
-		zz.Close()
-		zz.Reset(c.chnk)
-		c.zz.Put(zz)
-	default:
-		c.chnk.Write([]byte(data))
+// The schemes supported by Dial and Use, and the transport each one uses,
+// which decides how messages are written to the connection: chunked for
+// "udp", null byte delimited for "tcp", or as syslog messages for "syslog".
+// The scheme, without any "syslog+" prefix, is used as the network for
+// net.Dial, so udp4/udp6 and tcp4/tcp6 force the IP version used. Any scheme
+// added here must also be one net.Dial supports.
+var schemeTransports = map[string]string{
+	"udp":         "udp",
+	"udp4":        "udp",
+	"udp6":        "udp",
+	"tcp":         "tcp",
+	"tcp4":        "tcp",
+	"tcp6":        "tcp",
+	"syslog+udp":  "syslog",
+	"syslog+udp4": "syslog",
+	"syslog+udp6": "syslog",
+}
+
+// What to do with a message queued while the queue is full, for
+// ClientConfig.QueueStrategy
+const (
+	QUEUE_DROP_NEWEST = iota // Overflow or drop the new message
+	QUEUE_BLOCK              // Wait until there's room for it
+	QUEUE_DROP_OLDEST        // Drop the oldest queued message to make room
+)
+
+// What to do with a message that needs chunking, returned by
+// ClientConfig.OnChunking
+const (
+	CHUNKING_SEND     = iota // Send it in chunks
+	CHUNKING_DROP            // Drop it
+	CHUNKING_FALLBACK        // Send it to the FallbackSink instead
+)
+
+// The compression for each value of the "compress" query in a Dial URI
+var compressionNames = map[string]int{
+	"none": COMP_NONE,
+	"zlib": COMP_ZLIB,
+	"gzip": COMP_GZIP,
+	"auto": COMP_AUTO,
+}
+
+// Get all of the URI schemes that can be used with Dial, such as "udp" and
+// "tcp6", in sorted order
+func SupportedSchemes() []string {
+	schemes := make([]string, 0, len(schemeTransports)+1)
+	for scheme := range schemeTransports {
+		schemes = append(schemes, scheme)
 	}
+	schemes = append(schemes, SRV_SCHEME)
+	sort.Strings(schemes)
+	return schemes
+}
 
-	return nil
+// Get all of the values the "compress" query of a Dial URI can have, such as
+// "gzip", in sorted order
+func SupportedCompressions() []string {
+	names := make([]string, 0, len(compressionNames))
+	for name := range compressionNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the index of the CompressionBuckets bucket for a message that's size
+// bytes long and the compression to send it with, or -1 and compression if
+// it's shorter than all of them
+func (c *Client) compressionBucket(size int, compression int) (int, int) {
+	buckets := c.config.CompressionBuckets
+	for idx := len(buckets) - 1; idx >= 0; idx-- {
+		if size >= buckets[idx].MinBytes {
+			return idx, buckets[idx].Compression
+		}
+	}
+	return -1, compression
+}
+
+// Check if messages sent with the transport can be compressed
+func (c *Client) canCompress(transport string) bool {
+	return transport == "udp" || (transport == "tcp" && c.config.TCPFraming != TCP_FRAME_NULL)
+}
+
+// Get the network to dial for a scheme
+func schemeNetwork(scheme string) string {
+	return strings.TrimPrefix(scheme, "syslog+")
+}
+
+// Get the transport ("udp" or "tcp") for a scheme. Returns an empty string if
+// it's not a supported scheme.
+func schemeTransport(scheme string) string {
+	return schemeTransports[scheme]
+}
+
+// Use a Sink to deliver messages instead of connecting to a GELF server. Queued
+// messages are serialized to GELF JSON and passed to the sink in batches of up
+// to DEFAULT_BATCH_SIZE messages by a single goroutine. Compression and
+// chunking aren't applied, the sink is responsible for any encoding needed by
+// whatever it's delivering the messages to.
+//
+// If the sink implements io.Closer it will be closed when the client is
+// closed. Returns ErrAlreadyConnected if the client is already connected or
+// using a Sink.
+func (c *Client) UseSink(sink Sink) error {
+	sinks := []Sink{sink}
+	c.connMutex.Lock()
+	if c.conn != nil || c.sink != nil {
+		c.connMutex.Unlock()
+		return ErrAlreadyConnected
+	}
+	c.sink = sink
+	c.sinks = sinks
+	close(c.connectedChan)
+	c.connMutex.Unlock()
+	c.logf("golf: using sink %T", sink)
+
+	c.start(sinks, DEFAULT_BATCH_SIZE)
+
+	return nil
+}
+
+func (c *Client) start(sinks []Sink, batchSize int) {
+	c.senderQuit = make(chan int)
+
+	c.startSenders(sinks, batchSize)
+	c.startHeartbeat()
+	c.startIdleProbe()
+	c.queueLifecycleMsg(c.config.StartedMessage, DEFAULT_STARTED_MESSAGE)
+}
+
+func (c *Client) startSenders(sinks []Sink, batchSize int) {
+	c.senderStop = make(chan int)
+	for _, sink := range sinks {
+		c.senderWg.Add(1)
+		go c.msgSender(sink, batchSize, c.senderStop)
+	}
+}
+
+// Errors returns a channel that errors encountered by the client while sending
+// messages in the background are reported to. Errors are dropped if the
+// channel is full, so it doesn't need to be read if the errors aren't wanted.
+func (c *Client) Errors() <-chan error {
+	return c.errChan
+}
+
+// Subscribe returns a new channel that every error reported to Errors() is
+// also reported to, so more than one part of a program can watch them, and a
+// function that unsubscribes it and closes the channel. Like Errors() each
+// subscriber's errors are dropped while its channel is full, a slow
+// subscriber doesn't hold up the client or the other subscribers.
+func (c *Client) Subscribe() (<-chan error, func()) {
+	sub := make(chan error, 100)
+
+	c.subscribersMutex.Lock()
+	c.subscribers = append(c.subscribers, sub)
+	c.subscribersMutex.Unlock()
+
+	unsubscribe := func() {
+		c.subscribersMutex.Lock()
+		defer c.subscribersMutex.Unlock()
+
+		for idx, subscriber := range c.subscribers {
+			if subscriber == sub {
+				c.subscribers = append(c.subscribers[:idx], c.subscribers[idx+1:]...)
+				close(sub)
+				return
+			}
+		}
+	}
+	return sub, unsubscribe
+}
+
+func (c *Client) reportErr(err error) {
+	c.stats.errored(err)
+
+	select {
+	case c.errChan <- err:
+	default:
+	}
+
+	c.subscribersMutex.RLock()
+	for _, sub := range c.subscribers {
+		select {
+		case sub <- err:
+		default:
+		}
+	}
+	c.subscribersMutex.RUnlock()
+}
+
+// Log that a message was dropped for 'reason', one of the DROP_* constants,
+// pass it to the DropAuditFunc and report ErrMessageDropped if StrictMode is
+// on
+func (c *Client) reportDropped(msg *Message, reason string) {
+	c.logf("golf: dropped message, %s", dropDescriptions[reason])
+	c.auditDrop(msg, reason)
+	if c.config.StrictMode {
+		c.reportErr(ErrMessageDropped)
+	}
+}
+
+// Pass a dropped message to the DropAuditFunc, if there is one. It's removed
+// from the WAL unless it was only dropped because the client stopped
+// sending, so it can be recovered.
+func (c *Client) auditDrop(msg *Message, reason string) {
+	if reason != DROP_ABORTED && reason != DROP_RECONNECT_FAILED {
+		c.removeFromWAL(msg)
+	}
+	if c.config.DropAuditFunc != nil {
+		c.config.DropAuditFunc(DropRecord{Msg: msg, Reason: reason, Time: time.Now()})
+	}
+}
+
+// Get the options messages are serialized with for the client's config
+func (c *Client) serializeOptions() serializeOptions {
+	c.errorFieldsMutex.RLock()
+	errorFields, errorLevel := c.errorFields, c.errorLevel
+	c.errorFieldsMutex.RUnlock()
+
+	return serializeOptions{
+		errorFields:         errorFields,
+		errorLevel:          errorLevel,
+		maxFieldBytes:       c.config.MaxFieldBytes,
+		maxBinaryFieldBytes: c.config.MaxBinaryFieldBytes,
+		defaultAttrs:        c.defaultAttrs,
+		fieldCollisions:     c.config.FieldCollisions,
+		fieldTypes:          c.config.FieldTypes,
+		fullMessageTemplate: c.config.FullMessageTemplate,
+		fieldPrefix:         c.config.FieldPrefix,
+		unencodable:         c.config.UnencodableFields,
+		reportErr:           c.reportErr,
+		largeIntsAsStrings:  c.config.LargeIntsAsStrings,
+		keyTransform:        c.config.FieldKeyTransform,
+		allowFields:         c.config.AllowFields,
+		denyFields:          c.config.DenyFields,
+		fieldsStripped:      &c.stats.stripped,
+		maxFields:           c.config.MaxFields,
+		fieldsTruncated:     &c.stats.fieldsTruncated,
+	}
+}
+
+// Log to the InternalLogger, if there is one
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.config.InternalLogger != nil {
+		c.config.InternalLogger.Printf(format, v...)
+	}
+}
+
+// Retrieve the size of the chunks udp messages are split into
+func (c *Client) ChunkSize() int {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	return c.config.ChunkSize
+}
+
+// Change the size of the chunks udp messages are split into, see
+// ClientConfig.ChunkSize, before or after the client is connected. If it's
+// already connected over udp each sender uses the new size from the next
+// message it sends, messages are never split into chunks of different sizes.
+// Returns ErrChunkTooSmall if it's less than 13 or ErrChunkTooLarge if it's
+// more than the largest UDP payload, 65507 bytes, or ErrDatagramTooLarge if
+// it's more than MaxDatagramBytes. MaxChunkSize gives the largest size that
+// won't be fragmented.
+func (c *Client) SetChunkSize(size int) error {
+	if size < 13 {
+		return ErrChunkTooSmall
+	}
+	if size > maxUDPPayload {
+		return ErrChunkTooLarge
+	}
+	if size > c.maxDatagramBytes() {
+		return ErrDatagramTooLarge
+	}
+
+	// Senders created from now on use the new size from the config
+	c.configMutex.Lock()
+	c.config.ChunkSize = size
+	c.configMutex.Unlock()
+
+	c.connMutex.Lock()
+	sinks := c.sinks
+	c.connMutex.Unlock()
+	for _, sink := range sinks {
+		if s, ok := sink.(*sender); ok {
+			s.setChunkSize(size)
+		}
+	}
+
+	return nil
+}
+
+// Retrieve the largest ChunkSize that can be used without the chunks being
+// fragmented at the IP layer, based on the configured MTU. IPv4 headers are
+// assumed if the client isn't connected yet.
+func (c *Client) MaxChunkSize() int {
+	return c.maxChunkSize(c.conn)
+}
+
+func (c *Client) maxChunkSize(conn net.Conn) int {
+	mtu := c.config.MTU
+	if mtu <= 0 {
+		mtu = DEFAULT_MTU
+	}
+	return chunkSizeForMTU(mtu, conn)
+}
+
+// Get the largest datagram that can be sent over udp
+func (c *Client) maxDatagramBytes() int {
+	if c.config.MaxDatagramBytes <= 0 {
+		return DEFAULT_MAX_DATAGRAM_BYTES
+	}
+	return c.config.MaxDatagramBytes
+}
+
+// Get the largest chunk size that fits in mtu for the connection
+func chunkSizeForMTU(mtu int, conn net.Conn) int {
+	headerSize := udp4HeaderSize
+	if conn != nil {
+		addr, ok := conn.RemoteAddr().(*net.UDPAddr)
+		if ok && addr.IP.To4() == nil {
+			headerSize = udp6HeaderSize
+		}
+	}
+
+	return mtu - headerSize
+}
+
+// The largest UDP payload that can be sent over IPv4
+const maxUDPPayload = 65507
+
+// Get the chunk size for AutoChunkSize, or 0 if the MTU isn't configured and
+// the connection's interface can't be found
+func (c *Client) autoChunkSize(conn net.Conn) int {
+	mtu := c.config.MTU
+	if mtu <= 0 {
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			return 0
+		}
+		mtu = interfaceMTU(addr.IP)
+		if mtu <= 0 {
+			return 0
+		}
+	}
+
+	size := chunkSizeForMTU(mtu, conn)
+	if size > maxUDPPayload {
+		size = maxUDPPayload
+	}
+	return size
+}
+
+// Get the MTU of the network interface with the address ip, or 0 if there
+// isn't one
+func interfaceMTU(ip net.IP) int {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && ipNet.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+	return 0
+}
+
+// Change the compression used for messages (COMP_NONE, COMP_GZIP, COMP_ZLIB
+// or COMP_AUTO) while the client is running. The new compression is used for
+// any messages sent after the call, messages that have already been sent or
+// are being sent when it's called use the previous compression.
+//
+// Messages sent to syslog, or over tcp with TCPFraming left as
+// TCP_FRAME_NULL, can't be compressed so only COMP_NONE is allowed for those
+// connections. Any other TCPFraming allows compression over tcp.
+func (c *Client) SetCompression(mode int) error {
+	switch mode {
+	case COMP_NONE, COMP_GZIP, COMP_ZLIB, COMP_AUTO:
+	default:
+		return ErrUnknownCompression
+	}
+
+	c.configMutex.Lock()
+	defer c.configMutex.Unlock()
+
+	if c.scheme != "" && !c.canCompress(c.scheme) && mode != COMP_NONE {
+		return ErrCompressionNotSupported
+	}
+	c.compression = mode
+	c.config.Compression = mode
+
+	return nil
+}
+
+// Change the compression level used for messages (1 for the fastest to 9 for
+// the best compression, or 0 for the default level) while the client is
+// running, to trade compression for speed as bandwidth allows. Like
+// SetCompression it only applies to messages sent after the call. Returns
+// ErrCompressionLevel for any other level.
+func (c *Client) SetCompressionLevel(level int) error {
+	if level < 0 || level > gzip.BestCompression {
+		return ErrCompressionLevel
+	}
+
+	c.configMutex.Lock()
+	c.config.CompressionLevel = level
+	c.configMutex.Unlock()
+
+	return nil
+}
+
+// Close the connection to the server. This call will block until all the
+// currently queued messages for the client are sent. Once it's called
+// queueing a message returns ErrClosing until it returns, and every message
+// that was queued successfully before then is sent.
+//
+// If any of the messages sent while closing fail, or closing the connection
+// or sink fails, a *CloseError is returned with the number of messages that
+// were and weren't sent so it's clear whether anything was lost.
+func (c *Client) Close() error {
+	c.rebindMutex.Lock()
+	defer c.rebindMutex.Unlock()
+	c.stopWaitingForRoom(true)
+	defer c.stopWaitingForRoom(false)
+
+	c.connMutex.Lock()
+	running := c.conn != nil || c.sink != nil
+	c.connMutex.Unlock()
+	if !running {
+		// Already shut down so it doesn't need to run again
+		c.setClosing(false)
+		return nil
+	}
+	c.stopHeartbeat()
+	c.flushRepeats()
+	c.queueLifecycleMsg(c.config.StoppingMessage, DEFAULT_STOPPING_MESSAGE)
+	sentBefore := atomic.LoadUint64(&c.stats.sent)
+	lostBefore := atomic.LoadUint64(&c.stats.failed) + atomic.LoadUint64(&c.stats.dropped)
+	exportedBefore := atomic.LoadUint64(&c.stats.exported)
+	c.setClosing(true)
+
+	// Everything queued is sent before closing, even if it was paused
+	c.Resume()
+
+	// Writes that fail while closing aren't retried, otherwise closing
+	// could block forever trying to reconnect
+	if rc, ok := c.conn.(*reconnConn); ok {
+		rc.stop()
+	}
+	c.stopIdleProbe()
+
+	// Then quit the senders once they've sent all the queued messages
+	// and wait for all of them to finish
+	close(c.senderQuit)
+	c.senderWg.Wait()
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	var err error
+	if c.batchw != nil {
+		err = c.batchw.Flush()
+		if err != nil {
+			c.reportErr(err)
+		}
+		c.batchw = nil
+	}
+	closeErr := err
+	if c.conn != nil {
+		err = c.conn.Close()
+	} else if closer, ok := c.sink.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if err != nil {
+		closeErr = err
+	}
+
+	sent := atomic.LoadUint64(&c.stats.sent) - sentBefore
+	lost := atomic.LoadUint64(&c.stats.failed) + atomic.LoadUint64(&c.stats.dropped) - lostBefore
+	exported := atomic.LoadUint64(&c.stats.exported) - exportedBefore
+	// The client's shut down even if closing the connection failed, so it
+	// can be closed again or reconnected
+	c.conn = nil
+	c.scheme = ""
+	c.sink = nil
+	c.connectedChan = make(chan int)
+	atomic.StoreInt32(&c.failed, 0)
+	c.setClosing(false)
+	c.logf("golf: closed")
+
+	if c.overflow != nil {
+		err = c.overflow.close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	if c.wal != nil {
+		err = c.wal.close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	if closeErr != nil || lost > 0 {
+		return &CloseError{Sent: sent, Lost: lost, Exported: exported, Err: closeErr}
+	}
+	return nil
+}
+
+// Abort shuts the client down immediately without sending what's still
+// queued, for shutdown deadlines that can't wait for Close to drain the queue.
+// The connection is closed first so a sender blocked writing to it gives up,
+// then the messages left in the queue are dropped and counted in Dropped in
+// the client's Stats. Messages buffered for TCPBufferSize aren't written and
+// ones in the overflow are left on disk. Like Close it's no longer paused
+// afterwards and can be connected again.
+func (c *Client) Abort() error {
+	c.rebindMutex.Lock()
+	defer c.rebindMutex.Unlock()
+	c.stopWaitingForRoom(true)
+	defer c.stopWaitingForRoom(false)
+
+	c.connMutex.Lock()
+	running := c.conn != nil || c.sink != nil
+	c.connMutex.Unlock()
+	if !running {
+		c.setClosing(false)
+		return nil
+	}
+	c.stopHeartbeat()
+	c.stopIdleProbe()
+	c.setClosing(true)
+
+	if rc, ok := c.conn.(*reconnConn); ok {
+		rc.stop()
+	}
+	// What's buffered for TCPBufferSize is dropped, and nothing written
+	// after it's stopped is buffered to be written to the closed conn
+	// later
+	if c.batchw != nil {
+		c.batchw.discard()
+	}
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+	} else if closer, ok := c.sink.(io.Closer); ok {
+		err = closer.Close()
+	}
+
+	// The senders give up on the queue once they've finished the batch
+	// they're sending, which fails now that the connection is closed
+	close(c.senderStop)
+	c.senderWg.Wait()
+
+	c.queueMutex.Lock()
+	dropped := c.queue
+	atomic.AddUint64(&c.stats.dropped, uint64(len(dropped)))
+	c.pending -= len(dropped)
+	c.crossedHighWater()
+	c.queue = make([]*Message, 0)
+	c.paused = false
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+	for _, msg := range dropped {
+		msg.resolve(ErrMessageDropped)
+		c.reportDropped(msg, DROP_ABORTED)
+	}
+
+	c.connMutex.Lock()
+	c.conn = nil
+	c.scheme = ""
+	c.sink = nil
+	c.batchw = nil
+	c.connectedChan = make(chan int)
+	c.connMutex.Unlock()
+	atomic.StoreInt32(&c.failed, 0)
+	c.setClosing(false)
+	c.logf("golf: aborted, dropped %d queued messages", len(dropped))
+
+	if c.overflow != nil {
+		closeErr := c.overflow.close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if c.wal != nil {
+		closeErr := c.wal.close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Block until all of the messages queued before the call have been sent,
+// including any in the overflow, then write any messages buffered for
+// TCPBufferSize. Returns ErrNotConnected if the client isn't connected since
+// the messages can't be sent until it is.
+func (c *Client) Flush() error {
+	if !c.Connected() {
+		return ErrNotConnected
+	}
+
+	c.queueMutex.Lock()
+	if c.paused {
+		c.queueMutex.Unlock()
+		return ErrPaused
+	}
+	for c.pending > 0 || (c.overflow != nil && !c.overflow.empty()) {
+		c.sentCond.Wait()
+	}
+	c.queueMutex.Unlock()
+
+	c.connMutex.Lock()
+	batchw := c.batchw
+	c.connMutex.Unlock()
+	if batchw != nil {
+		return batchw.Flush()
+	}
+
+	return nil
+}
+
+// Stop accepting new messages and wait for the messages that are already
+// queued to be sent, for shutting down in two steps without new messages
+// arriving while the client is closed. Once it's called queueing a message
+// returns ErrClosing until the client is closed, so it should be followed by
+// Close even if ctx is done first, in which case ctx.Err() is returned.
+//
+// Unlike calling Flush and then Close, no messages can be queued between the
+// two steps, and every message that was queued successfully before Drain was
+// called is sent before it returns.
+func (c *Client) Drain(ctx context.Context) error {
+	c.stopWaitingForRoom(true)
+	c.setClosing(true)
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- c.Flush()
+	}()
+
+	select {
+	case err := <-flushed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check if the client is currently connected to a server, or is using a Sink.
+// Returns false once the client has given up reconnecting to the server.
+func (c *Client) Connected() bool {
+	if c.hasFailed() {
+		return false
+	}
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	return c.conn != nil || c.sink != nil
+}
+
+func (c *Client) hasFailed() bool {
+	return atomic.LoadInt32(&c.failed) == 1
+}
+
+// Start or stop refusing new messages with ErrClosing. Once it returns after
+// starting, any messages that were accepted before it was called have been
+// queued.
+func (c *Client) setClosing(closing bool) {
+	c.acceptMutex.Lock()
+	c.closing = closing
+	c.acceptMutex.Unlock()
+}
+
+// Check if the client is closing
+func (c *Client) isClosing() bool {
+	c.acceptMutex.RLock()
+	defer c.acceptMutex.RUnlock()
+
+	return c.closing
+}
+
+// Get the error for queueing a message if the client isn't accepting new
+// messages, or nil if it is. A read lock of acceptMutex must be held until
+// the message is queued.
+func (c *Client) acceptErr() error {
+	if c.closing {
+		return ErrClosing
+	}
+	if c.hasFailed() {
+		return ErrReconnectFailed
+	}
+	return nil
+}
+
+// Give up sending messages after failing to reconnect, reporting err and
+// dropping everything that's queued
+func (c *Client) fail(err error) {
+	if !atomic.CompareAndSwapInt32(&c.failed, 0, 1) {
+		return
+	}
+	c.logf("golf: giving up sending messages: %v", err)
+	c.reportErr(err)
+	c.dropQueue()
+}
+
+// The largest number of messages returned by QueueSnapshot
+const maxQueueSnapshot = 1000
+
+// Get copies of the messages at the front of the queue waiting to be sent, up
+// to the first 1000, to see what's backed up when messages aren't being sent.
+// Messages being sent, or that have been queued but haven't reached the queue
+// yet, aren't included. The copies and their Attrs can be modified without
+// affecting the queued messages, but any values in Attrs are shared.
+func (c *Client) QueueSnapshot() []*Message {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+
+	count := len(c.queue)
+	if count > maxQueueSnapshot {
+		count = maxQueueSnapshot
+	}
+
+	snapshot := make([]*Message, count)
+	for idx, msg := range c.queue[:count] {
+		msgCopy := *msg
+		msgCopy.result = nil
+		if msg.Attrs != nil {
+			msgCopy.Attrs = make(map[string]interface{}, len(msg.Attrs))
+			for key, val := range msg.Attrs {
+				msgCopy.Attrs[key] = val
+			}
+		}
+		snapshot[idx] = &msgCopy
+	}
+	return snapshot
+}
+
+// Stop sending messages, without disconnecting, such as during a planned
+// maintenance window on the server. Messages keep being queued while the
+// client is paused, up to MaxQueueSize, and they're sent once Resume is
+// called. Flush returns ErrPaused while the client is paused instead of
+// waiting for it to be resumed, and Close resumes it so the queued messages
+// are sent before it closes. Messages the senders had already taken from the
+// queue are still sent.
+func (c *Client) Pause() {
+	c.queueMutex.Lock()
+	c.paused = true
+	c.queueMutex.Unlock()
+	c.logf("golf: paused sending")
+}
+
+// Start sending messages again after Pause, beginning with the ones queued
+// while the client was paused
+func (c *Client) Resume() {
+	c.queueMutex.Lock()
+	wasPaused := c.paused
+	c.paused = false
+	c.queueMutex.Unlock()
+
+	if wasPaused {
+		c.logf("golf: resumed sending")
+		c.signalQueue()
+	}
+}
+
+// Drop all of the messages in the queue without sending them
+func (c *Client) dropQueue() {
+	c.queueMutex.Lock()
+	dropped := c.queue
+	atomic.AddUint64(&c.stats.dropped, uint64(len(dropped)))
+	c.pending -= len(dropped)
+	c.crossedHighWater()
+	c.queue = make([]*Message, 0)
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+
+	exporting := c.config.CloseExportWriter != nil && c.isClosing()
+	opts := c.serializeOptions()
+	for _, msg := range dropped {
+		msg.resolve(ErrMessageDropped)
+		c.reportDropped(msg, DROP_RECONNECT_FAILED)
+		c.writeFallback(msg)
+		if exporting {
+			data, err := generateMsgJson(msg, opts)
+			if err == nil {
+				c.exportMsg(data)
+			}
+		}
+	}
+}
+
+// Drop the messages in the queue with a Timestamp more than d before now,
+// returning how many were dropped, to clear out a stale backlog after an
+// outage without losing the messages queued since. The messages left are
+// still sent in the order they were queued. Only messages waiting in memory
+// are checked, not ones that overflowed to disk or that are already being
+// sent. Dropped messages are counted in Dropped in the client's Stats.
+func (c *Client) DropOlderThan(d time.Duration) int {
+	cutoff := c.now().Add(-d)
+
+	c.queueMutex.Lock()
+	kept := make([]*Message, 0, len(c.queue))
+	dropped := make([]*Message, 0)
+	for _, msg := range c.queue {
+		if msg.Timestamp != nil && msg.Timestamp.Before(cutoff) {
+			dropped = append(dropped, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	c.queue = kept
+	atomic.AddUint64(&c.stats.dropped, uint64(len(dropped)))
+	c.pending -= len(dropped)
+	c.crossedHighWater()
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+
+	for _, msg := range dropped {
+		msg.resolve(ErrMessageDropped)
+		c.reportDropped(msg, DROP_OLDER_THAN)
+	}
+	return len(dropped)
+}
+
+// Write the GELF JSON of each message waiting in the queue to w followed by a
+// newline, in the order they'll be sent, returning how many were written, to
+// see what's stuck in the backlog while the client keeps running. The
+// messages are left in the queue. Like DropOlderThan only messages waiting in
+// memory are written, not ones that overflowed to disk or that are already
+// being sent. They're serialized while holding the queue's lock and written
+// once it's released, so a slow writer doesn't hold up queueing. Messages
+// that can't be serialized are left out and the first error serializing one
+// is returned after the others are written.
+func (c *Client) DumpQueue(w io.Writer) (int, error) {
+	opts := c.serializeOptions()
+
+	var buf bytes.Buffer
+	var serializeErr error
+	count := 0
+	c.queueMutex.Lock()
+	for _, msg := range c.queue {
+		data, err := serializeMsg(msg, opts)
+		if err != nil {
+			if serializeErr == nil {
+				serializeErr = err
+			}
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+		count++
+	}
+	c.queueMutex.Unlock()
+
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return count, serializeErr
+}
+
+// Block until fewer than 'depth' messages are queued, the QueueDepth in the
+// client's Stats, or until ctx is done, returning ctx.Err() if it's done
+// first. Producers can call it before queueing a burst of messages to keep
+// pace with the senders. It's woken each time a batch of messages finishes
+// sending rather than polling the queue.
+func (c *Client) WaitQueueBelow(ctx context.Context, depth int) error {
+	// Wake up the wait below when ctx is done
+	stop := make(chan int)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.queueMutex.Lock()
+			c.sentCond.Broadcast()
+			c.queueMutex.Unlock()
+		case <-stop:
+		}
+	}()
+
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	for c.pending >= depth {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.sentCond.Wait()
+	}
+	return nil
+}
+
+// Block until the client is connected to a server or ctx is done, returning
+// ctx.Err() if it's done first, or ErrReconnectFailed if the client has
+// given up reconnecting. Messages can be queued before the client is
+// connected, they're sent once it is.
+func (c *Client) WaitConnected(ctx context.Context) error {
+	c.connMutex.Lock()
+	connected := c.connectedChan
+	c.connMutex.Unlock()
+
+	select {
+	case <-connected:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if c.hasFailed() {
+		return ErrReconnectFailed
+	}
+	return nil
+}
+
+// Queue the given message at the end of the message queue
+func (c *Client) QueueMsg(msg *Message) error {
+	return c.queueMsg(msg, 1)
+}
+
+// Queue a copy of the given message with its TENANT_ATTR attribute set to
+// 'tenant'. The message itself and its attributes aren't modified, so the same
+// message can be queued for different tenants from many goroutines at once.
+func (c *Client) QueueMsgTagged(msg *Message, tenant string) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+
+	tagged := *msg
+	tagged.tenant = tenant
+	return c.queueMsg(&tagged, 1)
+}
+
+// A value copied from a message's context to one of its attributes, see
+// RegisterContextField
+type contextField struct {
+	key  interface{}
+	name string
+}
+
+// Add 'fields' to the messages sent with a level of 'level' or more severe,
+// such as LEVEL_ERR, when they're serialized. This keeps expensive detail
+// like build information out of everyday messages while still sending it
+// with the ones that matter. The message's own attributes and its logger's
+// override them. Each call replaces the fields from the last, a nil map
+// stops adding any.
+func (c *Client) SetErrorFields(level int, fields map[string]interface{}) {
+	var copied map[string]interface{}
+	if len(fields) > 0 {
+		copied = make(map[string]interface{}, len(fields))
+		for name, val := range fields {
+			copied[name] = val
+		}
+	}
+
+	c.errorFieldsMutex.Lock()
+	c.errorFields = copied
+	c.errorLevel = level
+	c.errorFieldsMutex.Unlock()
+}
+
+// Add the value for 'key' in the context of messages queued with
+// QueueMsgContext as their 'name' attribute, such as a request ID stored in a
+// request's context. Messages without a value for the key don't have the
+// attribute added. Registering the same key again changes the attribute its
+// value is added as.
+func (c *Client) RegisterContextField(key interface{}, name string) {
+	c.contextMutex.Lock()
+	defer c.contextMutex.Unlock()
+
+	for idx, field := range c.contextFields {
+		if field.key == key {
+			c.contextFields[idx].name = name
+			return
+		}
+	}
+	c.contextFields = append(c.contextFields, contextField{key: key, name: name})
+}
+
+// Queue a copy of the given message with an attribute added for each of the
+// fields registered with RegisterContextField that ctx has a value for. The
+// message's own attributes take precedence over ones from the context, and
+// neither the message nor its attributes are modified.
+func (c *Client) QueueMsgContext(ctx context.Context, msg *Message) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+
+	c.contextMutex.RLock()
+	attrs := make(map[string]interface{}, len(msg.Attrs)+len(c.contextFields))
+	for _, field := range c.contextFields {
+		if val := ctx.Value(field.key); val != nil {
+			attrs[field.name] = val
+		}
+	}
+	c.contextMutex.RUnlock()
+	for name, val := range msg.Attrs {
+		attrs[name] = val
+	}
+
+	withContext := *msg
+	withContext.Attrs = attrs
+	return c.queueMsg(&withContext, 1)
+}
+
+// Queue a copy of the given message and return a channel that receives the
+// result of sending it: nil once it's been written, or the error if it failed
+// to send or was dropped. Only one result is sent and the channel is buffered
+// so it doesn't need to be read. It's meant for the few messages where
+// delivery needs to be confirmed, QueueMsg doesn't have the overhead of
+// tracking the result.
+//
+// Messages sent to a Sink share the result of sending the batch they're in.
+func (c *Client) QueueMsgWithResult(msg *Message) <-chan error {
+	result := make(chan error, 1)
+	if msg == nil {
+		result <- ErrNilMessage
+		return result
+	}
+
+	err := c.acquireInFlight()
+	if err != nil {
+		result <- err
+		return result
+	}
+
+	withResult := *msg
+	withResult.result = result
+	withResult.inFlight = c.inFlight
+	err = c.queueMsg(&withResult, 1)
+	if err != nil {
+		withResult.resolve(err)
+	}
+	return result
+}
+
+// Wait for room for another message under MaxInFlight, which is released once
+// the message is resolved. Returns ErrTooManyInFlight if there's still no
+// room after MaxInFlightWait.
+func (c *Client) acquireInFlight() error {
+	if c.inFlight == nil {
+		return nil
+	}
+	if c.config.MaxInFlightWait <= 0 {
+		c.inFlight <- struct{}{}
+		return nil
+	}
+
+	timer := time.NewTimer(c.config.MaxInFlightWait)
+	defer timer.Stop()
+	select {
+	case c.inFlight <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrTooManyInFlight
+	}
+}
+
+// Queue the message, where skip is the number of stack frames between
+// queueMsg and the code that logged the message
+func (c *Client) queueMsg(msg *Message, skip int) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+	c.acceptMutex.RLock()
+	defer c.acceptMutex.RUnlock()
+	err := c.acceptErr()
+	if err != nil {
+		return err
+	}
+	if c.filteredOut(msg) {
+		msg.resolve(ErrMessageDropped)
+		return nil
+	}
+	if c.config.UnencodableFields == FIELD_ERR_FAIL_FAST {
+		err = unencodableField(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.setDefaults(msg, c.now())
+	if c.config.IncludeCaller {
+		c.setCaller(msg, skip+1)
+	}
+	if c.config.IncludeGoroutineID {
+		msg.goroutine = goroutineID()
+	}
+
+	suppressed, summary := c.suppressRepeat(msg)
+	if suppressed {
+		return nil
+	}
+	if summary != nil {
+		c.assignID(summary)
+		c.logToWAL(summary)
+	}
+	c.assignID(msg)
+	c.logToWAL(msg)
+	if sink := c.syncSink(); sink != nil {
+		if summary != nil {
+			c.sendBatch(sink, []*Message{summary})
+		}
+		c.sendBatch(sink, []*Message{msg})
+		return nil
+	}
+	if summary != nil {
+		// Errors queueing the summary are the same as the ones
+		// queueing msg would get, there's no need to report both
+		c.pushMsg(summary)
+	}
+	return c.pushMsg(msg)
+}
+
+// Give the message the next ID if the client has OnBatchSent set
+func (c *Client) assignID(msg *Message) {
+	if c.config.OnBatchSent != nil {
+		msg.id = atomic.AddUint64(&c.stats.lastID, 1)
+	}
+}
+
+// Write the message to the WAL, if the client has one
+func (c *Client) logToWAL(msg *Message) {
+	if c.wal == nil {
+		return
+	}
+	data, err := serializeMsg(msg, c.serializeOptions())
+	if err == nil {
+		msg.walID, err = c.wal.add(data)
+	}
+	if err != nil {
+		c.reportErr(err)
+	}
+}
+
+// Remove the message from the WAL once it's been sent, or won't ever be
+func (c *Client) removeFromWAL(msg *Message) {
+	if msg.walID != 0 {
+		c.wal.remove(msg.walID)
+		msg.walID = 0
+	}
+}
+
+// Queue the messages left in the WAL by the last client using WALPath that
+// it didn't send, in the order they were queued. They were already
+// filtered and given their defaults when they were first queued so they're
+// queued as they are, and sent as the GELF they were stored as unless
+// Transform, a Serializer, syslog or AddSequenceNumbers need them serialized
+// again. They stay in the WAL until they're sent. It
+// should be called once, when the client starts, and it does nothing if
+// WALPath isn't set. Returns the error if any of the messages in the WAL
+// aren't valid, after queueing the others.
+func (c *Client) RecoverWAL() error {
+	if c.wal == nil {
+		return nil
+	}
+	c.acceptMutex.RLock()
+	defer c.acceptMutex.RUnlock()
+	err := c.acceptErr()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, entry := range c.wal.takeRecovered() {
+		msg, parseErr := ParseMessage(entry.data)
+		if parseErr != nil {
+			c.wal.remove(entry.id)
+			if firstErr == nil {
+				firstErr = parseErr
+			}
+			continue
+		}
+		msg.walID = entry.id
+		msg.stored = entry.data
+		c.assignID(msg)
+		if sink := c.syncSink(); sink != nil {
+			c.sendBatch(sink, []*Message{msg})
+			continue
+		}
+		err = c.pushMsg(msg)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Put a message that's ready to send in the queue, or in the overflow if the
+// queue is full
+func (c *Client) pushMsg(msg *Message) error {
+	c.queueMutex.Lock()
+	if c.blocking() && !c.waitForRoom() {
+		c.queueMutex.Unlock()
+		c.refuseMsg(msg)
+		return ErrClosing
+	}
+	var evicted []*Message
+	if c.queueFull(1) && c.config.QueueStrategy == QUEUE_DROP_OLDEST {
+		evicted = c.evictOldest(c.pending + 1 - c.config.MaxQueueSize)
+	}
+	if c.queueFull(1) {
+		c.queueMutex.Unlock()
+		c.dropEvicted(evicted)
+		return c.overflowMsg(msg)
+	}
+	c.queue = append(c.queue, msg)
+	c.pending++
+	crossed := c.crossedHighWater()
+	c.queueMutex.Unlock()
+	c.dropEvicted(evicted)
+	if crossed {
+		c.warnHighWater()
+	}
+
+	if c.hasFailed() {
+		c.dropQueue()
+		return nil
+	}
+	c.signalQueue()
+	return nil
+}
+
+// The shortest time between warnings about the queue reaching its
+// QueueHighWaterMark
+const highWaterInterval = time.Second
+
+// Check if the queue has just reached QueueHighWaterMark and should be warned
+// about, and reset the warning if it's gone back below it. The queue mutex
+// must be held.
+func (c *Client) crossedHighWater() bool {
+	mark := c.config.QueueHighWaterMark
+	if mark <= 0 {
+		return false
+	}
+	if c.pending < mark {
+		c.aboveHighWater = false
+		return false
+	}
+	if c.aboveHighWater {
+		return false
+	}
+
+	c.aboveHighWater = true
+	now := time.Now()
+	if now.Sub(c.highWaterWarn) < highWaterInterval {
+		return false
+	}
+	c.highWaterWarn = now
+	return true
+}
+
+// Warn that the queue has reached QueueHighWaterMark
+func (c *Client) warnHighWater() {
+	c.logf("golf: queue reached its high water mark of %d messages", c.config.QueueHighWaterMark)
+	c.reportErr(ErrQueueHighWaterMark)
+}
+
+// Get the current time to use as the timestamp of messages from the Clock,
+// corrected by ClockOffset
+func (c *Client) now() time.Time {
+	if c.config.Clock != nil {
+		return c.config.Clock().Add(c.config.ClockOffset)
+	}
+	return time.Now().Add(c.config.ClockOffset)
+}
+
+// Set the defaults for anything msg doesn't have, with the timestamp from the
+// TimestampFunc or now, unless NoTimestamp is set. Messages keep their own
+// Hostname, such as the host a forwarder received them from, and ones
+// without one are sent with the client's.
+func (c *Client) setDefaults(msg *Message, now time.Time) {
+	if msg.Hostname == "" {
+		msg.Hostname = c.hostname
+	}
+	if (msg.Timestamp == nil || msg.Timestamp.IsZero()) && c.config.TimestampFunc != nil {
+		ts := c.config.TimestampFunc(msg)
+		if !ts.IsZero() {
+			msg.Timestamp = &ts
+		}
+	}
+	if c.config.NoTimestamp {
+		if msg.Timestamp != nil && msg.Timestamp.IsZero() {
+			msg.Timestamp = nil
+		}
+		msg.setVersion()
+		return
+	}
+	msg.setDefaults(now)
+}
+
+// Check if msg shouldn't be sent because it's less severe than MinLevel or it
+// wasn't sampled, counting it as dropped if it is
+func (c *Client) filteredOut(msg *Message) bool {
+	return c.belowMinLevel(msg) || c.sampledOut(msg)
+}
+
+// Check if messages are sampled by the client's config
+func (c *Client) sampling() bool {
+	return len(c.config.SampleRates) > 0 || c.config.DefaultSampleRate > 0
+}
+
+// Check if msg should be dropped by sampling, counting it as dropped if it is
+func (c *Client) sampledOut(msg *Message) bool {
+	if !c.sampling() {
+		return false
+	}
+	rate, ok := c.config.SampleRates[msg.Level]
+	if !ok {
+		rate = c.config.DefaultSampleRate
+		if rate == 0 {
+			rate = 1
+		}
+	}
+	if rate >= 1 || (rate > 0 && c.randFloat() < rate) {
+		return false
+	}
+
+	atomic.AddUint64(&c.stats.dropped, 1)
+	if msg.Level >= 0 && msg.Level <= LEVEL_DBG {
+		atomic.AddUint64(&c.stats.sampledOut[msg.Level], 1)
+	}
+	// It's not logged since it's expected to happen all the time
+	c.auditDrop(msg, DROP_SAMPLED)
+	return true
+}
+
+// Get a random number from 0 up to 1, from the RandSource if there is one
+func (c *Client) randFloat() float64 {
+	if c.randSource == nil {
+		return rand.Float64()
+	}
+
+	var buf [8]byte
+	_, err := io.ReadFull(c.randSource, buf[:])
+	if err != nil {
+		return rand.Float64()
+	}
+	// The top 53 bits, which is all a float64 can hold exactly
+	return float64(binary.BigEndian.Uint64(buf[:])>>11) / (1 << 53)
+}
+
+// Check if msg is less severe than MinLevel and shouldn't be sent, counting it
+// as dropped if it is
+func (c *Client) belowMinLevel(msg *Message) bool {
+	if c.config.MinLevel <= 0 || msg.Level <= c.config.MinLevel {
+		return false
+	}
+	atomic.AddUint64(&c.stats.dropped, 1)
+	// It's not logged since it's expected to happen all the time
+	c.auditDrop(msg, DROP_MIN_LEVEL)
+	return true
+}
+
+// Get the sink to send messages to from the goroutine queueing them, for
+// Synchronous, or nil if they should be queued
+func (c *Client) syncSink() Sink {
+	if !c.config.Synchronous {
+		return nil
+	}
+
+	c.queueMutex.Lock()
+	paused := c.paused
+	c.queueMutex.Unlock()
+	if paused {
+		return nil
+	}
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if (c.conn != nil || c.sink != nil) && len(c.sinks) > 0 {
+		return c.sinks[0]
+	}
+	return nil
+}
+
+// Check if queueing waits for room in a full queue, for BackpressureBlock or
+// QUEUE_BLOCK
+func (c *Client) blocking() bool {
+	return c.config.BackpressureBlock || c.config.QueueStrategy == QUEUE_BLOCK
+}
+
+// Remove up to count of the oldest messages waiting in the queue to make room
+// for new ones, for QUEUE_DROP_OLDEST, counting them as dropped. The queue
+// mutex must be held, and the messages returned passed to dropEvicted once
+// it's released.
+func (c *Client) evictOldest(count int) []*Message {
+	if count > len(c.queue) {
+		count = len(c.queue)
+	}
+	if count <= 0 {
+		return nil
+	}
+
+	evicted := make([]*Message, count)
+	copy(evicted, c.queue)
+	c.queue = c.queue[count:]
+	c.pending -= count
+	atomic.AddUint64(&c.stats.dropped, uint64(count))
+	return evicted
+}
+
+// Resolve the messages removed by evictOldest
+func (c *Client) dropEvicted(evicted []*Message) {
+	for _, msg := range evicted {
+		msg.resolve(ErrMessageDropped)
+		c.reportDropped(msg, DROP_EVICTED)
+		c.writeFallback(msg)
+	}
+}
+
+// Check if there isn't room in the queue for 'count' more messages. The queue
+// mutex must be held.
+func (c *Client) queueFull(count int) bool {
+	return c.config.MaxQueueSize > 0 && c.pending+count > c.config.MaxQueueSize
+}
+
+// Wait until there's room in the queue for another message, for
+// BackpressureBlock or QUEUE_BLOCK, or until the client gives up
+// reconnecting or starts closing. Returns false if there still isn't room
+// because it's closing. The queue mutex must be held.
+func (c *Client) waitForRoom() bool {
+	for c.queueFull(1) && !c.hasFailed() && !c.stopWaiting {
+		c.sentCond.Wait()
+	}
+	return !c.queueFull(1) || !c.stopWaiting
+}
+
+// Start or stop making messages waiting for room in the queue give up, see
+// stopWaiting
+func (c *Client) stopWaitingForRoom(stop bool) {
+	c.queueMutex.Lock()
+	c.stopWaiting = stop
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+}
+
+// Give up on queueing a message that was waiting for room in the queue
+// when the client started closing
+func (c *Client) refuseMsg(msg *Message) {
+	c.removeFromWAL(msg)
+	msg.resolve(ErrClosing)
+}
+
+// Write a message that doesn't fit in the queue to the overflow, or return
+// ErrQueueFull if it can't be
+func (c *Client) overflowMsg(msg *Message) error {
+	err := ErrQueueFull
+	if c.overflow != nil && msg.result == nil {
+		var data []byte
+		data, err = serializeMsg(msg, c.serializeOptions())
+		if err == nil {
+			err = c.overflow.push(data)
+		}
+		if err == nil {
+			// Once it's in the overflow it's on disk, and it comes back
+			// from there without its WAL entry
+			c.removeFromWAL(msg)
+		}
+	}
+
+	if err != nil {
+		atomic.AddUint64(&c.stats.dropped, 1)
+		c.logf("golf: dropped message, it didn't fit in the queue: %v", err)
+		c.auditDrop(msg, DROP_QUEUE_FULL)
+		c.writeFallback(msg)
+	}
+	return err
+}
+
+// Move the oldest messages in the overflow back into the queue, dropping any
+// that have been in the overflow for longer than OverflowMaxAge
+func (c *Client) refillFromOverflow() {
+	batch, err := c.overflow.pop()
+	if err != nil {
+		c.reportErr(err)
+	}
+
+	msgs := make([]*Message, 0, len(batch))
+	for _, data := range batch {
+		msg, err := ParseMessage(data)
+		if err != nil {
+			c.reportErr(err)
+			continue
+		}
+		if c.config.OverflowMaxAge > 0 && msg.Timestamp != nil &&
+			time.Since(*msg.Timestamp) > c.config.OverflowMaxAge {
+			atomic.AddUint64(&c.stats.dropped, 1)
+			c.reportDropped(msg, DROP_OVERFLOW_EXPIRED)
+			continue
+		}
+		msg.stored = data
+		msgs = append(msgs, msg)
+	}
+
+	c.queueMutex.Lock()
+	c.queue = append(c.queue, msgs...)
+	c.pending += len(msgs)
+	crossed := c.crossedHighWater()
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+	if crossed {
+		c.warnHighWater()
+	}
+}
+
+func (c *Client) setCaller(msg *Message, skip int) {
+	_, file, line, ok := runtime.Caller(skip + 1 + c.config.CallerSkip)
+	if !ok {
+		return
+	}
+	msg.callerFile = file
+	msg.callerLine = line
+}
+
+// Queue all of the given messages at the end of the message queue. The
+// messages are added to the queue together with a single lock of the queue
+// instead of locking it for each one, so this should be preferred when a
+// large number of messages are ready at once.
+//
+// The order of msgs is preserved, and none of the messages queued
+// concurrently with QueueMsg come between them. If any of the messages are
+// nil none of them are queued. If only some of the
+// messages fit in the queue the rest are overflowed or dropped as they would
+// be by QueueMsg.
+func (c *Client) QueueMsgs(msgs []*Message) error {
+	for _, msg := range msgs {
+		if msg == nil {
+			return ErrNilMessage
+		}
+	}
+	c.acceptMutex.RLock()
+	defer c.acceptMutex.RUnlock()
+	err := c.acceptErr()
+	if err != nil {
+		return err
+	}
+	if c.config.MinLevel > 0 || c.sampling() {
+		filtered := make([]*Message, 0, len(msgs))
+		for _, msg := range msgs {
+			if !c.filteredOut(msg) {
+				filtered = append(filtered, msg)
+			}
+		}
+		msgs = filtered
+	}
+	if c.config.UnencodableFields == FIELD_ERR_FAIL_FAST {
+		// Check them all first so none are queued if one can't be sent
+		for _, msg := range msgs {
+			err = unencodableField(msg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	curTime := c.now()
+	var goroutine uint64
+	if c.config.IncludeGoroutineID {
+		goroutine = goroutineID()
+	}
+	for _, msg := range msgs {
+		c.setDefaults(msg, curTime)
+		if c.config.IncludeCaller {
+			c.setCaller(msg, 1)
+		}
+		msg.goroutine = goroutine
+	}
+	if c.repeats != nil {
+		kept := make([]*Message, 0, len(msgs))
+		for _, msg := range msgs {
+			suppressed, summary := c.suppressRepeat(msg)
+			if summary != nil {
+				kept = append(kept, summary)
+			}
+			if !suppressed {
+				kept = append(kept, msg)
+			}
+		}
+		msgs = kept
+	}
+	for _, msg := range msgs {
+		c.assignID(msg)
+		c.logToWAL(msg)
+	}
+	if sink := c.syncSink(); sink != nil {
+		for _, msg := range msgs {
+			c.sendBatch(sink, []*Message{msg})
+		}
+		return nil
+	}
+
+	c.queueMutex.Lock()
+	fit := len(msgs)
+	refused := false
+	var evicted []*Message
+	if c.blocking() && c.config.MaxQueueSize > 0 {
+		// Queue as many as there's room for at a time, waiting for
+		// the senders to make room for the rest
+		fit = 0
+		for fit < len(msgs) && !c.hasFailed() {
+			if !c.waitForRoom() {
+				refused = true
+				break
+			}
+			room := c.config.MaxQueueSize - c.pending
+			if room <= 0 {
+				break
+			}
+			if room > len(msgs)-fit {
+				room = len(msgs) - fit
+			}
+			c.queue = append(c.queue, msgs[fit:fit+room]...)
+			c.pending += room
+			fit += room
+			c.signalQueue()
+		}
+	} else {
+		if c.config.MaxQueueSize > 0 && c.config.QueueStrategy == QUEUE_DROP_OLDEST {
+			evicted = c.evictOldest(c.pending + len(msgs) - c.config.MaxQueueSize)
+		}
+		if c.config.MaxQueueSize > 0 {
+			fit = c.config.MaxQueueSize - c.pending
+			if fit < 0 {
+				fit = 0
+			} else if fit > len(msgs) {
+				fit = len(msgs)
+			}
+		}
+		c.queue = append(c.queue, msgs[:fit]...)
+		c.pending += fit
+	}
+	crossed := c.crossedHighWater()
+	c.queueMutex.Unlock()
+	c.dropEvicted(evicted)
+	if crossed {
+		c.warnHighWater()
+	}
+	if c.hasFailed() {
+		c.dropQueue()
+	} else {
+		c.signalQueue()
+	}
+
+	if refused {
+		for _, msg := range msgs[fit:] {
+			c.refuseMsg(msg)
+		}
+		return ErrClosing
+	}
+	var firstErr error
+	for _, msg := range msgs[fit:] {
+		err := c.overflowMsg(msg)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Wake up a sender waiting for messages to be queued
+func (c *Client) signalQueue() {
+	select {
+	case c.queueSignal <- 1:
+	default:
+	}
 }
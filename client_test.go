@@ -0,0 +1,2414 @@
+package golf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestQueueMsgs(t sweet.T) {
+	c, err := NewClient()
+	Expect(err).To(BeNil())
+
+	ts := time.Unix(1440387554, 0)
+	msgs := []*Message{
+		newMessage(),
+		&Message{Timestamp: &ts},
+		newMessage(),
+	}
+
+	err = c.QueueMsgs(msgs)
+	Expect(err).To(BeNil())
+
+	Expect(c.queue).To(HaveLen(3))
+	Expect(c.queue[0]).To(BeIdenticalTo(msgs[0]))
+	Expect(c.queue[1]).To(BeIdenticalTo(msgs[1]))
+	Expect(c.queue[2]).To(BeIdenticalTo(msgs[2]))
+
+	Expect(msgs[0].Timestamp).ToNot(BeNil())
+	Expect(msgs[1].Timestamp).To(Equal(&ts))
+	Expect(msgs[2].Timestamp).ToNot(BeNil())
+	Expect(msgs[0].Timestamp).ToNot(BeIdenticalTo(msgs[2].Timestamp))
+}
+
+func benchmarkMsgs(count int) []*Message {
+	msgs := make([]*Message, count)
+	for idx := range msgs {
+		msgs[idx] = newMessage()
+	}
+	return msgs
+}
+
+func BenchmarkQueueMsg(b *testing.B) {
+	c, _ := NewClient()
+
+	msgs := benchmarkMsgs(1000)
+
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		for _, msg := range msgs {
+			c.QueueMsg(msg)
+		}
+
+		b.StopTimer()
+		c.queueMutex.Lock()
+		c.queue = c.queue[:0]
+		c.queueMutex.Unlock()
+		b.StartTimer()
+	}
+}
+
+// Queue messages from many goroutines at once, to measure contention on the
+// queue
+func BenchmarkQueueMsgParallel(b *testing.B) {
+	c, _ := NewClient()
+
+	ts := time.Now()
+	b.SetParallelism(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		msg := newMessage()
+		msg.Timestamp = &ts
+		for pb.Next() {
+			c.QueueMsg(msg)
+		}
+	})
+}
+
+func BenchmarkQueueMsgs(b *testing.B) {
+	c, _ := NewClient()
+
+	msgs := benchmarkMsgs(1000)
+
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		c.QueueMsgs(msgs)
+
+		b.StopTimer()
+		c.queueMutex.Lock()
+		c.queue = c.queue[:0]
+		c.queueMutex.Unlock()
+		b.StartTimer()
+	}
+}
+
+func (s *GolfSuite) TestUseTcp(t sweet.T) {
+	c, _ := NewClient()
+
+	client, server := net.Pipe()
+	err := c.Use(client, "tcp")
+	Expect(err).To(BeNil())
+	Expect(c.config.Compression).To(Equal(COMP_NONE))
+
+	l, _ := c.NewLogger()
+	l.Info("test message")
+
+	data, err := bufio.NewReader(server).ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(data[len(data)-1]).To(Equal(byte(0)))
+
+	var obj map[string]interface{}
+	err = json.Unmarshal(data[:len(data)-1], &obj)
+	Expect(err).To(BeNil())
+	Expect(obj["short_message"]).To(Equal("test message"))
+	Expect(obj["level"]).To(Equal(float64(LEVEL_INFO)))
+
+	err = c.Close()
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestUseAlreadyConnected(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.UseSink(&testSink{})).To(BeNil())
+	defer c.Close()
+
+	// Being turned away doesn't switch off compression for the tcp conn
+	client, _ := net.Pipe()
+	defer client.Close()
+	Expect(c.Use(client, "tcp")).To(Equal(ErrAlreadyConnected))
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+}
+
+func (s *GolfSuite) TestUseUnsupportedScheme(t sweet.T) {
+	c, _ := NewClient()
+
+	client, _ := net.Pipe()
+	err := c.Use(client, "http")
+	Expect(err).To(Equal(ErrUnsupportedScheme))
+	Expect(c.conn).To(BeNil())
+}
+
+func (s *GolfSuite) TestMaxChunkSize(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.MaxChunkSize()).To(Equal(DEFAULT_MTU - 28))
+
+	c, _ = NewClientWithConfig(ClientConfig{ChunkSize: 1420, MTU: 9000})
+	Expect(c.MaxChunkSize()).To(Equal(9000 - 28))
+}
+
+func (s *GolfSuite) TestUseChunkExceedsMTU(t sweet.T) {
+	conn, err := net.Dial("udp", "[::1]:12201")
+	if err != nil {
+		t.Skip("IPv6 is not available")
+	}
+
+	// 1460 fits in an IPv4 packet with a 1500 MTU but not in an IPv6 one
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1460})
+	err = c.Use(conn, "udp")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(c.MaxChunkSize()).To(Equal(DEFAULT_MTU - 48))
+	Expect(c.Errors()).To(Receive(Equal(ErrChunkExceedsMTU)))
+}
+
+func (s *GolfSuite) TestUseChunkWithinMTU(t sweet.T) {
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1460})
+	err = c.Use(conn, "udp")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(c.Errors()).ToNot(Receive())
+}
+
+func (s *GolfSuite) TestSetCompression(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+
+	c, _ := NewClient()
+	err = c.Use(conn, "udp")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	l, _ := c.NewLogger()
+	buf := make([]byte, 2048)
+	for _, mode := range []int{COMP_ZLIB, COMP_NONE, COMP_GZIP} {
+		err = c.SetCompression(mode)
+		Expect(err).To(BeNil())
+
+		l.Info("test message")
+
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+
+		payload := buf[12:n]
+		switch mode {
+		case COMP_GZIP:
+			Expect(payload[0:2]).To(Equal([]byte{0x1f, 0x8b}))
+		case COMP_ZLIB:
+			Expect(payload[0]).To(Equal(byte(0x78)))
+		default:
+			Expect(payload[0]).To(Equal(byte('{')))
+		}
+	}
+}
+
+func (s *GolfSuite) TestSetCompressionLevel(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+
+	c, _ := NewClient()
+	err = c.Use(conn, "udp")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(c.SetCompressionLevel(-1)).To(Equal(ErrCompressionLevel))
+	Expect(c.SetCompressionLevel(10)).To(Equal(ErrCompressionLevel))
+
+	// The gzip header's extra flags are 2 for the best compression and 4
+	// for the fastest
+	buf := make([]byte, 2048)
+	for level, xfl := range map[int]byte{9: 2, 1: 4, 0: 0} {
+		Expect(c.SetCompressionLevel(level)).To(BeNil())
+		c.Infof("test message")
+
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		Expect(n).To(BeNumerically(">", 20))
+		Expect(buf[12+8]).To(Equal(xfl))
+	}
+}
+
+func (s *GolfSuite) TestSetCompressionInvalid(t sweet.T) {
+	c, _ := NewClient()
+	err := c.SetCompression(42)
+	Expect(err).To(Equal(ErrUnknownCompression))
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+
+	client, _ := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	err = c.SetCompression(COMP_GZIP)
+	Expect(err).To(Equal(ErrCompressionNotSupported))
+	err = c.SetCompression(COMP_NONE)
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestUseTCPFrameLength(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+		TCPFraming:  TCP_FRAME_LENGTH,
+	})
+
+	client, server := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+	Expect(c.SetCompression(COMP_ZLIB)).To(BeNil())
+	Expect(c.SetCompression(COMP_GZIP)).To(BeNil())
+
+	c.Infof("test message")
+
+	header := make([]byte, 4)
+	_, err := io.ReadFull(server, header)
+	Expect(err).To(BeNil())
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(server, data)
+	Expect(err).To(BeNil())
+
+	Expect(data[0:2]).To(Equal([]byte{0x1f, 0x8b}))
+	msg, err := ParseMessage(data)
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("test message"))
+}
+
+func (s *GolfSuite) TestUseTCPFrameChecksum(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_ZLIB,
+		TCPFraming:  TCP_FRAME_CHECKSUM,
+	})
+
+	client, server := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	c.Infof("test message")
+
+	header := make([]byte, 4)
+	_, err := io.ReadFull(server, header)
+	Expect(err).To(BeNil())
+	data := make([]byte, binary.BigEndian.Uint32(header)+4)
+	_, err = io.ReadFull(server, data)
+	Expect(err).To(BeNil())
+
+	payload, trailer := data[:len(data)-4], data[len(data)-4:]
+	Expect(binary.BigEndian.Uint32(trailer)).To(Equal(crc32.ChecksumIEEE(payload)))
+	msg, err := ParseMessage(payload)
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("test message"))
+}
+
+func (s *GolfSuite) TestQueueMsgIncludeCaller(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		IncludeCaller: true,
+	})
+	l, _ := c.NewLogger()
+	DefaultLogger(l)
+
+	_, file, line, _ := runtime.Caller(0)
+	c.QueueMsg(l.NewMessage())
+	l.Info("test")
+	Info("test")
+	c.QueueMsgs([]*Message{l.NewMessage()})
+
+	msgs := c.queue
+	Expect(msgs).To(HaveLen(4))
+
+	for idx, msg := range msgs {
+		Expect(msg.callerFile).To(Equal(file))
+		Expect(msg.callerLine).To(Equal(line + idx + 1))
+
+		data, _ := generateMsgJson(msg, serializeOptions{})
+		Expect(data).To(ContainSubstring(fmt.Sprintf(`"_file":%q`, file)))
+		Expect(data).To(ContainSubstring(fmt.Sprintf(`"_line":%d`, line+idx+1)))
+	}
+}
+
+func (s *GolfSuite) TestQueueMsgCallerSkip(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		IncludeCaller: true,
+		CallerSkip:    1,
+	})
+
+	wrapper := func(msg *Message) {
+		c.QueueMsg(msg)
+	}
+
+	_, file, line, _ := runtime.Caller(0)
+	wrapper(newMessage())
+
+	Expect(c.queue).To(HaveLen(1))
+	msg := c.queue[0]
+	Expect(msg.callerFile).To(Equal(file))
+	Expect(msg.callerLine).To(Equal(line + 1))
+}
+
+func (s *GolfSuite) TestQueueMsgNoCaller(t sweet.T) {
+	c, _ := NewClient()
+	c.QueueMsg(newMessage())
+
+	Expect(c.queue).To(HaveLen(1))
+	Expect(c.queue[0].callerFile).To(Equal(""))
+}
+
+func (s *GolfSuite) TestSenderWriteMsgFlushError(t sweet.T) {
+	writeErr := errors.New("write failed")
+
+	chnk, _ := newChunker(newFailWriter(0, writeErr), 1420)
+	snd := newSenderForWriter(chnk)
+
+	for _, compression := range []int{COMP_NONE, COMP_GZIP, COMP_ZLIB} {
+		err := snd.writeMsg([]byte("{}"), compression, 0)
+		Expect(err).To(Equal(writeErr))
+	}
+}
+
+func (s *GolfSuite) TestSendErrorReported(t sweet.T) {
+	c, _ := NewClient()
+
+	client, server := net.Pipe()
+	server.Close()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	c.Infof("test message")
+
+	var err error
+	Eventually(c.Errors(), 5*time.Second).Should(Receive(&err))
+	sendErr, ok := err.(*SendError)
+	Expect(ok).To(BeTrue())
+	Expect(sendErr.Op).To(Equal(SEND_OP_WRITE))
+	Expect(sendErr.Err).To(Equal(io.ErrClosedPipe))
+	Expect(sendErr.Msg.ShortMessage).To(Equal("test message"))
+	Expect(sendErr.Error()).To(Equal(`failed to write message "test message": io: read/write on closed pipe`))
+}
+
+// Get the error a SendError wraps, or err itself if it isn't one
+func sendErrCause(err error) error {
+	if sendErr, ok := err.(*SendError); ok {
+		return sendErr.Err
+	}
+	return err
+}
+
+func (s *GolfSuite) TestQueueMsgTagged(t sweet.T) {
+	c, _ := NewClient()
+	l, _ := c.NewLogger()
+	l.SetAttr("tenant", "logger-tenant")
+
+	msg := l.NewMessage()
+	msg.ShortMessage = "shared"
+	msg.Attrs["attr1"] = "val1"
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			c.QueueMsgTagged(msg, fmt.Sprintf("tenant%d", idx))
+		}(idx)
+	}
+	wg.Wait()
+
+	Expect(msg.tenant).To(Equal(""))
+	Expect(msg.Timestamp).To(BeNil())
+
+	Expect(c.queue).To(HaveLen(10))
+	tenants := make([]string, 0)
+	for _, tagged := range c.queue {
+		Expect(tagged).ToNot(BeIdenticalTo(msg))
+		Expect(tagged.Timestamp).ToNot(BeNil())
+
+		data, _ := generateMsgJson(tagged, serializeOptions{})
+		Expect(data).To(ContainSubstring(`"_attr1":"val1"`))
+		Expect(data).To(ContainSubstring(fmt.Sprintf(`"_tenant":%q`, tagged.tenant)))
+		tenants = append(tenants, tagged.tenant)
+	}
+	Expect(tenants).To(ConsistOf(
+		"tenant0", "tenant1", "tenant2", "tenant3", "tenant4",
+		"tenant5", "tenant6", "tenant7", "tenant8", "tenant9"))
+}
+
+func (s *GolfSuite) TestQueueNilMessage(t sweet.T) {
+	c, _ := NewClient()
+
+	Expect(c.QueueMsg(nil)).To(Equal(ErrNilMessage))
+	Expect(c.QueueMsgTagged(nil, "tenant")).To(Equal(ErrNilMessage))
+	Expect(c.QueueMsgs([]*Message{newMessage(), nil})).To(Equal(ErrNilMessage))
+
+	Expect(c.queue).To(HaveLen(0))
+}
+
+type panicMarshaler struct{}
+
+func (pm panicMarshaler) MarshalJSON() ([]byte, error) {
+	panic("bad value")
+}
+
+func (s *GolfSuite) TestSerializationPanicRecovered(t sweet.T) {
+	c, _ := NewClient()
+
+	client, server := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	badMsg := newMessage()
+	badMsg.ShortMessage = "bad message"
+	badMsg.Attrs["bad"] = panicMarshaler{}
+	goodMsg := newMessage()
+	goodMsg.ShortMessage = "good message"
+	c.QueueMsgs([]*Message{badMsg, goodMsg})
+
+	data, err := bufio.NewReader(server).ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring("good message"))
+
+	var sendErr error
+	Eventually(c.Errors()).Should(Receive(&sendErr))
+	Expect(sendErr.Error()).To(ContainSubstring("bad value"))
+}
+
+func (s *GolfSuite) TestUnencodableFieldDropField(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	msg := newMessage()
+	msg.ShortMessage = "bad field"
+	msg.AddField("callback", func() {})
+	msg.AddField("user", "someone")
+	c.QueueMsg(msg)
+	c.Flush()
+
+	Expect(sink.batches).To(HaveLen(1))
+	parsed, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"callback": "[unencodable func()]",
+		"user":     "someone",
+	}))
+
+	var fieldErr error
+	Eventually(c.Errors()).Should(Receive(&fieldErr))
+	Expect(fieldErr.Error()).To(ContainSubstring(`field "callback" can't be encoded`))
+}
+
+func (s *GolfSuite) TestUnencodableFieldDropMessage(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		UnencodableFields: FIELD_ERR_DROP_MESSAGE,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	msg := newMessage()
+	msg.AddField("events", make(chan int))
+	result := c.QueueMsgWithResult(msg)
+	c.Flush()
+
+	var sendErr error
+	Expect(result).To(Receive(&sendErr))
+	Expect(sendErr).ToNot(BeNil())
+	Expect(sink.batches).To(BeEmpty())
+	Eventually(c.Errors()).Should(Receive())
+}
+
+func (s *GolfSuite) TestUnencodableFieldFailFast(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		UnencodableFields: FIELD_ERR_FAIL_FAST,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	bad := newMessage()
+	bad.AddFieldForLevels("events", make(chan int), LEVEL_INFO)
+	err := c.QueueMsg(bad)
+	Expect(err).ToNot(BeNil())
+	Expect(err.Error()).To(ContainSubstring(`field "events" can't be encoded`))
+
+	good := newMessage()
+	good.ShortMessage = "good"
+	Expect(c.QueueMsgs([]*Message{good, bad})).ToNot(BeNil())
+
+	Expect(c.Infof("fine")).To(BeNil())
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"fine"}))
+}
+
+// A connection that panics the first time it's written to
+type panicConn struct {
+	net.Conn
+	panicked bool
+}
+
+func (pc *panicConn) Write(p []byte) (int, error) {
+	if !pc.panicked {
+		pc.panicked = true
+		panic("write panicked")
+	}
+	return pc.Conn.Write(p)
+}
+
+func (s *GolfSuite) TestSenderPanicRecovered(t sweet.T) {
+	c, _ := NewClient()
+
+	client, server := net.Pipe()
+	c.Use(&panicConn{Conn: client}, "tcp")
+	defer c.Close()
+
+	c.QueueMsgs([]*Message{
+		&Message{ShortMessage: "first message"},
+		&Message{ShortMessage: "second message"},
+	})
+
+	var sendErr error
+	Eventually(c.Errors(), 5*time.Second).Should(Receive(&sendErr))
+	Expect(sendErr.Error()).To(ContainSubstring("write panicked"))
+
+	data, err := bufio.NewReader(server).ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring("second message"))
+	Expect(string(data)).ToNot(ContainSubstring("first message"))
+}
+
+func (s *GolfSuite) TestWaitConnected(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.Connected()).To(BeFalse())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.WaitConnected(ctx)
+	Expect(err).To(Equal(context.DeadlineExceeded))
+
+	waitErr := make(chan error)
+	go func() {
+		waitErr <- c.WaitConnected(context.Background())
+	}()
+	Consistently(waitErr).ShouldNot(Receive())
+
+	client, _ := net.Pipe()
+	c.Use(client, "tcp")
+	Eventually(waitErr).Should(Receive(BeNil()))
+	Expect(c.Connected()).To(BeTrue())
+
+	// It can't be connected again while it's connected
+	other, _ := net.Pipe()
+	defer other.Close()
+	Expect(c.Use(other, "tcp")).To(Equal(ErrAlreadyConnected))
+	Expect(c.UseSink(&testSink{})).To(Equal(ErrAlreadyConnected))
+
+	// Once it's given up reconnecting it isn't connected
+	atomic.StoreInt32(&c.failed, 1)
+	Expect(c.WaitConnected(context.Background())).To(Equal(ErrReconnectFailed))
+	atomic.StoreInt32(&c.failed, 0)
+
+	c.Close()
+	Expect(c.Connected()).To(BeFalse())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = c.WaitConnected(ctx)
+	Expect(err).To(Equal(context.DeadlineExceeded))
+}
+
+func (s *GolfSuite) TestDialIPVersionSchemes(t sweet.T) {
+	listener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClient()
+	err = c.Dial("udp4://" + listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+	Expect(c.scheme).To(Equal("udp"))
+	Expect(c.conn.RemoteAddr().Network()).To(Equal("udp"))
+	c.Close()
+
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer tcpListener.Close()
+
+	c, _ = NewClient()
+	err = c.Dial("tcp4://" + tcpListener.Addr().String())
+	Expect(err).To(BeNil())
+	Expect(c.scheme).To(Equal("tcp"))
+	Expect(c.config.Compression).To(Equal(COMP_NONE))
+	c.Close()
+
+	// An IPv4 address can't be reached with udp6
+	c, _ = NewClient()
+	err = c.Dial("udp6://" + listener.LocalAddr().String())
+	Expect(err).ToNot(BeNil())
+
+	err = c.Dial("udp5://" + listener.LocalAddr().String())
+	Expect(err).To(Equal(ErrUnsupportedScheme))
+}
+
+func (s *GolfSuite) TestFlush(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		SenderConcurrency: 4,
+	})
+	Expect(c.Flush()).To(Equal(ErrNotConnected))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+
+	for idx := 0; idx < 500; idx++ {
+		c.Infof("message %d", idx)
+	}
+	err := c.Flush()
+	Expect(err).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(500))
+
+	// Nothing is queued so it should return right away
+	err = c.Flush()
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestQueueMsgWithResult(t sweet.T) {
+	c, _ := NewClient()
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	result := c.QueueMsgWithResult(&Message{ShortMessage: "confirmed"})
+	Eventually(result).Should(Receive(BeNil()))
+
+	// Nil messages are never queued
+	result = c.QueueMsgWithResult(nil)
+	Expect(result).To(Receive(Equal(ErrNilMessage)))
+}
+
+func (s *GolfSuite) TestQueueMsgWithResultError(t sweet.T) {
+	sendErr := errors.New("send failed")
+
+	c, _ := NewClient()
+	c.UseSink(&testSink{err: sendErr})
+	defer c.Close()
+
+	result := c.QueueMsgWithResult(&Message{ShortMessage: "failed"})
+	Eventually(result).Should(Receive(Equal(sendErr)))
+}
+
+func (s *GolfSuite) TestQueueMsgWithResultDropped(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		Transform: func(msg *Message) *Message {
+			return nil
+		},
+	})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	msg := &Message{ShortMessage: "dropped"}
+	result := c.QueueMsgWithResult(msg)
+	Eventually(result).Should(Receive(Equal(ErrMessageDropped)))
+	// The result is tracked on a copy so the message isn't modified
+	Expect(msg.result).To(BeNil())
+}
+
+func (s *GolfSuite) TestStrictModeDial(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		StrictMode: true,
+	})
+	err := c.Dial("udp://127.0.0.1:12201?compress=lz4")
+	Expect(err).To(Equal(ErrUnknownCompression))
+
+	// Without strict mode it's ignored
+	c, _ = NewClient()
+	err = c.Dial("udp://127.0.0.1:12201?compress=lz4")
+	Expect(err).To(BeNil())
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+	c.Close()
+}
+
+func (s *GolfSuite) TestStrictModeChunkExceedsMTU(t sweet.T) {
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	Expect(err).To(BeNil())
+	defer conn.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1490,
+		StrictMode: true,
+	})
+	err = c.Use(conn, "udp")
+	Expect(err).To(Equal(ErrChunkExceedsMTU))
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestStrictModeTransformDropped(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		StrictMode: true,
+		Transform: func(msg *Message) *Message {
+			return nil
+		},
+	})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	c.Infof("dropped")
+	c.Flush()
+	Expect(c.Errors()).To(Receive(Equal(ErrMessageDropped)))
+}
+
+func (s *GolfSuite) TestSchemeTransport(t sweet.T) {
+	Expect(schemeTransport("udp")).To(Equal("udp"))
+	Expect(schemeTransport("udp4")).To(Equal("udp"))
+	Expect(schemeTransport("udp6")).To(Equal("udp"))
+	Expect(schemeTransport("tcp")).To(Equal("tcp"))
+	Expect(schemeTransport("tcp4")).To(Equal("tcp"))
+	Expect(schemeTransport("tcp6")).To(Equal("tcp"))
+	Expect(schemeTransport("syslog+udp")).To(Equal("syslog"))
+	Expect(schemeTransport("http")).To(Equal(""))
+	Expect(schemeTransport("")).To(Equal(""))
+}
+
+func (s *GolfSuite) TestDialSupportedSchemes(t sweet.T) {
+	for scheme, transport := range schemeTransports {
+		host := "127.0.0.1"
+		if strings.HasSuffix(scheme, "6") {
+			host = "[::1]"
+		}
+
+		var addr string
+		if transport != "tcp" {
+			listener, err := net.ListenPacket(schemeNetwork(scheme), host+":0")
+			if err != nil {
+				t.Logf("skipping %s: %s", scheme, err)
+				continue
+			}
+			defer listener.Close()
+			addr = listener.LocalAddr().String()
+		} else {
+			listener, err := net.Listen(scheme, host+":0")
+			if err != nil {
+				t.Logf("skipping %s: %s", scheme, err)
+				continue
+			}
+			defer listener.Close()
+			addr = listener.Addr().String()
+		}
+
+		c, _ := NewClient()
+		err := c.Dial(scheme + "://" + addr)
+		Expect(err).To(BeNil())
+		Expect(c.scheme).To(Equal(transport))
+		c.Close()
+	}
+}
+
+func (s *GolfSuite) TestAutoChunkSize(t sweet.T) {
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:        1420,
+		AutoChunkSize:    true,
+		StrictMode:       true,
+		MaxDatagramBytes: maxUDPPayload,
+	})
+	Expect(c.Use(conn, "udp")).To(BeNil())
+	defer c.Close()
+
+	mtu := interfaceMTU(net.ParseIP("127.0.0.1"))
+	if mtu <= 0 {
+		t.Skip("loopback interface not found")
+	}
+	expected := mtu - udp4HeaderSize
+	if expected > maxUDPPayload {
+		expected = maxUDPPayload
+	}
+	Expect(c.config.ChunkSize).To(Equal(expected))
+	Expect(c.sinks[0].(*sender).msgw.(*chunker).chunkSize).To(Equal(expected))
+}
+
+func (s *GolfSuite) TestAutoChunkSizeConfiguredMTU(t sweet.T) {
+	conn, err := net.Dial("udp", "[::1]:12201")
+	if err != nil {
+		t.Skip("no IPv6 loopback")
+	}
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     8192,
+		MTU:           1280,
+		AutoChunkSize: true,
+	})
+	Expect(c.Use(conn, "udp")).To(BeNil())
+	defer c.Close()
+
+	Expect(c.config.ChunkSize).To(Equal(1280 - udp6HeaderSize))
+}
+
+func (s *GolfSuite) TestAutoChunkSizeTCP(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		AutoChunkSize: true,
+	})
+	client, _ := net.Pipe()
+	Expect(c.Use(client, "tcp")).To(BeNil())
+	defer c.Close()
+
+	Expect(c.config.ChunkSize).To(Equal(1420))
+}
+
+func (s *GolfSuite) TestSetChunkSize(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_NONE,
+	})
+	Expect(c.SetChunkSize(12)).To(Equal(ErrChunkTooSmall))
+	Expect(c.SetChunkSize(65508)).To(Equal(ErrChunkTooLarge))
+	Expect(c.ChunkSize()).To(Equal(1420))
+
+	// Set before connecting
+	Expect(c.SetChunkSize(1000)).To(BeNil())
+	Expect(c.ChunkSize()).To(Equal(1000))
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+	Expect(c.Use(conn, "udp")).To(BeNil())
+	defer c.Close()
+
+	buf := make([]byte, 2048)
+	c.Infof("%s", strings.Repeat("x", 1500))
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(1000))
+	Expect(buf[11]).To(Equal(byte(2)))
+	listener.ReadFrom(buf)
+
+	// And changed while connected
+	Expect(c.SetChunkSize(500)).To(BeNil())
+	c.Infof("%s", strings.Repeat("x", 1500))
+	n, _, err = listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(n).To(Equal(500))
+	Expect(buf[11]).To(BeNumerically(">", 2))
+}
+
+func (s *GolfSuite) TestMaxDatagramBytes(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 9000})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(Equal(ErrDatagramTooLarge))
+	Expect(c.Connected()).To(BeFalse())
+
+	c, _ = NewClientWithConfig(ClientConfig{
+		ChunkSize:        1420,
+		MaxDatagramBytes: 1200,
+	})
+	Expect(c.SetChunkSize(1300)).To(Equal(ErrDatagramTooLarge))
+	Expect(c.ChunkSize()).To(Equal(1420))
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(Equal(ErrDatagramTooLarge))
+
+	Expect(c.SetChunkSize(1200)).To(BeNil())
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	// Only udp datagrams are limited
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer tcpListener.Close()
+	tcpClient, _ := NewClientWithConfig(ClientConfig{ChunkSize: 9000})
+	Expect(tcpClient.Dial("tcp://" + tcpListener.Addr().String())).To(BeNil())
+	tcpClient.Close()
+}
+
+func (s *GolfSuite) TestMaxDatagramBytesAutoChunkSize(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	// The loopback interface has a much larger MTU
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:        1420,
+		AutoChunkSize:    true,
+		MaxDatagramBytes: 4000,
+	})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+	Expect(c.ChunkSize()).To(Equal(4000))
+}
+
+func (s *GolfSuite) TestSupportedSchemes(t sweet.T) {
+	Expect(SupportedSchemes()).To(Equal([]string{
+		"gelf+srv",
+		"syslog+udp", "syslog+udp4", "syslog+udp6",
+		"tcp", "tcp4", "tcp6",
+		"udp", "udp4", "udp6",
+	}))
+	for _, scheme := range SupportedSchemes() {
+		host := "127.0.0.1"
+		if scheme == SRV_SCHEME {
+			host = "_gelf._udp.example.com"
+		}
+		Expect(ValidateURI(scheme+"://"+host)).To(BeNil(), scheme)
+	}
+}
+
+func (s *GolfSuite) TestSupportedCompressions(t sweet.T) {
+	Expect(SupportedCompressions()).To(Equal([]string{"auto", "gzip", "none", "zlib"}))
+	for _, name := range SupportedCompressions() {
+		Expect(ValidateURI("udp://127.0.0.1?compress="+name)).To(BeNil(), name)
+	}
+}
+
+func (s *GolfSuite) TestDialUnsupportedScheme(t sweet.T) {
+	c, _ := NewClient()
+	err := c.Dial("http://127.0.0.1:12201")
+	Expect(err).To(Equal(ErrUnsupportedScheme))
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestDialInvalidDSCP(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		DSCP:      64,
+	})
+	err := c.Dial("udp://127.0.0.1:12201")
+	Expect(err).To(Equal(ErrInvalidDSCP))
+}
+
+func (s *GolfSuite) TestValidateURI(t sweet.T) {
+	valid := []string{
+		"udp://127.0.0.1:12201",
+		"tcp://graylog.example.com",
+		"udp6://[::1]",
+		"udp6://[::1]:12201",
+		"syslog+udp://127.0.0.1",
+		"udp://127.0.0.1:12201?compress=gzip",
+		"gelf+srv://_gelf._tcp.example.com?compress=none",
+	}
+	for _, uri := range valid {
+		Expect(ValidateURI(uri)).To(BeNil(), uri)
+	}
+
+	invalid := map[string]error{
+		"http://127.0.0.1:12201":              ErrUnsupportedScheme,
+		"udp://":                              ErrMissingHost,
+		"udp://127.0.0.1:0":                   ErrInvalidPort,
+		"udp://127.0.0.1:70000":               ErrInvalidPort,
+		"udp://127.0.0.1:12201?compress=lz4":  ErrUnknownCompression,
+		"syslog+udp://127.0.0.1?compress=lz4": ErrUnknownCompression,
+		"gelf+srv://_gelf._sctp.example.com":  ErrInvalidSRVName,
+		"gelf+srv://example.com":              ErrInvalidSRVName,
+		"gelf+srv://_gelf._udp.example.com:1": ErrInvalidPort,
+		"gelf+srv://":                         ErrMissingHost,
+	}
+	for uri, expected := range invalid {
+		Expect(ValidateURI(uri)).To(Equal(expected), uri)
+	}
+}
+
+func (s *GolfSuite) TestDialInvalidPort(t sweet.T) {
+	c, _ := NewClient()
+	err := c.Dial("udp://127.0.0.1:0")
+	Expect(err).To(Equal(ErrInvalidPort))
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestDialLocalAddr(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	// Find a free port to send from
+	free, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	localAddr := free.LocalAddr().String()
+	free.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_NONE,
+		LocalAddr:   localAddr,
+	})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	c.Infof("from a fixed port")
+	buf := make([]byte, 1500)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	_, from, err := listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(from.String()).To(Equal(localAddr))
+
+	// Binding fails while the port's in use
+	other, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		LocalAddr: localAddr,
+	})
+	err = other.Dial("udp://" + listener.LocalAddr().String())
+	Expect(err).ToNot(BeNil())
+	Expect(err.Error()).To(ContainSubstring("address already in use"))
+	Expect(other.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestDialInvalidLocalAddr(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		LocalAddr: "not an address",
+	})
+	Expect(c.Dial("tcp://127.0.0.1:12201")).To(Equal(ErrInvalidLocalAddr))
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestQueueSnapshot(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.QueueSnapshot()).To(BeEmpty())
+
+	msgs := make([]*Message, 1500)
+	for idx := range msgs {
+		msgs[idx] = c.genMsg(LEVEL_INFO, "message %d", idx)
+	}
+	c.QueueMsgs(msgs)
+
+	snapshot := c.QueueSnapshot()
+	Expect(snapshot).To(HaveLen(maxQueueSnapshot))
+	Expect(snapshot[0].ShortMessage).To(Equal("message 0"))
+	Expect(snapshot[999].ShortMessage).To(Equal("message 999"))
+
+	// Changing the copies doesn't change what's queued
+	snapshot[0].ShortMessage = "changed"
+	snapshot[0].Attrs["added"] = true
+	Expect(msgs[0].ShortMessage).To(Equal("message 0"))
+	Expect(msgs[0].Attrs).ToNot(HaveKey("added"))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+	Expect(c.QueueSnapshot()).To(BeEmpty())
+}
+
+func (s *GolfSuite) TestDropOlderThan(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.DropOlderThan(time.Minute)).To(Equal(0))
+
+	old := time.Now().Add(-time.Hour)
+	c.QueueMsgs([]*Message{
+		{ShortMessage: "stale 1", Timestamp: &old},
+		{ShortMessage: "fresh 1"},
+		{ShortMessage: "stale 2", Timestamp: &old},
+		{ShortMessage: "fresh 2"},
+	})
+
+	Expect(c.DropOlderThan(time.Minute)).To(Equal(2))
+	Expect(c.Stats().QueueDepth).To(Equal(2))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"fresh 1", "fresh 2"}))
+	Expect(c.Stats().Dropped).To(Equal(uint64(2)))
+}
+
+func (s *GolfSuite) TestDumpQueue(t sweet.T) {
+	c, _ := NewClient()
+	var buf bytes.Buffer
+	Expect(c.DumpQueue(&buf)).To(Equal(0))
+	Expect(buf.Len()).To(BeZero())
+
+	c.QueueMsgs([]*Message{
+		{ShortMessage: "first"},
+		{ShortMessage: "second"},
+	})
+	count, err := c.DumpQueue(&buf)
+	Expect(err).To(BeNil())
+	Expect(count).To(Equal(2))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	Expect(lines).To(HaveLen(2))
+	for idx, text := range []string{"first", "second"} {
+		msg, err := ParseMessage([]byte(lines[idx]))
+		Expect(err).To(BeNil())
+		Expect(msg.ShortMessage).To(Equal(text))
+	}
+
+	// They're still sent afterwards
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"first", "second"}))
+}
+
+// An InternalLogger that keeps everything logged to it
+type testLogger struct {
+	linesMutex sync.Mutex
+	lines      []string
+}
+
+func (tl *testLogger) Printf(format string, v ...interface{}) {
+	tl.linesMutex.Lock()
+	defer tl.linesMutex.Unlock()
+
+	tl.lines = append(tl.lines, fmt.Sprintf(format, v...))
+}
+
+func (tl *testLogger) logged() []string {
+	tl.linesMutex.Lock()
+	defer tl.linesMutex.Unlock()
+
+	return append([]string{}, tl.lines...)
+}
+
+func (s *GolfSuite) TestInternalLogger(t sweet.T) {
+	tl := &testLogger{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		MaxQueueSize:   1,
+		InternalLogger: tl,
+		Transform: func(msg *Message) *Message {
+			return nil
+		},
+	})
+
+	c.Infof("queued")
+	c.Infof("queue full")
+	c.UseSink(&testSink{})
+	c.Flush()
+	c.Close()
+
+	Expect(tl.logged()).To(Equal([]string{
+		"golf: dropped message, it didn't fit in the queue: message queue is full",
+		"golf: using sink *golf.testSink",
+		"golf: dropped message, Transform returned nil",
+		"golf: closed",
+	}))
+}
+
+// A Sink that blocks sending until it's released
+type blockingSink struct {
+	testSink
+	release chan int
+}
+
+func (bs *blockingSink) Send(batch [][]byte) error {
+	<-bs.release
+	return bs.testSink.Send(batch)
+}
+
+func (s *GolfSuite) TestDrain(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+
+	for idx := 0; idx < 100; idx++ {
+		c.Infof("message %d", idx)
+	}
+	err := c.Drain(context.Background())
+	Expect(err).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(100))
+
+	Expect(c.Infof("too late")).To(Equal(ErrClosing))
+	Expect(c.QueueMsgs([]*Message{{ShortMessage: "too late"}})).To(Equal(ErrClosing))
+	Expect(c.Close()).To(BeNil())
+
+	// Messages are accepted again once it's closed
+	Expect(c.Infof("reopened")).To(BeNil())
+	c.UseSink(sink)
+	c.Close()
+	Expect(sink.messages()).To(HaveLen(101))
+}
+
+func (s *GolfSuite) TestDrainContextDone(t sweet.T) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClient()
+	c.UseSink(sink)
+
+	c.Infof("blocked")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Drain(ctx)
+	Expect(err).To(Equal(context.DeadlineExceeded))
+
+	close(sink.release)
+	Expect(c.Close()).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(1))
+}
+
+func (s *GolfSuite) TestCloseRefusesMessages(t sweet.T) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	c.Infof("blocked")
+
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	// Messages accepted before Close stopped accepting them are still sent
+	accepted := 1
+	Eventually(func() error {
+		err := c.Infof("while closing")
+		if err == nil {
+			accepted++
+		}
+		return err
+	}).Should(Equal(ErrClosing))
+
+	close(sink.release)
+	Eventually(closed).Should(Receive(BeNil()))
+	Expect(sink.messages()).To(HaveLen(accepted))
+}
+
+func (s *GolfSuite) TestCloseWhileQueueing(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+
+	var accepted, refused uint64
+	var stop int32
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for idx := 0; atomic.LoadInt32(&stop) == 0; idx++ {
+				var err error
+				if idx%2 == 0 {
+					err = c.Infof("goroutine %d message %d", g, idx)
+				} else {
+					err = c.QueueMsgs([]*Message{{ShortMessage: "batched"}})
+				}
+				switch err {
+				case nil:
+					atomic.AddUint64(&accepted, 1)
+				case ErrClosing:
+					atomic.AddUint64(&refused, 1)
+				default:
+					t.Errorf("unexpected error queueing: %v", err)
+				}
+				runtime.Gosched()
+			}
+		}(g)
+	}
+
+	Eventually(func() uint64 {
+		return atomic.LoadUint64(&accepted)
+	}).Should(BeNumerically(">", 100))
+	before := atomic.LoadUint64(&accepted)
+	Expect(c.Close()).To(BeNil())
+	Expect(len(sink.messages())).To(BeNumerically(">=", before))
+
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	// Anything accepted after the client closed is sent once it's used
+	// again, nothing accepted is lost
+	c.UseSink(sink)
+	Expect(c.Close()).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(int(atomic.LoadUint64(&accepted))))
+}
+
+func (s *GolfSuite) TestClientMaxFieldBytes(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		MaxFieldBytes: 8,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	msg := c.genMsg(LEVEL_INFO, "message")
+	msg.AddField("body", "0123456789")
+	c.QueueMsg(msg)
+	c.Flush()
+
+	parsed, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(parsed.Attrs["body"]).To(Equal("01234..."))
+	Expect(parsed.Attrs[FIELD_TRUNCATED_ATTR]).To(Equal(true))
+}
+
+func (s *GolfSuite) TestClientAddProcessFields(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:        1420,
+		AddProcessFields: true,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("first")
+	overridden := c.genMsg(LEVEL_INFO, "second")
+	overridden.AddField(PID_ATTR, "other")
+	c.QueueMsg(overridden)
+	c.Flush()
+
+	msgs := make([]*Message, 0)
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			msg, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			msgs = append(msgs, msg)
+		}
+	}
+	Expect(msgs).To(HaveLen(2))
+
+	Expect(msgs[0].Attrs[PID_ATTR]).To(Equal(float64(os.Getpid())))
+	start, err := time.Parse(time.RFC3339Nano, msgs[0].Attrs[PROCESS_START_ATTR].(string))
+	Expect(err).To(BeNil())
+	Expect(start.Equal(processStart)).To(BeTrue())
+
+	Expect(msgs[1].Attrs[PID_ATTR]).To(Equal("other"))
+	Expect(msgs[1].Attrs).To(HaveKey(PROCESS_START_ATTR))
+}
+
+func (s *GolfSuite) TestClientResourceAttributes(t sweet.T) {
+	resource := map[string]interface{}{
+		"service.name":           "checkout",
+		"service.version":        "1.2.3",
+		"deployment.environment": "production",
+		"k8s pod/name":           "checkout-7d9f",
+	}
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		ResourceAttributes: resource,
+		AddProcessFields:   true,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	overridden := c.genMsg(LEVEL_INFO, "overridden")
+	overridden.AddField("service_version", "2.0.0")
+	c.QueueMsg(overridden)
+	c.Flush()
+
+	msg, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(msg.Attrs).To(HaveKeyWithValue("service_name", "checkout"))
+	Expect(msg.Attrs).To(HaveKeyWithValue("service_version", "2.0.0"))
+	Expect(msg.Attrs).To(HaveKeyWithValue("deployment_environment", "production"))
+	Expect(msg.Attrs).To(HaveKeyWithValue("k8s_pod_name", "checkout-7d9f"))
+	Expect(msg.Attrs).To(HaveKey(PID_ATTR))
+}
+
+func (s *GolfSuite) TestClientResourceAttributesKeepDots(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		ResourceAttributes: map[string]interface{}{"service.name": "checkout"},
+		KeepResourceDots:   true,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("kept")
+	c.Flush()
+
+	msg, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{"service.name": "checkout"}))
+}
+
+func (s *GolfSuite) TestClientNoProcessFields(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(string(sink.batches[0][0])).ToNot(ContainSubstring(`"_pid"`))
+}
+
+func (s *GolfSuite) TestClientQueueHighWaterMark(t sweet.T) {
+	logger := &testLogger{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		QueueHighWaterMark: 2,
+		InternalLogger:     logger,
+	})
+
+	c.Infof("first")
+	Consistently(c.Errors()).ShouldNot(Receive())
+	c.Infof("second")
+	Eventually(c.Errors()).Should(Receive(Equal(ErrQueueHighWaterMark)))
+	Expect(logger.logged()).To(ContainElement(ContainSubstring("high water mark of 2")))
+
+	// Only warned once while it's above the mark
+	c.QueueMsgs([]*Message{newMessage(), newMessage()})
+	Consistently(c.Errors()).ShouldNot(Receive())
+
+	c.UseSink(&testSink{})
+	defer c.Close()
+	c.Flush()
+
+	// Warned again after going back below the mark, once enough time has
+	// passed since the last warning
+	c.queueMutex.Lock()
+	Expect(c.aboveHighWater).To(BeFalse())
+	c.highWaterWarn = time.Time{}
+	c.queueMutex.Unlock()
+	c.QueueMsgs([]*Message{newMessage(), newMessage()})
+	Eventually(c.Errors()).Should(Receive(Equal(ErrQueueHighWaterMark)))
+}
+
+func (s *GolfSuite) TestClientMinLevel(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		MinLevel:  LEVEL_WARN,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	Expect(c.Errf("error")).To(BeNil())
+	Expect(c.Warnf("warning")).To(BeNil())
+	Expect(c.Noticef("notice")).To(BeNil())
+	Expect(c.Dbgf("debug")).To(BeNil())
+
+	emerg := &Message{Level: LEVEL_EMERG, ShortMessage: "emergency"}
+	info := &Message{Level: LEVEL_INFO, ShortMessage: "info"}
+	Expect(c.QueueMsgs([]*Message{emerg, info})).To(BeNil())
+
+	result := c.QueueMsgWithResult(&Message{Level: LEVEL_NOTICE, ShortMessage: "notice"})
+	Expect(result).To(Receive(Equal(ErrMessageDropped)))
+
+	c.Flush()
+	Expect(sink.messages()).To(ConsistOf("error", "warning", "emergency"))
+	Expect(c.Stats().Dropped).To(Equal(uint64(4)))
+}
+
+func (s *GolfSuite) TestClientClockOffset(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		ClockOffset: -time.Hour,
+	})
+
+	before := time.Now()
+	queued := &Message{ShortMessage: "no timestamp"}
+	c.QueueMsg(queued)
+	Expect(queued.Timestamp.Before(before.Add(-59 * time.Minute))).To(BeTrue())
+	Expect(queued.Timestamp.After(before.Add(-61 * time.Minute))).To(BeTrue())
+
+	batched := &Message{ShortMessage: "no timestamp"}
+	c.QueueMsgs([]*Message{batched})
+	Expect(batched.Timestamp.Before(before.Add(-59 * time.Minute))).To(BeTrue())
+
+	// Timestamps that were set aren't corrected
+	ts := time.Unix(1500000000, 0)
+	explicit := &Message{ShortMessage: "timestamp", Timestamp: &ts}
+	c.QueueMsg(explicit)
+	Expect(*explicit.Timestamp).To(Equal(time.Unix(1500000000, 0)))
+}
+
+func (s *GolfSuite) TestClientClock(t sweet.T) {
+	reference := time.Unix(1600000000, 0)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		ClockOffset: time.Second,
+		Clock: func() time.Time {
+			return reference
+		},
+	})
+
+	queued := &Message{ShortMessage: "no timestamp"}
+	c.QueueMsg(queued)
+	Expect(*queued.Timestamp).To(Equal(reference.Add(time.Second)))
+
+	batched := &Message{ShortMessage: "no timestamp"}
+	c.QueueMsgs([]*Message{batched})
+	Expect(*batched.Timestamp).To(Equal(reference.Add(time.Second)))
+
+	// Timestamps that were set don't come from the clock
+	ts := time.Unix(1500000000, 0)
+	explicit := &Message{ShortMessage: "timestamp", Timestamp: &ts}
+	c.QueueMsg(explicit)
+	Expect(*explicit.Timestamp).To(Equal(time.Unix(1500000000, 0)))
+}
+
+// Receive the short messages of everything sent to a tcp listener
+func receiveTCP(listener net.Listener) chan string {
+	received := make(chan string, 1000)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				r := bufio.NewReader(conn)
+				for {
+					data, err := r.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					msg, _ := ParseMessage(data[:len(data)-1])
+					received <- msg.ShortMessage
+				}
+			}()
+		}
+	}()
+	return received
+}
+
+func (s *GolfSuite) TestRebind(t sweet.T) {
+	oldListener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer oldListener.Close()
+	newListener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer newListener.Close()
+	oldReceived := receiveTCP(oldListener)
+	newReceived := receiveTCP(newListener)
+
+	c, _ := NewClient()
+	Expect(c.Dial("tcp://" + oldListener.Addr().String())).To(BeNil())
+	defer c.Close()
+	oldConn := c.conn
+
+	msgs := make([]*Message, 500)
+	for idx := range msgs {
+		msgs[idx] = c.genMsg(LEVEL_INFO, "message %d", idx)
+	}
+	c.QueueMsgs(msgs)
+	err = c.Rebind(context.Background(), "tcp://"+newListener.Addr().String())
+	Expect(err).To(BeNil())
+	Expect(c.conn).ToNot(BeIdenticalTo(oldConn))
+	c.Infof("after rebind")
+	c.Flush()
+
+	// Every message is sent to one of them, and everything after the
+	// switch goes to the new one
+	seen := make(map[string]bool)
+	Eventually(func() int {
+		for {
+			select {
+			case msg := <-oldReceived:
+				seen[msg] = true
+			case msg := <-newReceived:
+				seen[msg] = true
+			default:
+				return len(seen)
+			}
+		}
+	}).Should(Equal(501))
+	Expect(seen).To(HaveKey("after rebind"))
+	Expect(c.Stats().Sent).To(Equal(uint64(501)))
+}
+
+func (s *GolfSuite) TestRebindFails(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+
+	err := c.Rebind(context.Background(), "udp://127.0.0.1:12201")
+	Expect(err).To(Equal(ErrNotConnected))
+
+	c.UseSink(sink)
+	defer c.Close()
+
+	err = c.Rebind(context.Background(), "http://127.0.0.1:12201")
+	Expect(err).To(Equal(ErrUnsupportedScheme))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.Rebind(ctx, "tcp://127.0.0.1:1?compress=zlib")
+	Expect(err).ToNot(BeNil())
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+
+	// Still sending to the sink it was using
+	c.Infof("still sent")
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"still sent"}))
+	Expect(sink.closed).To(BeFalse())
+}
+
+func (s *GolfSuite) TestRebindRestoresCompression(t sweet.T) {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer udpConn.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	receiveTCP(listener)
+
+	c, _ := NewClient()
+	Expect(c.Dial("udp://" + udpConn.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+
+	Expect(c.Rebind(context.Background(), "tcp://"+listener.Addr().String())).To(BeNil())
+	Expect(c.config.Compression).To(Equal(COMP_NONE))
+
+	// Switching back to udp compresses messages again
+	Expect(c.Rebind(context.Background(), "udp://"+udpConn.LocalAddr().String())).To(BeNil())
+	Expect(c.config.Compression).To(Equal(COMP_GZIP))
+}
+
+func (s *GolfSuite) TestRebindFromSink(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	received := receiveTCP(listener)
+
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	Expect(c.Rebind(context.Background(), "tcp://"+listener.Addr().String())).To(BeNil())
+	Expect(sink.closed).To(BeTrue())
+	c.Infof("to the server")
+	Eventually(received).Should(Receive(Equal("to the server")))
+}
+
+func (s *GolfSuite) TestClientTimestampFunc(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		TimestampFunc: func(msg *Message) time.Time {
+			eventTime, _ := msg.Attrs["event_time"].(string)
+			ts, _ := time.Parse(time.RFC3339, eventTime)
+			return ts
+		},
+	})
+
+	forwarded := (&Message{ShortMessage: "forwarded"}).AddField("event_time", "2017-07-14T02:40:00Z")
+	c.QueueMsg(forwarded)
+	Expect(*forwarded.Timestamp).To(Equal(time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)))
+
+	batched := (&Message{ShortMessage: "batched"}).AddField("event_time", "2018-01-02T03:04:05Z")
+	c.QueueMsgs([]*Message{batched})
+	Expect(*batched.Timestamp).To(Equal(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	// The current time is used when it returns the zero time
+	before := time.Now()
+	noTime := &Message{ShortMessage: "no event time"}
+	c.QueueMsg(noTime)
+	Expect(noTime.Timestamp.Before(before)).To(BeFalse())
+
+	// And it's not called for messages that already have a timestamp
+	ts := time.Unix(1500000000, 0)
+	explicit := (&Message{ShortMessage: "explicit", Timestamp: &ts}).AddField("event_time", "2017-07-14T02:40:00Z")
+	c.QueueMsg(explicit)
+	Expect(*explicit.Timestamp).To(Equal(time.Unix(1500000000, 0)))
+}
+
+func (s *GolfSuite) TestClientZeroTimestamp(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	before := time.Now()
+	zero := time.Time{}
+	msg := &Message{ShortMessage: "zero time", Timestamp: &zero}
+	Expect(c.QueueMsg(msg)).To(BeNil())
+	c.Flush()
+
+	parsed, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(parsed.Timestamp.Before(before.Truncate(time.Millisecond))).To(BeFalse())
+	Expect(parsed.Timestamp.Year()).ToNot(Equal(1))
+
+	// The caller's time isn't changed, only the message's pointer to it
+	Expect(zero.IsZero()).To(BeTrue())
+}
+
+func (s *GolfSuite) TestClientMessageHostname(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.QueueMsg(&Message{ShortMessage: "forwarded", Hostname: "source.example.com"})
+	c.QueueMsg(&Message{ShortMessage: "local"})
+	c.Flush()
+
+	hosts := make([]string, 0)
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			msg, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			hosts = append(hosts, msg.Hostname)
+		}
+	}
+	Expect(hosts).To(Equal([]string{"source.example.com", c.hostname}))
+}
+
+func (s *GolfSuite) TestClientNoTimestamp(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		NoTimestamp: true,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	ts := time.Unix(1500000000, 0)
+	zero := time.Time{}
+	c.QueueMsg(&Message{ShortMessage: "no timestamp"})
+	c.QueueMsg(&Message{ShortMessage: "zero timestamp", Timestamp: &zero})
+	c.QueueMsg(&Message{ShortMessage: "timestamp", Timestamp: &ts})
+	c.Flush()
+
+	sink.batchesMutex.Lock()
+	defer sink.batchesMutex.Unlock()
+	sent := make([]map[string]interface{}, 0)
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			var obj map[string]interface{}
+			Expect(json.Unmarshal(data, &obj)).To(BeNil())
+			sent = append(sent, obj)
+		}
+	}
+	Expect(sent).To(HaveLen(3))
+	Expect(sent[0]).ToNot(HaveKey("timestamp"))
+	Expect(sent[1]).ToNot(HaveKey("timestamp"))
+	Expect(sent[2]).To(HaveKeyWithValue("timestamp", float64(1500000000)))
+}
+
+// A Sink that blocks each batch until it's released, and fails to close
+type closeFailSink struct {
+	blockingSink
+	closeErr error
+}
+
+func (cs *closeFailSink) Close() error {
+	cs.closed = true
+	return cs.closeErr
+}
+
+// Close c once it's blocked sending to sink, returning the error from Close
+func closeBlocked(c *Client, release chan int) error {
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	// Once it's refusing messages everything queued is sent while closing
+	Eventually(func() bool {
+		c.acceptMutex.RLock()
+		defer c.acceptMutex.RUnlock()
+		return c.closing
+	}).Should(BeTrue())
+	close(release)
+	return <-closed
+}
+
+func (s *GolfSuite) TestCloseError(t sweet.T) {
+	closeErr := errors.New("close failed")
+	sink := &closeFailSink{
+		blockingSink: blockingSink{release: make(chan int)},
+		closeErr:     closeErr,
+	}
+	c, _ := NewClient()
+	c.UseSink(sink)
+
+	// Everything was sent but the sink didn't close
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}})
+	err := closeBlocked(c, sink.release)
+	Expect(err).To(Equal(&CloseError{Sent: 2, Lost: 0, Err: closeErr}))
+	Expect(err.Error()).To(Equal("sent 2 messages while closing, 0 were lost: close failed"))
+	Expect(sink.closed).To(BeTrue())
+
+	// It's still shut down
+	Expect(c.Connected()).To(BeFalse())
+	Expect(c.Close()).To(BeNil())
+}
+
+func (s *GolfSuite) TestCloseErrorLostMessages(t sweet.T) {
+	sink := &closeFailSink{blockingSink: blockingSink{release: make(chan int)}}
+	sink.err = errors.New("send failed")
+	c, _ := NewClient()
+	c.UseSink(sink)
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}})
+	err := closeBlocked(c, sink.release)
+	Expect(err).To(Equal(&CloseError{Sent: 0, Lost: 2}))
+	Expect(err.Error()).To(Equal("sent 0 messages while closing, 2 were lost"))
+	Expect(sink.closed).To(BeTrue())
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestCloseExportWriter(t sweet.T) {
+	export := &syncBuffer{}
+	sink := &closeFailSink{blockingSink: blockingSink{release: make(chan int)}}
+	sink.err = errors.New("send failed")
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		CloseExportWriter: export,
+	})
+	c.UseSink(sink)
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}})
+	err := closeBlocked(c, sink.release)
+	Expect(err).To(Equal(&CloseError{Sent: 0, Lost: 2, Exported: 2}))
+	Expect(err.Error()).To(Equal("sent 0 messages while closing, 2 were lost (2 exported)"))
+	Expect(c.Stats().Exported).To(Equal(uint64(2)))
+
+	lines := strings.Split(strings.TrimSuffix(export.String(), "\n"), "\n")
+	Expect(lines).To(HaveLen(2))
+	exported := make([]string, 0, len(lines))
+	for _, line := range lines {
+		msg, err := ParseMessage([]byte(line))
+		Expect(err).To(BeNil())
+		exported = append(exported, msg.ShortMessage)
+	}
+	Expect(exported).To(ConsistOf("one", "two"))
+}
+
+func (s *GolfSuite) TestCloseExportWriterOnlyWhileClosing(t sweet.T) {
+	export := &syncBuffer{}
+	sink := &testSink{err: errors.New("send failed")}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		CloseExportWriter: export,
+	})
+	c.UseSink(sink)
+
+	c.QueueMsg(&Message{ShortMessage: "not closing"})
+	c.Flush()
+	Expect(export.String()).To(BeEmpty())
+	c.Close()
+	Expect(c.Stats().Exported).To(BeZero())
+}
+
+func (s *GolfSuite) TestAbort(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	c.Pause()
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}})
+	result := c.QueueMsgWithResult(&Message{ShortMessage: "three"})
+	Eventually(func() int {
+		return c.Stats().QueueDepth
+	}).Should(Equal(3))
+
+	Expect(c.Abort()).To(BeNil())
+	Expect(result).To(Receive(Equal(ErrMessageDropped)))
+	Expect(sink.messages()).To(HaveLen(0))
+	Expect(c.Stats().Dropped).To(Equal(uint64(3)))
+	Expect(c.Stats().QueueDepth).To(BeZero())
+	Expect(c.Connected()).To(BeFalse())
+	Expect(c.Abort()).To(BeNil())
+	Expect(c.Close()).To(BeNil())
+
+	// It can be used again afterwards
+	sink = &testSink{}
+	c.UseSink(sink)
+	c.Infof("again")
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"again"}))
+	Expect(c.Close()).To(BeNil())
+}
+
+func (s *GolfSuite) TestAbortBlockedWrite(t sweet.T) {
+	// Nothing reads from the other end of the pipe so the first write
+	// blocks until Abort closes it
+	client, _ := net.Pipe()
+	c, _ := NewClient()
+	c.Use(client, "tcp")
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}, {ShortMessage: "three"}})
+	Expect(c.Abort()).To(BeNil())
+
+	stats := c.Stats()
+	Expect(stats.Sent).To(BeZero())
+	Expect(stats.Failed + stats.Dropped).To(Equal(uint64(3)))
+	Expect(c.Connected()).To(BeFalse())
+}
+
+func (s *GolfSuite) TestSubscribe(t sweet.T) {
+	sendErr := errors.New("send failed")
+	c, _ := NewClient()
+	c.UseSink(&testSink{err: sendErr})
+	defer c.Close()
+
+	first, unsubscribeFirst := c.Subscribe()
+	second, unsubscribeSecond := c.Subscribe()
+	defer unsubscribeSecond()
+
+	c.QueueMsg(&Message{ShortMessage: "failed"})
+	c.Flush()
+	for _, errs := range []<-chan error{c.Errors(), first, second} {
+		Eventually(errs).Should(Receive(WithTransform(sendErrCause, Equal(sendErr))))
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	Expect(first).To(BeClosed())
+
+	c.QueueMsg(&Message{ShortMessage: "failed again"})
+	c.Flush()
+	Eventually(second).Should(Receive(WithTransform(sendErrCause, Equal(sendErr))))
+}
+
+func (s *GolfSuite) TestSubscribeFull(t sweet.T) {
+	c, _ := NewClient()
+	sub, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	// A subscriber that isn't read doesn't block reporting errors
+	for idx := 0; idx < 200; idx++ {
+		c.reportErr(ErrQueueFull)
+	}
+	Expect(sub).To(HaveLen(100))
+}
+
+type testContextKey string
+
+func (s *GolfSuite) TestQueueMsgContext(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.RegisterContextField(testContextKey("request"), "request_id")
+	c.RegisterContextField(testContextKey("user"), "user")
+	c.RegisterContextField(testContextKey("user"), "user_id")
+
+	ctx := context.WithValue(context.Background(), testContextKey("request"), "abc123")
+	ctx = context.WithValue(ctx, testContextKey("user"), 42)
+	msg := &Message{ShortMessage: "with context"}
+	msg.AddField("user_id", "set on the message")
+	Expect(c.QueueMsgContext(ctx, msg)).To(BeNil())
+	Expect(c.QueueMsgContext(context.Background(), &Message{ShortMessage: "no values"})).To(BeNil())
+	Expect(c.QueueMsgContext(ctx, nil)).To(Equal(ErrNilMessage))
+	c.Flush()
+
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{"user_id": "set on the message"}))
+
+	attrs := make(map[string]map[string]interface{})
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			parsed, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			attrs[parsed.ShortMessage] = parsed.Attrs
+		}
+	}
+	Expect(attrs["with context"]).To(Equal(map[string]interface{}{
+		"request_id": "abc123",
+		"user_id":    "set on the message",
+	}))
+	Expect(attrs).To(HaveKey("no values"))
+	Expect(attrs["no values"]).To(BeEmpty())
+}
+
+func (s *GolfSuite) TestSetErrorFields(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	fields := map[string]interface{}{"build": "abc123", "config_hash": "f00d"}
+	c.SetErrorFields(LEVEL_ERR, fields)
+	fields["build"] = "changed"
+
+	msg := &Message{Level: LEVEL_CRIT, ShortMessage: "critical"}
+	msg.AddField("config_hash", "from the message")
+	c.QueueMsg(msg)
+	c.QueueMsg(&Message{Level: LEVEL_ERR, ShortMessage: "error"})
+	c.QueueMsg(&Message{Level: LEVEL_WARN, ShortMessage: "warning"})
+	c.Flush()
+
+	c.SetErrorFields(LEVEL_ERR, nil)
+	c.QueueMsg(&Message{Level: LEVEL_ERR, ShortMessage: "cleared"})
+	c.Flush()
+
+	attrs := make(map[string]map[string]interface{})
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			parsed, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			attrs[parsed.ShortMessage] = parsed.Attrs
+		}
+	}
+	Expect(attrs["critical"]).To(Equal(map[string]interface{}{
+		"build":       "abc123",
+		"config_hash": "from the message",
+	}))
+	Expect(attrs["error"]).To(Equal(map[string]interface{}{
+		"build":       "abc123",
+		"config_hash": "f00d",
+	}))
+	Expect(attrs).To(HaveKey("warning"))
+	Expect(attrs["warning"]).To(BeEmpty())
+	Expect(attrs).To(HaveKey("cleared"))
+	Expect(attrs["cleared"]).To(BeEmpty())
+}
+
+func (s *GolfSuite) TestFullMessageTemplate(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:           1420,
+		FullMessageTemplate: template.Must(template.New("full").Parse("user {{.user_id}} did {{.action}}")),
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	l, _ := c.NewLogger()
+	l.SetAttr("user_id", "someone")
+	l.Infom(map[string]interface{}{"action": "login"}, "logged in")
+	c.Flush()
+
+	msg, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(msg.FullMessage).To(Equal("user someone did login"))
+}
+
+func (s *GolfSuite) TestPauseResume(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("before")
+	c.Flush()
+
+	c.Pause()
+	c.Infof("while paused 1")
+	c.Infof("while paused 2")
+	Expect(c.Flush()).To(Equal(ErrPaused))
+	Consistently(sink.messages, "100ms").Should(Equal([]string{"before"}))
+	Eventually(func() int { return c.Stats().QueueDepth }).Should(Equal(2))
+	Expect(c.Connected()).To(BeTrue())
+
+	c.Resume()
+	Expect(c.Flush()).To(BeNil())
+	Expect(sink.messages()).To(Equal([]string{"before", "while paused 1", "while paused 2"}))
+}
+
+func (s *GolfSuite) TestPauseClose(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1420, SenderConcurrency: 2})
+	c.UseSink(sink)
+
+	c.Pause()
+	c.Infof("queued while paused")
+	Expect(c.Close()).To(BeNil())
+	Expect(sink.messages()).To(Equal([]string{"queued while paused"}))
+}
+
+func (s *GolfSuite) TestClientFilterFields(t sweet.T) {
+	_, err := NewClientWithConfig(ClientConfig{DenyFields: []string{"[bad"}})
+	Expect(err).To(Equal(path.ErrBadPattern))
+
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		DenyFields: []string{"token"},
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.QueueMsg((&Message{ShortMessage: "login"}).AddField("token", "secret").AddField("user", "someone"))
+	c.Flush()
+	Expect(string(sink.batches[0][0])).ToNot(ContainSubstring("secret"))
+	Expect(string(sink.batches[0][0])).To(ContainSubstring(`"_user":"someone"`))
+	Expect(c.Stats().FieldsStripped).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestBackpressureBlock(t sweet.T) {
+	testBackpressure(ClientConfig{
+		ChunkSize:         1420,
+		MaxQueueSize:      2,
+		BackpressureBlock: true,
+	})
+}
+
+func (s *GolfSuite) TestQueueStrategyBlock(t sweet.T) {
+	testBackpressure(ClientConfig{
+		ChunkSize:     1420,
+		MaxQueueSize:  2,
+		QueueStrategy: QUEUE_BLOCK,
+	})
+}
+
+// Check that a client with config waits for room in its queue of 2 messages
+func testBackpressure(config ClientConfig) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClientWithConfig(config)
+	c.UseSink(sink)
+	defer c.Close()
+
+	done := make(chan int)
+	go func() {
+		c.Infof("message 0")
+		c.Infof("message 1")
+		c.Infof("message 2")
+		c.QueueMsgs([]*Message{
+			{ShortMessage: "message 3"},
+			{ShortMessage: "message 4"},
+			{ShortMessage: "message 5"},
+		})
+		close(done)
+	}()
+
+	// The producer waits for the sender instead of dropping messages
+	Consistently(done, "100ms").ShouldNot(BeClosed())
+	Expect(c.Stats().QueueDepth).To(Equal(2))
+	close(sink.release)
+	Eventually(done).Should(BeClosed())
+
+	c.Flush()
+	Expect(sink.messages()).To(ConsistOf("message 0", "message 1", "message 2", "message 3", "message 4", "message 5"))
+	Expect(c.Stats().Dropped).To(Equal(uint64(0)))
+}
+
+func (s *GolfSuite) TestBackpressureBlockClosePaused(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		MaxQueueSize:      2,
+		BackpressureBlock: true,
+	})
+	c.UseSink(sink)
+	c.Pause()
+
+	c.Infof("message 0")
+	c.Infof("message 1")
+	queued := make(chan error, 2)
+	go func() {
+		queued <- c.Infof("message 2")
+		queued <- c.QueueMsgs([]*Message{{ShortMessage: "message 3"}})
+	}()
+	Consistently(queued, "50ms").ShouldNot(Receive())
+
+	// The waiting producer gives up so Close can resume and send what's
+	// already queued
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	Eventually(queued).Should(Receive(Equal(ErrClosing)))
+	Eventually(queued).Should(Receive(Equal(ErrClosing)))
+	Eventually(closed).Should(Receive(BeNil()))
+	Expect(sink.messages()).To(Equal([]string{"message 0", "message 1"}))
+}
+
+func (s *GolfSuite) TestBackpressureBlockCloseNotConnected(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		MaxQueueSize:  1,
+		QueueStrategy: QUEUE_BLOCK,
+	})
+
+	c.Infof("message 0")
+	queued := make(chan error)
+	go func() {
+		queued <- c.Infof("message 1")
+	}()
+	Consistently(queued, "50ms").ShouldNot(Receive())
+
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	Eventually(queued).Should(Receive(Equal(ErrClosing)))
+	Eventually(closed).Should(Receive(BeNil()))
+	Expect(c.Stats().QueueDepth).To(Equal(1))
+}
+
+func (s *GolfSuite) TestQueueMsgsAfterGivingUp(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		MaxQueueSize:  1,
+		QueueStrategy: QUEUE_BLOCK,
+	})
+	c.Infof("message 0")
+
+	queued := make(chan error)
+	go func() {
+		queued <- c.QueueMsgs([]*Message{{ShortMessage: "message 1"}})
+	}()
+	Consistently(queued, "50ms").ShouldNot(Receive())
+
+	// Once the client gives up reconnecting the waiting messages and the
+	// ones already queued are dropped
+	atomic.StoreInt32(&c.failed, 1)
+	c.queueMutex.Lock()
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+	Eventually(queued).Should(Receive(Equal(ErrQueueFull)))
+	Expect(c.Stats().QueueDepth).To(Equal(0))
+	Expect(c.Stats().Dropped).To(Equal(uint64(2)))
+}
+
+func (s *GolfSuite) TestQueueStrategyDropOldest(t sweet.T) {
+	sink := &testSink{}
+	var dropsMutex sync.Mutex
+	drops := make([]string, 0)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:     1420,
+		MaxQueueSize:  3,
+		QueueStrategy: QUEUE_DROP_OLDEST,
+		DropAuditFunc: func(record DropRecord) {
+			dropsMutex.Lock()
+			defer dropsMutex.Unlock()
+			Expect(record.Reason).To(Equal(DROP_EVICTED))
+			drops = append(drops, record.Msg.ShortMessage)
+		},
+	})
+	c.UseSink(sink)
+	defer c.Close()
+	c.Pause()
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}, {ShortMessage: "three"}})
+	Expect(c.QueueMsgs([]*Message{{ShortMessage: "four"}, {ShortMessage: "five"}})).To(BeNil())
+	Expect(c.Infof("six")).To(BeNil())
+	Expect(c.Stats().QueueDepth).To(Equal(3))
+
+	c.Resume()
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"four", "five", "six"}))
+	Expect(c.Stats().Dropped).To(Equal(uint64(3)))
+	dropsMutex.Lock()
+	defer dropsMutex.Unlock()
+	Expect(drops).To(Equal([]string{"one", "two", "three"}))
+}
+
+func (s *GolfSuite) TestSynchronous(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Synchronous: true,
+	})
+
+	// Queued until it's connected
+	c.Infof("before connecting")
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+
+	c.Infof("sent")
+	result := c.QueueMsgWithResult(&Message{ShortMessage: "with result"})
+	Expect(result).To(Receive(BeNil()))
+	Expect(sink.messages()).To(Equal([]string{"before connecting", "sent", "with result"}))
+	Expect(c.Stats().Sent).To(Equal(uint64(3)))
+}
+
+func (s *GolfSuite) TestWaitQueueBelow(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.WaitQueueBelow(context.Background(), 1)).To(BeNil())
+
+	c.QueueMsgs([]*Message{{ShortMessage: "first"}, {ShortMessage: "second"}})
+	Expect(c.WaitQueueBelow(context.Background(), 3)).To(BeNil())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	Expect(c.WaitQueueBelow(ctx, 2)).To(Equal(context.DeadlineExceeded))
+
+	done := make(chan error)
+	go func() {
+		done <- c.WaitQueueBelow(context.Background(), 1)
+	}()
+	Consistently(done, "50ms").ShouldNot(Receive())
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	Eventually(done).Should(Receive(BeNil()))
+	Expect(c.Stats().QueueDepth).To(Equal(0))
+}
+
+func (s *GolfSuite) TestSampleRates(t sweet.T) {
+	newSampled := func() *Client {
+		c, _ := NewClientWithConfig(ClientConfig{
+			ChunkSize:   1420,
+			SampleRates: map[int]float64{LEVEL_DBG: 0.1, LEVEL_ERR: 1, LEVEL_WARN: 0},
+			RandSource:  rand.New(rand.NewSource(1)),
+		})
+		msgs := make([]*Message, 0)
+		for idx := 0; idx < 1000; idx++ {
+			msgs = append(msgs, c.genMsg(LEVEL_DBG, "debug %d", idx))
+		}
+		for idx := 0; idx < 10; idx++ {
+			msgs = append(msgs,
+				c.genMsg(LEVEL_ERR, "error %d", idx),
+				c.genMsg(LEVEL_INFO, "info %d", idx),
+				c.genMsg(LEVEL_WARN, "warning %d", idx))
+		}
+		c.QueueMsgs(msgs)
+		return c
+	}
+
+	c := newSampled()
+	stats := c.Stats()
+	Expect(stats.SampledOut[LEVEL_DBG]).To(BeNumerically("~", 900, 50))
+	Expect(stats.SampledOut[LEVEL_WARN]).To(Equal(uint64(10)))
+	Expect(stats.SampledOut).ToNot(HaveKey(LEVEL_ERR))
+	Expect(stats.SampledOut).ToNot(HaveKey(LEVEL_INFO))
+	Expect(stats.Dropped).To(Equal(stats.SampledOut[LEVEL_DBG] + 10))
+	Expect(stats.QueueDepth).To(Equal(1030 - int(stats.Dropped)))
+
+	// The same seed samples the same messages
+	Expect(newSampled().Stats().SampledOut).To(Equal(stats.SampledOut))
+}
+
+func (s *GolfSuite) TestDefaultSampleRate(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		SampleRates:       map[int]float64{LEVEL_ERR: 1},
+		DefaultSampleRate: 0.5,
+	})
+	msgs := make([]*Message, 0)
+	for idx := 0; idx < 1000; idx++ {
+		msgs = append(msgs, c.genMsg(LEVEL_INFO, "info %d", idx), c.genMsg(LEVEL_ERR, "error %d", idx))
+	}
+	c.QueueMsgs(msgs)
+
+	stats := c.Stats()
+	Expect(stats.SampledOut[LEVEL_INFO]).To(BeNumerically("~", 500, 100))
+	Expect(stats.SampledOut).ToNot(HaveKey(LEVEL_ERR))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Infof("queued one at a time")
+	c.Flush()
+	Expect(c.Stats().Sent + c.Stats().Dropped).To(Equal(uint64(2001)))
+}
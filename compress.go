@@ -0,0 +1,66 @@
+package golf
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// A cache of compression writers, one for each compression level used.
+// Writers keep their level when they're Reset so a writer taken from the cache
+// will always compress at that level. A writerCache belongs to a single
+// sender and isn't safe for concurrent use, since only one message is written
+// by a sender at a time there's no need to pool writers or lock around them.
+type writerCache struct {
+	w         io.Writer
+	newWriter func(w io.Writer, level int) (compressWriter, error)
+
+	writers map[int]compressWriter
+}
+
+func newGzipCache(w io.Writer) *writerCache {
+	return newWriterCache(w, func(w io.Writer, level int) (compressWriter, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+func newZlibCache(w io.Writer) *writerCache {
+	return newWriterCache(w, func(w io.Writer, level int) (compressWriter, error) {
+		return zlib.NewWriterLevel(w, level)
+	})
+}
+
+func newWriterCache(w io.Writer, newWriter func(io.Writer, int) (compressWriter, error)) *writerCache {
+	return &writerCache{
+		w:         w,
+		newWriter: newWriter,
+		writers:   make(map[int]compressWriter, 0),
+	}
+}
+
+// Get a writer compressing at the given level, creating one if there isn't
+// one cached. Returns nil if the level isn't valid for the compression type.
+func (wc *writerCache) Get(level int) compressWriter {
+	cw, ok := wc.writers[level]
+	if ok {
+		delete(wc.writers, level)
+		return cw
+	}
+
+	cw, err := wc.newWriter(wc.w, level)
+	if err != nil {
+		return nil
+	}
+	return cw
+}
+
+// Reset the writer and return it to the cache for the given level
+func (wc *writerCache) Put(level int, cw compressWriter) {
+	cw.Reset(wc.w)
+	wc.writers[level] = cw
+}
@@ -0,0 +1,152 @@
+package golf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func compressWith(newWriter func(io.Writer, int) (compressWriter, error), level int, data string) []byte {
+	buf := &bytes.Buffer{}
+	cw, _ := newWriter(buf, level)
+	cw.Write([]byte(data))
+	cw.Close()
+	return buf.Bytes()
+}
+
+func (s *GolfSuite) TestSenderWriteMsgMixedLevels(t sweet.T) {
+	gzipWriter := func(w io.Writer, level int) (compressWriter, error) {
+		return gzip.NewWriterLevel(w, level)
+	}
+	zlibWriter := func(w io.Writer, level int) (compressWriter, error) {
+		return zlib.NewWriterLevel(w, level)
+	}
+
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 8192)
+	snd := newSenderForWriter(chnk)
+
+	data := `{"short_message":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}`
+
+	tests := []struct {
+		compression int
+		level       int
+		newWriter   func(io.Writer, int) (compressWriter, error)
+	}{
+		{COMP_GZIP, gzip.BestSpeed, gzipWriter},
+		{COMP_GZIP, gzip.BestCompression, gzipWriter},
+		{COMP_ZLIB, zlib.BestSpeed, zlibWriter},
+		{COMP_GZIP, gzip.BestSpeed, gzipWriter},
+		{COMP_ZLIB, zlib.BestCompression, zlibWriter},
+		{COMP_GZIP, gzip.HuffmanOnly, gzipWriter},
+		{COMP_GZIP, gzip.BestCompression, gzipWriter},
+	}
+
+	for _, test := range tests {
+		w.reset()
+		err := snd.writeMsg([]byte(data), test.compression, test.level)
+		Expect(err).To(BeNil())
+		Expect(w.Written).To(HaveLen(1))
+
+		payload := w.Written[0][12:]
+		Expect(payload).To(Equal(compressWith(test.newWriter, test.level, data)))
+
+		var r io.Reader
+		if test.compression == COMP_GZIP {
+			r, err = gzip.NewReader(bytes.NewReader(payload))
+		} else {
+			r, err = zlib.NewReader(bytes.NewReader(payload))
+		}
+		Expect(err).To(BeNil())
+		decompressed, err := ioutil.ReadAll(r)
+		Expect(err).To(BeNil())
+		Expect(string(decompressed)).To(Equal(data))
+	}
+}
+
+func (s *GolfSuite) TestSenderWriteMsgInvalidLevel(t sweet.T) {
+	chnk, _ := newChunker(newTestWriter(), 8192)
+	snd := newSenderForWriter(chnk)
+
+	// Falls back to the default level
+	err := snd.writeMsg([]byte("{}"), COMP_GZIP, 42)
+	Expect(err).To(BeNil())
+
+	err = snd.writeMsg([]byte("{}"), COMP_ZLIB, 42)
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestSenderWriteMsgLevelFallbackWarning(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 8192)
+	snd := newSenderForWriter(chnk)
+	snd.client, _ = NewClient()
+
+	err := snd.writeMsg([]byte("{}"), COMP_GZIP, 42)
+	Expect(err).To(BeNil())
+	Expect(snd.client.Errors()).To(Receive(Equal(ErrCompressionFallback)))
+
+	r, err := gzip.NewReader(bytes.NewReader(w.Written[0][12:]))
+	Expect(err).To(BeNil())
+	decompressed, err := ioutil.ReadAll(r)
+	Expect(err).To(BeNil())
+	Expect(string(decompressed)).To(Equal("{}"))
+
+	// Only warned about once
+	err = snd.writeMsg([]byte("{}"), COMP_ZLIB, 42)
+	Expect(err).To(BeNil())
+	Expect(snd.client.Errors()).ToNot(Receive())
+}
+
+func (s *GolfSuite) TestSenderWriteMsgLevelStrict(t sweet.T) {
+	chnk, _ := newChunker(newTestWriter(), 8192)
+	snd := newSenderForWriter(chnk)
+	snd.client, _ = NewClientWithConfig(ClientConfig{
+		ChunkSize:  8192,
+		StrictMode: true,
+	})
+
+	err := snd.writeMsg([]byte("{}"), COMP_GZIP, 42)
+	Expect(err).To(Equal(&SendError{Op: SEND_OP_COMPRESS, Err: ErrCompressionLevel}))
+
+	err = snd.writeMsg([]byte("{}"), COMP_ZLIB, 42)
+	Expect(err).To(Equal(&SendError{Op: SEND_OP_COMPRESS, Err: ErrCompressionLevel}))
+}
+
+func (s *GolfSuite) TestSenderWriteMsgAuto(t sweet.T) {
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 8192)
+	snd := newSenderForWriter(chnk)
+
+	// Small messages get bigger when compressed so they're sent as-is
+	small := `{"short_message":"a"}`
+	err := snd.writeMsg([]byte(small), COMP_AUTO, 0)
+	Expect(err).To(BeNil())
+	Expect(w.Written).To(HaveLen(1))
+	Expect(string(w.Written[0][12:])).To(Equal(small))
+
+	w.reset()
+	large := `{"short_message":"` + strings.Repeat("a", 1000) + `"}`
+	err = snd.writeMsg([]byte(large), COMP_AUTO, 0)
+	Expect(err).To(BeNil())
+	Expect(w.Written).To(HaveLen(1))
+
+	payload := w.Written[0][12:]
+	Expect(len(payload)).To(BeNumerically("<", len(large)))
+	msg, err := ParseMessage(payload)
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal(strings.Repeat("a", 1000)))
+
+	// The writer must go back to writing to the chunker once it's reused
+	w.reset()
+	err = snd.writeMsg([]byte(large), COMP_GZIP, 0)
+	Expect(err).To(BeNil())
+	Expect(w.Written).To(HaveLen(1))
+	Expect(w.Written[0][12:]).To(Equal(payload))
+}
@@ -0,0 +1,106 @@
+package golf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// Get a copy of the client's current configuration, including any changes
+// made since it was created such as with SetCompression, SetChunkSize or
+// SetMaxQueueSize. The slices and maps in it are copies so changing them
+// doesn't change the client's.
+func (c *Client) Config() ClientConfig {
+	// MaxQueueSize is changed while holding the queue's lock rather than the
+	// config's
+	c.queueMutex.Lock()
+	c.configMutex.RLock()
+	config := c.config
+	c.configMutex.RUnlock()
+	c.queueMutex.Unlock()
+
+	if config.AllowFields != nil {
+		config.AllowFields = append([]string{}, config.AllowFields...)
+	}
+	if config.DenyFields != nil {
+		config.DenyFields = append([]string{}, config.DenyFields...)
+	}
+	if config.CompressionBuckets != nil {
+		config.CompressionBuckets = append([]CompressionBucket{}, config.CompressionBuckets...)
+	}
+	if config.FieldTypes != nil {
+		types := make(map[string]int, len(config.FieldTypes))
+		for name, fieldType := range config.FieldTypes {
+			types[name] = fieldType
+		}
+		config.FieldTypes = types
+	}
+	if config.ResourceAttributes != nil {
+		attrs := make(map[string]interface{}, len(config.ResourceAttributes))
+		for name, val := range config.ResourceAttributes {
+			attrs[name] = val
+		}
+		config.ResourceAttributes = attrs
+	}
+	if config.SampleRates != nil {
+		rates := make(map[int]float64, len(config.SampleRates))
+		for level, rate := range config.SampleRates {
+			rates[level] = rate
+		}
+		config.SampleRates = rates
+	}
+	if config.HeartbeatFields != nil {
+		fields := make(map[string]interface{}, len(config.HeartbeatFields))
+		for key, val := range config.HeartbeatFields {
+			fields[key] = val
+		}
+		config.HeartbeatFields = fields
+	}
+	if config.LifecycleFields != nil {
+		fields := make(map[string]interface{}, len(config.LifecycleFields))
+		for key, val := range config.LifecycleFields {
+			fields[key] = val
+		}
+		config.LifecycleFields = fields
+	}
+
+	return config
+}
+
+// Render the configuration with one "Name: value" line for each field, to
+// help diagnose misconfiguration. Functions, sinks, writers, loggers and
+// the other fields holding something other than plain values are only shown
+// as "set" or "unset", so nothing they hold on to, such as a sink's
+// credentials, ends up in the output.
+func (config ClientConfig) String() string {
+	var buf bytes.Buffer
+
+	val := reflect.ValueOf(config)
+	for idx := 0; idx < val.NumField(); idx++ {
+		name := val.Type().Field(idx).Name
+		fmt.Fprintf(&buf, "%s: %s\n", name, configValue(name, val.Field(idx)))
+	}
+
+	return buf.String()
+}
+
+// Render a single field of a ClientConfig for String
+func configValue(name string, val reflect.Value) string {
+	switch val.Kind() {
+	case reflect.Func, reflect.Interface, reflect.Chan, reflect.Ptr:
+		if val.IsNil() {
+			return "unset"
+		}
+		return "set"
+	}
+
+	if name == "Compression" {
+		for compName, mode := range compressionNames {
+			if int(val.Int()) == mode {
+				return compName
+			}
+		}
+	}
+
+	return fmt.Sprintf("%v", val.Interface())
+}
@@ -0,0 +1,49 @@
+package golf
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestConfig(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+		AllowFields: []string{"user_*"},
+		SampleRates: map[int]float64{LEVEL_DBG: 0.5},
+	})
+	Expect(c.SetCompression(COMP_ZLIB)).To(BeNil())
+	Expect(c.SetChunkSize(1000)).To(BeNil())
+	c.SetMaxQueueSize(10)
+
+	config := c.Config()
+	Expect(config.Compression).To(Equal(COMP_ZLIB))
+	Expect(config.ChunkSize).To(Equal(1000))
+	Expect(config.MaxQueueSize).To(Equal(10))
+	Expect(config.AllowFields).To(Equal([]string{"user_*"}))
+
+	// Changing the copy doesn't change the client
+	config.AllowFields[0] = "other"
+	config.SampleRates[LEVEL_DBG] = 1
+	Expect(c.Config().AllowFields).To(Equal([]string{"user_*"}))
+	Expect(c.Config().SampleRates[LEVEL_DBG]).To(Equal(0.5))
+}
+
+func (s *GolfSuite) TestConfigString(t sweet.T) {
+	config := ClientConfig{
+		ChunkSize:    1420,
+		Compression:  COMP_GZIP,
+		FallbackSink: &testSink{},
+		Transform:    func(msg *Message) *Message { return msg },
+		DenyFields:   []string{"password"},
+	}
+
+	str := config.String()
+	Expect(str).To(ContainSubstring("ChunkSize: 1420\n"))
+	Expect(str).To(ContainSubstring("Compression: gzip\n"))
+	Expect(str).To(ContainSubstring("FallbackSink: set\n"))
+	Expect(str).To(ContainSubstring("Transform: set\n"))
+	Expect(str).To(ContainSubstring("FallbackWriter: unset\n"))
+	Expect(str).To(ContainSubstring("DenyFields: [password]\n"))
+	Expect(str).To(ContainSubstring("MaxQueueSize: 0\n"))
+}
@@ -0,0 +1,13 @@
+//go:build !go1.9 || (!linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd)
+// +build !go1.9 !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package golf
+
+import (
+	"net"
+)
+
+// Setting DSCP isn't supported on this platform so it's ignored
+func setDSCP(conn net.Conn, dscp int) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package golf
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func getTOS(conn net.Conn) int {
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	Expect(err).To(BeNil())
+
+	var tos int
+	raw.Control(func(fd uintptr) {
+		tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	Expect(err).To(BeNil())
+	return tos
+}
+
+func (s *GolfSuite) TestDialDSCP(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		DSCP:      46,
+	})
+	err := c.Dial("udp://127.0.0.1:12201")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(getTOS(c.conn.(*redialConn).current())).To(Equal(46 << 2))
+}
+
+func (s *GolfSuite) TestDialDSCPTcp(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		DSCP:      8,
+	})
+	err = c.Dial("tcp://" + listener.Addr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(getTOS(c.conn.(*reconnConn).current())).To(Equal(8 << 2))
+}
@@ -0,0 +1,48 @@
+//go:build go1.9 && (linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+// +build go1.9
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package golf
+
+import (
+	"net"
+	"syscall"
+)
+
+// Set the DSCP bits of the traffic class for packets sent on conn
+func setDSCP(conn net.Conn, dscp int) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	level, opt := syscall.IPPROTO_IP, syscall.IP_TOS
+	if isIPv6(conn.LocalAddr()) {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+
+	var setErr error
+	err = raw.Control(func(fd uintptr) {
+		// DSCP is the upper 6 bits of the ToS/traffic class byte
+		setErr = syscall.SetsockoptInt(int(fd), level, opt, dscp<<2)
+	})
+	if err != nil {
+		return err
+	}
+	return setErr
+}
+
+func isIPv6(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+	return ip != nil && ip.To4() == nil
+}
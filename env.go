@@ -0,0 +1,99 @@
+package golf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Names of the environment variables read by ClientConfigFromEnv
+const (
+	ENV_COMPRESSION        = "GOLF_COMPRESSION"        // "none", "gzip", "zlib" or "auto"
+	ENV_COMPRESSION_LEVEL  = "GOLF_COMPRESSION_LEVEL"  // 0 to 9
+	ENV_CHUNK_SIZE         = "GOLF_CHUNK_SIZE"         // Bytes in each chunk
+	ENV_MTU                = "GOLF_MTU"                // The path MTU to the server
+	ENV_SENDER_CONCURRENCY = "GOLF_SENDER_CONCURRENCY" // Number of senders for udp
+	ENV_MAX_QUEUE_SIZE     = "GOLF_MAX_QUEUE_SIZE"     // Largest number of queued messages
+	ENV_MIN_LEVEL          = "GOLF_MIN_LEVEL"          // A level name such as "warning"
+)
+
+// Create a ClientConfig from the GOLF_* environment variables, starting from
+// the same defaults as NewClient for anything that isn't set. It's never used
+// automatically, the config has to be passed to NewClientWithConfig, and it
+// can be changed before it is. Returns an error naming the variable if any of
+// them aren't valid.
+func ClientConfigFromEnv() (ClientConfig, error) {
+	config := ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+	}
+
+	if val, ok := lookupEnv(ENV_COMPRESSION); ok {
+		switch strings.ToLower(val) {
+		case "none":
+			config.Compression = COMP_NONE
+		case "gzip":
+			config.Compression = COMP_GZIP
+		case "zlib":
+			config.Compression = COMP_ZLIB
+		case "auto":
+			config.Compression = COMP_AUTO
+		default:
+			return config, envErr(ENV_COMPRESSION, ErrUnknownCompression)
+		}
+	}
+
+	ints := []struct {
+		name  string
+		field *int
+		min   int
+		max   int
+		err   error
+	}{
+		{ENV_COMPRESSION_LEVEL, &config.CompressionLevel, 0, 9, ErrCompressionLevel},
+		{ENV_CHUNK_SIZE, &config.ChunkSize, 13, 0, ErrChunkTooSmall},
+		{ENV_MTU, &config.MTU, 0, 0, nil},
+		{ENV_SENDER_CONCURRENCY, &config.SenderConcurrency, 0, 0, nil},
+		{ENV_MAX_QUEUE_SIZE, &config.MaxQueueSize, 0, 0, nil},
+	}
+	for _, env := range ints {
+		val, ok := lookupEnv(env.name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return config, envErr(env.name, err)
+		}
+		if n < env.min || (env.max > 0 && n > env.max) {
+			err := env.err
+			if err == nil {
+				err = fmt.Errorf("must be at least %d", env.min)
+			}
+			return config, envErr(env.name, err)
+		}
+		*env.field = n
+	}
+
+	if val, ok := lookupEnv(ENV_MIN_LEVEL); ok {
+		level, ok := levelNames[strings.ToLower(val)]
+		if !ok {
+			return config, envErr(ENV_MIN_LEVEL, ErrUnknownLevel)
+		}
+		config.MinLevel = level
+	}
+
+	return config, nil
+}
+
+// Get the value of an environment variable, treating an empty value the same
+// as it not being set
+func lookupEnv(name string) (string, bool) {
+	val := strings.TrimSpace(os.Getenv(name))
+	return val, val != ""
+}
+
+func envErr(name string, err error) error {
+	return fmt.Errorf("%s: %v", name, err)
+}
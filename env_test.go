@@ -0,0 +1,78 @@
+package golf
+
+import (
+	"os"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// Set environment variables for a test, returning a function that restores
+// them
+func setEnv(vars map[string]string) func() {
+	old := make(map[string]string, len(vars))
+	for name, val := range vars {
+		old[name] = os.Getenv(name)
+		os.Setenv(name, val)
+	}
+	return func() {
+		for name, val := range old {
+			os.Setenv(name, val)
+		}
+	}
+}
+
+func (s *GolfSuite) TestClientConfigFromEnvDefaults(t sweet.T) {
+	config, err := ClientConfigFromEnv()
+	Expect(err).To(BeNil())
+	Expect(config).To(Equal(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+	}))
+}
+
+func (s *GolfSuite) TestClientConfigFromEnv(t sweet.T) {
+	defer setEnv(map[string]string{
+		ENV_COMPRESSION:        "ZLIB",
+		ENV_COMPRESSION_LEVEL:  "9",
+		ENV_CHUNK_SIZE:         "8154",
+		ENV_MTU:                "9000",
+		ENV_SENDER_CONCURRENCY: "4",
+		ENV_MAX_QUEUE_SIZE:     "1000",
+		ENV_MIN_LEVEL:          "warning",
+	})()
+
+	config, err := ClientConfigFromEnv()
+	Expect(err).To(BeNil())
+	Expect(config).To(Equal(ClientConfig{
+		ChunkSize:         8154,
+		Compression:       COMP_ZLIB,
+		CompressionLevel:  9,
+		MTU:               9000,
+		SenderConcurrency: 4,
+		MaxQueueSize:      1000,
+		MinLevel:          LEVEL_WARN,
+	}))
+}
+
+func (s *GolfSuite) TestClientConfigFromEnvInvalid(t sweet.T) {
+	tests := []struct {
+		name string
+		val  string
+		err  string
+	}{
+		{ENV_COMPRESSION, "brotli", "GOLF_COMPRESSION: " + ErrUnknownCompression.Error()},
+		{ENV_COMPRESSION_LEVEL, "10", "GOLF_COMPRESSION_LEVEL: " + ErrCompressionLevel.Error()},
+		{ENV_CHUNK_SIZE, "12", "GOLF_CHUNK_SIZE: " + ErrChunkTooSmall.Error()},
+		{ENV_CHUNK_SIZE, "big", `GOLF_CHUNK_SIZE: strconv.Atoi: parsing "big": invalid syntax`},
+		{ENV_MAX_QUEUE_SIZE, "-1", "GOLF_MAX_QUEUE_SIZE: must be at least 0"},
+		{ENV_MIN_LEVEL, "loud", "GOLF_MIN_LEVEL: " + ErrUnknownLevel.Error()},
+	}
+
+	for _, test := range tests {
+		restore := setEnv(map[string]string{test.name: test.val})
+		_, err := ClientConfigFromEnv()
+		restore()
+		Expect(err).To(MatchError(test.err))
+	}
+}
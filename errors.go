@@ -2,8 +2,132 @@ package golf
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 )
 
 var (
-	ErrChunkTooSmall = errors.New("chunk size is too small, it must be at least 13")
+	ErrChunkTooSmall       = errors.New("chunk size is too small, it must be at least 13")
+	ErrChunkTooLarge       = errors.New("chunk size is too large, it must be at most 65507")
+	ErrCompressionLevel    = errors.New("compression level is not valid for the compression type")
+	ErrCompressionFallback = errors.New("compression level is not valid, falling back to the default level")
+	ErrUnsupportedScheme   = errors.New("Unsupported scheme provided")
+	ErrTooManyInFlight     = errors.New("too many messages are waiting to be sent")
+	ErrMissingHost         = errors.New("URI doesn't have a host")
+	ErrInvalidPort         = errors.New("port must be a number from 1 to 65535")
+	ErrChunkExceedsMTU     = errors.New("chunk size is larger than the MTU allows, chunks will be fragmented")
+	ErrDatagramTooLarge    = errors.New("chunk size is larger than the largest datagram that can be sent")
+	ErrInvalidDSCP         = errors.New("DSCP value must be between 0 and 63")
+	ErrRandFallback        = errors.New("crypto/rand failed, falling back to math/rand for chunk ids")
+	ErrInvalidDelimiter    = errors.New("TCP delimiter must be a control character below 0x20")
+	ErrInvalidSRVName      = errors.New("SRV name must be like _service._udp.example.com or _service._tcp.example.com")
+	ErrNoSRVRecords        = errors.New("SRV record has no servers")
+	ErrInvalidLocalAddr    = errors.New("local address must be a host and port like 0.0.0.0:5140")
+	ErrUDPUnreachable      = errors.New("nothing is listening at the udp address, messages sent to it will be lost")
+
+	ErrNilMessage       = errors.New("message is nil")
+	ErrUnknownLevel     = errors.New("unknown level name")
+	ErrNotConnected     = errors.New("client is not connected")
+	ErrAlreadyConnected = errors.New("client is already connected")
+	ErrPaused           = errors.New("client is paused")
+
+	ErrReconnectFailed    = errors.New("gave up reconnecting to the server")
+	ErrMessageDropped     = errors.New("message was dropped without being sent")
+	ErrMessageExpired     = errors.New("message was too old to be sent")
+	ErrThrottled          = errors.New("message was dropped for going over the bandwidth limit")
+	ErrQueueFull          = errors.New("message queue is full")
+	ErrQueueHighWaterMark = errors.New("message queue reached its high water mark")
+	ErrClosing            = errors.New("client is closing and not accepting messages")
+	ErrWALFull            = errors.New("write-ahead log is full, the message won't survive a restart")
+
+	ErrUnknownCompression      = errors.New("unknown compression type")
+	ErrCompressionNotSupported = errors.New("compression is not supported by the connection")
+
+	// Returned by the chunker for messages OnChunking didn't send
+	errChunkingDrop     = errors.New("message was dropped instead of being chunked")
+	errChunkingFallback = errors.New("message was sent to the fallback instead of being chunked")
+
+	// Returned by a batchWriter written to after it was discarded
+	errBatchWriterDiscarded = errors.New("buffered writer was discarded")
+)
+
+// A PartialSendError is returned when writing a chunked message fails after
+// some of its chunks were already written. The server can't put the message
+// back together from the chunks it was sent so it's lost, and it will only
+// give up waiting for the rest of them after a few seconds.
+type PartialSendError struct {
+	Sent  int   // Number of chunks written before the error
+	Total int   // Number of chunks in the message
+	Err   error // The error writing the next chunk
+}
+
+func (e *PartialSendError) Error() string {
+	return fmt.Sprintf("only sent %d of %d chunks: %v", e.Sent, e.Total, e.Err)
+}
+
+// A MultiSinkError is returned by a MultiSink when some of its sinks failed
+// to send a batch
+type MultiSinkError struct {
+	Errs map[int]error // The error from each sink that failed, by its index
+}
+
+func (e *MultiSinkError) Error() string {
+	idxs := make([]int, 0, len(e.Errs))
+	for idx := range e.Errs {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+
+	msgs := make([]string, len(idxs))
+	for pos, idx := range idxs {
+		msgs[pos] = fmt.Sprintf("sink %d: %v", idx, e.Errs[idx])
+	}
+	return fmt.Sprintf("%d of the sinks failed: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// A CloseError is returned by Client.Close when messages failed to send while
+// it was closing, or closing the connection or sink failed. If Lost is 0
+// everything queued was sent before the connection failed to close.
+type CloseError struct {
+	Sent uint64 // Messages sent while closing
+	Lost uint64 // Messages that failed to send or were dropped while closing
+	// Messages that were lost but written to the CloseExportWriter, so they
+	// can be replayed
+	Exported uint64
+	Err      error // The error closing the connection or sink, if there was one
+}
+
+func (e *CloseError) Error() string {
+	lost := fmt.Sprintf("%d were lost", e.Lost)
+	if e.Exported > 0 {
+		lost = fmt.Sprintf("%d were lost (%d exported)", e.Lost, e.Exported)
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("sent %d messages while closing, %s", e.Sent, lost)
+	}
+	return fmt.Sprintf("sent %d messages while closing, %s: %v", e.Sent, lost, e.Err)
+}
+
+// The operations that can fail while sending a message, for SendError
+const (
+	SEND_OP_SERIALIZE = "serialize"
+	SEND_OP_COMPRESS  = "compress"
+	SEND_OP_WRITE     = "write"
 )
+
+// A SendError is reported to Client.Errors() when a message fails to send,
+// saying which message it was and what went wrong with it. When sending a
+// batch of messages fails an error is reported for each of them.
+type SendError struct {
+	Msg *Message // A copy of the message as it was queued
+	Op  string   // What failed, one of the SEND_OP_* constants
+	Err error    // The error from the operation
+}
+
+func (e *SendError) Error() string {
+	if e.Msg == nil {
+		return fmt.Sprintf("failed to %s message: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("failed to %s message %q: %v", e.Op, e.Msg.ShortMessage, e.Err)
+}
@@ -0,0 +1,71 @@
+package golf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Render msg as a single line of human readable text, with its full message,
+// if it has one, on the lines after it. Its fields are the ones opts would
+// serialize it with.
+func renderText(msg *Message, opts serializeOptions) []byte {
+	buf := &bytes.Buffer{}
+
+	ts := time.Now()
+	if msg.Timestamp != nil {
+		ts = *msg.Timestamp
+	}
+	fmt.Fprintf(buf, "%s %s %s: %s", ts.Format(time.RFC3339Nano), levelName(msg.Level), msg.Hostname, msg.ShortMessage)
+
+	fields := msgFields(msg, opts)
+	names := make([]string, 0, len(fields))
+	for key := range fields {
+		if strings.HasPrefix(key, "_") {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		fmt.Fprintf(buf, " %s=%v", key[1:], fields[key])
+	}
+	buf.WriteString("\n")
+
+	if msg.FullMessage != "" {
+		for _, line := range strings.Split(strings.TrimRight(msg.FullMessage, "\n"), "\n") {
+			buf.WriteString("\t" + line + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Render the message as a line of human readable text with its additional
+// fields after it, and its full message indented on the lines after that,
+// the same as it's written to a FallbackWriter
+func (m *Message) Text() string {
+	return string(renderText(m, serializeOptions{}))
+}
+
+// Write a message that couldn't be delivered to the FallbackWriter as text, if
+// there is one
+func (c *Client) writeFallback(msg *Message) {
+	if c.config.FallbackWriter == nil {
+		return
+	}
+
+	// Fields that can't be sent to the server aren't written either
+	data := renderText(msg, serializeOptions{
+		allowFields:     c.config.AllowFields,
+		denyFields:      c.config.DenyFields,
+		fieldCollisions: c.config.FieldCollisions,
+	})
+	c.fallbackMutex.Lock()
+	defer c.fallbackMutex.Unlock()
+	_, err := c.config.FallbackWriter.Write(data)
+	if err != nil {
+		c.reportErr(err)
+	}
+}
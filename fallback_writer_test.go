@@ -0,0 +1,127 @@
+package golf
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// An io.Writer that collects everything written to it, safe for concurrent
+// use
+type syncBuffer struct {
+	buffMutex sync.Mutex
+	buff      bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.buffMutex.Lock()
+	defer sb.buffMutex.Unlock()
+	return sb.buff.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.buffMutex.Lock()
+	defer sb.buffMutex.Unlock()
+	return sb.buff.String()
+}
+
+func (s *GolfSuite) TestRenderText(t sweet.T) {
+	ts := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	msg := newMessage()
+	msg.Level = LEVEL_WARN
+	msg.Hostname = "host"
+	msg.ShortMessage = "short message"
+	msg.FullMessage = "line one\nline two\n"
+	msg.Timestamp = &ts
+	msg.AddField("b", 2)
+	msg.AddField("a", "one")
+
+	Expect(string(renderText(msg, serializeOptions{}))).To(Equal(
+		"2017-07-14T02:40:00Z WARN host: short message a=one b=2\n" +
+			"\tline one\n" +
+			"\tline two\n"))
+
+	msg = newMessage()
+	msg.Level = 12
+	msg.Timestamp = &ts
+	Expect(string(renderText(msg, serializeOptions{}))).To(Equal("2017-07-14T02:40:00Z LEVEL12 : \n"))
+	Expect(msg.Text()).To(Equal("2017-07-14T02:40:00Z LEVEL12 : \n"))
+}
+
+func (s *GolfSuite) TestFallbackWriterSendFailed(t sweet.T) {
+	buf := &syncBuffer{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		FallbackWriter: buf,
+		Transform: func(msg *Message) *Message {
+			msg.AddField("transformed", true)
+			return msg
+		},
+	})
+	c.UseSink(&testSink{err: errors.New("send failed")})
+	defer c.Close()
+
+	c.Errf("failed message")
+	c.Flush()
+	Expect(buf.String()).To(MatchRegexp(`^\S+ ERR \S+: failed message transformed=true\n$`))
+}
+
+func (s *GolfSuite) TestFallbackWriterQueueFull(t sweet.T) {
+	buf := &syncBuffer{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		MaxQueueSize:   1,
+		FallbackWriter: buf,
+	})
+
+	c.Infof("queued")
+	Expect(c.Infof("queue full")).To(Equal(ErrQueueFull))
+	Expect(buf.String()).To(ContainSubstring("INFO"))
+	Expect(buf.String()).To(ContainSubstring(": queue full\n"))
+	Expect(buf.String()).ToNot(ContainSubstring("queued"))
+}
+
+func (s *GolfSuite) TestFallbackWriterDenyFields(t sweet.T) {
+	buf := &syncBuffer{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		MaxQueueSize:   1,
+		FallbackWriter: buf,
+		DenyFields:     []string{"token"},
+	})
+
+	c.Infof("queued")
+	c.QueueMsg((&Message{ShortMessage: "queue full"}).AddField("token", "secret"))
+	Expect(buf.String()).To(ContainSubstring(": queue full\n"))
+	Expect(buf.String()).ToNot(ContainSubstring("secret"))
+}
+
+func (s *GolfSuite) TestFallbackWriterNotUsed(t sweet.T) {
+	buf := &syncBuffer{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		FallbackWriter: buf,
+	})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	c.Infof("sent")
+	c.Flush()
+	Expect(buf.String()).To(Equal(""))
+}
+
+func (s *GolfSuite) TestFallbackWriterReconnectFailed(t sweet.T) {
+	buf := &syncBuffer{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		FallbackWriter: buf,
+	})
+
+	c.QueueMsgs([]*Message{{ShortMessage: "first"}, {ShortMessage: "second"}})
+	c.fail(ErrReconnectFailed)
+	Expect(buf.String()).To(MatchRegexp(`: first\n.*: second\n$`))
+}
@@ -0,0 +1,143 @@
+package golf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+)
+
+// A FileSink is a Sink that appends messages to a file as newline delimited
+// GELF JSON. It can be used as a client's FallbackSink to spool messages to
+// disk while the server can't be reached, and the spooled messages sent once
+// it can be with ReplayFile.
+type FileSink struct {
+	fileMutex sync.Mutex
+	file      *os.File
+	// Gzip each batch before it's written
+	compress bool
+}
+
+// Create a FileSink that appends to the file at path, creating it if it
+// doesn't exist
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		file: file,
+	}, nil
+}
+
+// Create a FileSink that appends to the file at path like NewFileSink, but
+// gzips each batch it writes so messages spooled during a long outage take up
+// less disk. The file is a series of gzip streams, one for each batch, which
+// ReplayFile and tools such as zcat read as a single one. Messages are
+// compressed separately from the client's Compression since they're only
+// ever read back from disk. A file should only be written to by one kind of
+// FileSink, ReplayFile can't read one with both.
+func NewGzipFileSink(path string) (*FileSink, error) {
+	fs, err := NewFileSink(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.compress = true
+	return fs, nil
+}
+
+// Write each message in the batch to the file on its own line
+func (fs *FileSink) Send(batch [][]byte) error {
+	fs.fileMutex.Lock()
+	defer fs.fileMutex.Unlock()
+
+	// Write the whole batch at once so a batch is never partially written
+	// by a failed write in the middle of it
+	size := 0
+	for _, data := range batch {
+		size += len(data) + 1
+	}
+	buf := make([]byte, 0, size)
+	for _, data := range batch {
+		buf = append(buf, data...)
+		buf = append(buf, '\n')
+	}
+	if fs.compress {
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		_, err := w.Write(buf)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return err
+		}
+		buf = compressed.Bytes()
+	}
+
+	_, err := fs.file.Write(buf)
+	return err
+}
+
+// Close the file
+func (fs *FileSink) Close() error {
+	fs.fileMutex.Lock()
+	defer fs.fileMutex.Unlock()
+
+	return fs.file.Close()
+}
+
+// Queue all of the messages spooled to the file at path by a FileSink on c and
+// wait for them to be sent. Files written by a NewGzipFileSink are
+// decompressed as they're read. The file is left as it is, once ReplayFile returns
+// without an error it can be removed or truncated. It shouldn't still be in
+// use by a FileSink since messages spooled while it's being replayed may not
+// be sent.
+//
+// Returns the error if any line in the file isn't a valid message, without
+// queueing any of the messages after it.
+func ReplayFile(path string, c *Client) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > 0 {
+			msg, parseErr := ParseMessage(line)
+			if parseErr != nil {
+				return parseErr
+			}
+			queueErr := c.QueueMsg(msg)
+			if queueErr != nil {
+				return queueErr
+			}
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return c.Flush()
+}
@@ -0,0 +1,128 @@
+package golf
+
+import (
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestFileSink(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.log")
+
+	fs, err := NewFileSink(path)
+	Expect(err).To(BeNil())
+	err = fs.Send([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)})
+	Expect(err).To(BeNil())
+	Expect(fs.Close()).To(BeNil())
+
+	// Reopening appends to what's already there
+	fs, err = NewFileSink(path)
+	Expect(err).To(BeNil())
+	err = fs.Send([][]byte{[]byte(`{"c":3}`)})
+	Expect(err).To(BeNil())
+	Expect(fs.Close()).To(BeNil())
+
+	data, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n"))
+}
+
+func (s *GolfSuite) TestFallbackSinkReplay(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.log")
+
+	fs, err := NewFileSink(path)
+	Expect(err).To(BeNil())
+
+	sendErr := errors.New("server unreachable")
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		FallbackSink: fs,
+	})
+	c.UseSink(&testSink{err: sendErr})
+	for idx := 0; idx < 10; idx++ {
+		c.Infof("message %d", idx)
+	}
+	Expect(c.Flush()).To(BeNil())
+	Expect(c.Errors()).To(Receive(WithTransform(sendErrCause, Equal(sendErr))))
+	c.Close()
+	fs.Close()
+
+	sink := &testSink{}
+	replay, _ := NewClient()
+	replay.UseSink(sink)
+	defer replay.Close()
+
+	err = ReplayFile(path, replay)
+	Expect(err).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(10))
+	Expect(sink.messages()).To(ContainElement("message 9"))
+}
+
+func (s *GolfSuite) TestReplayFileInvalid(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.log")
+
+	err = ioutil.WriteFile(path, []byte("{\"short_message\":\"ok\"}\nnot json\n"), 0644)
+	Expect(err).To(BeNil())
+
+	c, _ := NewClient()
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	err = ReplayFile(path, c)
+	Expect(err).ToNot(BeNil())
+
+	err = ReplayFile(filepath.Join(dir, "missing.log"), c)
+	Expect(os.IsNotExist(err)).To(BeTrue())
+}
+
+func (s *GolfSuite) TestGzipFileSinkReplay(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.log.gz")
+
+	fs, err := NewGzipFileSink(path)
+	Expect(err).To(BeNil())
+	err = fs.Send([][]byte{[]byte(`{"short_message":"one"}`), []byte(`{"short_message":"two"}`)})
+	Expect(err).To(BeNil())
+	Expect(fs.Close()).To(BeNil())
+
+	// Reopening appends another gzip stream
+	fs, err = NewGzipFileSink(path)
+	Expect(err).To(BeNil())
+	err = fs.Send([][]byte{[]byte(`{"short_message":"three"}`)})
+	Expect(err).To(BeNil())
+	Expect(fs.Close()).To(BeNil())
+
+	file, err := os.Open(path)
+	Expect(err).To(BeNil())
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	Expect(err).To(BeNil())
+	data, err := ioutil.ReadAll(gz)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("{\"short_message\":\"one\"}\n{\"short_message\":\"two\"}\n{\"short_message\":\"three\"}\n"))
+
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	err = ReplayFile(path, c)
+	Expect(err).To(BeNil())
+	Expect(sink.messages()).To(Equal([]string{"one", "two", "three"}))
+}
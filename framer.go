@@ -0,0 +1,119 @@
+package golf
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// A framer buffers a message and writes it with a trailing null byte when
+// flushed, which is how GELF messages are delimited over TCP. Another
+// delimiter can be used instead, such as a newline for newline delimited JSON.
+type framer struct {
+	buff      []byte
+	w         io.Writer
+	delimiter byte
+}
+
+func newFramer(w io.Writer) *framer {
+	f := &framer{
+		buff: make([]byte, 0),
+		w:    w,
+	}
+	return f
+}
+
+func (f *framer) reset() {
+	f.buff = f.buff[:0]
+}
+func (f *framer) Write(p []byte) (int, error) {
+	f.buff = append(f.buff, p...)
+	return len(p), nil
+}
+
+// Write the buffered message to the underlying io.Writer followed by the
+// delimiter. Nothing is written if there's no data buffered.
+func (f *framer) Flush() error {
+	if len(f.buff) == 0 {
+		return nil
+	}
+	defer f.reset()
+
+	f.buff = append(f.buff, f.delimiter)
+	_, err := f.w.Write(f.buff)
+	return err
+}
+
+// Write just the delimiter, with no message before it
+func (f *framer) writeEmpty() error {
+	_, err := f.w.Write([]byte{f.delimiter})
+	return err
+}
+
+// Size of the length prefix written before each message by a lengthFramer
+const lengthPrefixSize = 4
+
+// A lengthFramer buffers a message and writes it prefixed with its length as a
+// 4 byte big-endian integer when flushed, for receivers that accept length
+// prefixed GELF over TCP. Unlike null byte delimited messages these can be
+// compressed since the message data can contain null bytes.
+type lengthFramer struct {
+	buff []byte
+	w    io.Writer
+	// Write a CRC-32 of the message after it
+	checksum bool
+}
+
+func newLengthFramer(w io.Writer) *lengthFramer {
+	f := &lengthFramer{
+		buff: make([]byte, lengthPrefixSize),
+		w:    w,
+	}
+	return f
+}
+
+// Create a lengthFramer that also writes the 4 byte big-endian IEEE CRC-32 of
+// each message after it, so a receiver can detect corrupted messages
+func newChecksumFramer(w io.Writer) *lengthFramer {
+	f := newLengthFramer(w)
+	f.checksum = true
+	return f
+}
+
+func (f *lengthFramer) reset() {
+	f.buff = f.buff[:lengthPrefixSize]
+}
+func (f *lengthFramer) Write(p []byte) (int, error) {
+	f.buff = append(f.buff, p...)
+	return len(p), nil
+}
+
+// Write the length of the buffered message and then the message to the
+// underlying io.Writer. Nothing is written if there's no data buffered.
+func (f *lengthFramer) Flush() error {
+	if len(f.buff) == lengthPrefixSize {
+		return nil
+	}
+	defer f.reset()
+
+	binary.BigEndian.PutUint32(f.buff, uint32(len(f.buff)-lengthPrefixSize))
+	if f.checksum {
+		sum := crc32.ChecksumIEEE(f.buff[lengthPrefixSize:])
+		f.buff = append(f.buff, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(f.buff[len(f.buff)-4:], sum)
+	}
+	_, err := f.w.Write(f.buff)
+	return err
+}
+
+// Write a zero length prefix with no message after it, and the checksum of
+// the empty message if there is one
+func (f *lengthFramer) writeEmpty() error {
+	frame := make([]byte, lengthPrefixSize, lengthPrefixSize+4)
+	if f.checksum {
+		frame = append(frame, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(frame[lengthPrefixSize:], crc32.ChecksumIEEE(nil))
+	}
+	_, err := f.w.Write(frame)
+	return err
+}
@@ -0,0 +1,118 @@
+package golf
+
+import (
+	"errors"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type FramerSuite struct{}
+
+func (s *FramerSuite) TestFramerWrite(t sweet.T) {
+	frm := newFramer(nil)
+
+	Expect(frm.buff).To(HaveLen(0))
+
+	frm.Write([]byte{1, 2, 3, 4})
+	Expect(frm.buff).To(Equal([]byte{1, 2, 3, 4}))
+
+	frm.Write([]byte{5, 6, 7, 8})
+	Expect(frm.buff).To(Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+}
+
+func (s *FramerSuite) TestFramerFlush(t sweet.T) {
+	w := newTestWriter()
+	frm := newFramer(w)
+
+	frm.Write([]byte{1, 2, 3, 4, 5})
+	err := frm.Flush()
+	Expect(err).To(BeNil())
+
+	frm.Write([]byte{6, 7})
+	err = frm.Flush()
+	Expect(err).To(BeNil())
+
+	Expect(w.Written).To(HaveLen(2))
+	Expect(w.Written[0]).To(Equal([]byte{1, 2, 3, 4, 5, 0}))
+	Expect(w.Written[1]).To(Equal([]byte{6, 7, 0}))
+
+	Expect(frm.buff).To(HaveLen(0))
+}
+
+func (s *FramerSuite) TestFramerFlushDelimiter(t sweet.T) {
+	w := newTestWriter()
+	frm := newFramer(w)
+	frm.delimiter = '\n'
+
+	frm.Write([]byte(`{"a":1}`))
+	Expect(frm.Flush()).To(BeNil())
+	Expect(w.Written).To(Equal([][]byte{[]byte("{\"a\":1}\n")}))
+}
+
+func (s *FramerSuite) TestFramerFlushEmpty(t sweet.T) {
+	w := newTestWriter()
+	frm := newFramer(w)
+
+	err := frm.Flush()
+	Expect(err).To(BeNil())
+
+	Expect(w.Written).To(HaveLen(0))
+}
+
+func (s *FramerSuite) TestFramerFlushWriteError(t sweet.T) {
+	writeErr := errors.New("write failed")
+	frm := newFramer(newFailWriter(0, writeErr))
+
+	frm.Write([]byte{1, 2, 3})
+	err := frm.Flush()
+	Expect(err).To(Equal(writeErr))
+	Expect(frm.buff).To(HaveLen(0))
+}
+
+func (s *FramerSuite) TestLengthFramerFlush(t sweet.T) {
+	w := newTestWriter()
+	frm := newLengthFramer(w)
+
+	Expect(frm.Flush()).To(BeNil())
+	Expect(w.Written).To(HaveLen(0))
+
+	frm.Write([]byte{1, 2, 0, 4, 5})
+	err := frm.Flush()
+	Expect(err).To(BeNil())
+
+	frm.Write([]byte{6, 7})
+	err = frm.Flush()
+	Expect(err).To(BeNil())
+
+	Expect(w.Written).To(HaveLen(2))
+	Expect(w.Written[0]).To(Equal([]byte{0, 0, 0, 5, 1, 2, 0, 4, 5}))
+	Expect(w.Written[1]).To(Equal([]byte{0, 0, 0, 2, 6, 7}))
+}
+
+func (s *FramerSuite) TestLengthFramerFlushWriteError(t sweet.T) {
+	writeErr := errors.New("write failed")
+	frm := newLengthFramer(newFailWriter(0, writeErr))
+
+	frm.Write([]byte{1, 2, 3})
+	err := frm.Flush()
+	Expect(err).To(Equal(writeErr))
+	Expect(frm.buff).To(HaveLen(lengthPrefixSize))
+}
+
+func (s *FramerSuite) TestChecksumFramerFlush(t sweet.T) {
+	w := newTestWriter()
+	frm := newChecksumFramer(w)
+
+	frm.Write([]byte("123456789"))
+	Expect(frm.Flush()).To(BeNil())
+	frm.Write([]byte{6, 7})
+	Expect(frm.Flush()).To(BeNil())
+
+	// 0xcbf43926 is the standard check value for CRC-32 of "123456789"
+	Expect(w.Written).To(HaveLen(2))
+	Expect(w.Written[0]).To(Equal(append(append([]byte{0, 0, 0, 9}, "123456789"...), 0xcb, 0xf4, 0x39, 0x26)))
+	Expect(w.Written[1][:6]).To(Equal([]byte{0, 0, 0, 2, 6, 7}))
+	Expect(w.Written[1]).To(HaveLen(10))
+	Expect(frm.buff).To(HaveLen(lengthPrefixSize))
+}
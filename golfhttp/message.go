@@ -0,0 +1,52 @@
+/*
+Provides helpers for logging HTTP requests using golf
+*/
+package golfhttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aphistic/golf"
+)
+
+// Create a message for an access log entry for r, which was responded to
+// with 'status' after taking 'dur'. The short message is the method, path and
+// status, such as "GET /index.html 200", and the same values are added as the
+// "method", "path" and "status" attributes along with "duration_ms",
+// "remote_addr" and "user_agent". The user agent is left out if the request
+// didn't have one.
+//
+// The message's level is LEVEL_ERR for 5xx statuses, LEVEL_WARN for 4xx
+// statuses and LEVEL_INFO for any others.
+func MessageFromRequest(r *http.Request, status int, dur time.Duration) *golf.Message {
+	msg := &golf.Message{
+		Level:        statusLevel(status),
+		ShortMessage: fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, status),
+		Attrs: map[string]interface{}{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      status,
+			"duration_ms": float64(dur) / float64(time.Millisecond),
+			"remote_addr": r.RemoteAddr,
+		},
+	}
+	if agent := r.UserAgent(); agent != "" {
+		msg.Attrs["user_agent"] = agent
+	}
+
+	return msg
+}
+
+// Get the level to log a response with 'status' at
+func statusLevel(status int) int {
+	switch {
+	case status >= 500:
+		return golf.LEVEL_ERR
+	case status >= 400:
+		return golf.LEVEL_WARN
+	default:
+		return golf.LEVEL_INFO
+	}
+}
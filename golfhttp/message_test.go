@@ -0,0 +1,45 @@
+package golfhttp
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"github.com/aphistic/golf"
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type MessageSuite struct{}
+
+func (s *MessageSuite) TestMessageFromRequest(t sweet.T) {
+	r := httptest.NewRequest("POST", "/users/42?verbose=1", nil)
+	r.RemoteAddr = "192.0.2.1:5123"
+	r.Header.Set("User-Agent", "golf-test/1.0")
+
+	msg := MessageFromRequest(r, 201, 1500*time.Microsecond)
+	Expect(msg.Level).To(Equal(golf.LEVEL_INFO))
+	Expect(msg.ShortMessage).To(Equal("POST /users/42 201"))
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"method":      "POST",
+		"path":        "/users/42",
+		"status":      201,
+		"duration_ms": 1.5,
+		"remote_addr": "192.0.2.1:5123",
+		"user_agent":  "golf-test/1.0",
+	}))
+}
+
+func (s *MessageSuite) TestMessageFromRequestLevel(t sweet.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	Expect(MessageFromRequest(r, 302, 0).Level).To(Equal(golf.LEVEL_INFO))
+	Expect(MessageFromRequest(r, 404, 0).Level).To(Equal(golf.LEVEL_WARN))
+	Expect(MessageFromRequest(r, 503, 0).Level).To(Equal(golf.LEVEL_ERR))
+}
+
+func (s *MessageSuite) TestMessageFromRequestNoUserAgent(t sweet.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Del("User-Agent")
+
+	msg := MessageFromRequest(r, 200, 0)
+	Expect(msg.Attrs).ToNot(HaveKey("user_agent"))
+}
@@ -0,0 +1,74 @@
+package golftest
+
+import (
+	"time"
+)
+
+// How long the chunks of a message are kept waiting for the rest of the
+// chunks to arrive. This matches the timeout the GELF spec gives servers.
+const chunkTimeout = 5 * time.Second
+
+type chunkSet struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// An assembler reassembles the messages received in chunks
+type assembler struct {
+	chunks map[string]*chunkSet
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		chunks: make(map[string]*chunkSet, 0),
+	}
+}
+
+// Add data to the chunks being reassembled. If data isn't a chunk it's
+// returned as-is, otherwise the full message is returned once all its chunks
+// have been received and nil is returned until then.
+func (a *assembler) reassemble(data []byte) []byte {
+	if len(data) < 12 || data[0] != 0x1e || data[1] != 0x0f {
+		return data
+	}
+
+	now := time.Now()
+	for id, set := range a.chunks {
+		if now.Sub(set.started) > chunkTimeout {
+			delete(a.chunks, id)
+		}
+	}
+
+	id := string(data[2:10])
+	seq := int(data[10])
+	total := int(data[11])
+	if total == 0 || seq >= total {
+		return nil
+	}
+
+	set, ok := a.chunks[id]
+	if !ok {
+		set = &chunkSet{
+			chunks:  make([][]byte, total),
+			started: now,
+		}
+		a.chunks[id] = set
+	}
+	if len(set.chunks) != total || set.chunks[seq] != nil {
+		return nil
+	}
+
+	set.chunks[seq] = data[12:]
+	set.received++
+	if set.received < total {
+		return nil
+	}
+
+	delete(a.chunks, id)
+	full := make([]byte, 0)
+	for _, chunk := range set.chunks {
+		full = append(full, chunk...)
+	}
+	return full
+}
@@ -0,0 +1,102 @@
+package golftest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/aphistic/golf"
+)
+
+// A DevServer is a GELF server listening for UDP messages that prints every
+// message it receives, for developing without a real GELF server such as
+// Graylog. Messages are reassembled, decompressed and decoded the same as by
+// a TestReceiver, then written to stdout as text with Message.Text.
+type DevServer struct {
+	conn *net.UDPConn
+
+	outMutex sync.Mutex
+	out      io.Writer
+
+	chunks *assembler
+
+	done chan int
+}
+
+// Create a new DevServer listening on addr, such as "127.0.0.1:12201" or
+// ":0" for a random port, that prints the messages it receives to stdout
+func NewDevServer(addr string) (*DevServer, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &DevServer{
+		conn:   conn,
+		out:    os.Stdout,
+		chunks: newAssembler(),
+		done:   make(chan int),
+	}
+
+	go ds.receive()
+
+	return ds, nil
+}
+
+// Address of the server as a URI that can be passed to Client.Dial
+func (ds *DevServer) Addr() string {
+	return "udp://" + ds.conn.LocalAddr().String()
+}
+
+// Print messages to w instead of stdout from now on
+func (ds *DevServer) SetOutput(w io.Writer) {
+	ds.outMutex.Lock()
+	defer ds.outMutex.Unlock()
+
+	ds.out = w
+}
+
+// Stop listening for messages
+func (ds *DevServer) Close() error {
+	err := ds.conn.Close()
+	<-ds.done
+	return err
+}
+
+func (ds *DevServer) receive() {
+	defer close(ds.done)
+
+	buf := make([]byte, 65536)
+	for {
+		n, err := ds.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		data = ds.chunks.reassemble(data)
+		if data == nil {
+			continue
+		}
+
+		var text string
+		msg, err := golf.ParseMessage(data)
+		if err != nil {
+			text = "golftest: couldn't decode message: " + err.Error() + "\n"
+		} else {
+			text = msg.Text()
+		}
+
+		ds.outMutex.Lock()
+		io.WriteString(ds.out, text)
+		ds.outMutex.Unlock()
+	}
+}
@@ -0,0 +1,80 @@
+package golftest
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aphistic/golf"
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type DevServerSuite struct{}
+
+type syncBuffer struct {
+	buffMutex sync.Mutex
+	buff      bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.buffMutex.Lock()
+	defer sb.buffMutex.Unlock()
+	return sb.buff.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.buffMutex.Lock()
+	defer sb.buffMutex.Unlock()
+	return sb.buff.String()
+}
+
+func (s *DevServerSuite) TestPrintMessages(t sweet.T) {
+	ds, err := NewDevServer("127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ds.Close()
+	out := &syncBuffer{}
+	ds.SetOutput(out)
+
+	Expect(ds.Addr()).To(HavePrefix("udp://127.0.0.1:"))
+
+	c, err := golf.NewClientWithConfig(golf.ClientConfig{
+		ChunkSize:   100,
+		Compression: golf.COMP_GZIP,
+	})
+	Expect(err).To(BeNil())
+	Expect(c.Dial(ds.Addr())).To(BeNil())
+
+	l, _ := c.NewLogger()
+	l.SetAttr("facility", "golftest")
+	msg := l.NewMessage()
+	msg.Level = golf.LEVEL_ERR
+	msg.ShortMessage = "large message"
+	msg.FullMessage = strings.Repeat("0123456789", 20) + "\nsecond line"
+	c.QueueMsg(msg)
+	c.Close()
+
+	Eventually(out.String, 5*time.Second).Should(ContainSubstring("second line"))
+	lines := strings.Split(out.String(), "\n")
+	Expect(lines[0]).To(ContainSubstring(" ERR "))
+	Expect(lines[0]).To(HaveSuffix(": large message facility=golftest"))
+	Expect(lines[1]).To(Equal("\t" + strings.Repeat("0123456789", 20)))
+	Expect(lines[2]).To(Equal("\tsecond line"))
+}
+
+func (s *DevServerSuite) TestPrintDecodeErrors(t sweet.T) {
+	ds, err := NewDevServer("127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ds.Close()
+	out := &syncBuffer{}
+	ds.SetOutput(out)
+
+	Expect(sendRaw(ds.conn.LocalAddr().String(), []byte("not gelf"))).To(BeNil())
+	Eventually(out.String, 5*time.Second).Should(HavePrefix("golftest: couldn't decode message: "))
+}
+
+func (s *DevServerSuite) TestBadAddr(t sweet.T) {
+	_, err := NewDevServer("not an address")
+	Expect(err).ToNot(BeNil())
+}
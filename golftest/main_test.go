@@ -0,0 +1,18 @@
+package golftest
+
+import (
+	"testing"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func TestMain(m *testing.M) {
+	RegisterFailHandler(sweet.GomegaFail)
+
+	sweet.Run(m, func(s *sweet.S) {
+		s.AddSuite(&ReceiverSuite{})
+		s.AddSuite(&DevServerSuite{})
+		s.AddSuite(&RecorderSuite{})
+	})
+}
@@ -0,0 +1,123 @@
+/*
+Provides helpers for testing code that logs using golf
+*/
+package golftest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/aphistic/golf"
+)
+
+// A TestReceiver is a GELF server listening for UDP messages on the loopback
+// interface. Messages it receives are reassembled, decompressed and decoded
+// so tests can make assertions on the messages that were sent.
+type TestReceiver struct {
+	conn *net.UDPConn
+
+	msgsMutex sync.Mutex
+	msgs      []*golf.Message
+	errs      []error
+
+	chunks *assembler
+
+	done chan int
+}
+
+// Create a new TestReceiver listening on a random port. Use Addr() to get the
+// URI to Dial to send messages to it.
+func NewTestReceiver() (*TestReceiver, error) {
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TestReceiver{
+		conn:   conn,
+		msgs:   make([]*golf.Message, 0),
+		errs:   make([]error, 0),
+		chunks: newAssembler(),
+		done:   make(chan int),
+	}
+
+	go r.receive()
+
+	return r, nil
+}
+
+// Address of the receiver as a URI that can be passed to Client.Dial
+func (r *TestReceiver) Addr() string {
+	return "udp://" + r.conn.LocalAddr().String()
+}
+
+// Retrieve a copy of all the messages that have been received so far
+func (r *TestReceiver) Messages() []*golf.Message {
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+
+	msgs := make([]*golf.Message, len(r.msgs))
+	copy(msgs, r.msgs)
+	return msgs
+}
+
+// Retrieve any errors that happened while decoding received messages
+func (r *TestReceiver) Errors() []error {
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+
+	errs := make([]error, len(r.errs))
+	copy(errs, r.errs)
+	return errs
+}
+
+// Remove all the received messages and errors
+func (r *TestReceiver) Reset() {
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+
+	r.msgs = make([]*golf.Message, 0)
+	r.errs = make([]error, 0)
+}
+
+// Stop listening for messages
+func (r *TestReceiver) Close() error {
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
+
+func (r *TestReceiver) receive() {
+	defer close(r.done)
+
+	buf := make([]byte, 65536)
+	for {
+		n, err := r.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		data = r.chunks.reassemble(data)
+		if data == nil {
+			continue
+		}
+
+		msg, err := golf.ParseMessage(data)
+
+		r.msgsMutex.Lock()
+		if err != nil {
+			r.errs = append(r.errs, err)
+		} else {
+			r.msgs = append(r.msgs, msg)
+		}
+		r.msgsMutex.Unlock()
+	}
+}
@@ -0,0 +1,73 @@
+package golftest
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aphistic/golf"
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type ReceiverSuite struct{}
+
+func (s *ReceiverSuite) TestReceiveMessages(t sweet.T) {
+	r, err := NewTestReceiver()
+	Expect(err).To(BeNil())
+	defer r.Close()
+
+	Expect(r.Addr()).To(HavePrefix("udp://127.0.0.1:"))
+
+	for _, compress := range []string{"none", "gzip", "zlib"} {
+		r.Reset()
+
+		c, err := golf.NewClientWithConfig(golf.ClientConfig{
+			ChunkSize: 100,
+		})
+		Expect(err).To(BeNil())
+		err = c.Dial(r.Addr() + "?compress=" + compress)
+		Expect(err).To(BeNil())
+
+		l, _ := c.NewLogger()
+		l.SetAttr("facility", "golftest")
+
+		fullMsg := strings.Repeat("0123456789", 100)
+		msg := l.NewMessage()
+		msg.Level = golf.LEVEL_ERR
+		msg.ShortMessage = "large message"
+		msg.FullMessage = fullMsg
+		c.QueueMsg(msg)
+		l.Infom(map[string]interface{}{"attr1": 1234}, "small message")
+
+		c.Close()
+
+		Eventually(func() []*golf.Message {
+			return r.Messages()
+		}, 5*time.Second).Should(HaveLen(2))
+		Expect(r.Errors()).To(BeEmpty())
+
+		msgs := r.Messages()
+		Expect(msgs[0].Level).To(Equal(golf.LEVEL_ERR))
+		Expect(msgs[0].ShortMessage).To(Equal("large message"))
+		Expect(msgs[0].FullMessage).To(Equal(fullMsg))
+		Expect(msgs[0].Attrs["facility"]).To(Equal("golftest"))
+		Expect(msgs[0].Timestamp).ToNot(BeNil())
+
+		Expect(msgs[1].Level).To(Equal(golf.LEVEL_INFO))
+		Expect(msgs[1].ShortMessage).To(Equal("small message"))
+		Expect(msgs[1].Attrs["attr1"]).To(Equal(float64(1234)))
+	}
+}
+
+// Send data to addr in a single udp datagram
+func sendRaw(addr string, data []byte) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,66 @@
+package golftest
+
+import (
+	"sync"
+
+	"github.com/aphistic/golf"
+)
+
+// A Recorder is a golf.Sink that keeps every message sent to it, in the order
+// they were sent, so tests can make assertions on them without a server.
+type Recorder struct {
+	msgsMutex sync.Mutex
+	msgs      []*golf.Message
+}
+
+// Create a Client that records the messages it sends to a Recorder instead of
+// sending them to a server. The client sends each message from the goroutine
+// that queues it, so it's been recorded by the time QueueMsg or the logging
+// function returns, and there's nothing to wait for before checking the
+// Recorder's messages. Messages are decoded from the GELF the client
+// serialized them to, so they include the attributes from the client's config
+// and loggers the same as a server would see.
+func NewRecordingClient() (*golf.Client, *Recorder) {
+	c, _ := golf.NewClientWithConfig(golf.ClientConfig{
+		ChunkSize:   1420,
+		Synchronous: true,
+	})
+	r := &Recorder{}
+	c.UseSink(r)
+	return c, r
+}
+
+// Record a batch of messages
+func (r *Recorder) Send(batch [][]byte) error {
+	msgs := make([]*golf.Message, 0, len(batch))
+	for _, data := range batch {
+		msg, err := golf.ParseMessage(data)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+	r.msgs = append(r.msgs, msgs...)
+	return nil
+}
+
+// Retrieve a copy of all the messages that have been recorded so far
+func (r *Recorder) Messages() []*golf.Message {
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+
+	msgs := make([]*golf.Message, len(r.msgs))
+	copy(msgs, r.msgs)
+	return msgs
+}
+
+// Remove all the recorded messages
+func (r *Recorder) Reset() {
+	r.msgsMutex.Lock()
+	defer r.msgsMutex.Unlock()
+
+	r.msgs = nil
+}
@@ -0,0 +1,46 @@
+package golftest
+
+import (
+	"github.com/aphistic/golf"
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type RecorderSuite struct{}
+
+func (s *RecorderSuite) TestRecordingClient(t sweet.T) {
+	c, r := NewRecordingClient()
+	defer c.Close()
+
+	l, _ := c.NewLogger()
+	l.SetAttr("service", "api")
+	l.Infof("first")
+	c.QueueMsg(&golf.Message{Level: golf.LEVEL_WARN, ShortMessage: "second"})
+	c.QueueMsgs([]*golf.Message{{ShortMessage: "third"}, {ShortMessage: "fourth"}})
+
+	// Recorded as soon as they're queued, in order
+	msgs := r.Messages()
+	Expect(msgs).To(HaveLen(4))
+	texts := make([]string, len(msgs))
+	for idx, msg := range msgs {
+		texts[idx] = msg.ShortMessage
+	}
+	Expect(texts).To(Equal([]string{"first", "second", "third", "fourth"}))
+	Expect(msgs[0].Attrs).To(HaveKeyWithValue("service", "api"))
+	Expect(msgs[1].Level).To(Equal(golf.LEVEL_WARN))
+
+	r.Reset()
+	Expect(r.Messages()).To(HaveLen(0))
+}
+
+func (s *RecorderSuite) TestRecordingClientPaused(t sweet.T) {
+	c, r := NewRecordingClient()
+	defer c.Close()
+
+	c.Pause()
+	c.Infof("paused")
+	Expect(r.Messages()).To(HaveLen(0))
+	c.Resume()
+	c.Flush()
+	Expect(r.Messages()).To(HaveLen(1))
+}
@@ -0,0 +1,30 @@
+package golf
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+var goroutinePrefix = []byte("goroutine ")
+
+// Get the ID of the current goroutine from the header of its stack trace,
+// "goroutine 18 [running]:", or 0 if it can't be found
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	if !bytes.HasPrefix(buf, goroutinePrefix) {
+		return 0
+	}
+	buf = buf[len(goroutinePrefix):]
+
+	end := bytes.IndexByte(buf, ' ')
+	if end < 0 {
+		return 0
+	}
+	id, err := strconv.ParseUint(string(buf[:end]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,56 @@
+package golf
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestGoroutineID(t sweet.T) {
+	id := goroutineID()
+	Expect(id).ToNot(BeZero())
+	Expect(goroutineID()).To(Equal(id))
+
+	other := make(chan uint64)
+	go func() {
+		other <- goroutineID()
+	}()
+	Expect(<-other).ToNot(Equal(id))
+}
+
+func (s *GolfSuite) TestClientIncludeGoroutineID(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		IncludeGoroutineID: true,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	done := make(chan uint64)
+	go func() {
+		c.Infof("from another goroutine")
+		done <- goroutineID()
+	}()
+	other := <-done
+	c.Flush()
+	c.QueueMsgs([]*Message{{ShortMessage: "batched"}})
+	c.Flush()
+
+	first, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(first.Attrs[GOROUTINE_ATTR]).To(Equal(float64(other)))
+	second, err := ParseMessage(sink.batches[1][0])
+	Expect(err).To(BeNil())
+	Expect(second.Attrs[GOROUTINE_ATTR]).To(Equal(float64(goroutineID())))
+}
+
+func (s *GolfSuite) TestClientNoGoroutineID(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(string(sink.batches[0][0])).ToNot(ContainSubstring(`"_goroutine"`))
+}
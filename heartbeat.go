@@ -0,0 +1,71 @@
+package golf
+
+import (
+	"time"
+)
+
+// The short message of heartbeat messages when HeartbeatMessage isn't set
+const DEFAULT_HEARTBEAT_MESSAGE = "heartbeat"
+
+// Start queueing a heartbeat message every HeartbeatInterval until the
+// heartbeat is stopped, if there's an interval set
+func (c *Client) startHeartbeat() {
+	if c.config.HeartbeatInterval <= 0 {
+		return
+	}
+
+	c.heartbeatQuit = make(chan int)
+	c.heartbeatDone = make(chan int)
+	go func(quit chan int, done chan int) {
+		defer close(done)
+
+		ticker := time.NewTicker(c.config.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := c.queueMsg(c.heartbeatMsg(), 0)
+				if err != nil && err != ErrClosing {
+					c.reportErr(err)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}(c.heartbeatQuit, c.heartbeatDone)
+}
+
+// Stop queueing heartbeat messages and wait for the heartbeat to stop
+func (c *Client) stopHeartbeat() {
+	if c.heartbeatQuit == nil {
+		return
+	}
+
+	close(c.heartbeatQuit)
+	<-c.heartbeatDone
+	c.heartbeatQuit = nil
+	c.heartbeatDone = nil
+}
+
+func (c *Client) heartbeatMsg() *Message {
+	text := c.config.HeartbeatMessage
+	if text == "" {
+		text = DEFAULT_HEARTBEAT_MESSAGE
+	}
+
+	return c.markerMsg(text, c.config.HeartbeatFields)
+}
+
+// Create an info message the client sends on its own, such as a heartbeat or
+// lifecycle message, with text as its short message and fields as its
+// attributes
+func (c *Client) markerMsg(text string, fields map[string]interface{}) *Message {
+	msg := newMessage()
+	msg.Level = LEVEL_INFO
+	msg.Hostname = c.hostname
+	msg.ShortMessage = text
+	for name, val := range fields {
+		msg.AddField(name, val)
+	}
+	return msg
+}
@@ -0,0 +1,54 @@
+package golf
+
+import (
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestHeartbeat(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		HeartbeatInterval: 5 * time.Millisecond,
+		HeartbeatMessage:  "still alive",
+		HeartbeatFields:   map[string]interface{}{"service": "api"},
+	})
+	c.UseSink(sink)
+
+	Eventually(func() int {
+		return len(sink.messages())
+	}).Should(BeNumerically(">=", 2))
+	Expect(c.Close()).To(BeNil())
+
+	msg, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("still alive"))
+	Expect(msg.Level).To(Equal(LEVEL_INFO))
+	Expect(msg.Hostname).To(Equal(c.hostname))
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{"service": "api"}))
+
+	// Stopped once it's closed
+	count := len(sink.messages())
+	Consistently(func() int {
+		return len(sink.messages())
+	}, 50*time.Millisecond).Should(Equal(count))
+}
+
+func (s *GolfSuite) TestHeartbeatDefaultMessage(t sweet.T) {
+	c, _ := NewClient()
+	msg := c.heartbeatMsg()
+	Expect(msg.ShortMessage).To(Equal(DEFAULT_HEARTBEAT_MESSAGE))
+	Expect(msg.Attrs).To(HaveLen(0))
+}
+
+func (s *GolfSuite) TestHeartbeatDisabled(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	Expect(c.heartbeatQuit).To(BeNil())
+	Consistently(sink.messages, 20*time.Millisecond).Should(HaveLen(0))
+}
@@ -0,0 +1,66 @@
+package golf
+
+import (
+	"time"
+)
+
+// Start probing the client's tcp connection whenever it's been idle for
+// IdleTimeout until the probe is stopped, if there's a timeout set
+func (c *Client) startIdleProbe() {
+	if c.config.IdleTimeout <= 0 {
+		return
+	}
+
+	c.idleProbeQuit = make(chan int)
+	c.idleProbeDone = make(chan int)
+	go func(quit chan int, done chan int) {
+		defer close(done)
+
+		// Checking twice as often as the timeout means a connection is
+		// probed at most one and a half timeouts after it was last used
+		ticker := time.NewTicker(c.config.IdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.probeIdle()
+			case <-quit:
+				return
+			}
+		}
+	}(c.idleProbeQuit, c.idleProbeDone)
+}
+
+// Stop probing and wait for the probe to stop
+func (c *Client) stopIdleProbe() {
+	if c.idleProbeQuit == nil {
+		return
+	}
+
+	close(c.idleProbeQuit)
+	<-c.idleProbeDone
+	c.idleProbeQuit = nil
+	c.idleProbeDone = nil
+}
+
+// Write an empty frame with each of the senders that haven't written
+// anything for IdleTimeout. Writing it fails if the connection's dead, which
+// reconnects it.
+func (c *Client) probeIdle() {
+	c.connMutex.Lock()
+	sinks := c.sinks
+	c.connMutex.Unlock()
+
+	for _, sink := range sinks {
+		s, ok := sink.(*sender)
+		if !ok {
+			continue
+		}
+		_, err := s.probe(c.config.IdleTimeout)
+		if err == ErrReconnectFailed {
+			c.fail(err)
+		} else if err != nil {
+			c.reportErr(&SendError{Op: SEND_OP_WRITE, Err: err})
+		}
+	}
+}
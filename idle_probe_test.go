@@ -0,0 +1,125 @@
+package golf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// Accept connections on listener, sending everything read from each one to
+// the channel returned
+func readConns(listener net.Listener) <-chan []byte {
+	received := make(chan []byte, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						conn.Close()
+						return
+					}
+					received <- append([]byte{}, buf[:n]...)
+				}
+			}()
+		}
+	}()
+	return received
+}
+
+func (s *GolfSuite) TestIdleProbe(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	received := readConns(listener)
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	Expect(c.Dial("tcp://" + listener.Addr().String())).To(BeNil())
+
+	Eventually(received).Should(Receive(Equal([]byte{0})))
+	Expect(c.Close()).To(BeNil())
+
+	// Stopped once it's closed
+	Consistently(received, 60*time.Millisecond).ShouldNot(Receive())
+}
+
+func (s *GolfSuite) TestIdleProbeNotWhileBusy(t sweet.T) {
+	c, _ := NewClient()
+	frmr := newFramer(&bytes.Buffer{})
+	sndr := newSenderForWriter(frmr)
+	sndr.client = c
+
+	Expect(sndr.writeMsg([]byte("{}"), COMP_NONE, 0)).To(BeNil())
+	probed, err := sndr.probe(time.Hour)
+	Expect(err).To(BeNil())
+	Expect(probed).To(BeFalse())
+
+	probed, err = sndr.probe(0)
+	Expect(err).To(BeNil())
+	Expect(probed).To(BeTrue())
+	Expect(frmr.w.(*bytes.Buffer).Bytes()).To(Equal([]byte("{}\x00\x00")))
+
+	// Only tcp frames can be empty
+	chnk, _ := newChunker(newTestWriter(), 1420)
+	probed, err = newSenderForWriter(chnk).probe(0)
+	Expect(err).To(BeNil())
+	Expect(probed).To(BeFalse())
+}
+
+func (s *GolfSuite) TestIdleProbeLengthFraming(t sweet.T) {
+	buf := &bytes.Buffer{}
+	Expect(newLengthFramer(buf).writeEmpty()).To(BeNil())
+	Expect(buf.Bytes()).To(Equal([]byte{0, 0, 0, 0}))
+
+	buf.Reset()
+	Expect(newChecksumFramer(buf).writeEmpty()).To(BeNil())
+	expected := make([]byte, 8)
+	binary.BigEndian.PutUint32(expected[4:], crc32.ChecksumIEEE(nil))
+	Expect(buf.Bytes()).To(Equal(expected))
+}
+
+func (s *GolfSuite) TestIdleProbeReconnects(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	Expect(c.Dial("tcp://" + listener.Addr().String())).To(BeNil())
+	defer c.Close()
+
+	// The server drops the connection while nothing's being sent
+	conn, err := listener.Accept()
+	Expect(err).To(BeNil())
+	conn.Close()
+
+	Eventually(func() uint64 {
+		return c.Stats().Reconnects
+	}, 5*time.Second).Should(BeNumerically(">=", 1))
+
+	// The new connection is probed too
+	conn, err = listener.Accept()
+	Expect(err).To(BeNil())
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(conn, buf)
+	Expect(err).To(BeNil())
+	Expect(buf).To(Equal([]byte{0}))
+}
@@ -1,8 +1,26 @@
 package golf
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Workaround for json encoding 64 bit floats.  When using
@@ -19,8 +37,246 @@ func (jf *jsonFloat) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("%0f", jf.val)), nil
 }
 
-func generateMsgJson(msg *Message) (string, error) {
-	obj := make(map[string]interface{}, 0)
+// Options for how messages are serialized, set from the client's config
+type serializeOptions struct {
+	maxFieldBytes int
+	// Largest []byte attribute sent, before it's base64 encoded
+	maxBinaryFieldBytes int
+	// Attrs added to every message, which any other attrs override
+	defaultAttrs map[string]interface{}
+	// One of the FIELD_COLLISION_* constants
+	fieldCollisions int
+	// The FIELD_TYPE_* to send additional fields as, by name
+	fieldTypes map[string]int
+	// Renders the full message of messages that don't have one
+	fullMessageTemplate *template.Template
+	// Attrs added to messages at errorLevel or more severe, overriding
+	// only the default attrs
+	errorFields map[string]interface{}
+	errorLevel  int
+	// Prefix added to the name of every additional field
+	fieldPrefix string
+	// One of the FIELD_ERR_* constants, and where to report fields that
+	// were replaced with a placeholder
+	unencodable int
+	reportErr   func(error)
+	// Send integers too large for a float64 as strings
+	largeIntsAsStrings bool
+	// Changes the name of every additional field, after it's prefixed
+	keyTransform func(string) string
+	// Patterns for the additional fields that can be sent and the ones
+	// that can't, and a counter of how many fields were left out
+	allowFields    []string
+	denyFields     []string
+	fieldsStripped *uint64
+	// Most additional fields sent, and a counter of the fields left out
+	// because of it
+	maxFields       int
+	fieldsTruncated *uint64
+}
+
+// Generate the JSON for msg, returning an error instead of panicking if
+// anything in the message (such as an attribute's MarshalJSON) panics
+func serializeMsg(msg *Message, opts serializeOptions) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			data = nil
+			err = fmt.Errorf("panic while serializing message: %v", r)
+		}
+	}()
+
+	return generateMsgJson(msg, opts)
+}
+
+func generateMsgJson(msg *Message, opts serializeOptions) ([]byte, error) {
+	obj := msgFields(msg, opts)
+
+	// The fields are written in sorted order, the same as encoding/json
+	// writes map keys, so the same message always serializes to the same
+	// JSON no matter what order the attrs were added in
+	keysPtr := sortedKeysPool.Get().(*[]string)
+	keys := (*keysPtr)[:0]
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	defer func() {
+		for idx := range keys {
+			keys[idx] = ""
+		}
+		*keysPtr = keys[:0]
+		sortedKeysPool.Put(keysPtr)
+	}()
+
+	// Roughly enough for a message with short fields
+	buf := make([]byte, 0, 256+32*len(keys))
+	buf = append(buf, '{')
+	for idx, key := range keys {
+		if idx > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+
+		val := obj[key]
+		var err error
+		buf, err = appendJSONValue(buf, val)
+		if err == nil {
+			continue
+		}
+		if !strings.HasPrefix(key, "_") || opts.unencodable != FIELD_ERR_DROP_FIELD {
+			return nil, err
+		}
+
+		// Replace the field and carry on with the rest of them
+		buf = appendJSONString(buf, fmt.Sprintf("[unencodable %T]", val))
+		if opts.reportErr != nil {
+			opts.reportErr(unencodableErr(key[1:], err))
+		}
+	}
+	buf = append(buf, '}')
+
+	return buf, nil
+}
+
+// Slices used to sort the field names of each message serialized, so messages
+// with a lot of fields don't need a new one each time
+var sortedKeysPool = sync.Pool{
+	New: func() interface{} {
+		keys := make([]string, 0, 32)
+		return &keys
+	},
+}
+
+// Append the JSON encoding of val to buf. The common types are written
+// directly, anything else is encoded with encoding/json. If val can't be
+// encoded buf is returned unchanged with the error.
+func appendJSONValue(buf []byte, val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case string:
+		return appendJSONString(buf, v), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, data...), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// Append str to buf as a JSON string, escaped the same way encoding/json
+// escapes strings, including the HTML characters <, > and &. Invalid UTF-8 is
+// replaced with U+FFFD.
+func appendJSONString(buf []byte, str string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for idx := 0; idx < len(str); {
+		if b := str[idx]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				idx++
+				continue
+			}
+			buf = append(buf, str[start:idx]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			idx++
+			start = idx
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(str[idx:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, str[start:idx]...)
+			buf = append(buf, "\ufffd"...)
+			idx += size
+			start = idx
+			continue
+		}
+		// These are valid JSON but not valid JavaScript
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, str[start:idx]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			idx += size
+			start = idx
+			continue
+		}
+		idx += size
+	}
+	buf = append(buf, str[start:]...)
+	return append(buf, '"')
+}
+
+func unencodableErr(name string, err error) error {
+	return fmt.Errorf("field %q can't be encoded: %v", name, err)
+}
+
+// Check that all of msg's additional fields can be encoded as JSON, returning
+// the error for the first one that can't
+func unencodableField(msg *Message) error {
+	check := func(name string, val interface{}) error {
+		_, err := json.Marshal(val)
+		if err != nil {
+			return unencodableErr(name, err)
+		}
+		return nil
+	}
+
+	if msg.logger != nil {
+		for name, val := range msg.logger.attrs {
+			if err := check(name, val); err != nil {
+				return err
+			}
+		}
+	}
+	for name, val := range msg.Attrs {
+		if err := check(name, val); err != nil {
+			return err
+		}
+	}
+	for name, attr := range msg.levelAttrs {
+		if err := check(name, attr.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get all of the GELF fields for msg, with each additional field's name
+// prefixed with an underscore
+func msgFields(msg *Message, opts serializeOptions) map[string]interface{} {
+	size := len(opts.defaultAttrs) + len(msg.Attrs) + len(msg.levelAttrs) + 10
+	if msg.logger != nil {
+		size += len(msg.logger.attrs)
+	}
+	obj := make(map[string]interface{}, size)
 
 	obj["version"] = msg.version
 	obj["host"] = msg.Hostname
@@ -31,26 +287,511 @@ func generateMsgJson(msg *Message) (string, error) {
 		obj["full_message"] = msg.FullMessage
 	}
 
-	ts := float64(msg.Timestamp.UnixNano()) * float64(0.000000001)
-	obj["timestamp"] = newJsonFloat(ts)
+	// The server uses the time it received the message if it doesn't
+	// have one, see ClientConfig.NoTimestamp
+	if msg.Timestamp != nil {
+		ts := float64(msg.Timestamp.UnixNano()) * float64(0.000000001)
+		obj["timestamp"] = newJsonFloat(ts)
+	}
 
-	// First add all the logger level attrs if it exists
+	// Each source of attrs is added in turn, with FIELD_COLLISION_LAST_WINS
+	// each one overrides the ones before it
+	firstWins := opts.fieldCollisions == FIELD_COLLISION_FIRST_WINS
+
+	// First add the client's default attrs, everything else overrides them
+	for attrName, attrVal := range opts.defaultAttrs {
+		setField(obj, "_"+attrName, attrVal, firstWins)
+	}
+	if msg.Level <= opts.errorLevel {
+		for attrName, attrVal := range opts.errorFields {
+			setField(obj, "_"+attrName, attrVal, firstWins)
+		}
+	}
+
+	// Then add all the logger level attrs if it exists
 	if msg.logger != nil {
 		for attrName, attrVal := range msg.logger.attrs {
-			obj[fmt.Sprintf("_%v", attrName)] = attrVal
+			setField(obj, "_"+attrName, attrVal, firstWins)
 		}
 	}
 
+	// Add the caller if the client included it, the message level
+	// attrs can still override it
+	if msg.callerFile != "" {
+		setField(obj, "_file", msg.callerFile, firstWins)
+		setField(obj, "_line", msg.callerLine, firstWins)
+	}
+	if msg.goroutine != 0 {
+		setField(obj, "_"+GOROUTINE_ATTR, msg.goroutine, firstWins)
+	}
+
 	// Next add all the message level attrs. Those override
 	// logger level attrs
 	for attrName, attrVal := range msg.Attrs {
-		obj[fmt.Sprintf("_%v", attrName)] = attrVal
+		setField(obj, "_"+attrName, attrVal, firstWins)
+	}
+
+	for attrName, attr := range msg.levelAttrs {
+		if attr.hasLevel(msg.Level) {
+			setField(obj, "_"+attrName, attr.val, firstWins)
+		}
+	}
+
+	// The tenant is given when the message is queued so it overrides
+	// any attrs set on the message itself
+	if msg.tenant != "" {
+		obj["_"+TENANT_ATTR] = msg.tenant
+	}
+	if msg.seq != 0 {
+		obj["_"+SEQUENCE_ATTR] = msg.seq
+	}
+
+	if len(opts.allowFields) > 0 || len(opts.denyFields) > 0 {
+		stripped := filterFields(obj, opts.allowFields, opts.denyFields)
+		if stripped > 0 && opts.fieldsStripped != nil {
+			atomic.AddUint64(opts.fieldsStripped, uint64(stripped))
+		}
+	}
+
+	if opts.maxFields > 0 {
+		dropped := limitFields(obj, opts.maxFields)
+		if dropped > 0 {
+			obj["_"+FIELDS_TRUNCATED_ATTR] = dropped
+			if opts.fieldsTruncated != nil {
+				atomic.AddUint64(opts.fieldsTruncated, uint64(dropped))
+			}
+		}
+	}
+
+	if len(opts.fieldTypes) > 0 {
+		coerceFields(obj, opts.fieldTypes, opts.reportErr)
+	}
+
+	// Rendered after filtering so fields that aren't sent can't end up in
+	// the full message
+	if opts.fullMessageTemplate != nil && len(msg.FullMessage) == 0 {
+		renderFullMessage(obj, opts)
+	}
+
+	if opts.maxFieldBytes > 0 && truncateFields(obj, opts.maxFieldBytes) {
+		obj["_"+FIELD_TRUNCATED_ATTR] = true
+	}
+	// Encoded after truncating so the base64 is never cut short
+	if encodeBinaryFields(obj, opts.maxBinaryFieldBytes) {
+		obj["_"+FIELD_TRUNCATED_ATTR] = true
+	}
+
+	if opts.largeIntsAsStrings {
+		stringifyLargeInts(obj)
+	}
+
+	if opts.fieldPrefix != "" {
+		prefixFields(obj, opts.fieldPrefix)
+	}
+	if opts.keyTransform != nil {
+		transformKeys(obj, opts.keyTransform)
+	}
+
+	return obj
+}
+
+// Remove the additional fields from obj that don't match any of the allow
+// patterns, if there are any, or that match any of the deny patterns,
+// returning how many were removed
+func filterFields(obj map[string]interface{}, allow []string, deny []string) int {
+	stripped := 0
+	for key := range obj {
+		if !strings.HasPrefix(key, "_") {
+			continue
+		}
+		name := key[1:]
+		if (len(allow) > 0 && !matchesAny(allow, name)) || matchesAny(deny, name) {
+			delete(obj, key)
+			stripped++
+		}
+	}
+	return stripped
+}
+
+// Check if name matches any of the path.Match patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert the additional fields in obj to the types they're sent as,
+// removing the ones that can't be converted and reporting why to reportErr
+func coerceFields(obj map[string]interface{}, types map[string]int, reportErr func(error)) {
+	for name, fieldType := range types {
+		val, ok := obj["_"+name]
+		if !ok || val == nil {
+			continue
+		}
+
+		converted, ok := coerceValue(val, fieldType)
+		if ok {
+			obj["_"+name] = converted
+			continue
+		}
+		delete(obj, "_"+name)
+		if reportErr != nil {
+			reportErr(fmt.Errorf("field %q with the value %v can't be sent as %s, it was left out",
+				name, val, fieldTypeNames[fieldType]))
+		}
+	}
+}
+
+// Names of the FIELD_TYPE_* constants for errors
+var fieldTypeNames = map[int]string{
+	FIELD_TYPE_STRING: "a string",
+	FIELD_TYPE_INT:    "an int",
+	FIELD_TYPE_FLOAT:  "a float",
+}
+
+// Convert val to one of the FIELD_TYPE_* types, returning false if it can't
+// be without losing part of it
+func coerceValue(val interface{}, fieldType int) (interface{}, bool) {
+	if num, ok := val.(json.Number); ok {
+		val = string(num)
+		if fieldType == FIELD_TYPE_STRING {
+			return val, true
+		}
+	}
+
+	switch fieldType {
+	case FIELD_TYPE_STRING:
+		if data, ok := val.([]byte); ok {
+			return string(data), true
+		}
+		return fmt.Sprint(val), true
+	case FIELD_TYPE_INT:
+		switch v := val.(type) {
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return n, err == nil
+		case float32:
+			return coerceFloatToInt(float64(v))
+		case float64:
+			return coerceFloatToInt(v)
+		}
+		rv := reflect.ValueOf(val)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return rv.Uint(), rv.Uint() <= math.MaxInt64
+		}
+	case FIELD_TYPE_FLOAT:
+		if v, ok := val.(string); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			// JSON can't hold NaN or the infinities
+			return n, err == nil && !math.IsNaN(n) && !math.IsInf(n, 0)
+		}
+		rv := reflect.ValueOf(val)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return float64(rv.Uint()), true
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), true
+		}
+	}
+	return nil, false
+}
+
+// Convert a float to an int if it's a whole number that fits in an int64
+func coerceFloatToInt(f float64) (interface{}, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, false
+	}
+	return int64(f), true
+}
+
+// Remove the additional fields from obj after the first max of them in sorted
+// order, returning the number removed
+func limitFields(obj map[string]interface{}, max int) int {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		if strings.HasPrefix(key, "_") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) <= max {
+		return 0
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys[max:] {
+		delete(obj, key)
+	}
+	return len(keys) - max
+}
+
+// Set the full message in obj from the FullMessageTemplate, executed with the
+// additional fields by name along with the message's short_message, host and
+// level. An error executing it is reported and the full message is left out.
+func renderFullMessage(obj map[string]interface{}, opts serializeOptions) {
+	data := make(map[string]interface{}, len(obj))
+	for key, val := range obj {
+		if strings.HasPrefix(key, "_") {
+			data[key[1:]] = val
+		}
+	}
+	for _, key := range []string{"short_message", "host", "level"} {
+		if _, ok := data[key]; !ok {
+			data[key] = obj[key]
+		}
+	}
+
+	var buf bytes.Buffer
+	err := opts.fullMessageTemplate.Execute(&buf, data)
+	if err != nil {
+		if opts.reportErr != nil {
+			opts.reportErr(fmt.Errorf("failed to render full message: %v", err))
+		}
+		return
+	}
+	if buf.Len() > 0 {
+		obj["full_message"] = buf.String()
+	}
+}
+
+// Set the field 'key' in obj to val, unless firstWins is set and obj already
+// has the field
+func setField(obj map[string]interface{}, key string, val interface{}, firstWins bool) {
+	if firstWins {
+		if _, ok := obj[key]; ok {
+			return
+		}
+	}
+	obj[key] = val
+}
+
+// Add prefix to the name of every additional field in obj, after the leading
+// underscore, unless it already starts with it
+func prefixFields(obj map[string]interface{}, prefix string) {
+	for key, val := range obj {
+		if !strings.HasPrefix(key, "_") || strings.HasPrefix(key[1:], prefix) {
+			continue
+		}
+		delete(obj, key)
+		obj["_"+prefix+key[1:]] = val
+	}
+}
+
+// Replace the name of every additional field in obj with what transform
+// returns for it, keeping the leading underscore. When more than one field
+// ends up with the same name, such as "userId" and "user_id" with
+// SnakeCaseKey, a field that already had the name is kept, otherwise it's the
+// one whose original name sorts first, so the same message always keeps the
+// same value.
+func transformKeys(obj map[string]interface{}, transform func(string) string) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		if strings.HasPrefix(key, "_") {
+			keys = append(keys, key)
+		}
 	}
+	sort.Strings(keys)
 
-	data, err := json.Marshal(obj)
+	renamed := make(map[string]interface{})
+	for _, key := range keys {
+		newKey := "_" + transform(key[1:])
+		if newKey == key {
+			continue
+		}
+		val := obj[key]
+		delete(obj, key)
+		if _, ok := renamed[newKey]; !ok {
+			renamed[newKey] = val
+		}
+	}
+	for key, val := range renamed {
+		if _, ok := obj[key]; !ok {
+			obj[key] = val
+		}
+	}
+}
+
+// Convert a field name from camelCase, PascalCase or kebab-case to
+// snake_case, for FieldKeyTransform. Runs of capitals are treated as one
+// word, so "userID" and "HTTPStatus" become "user_id" and "http_status".
+// Dashes and spaces become underscores and anything else is kept as it is.
+func SnakeCaseKey(name string) string {
+	runes := []rune(name)
+	buf := make([]rune, 0, len(runes)+4)
+	for idx, r := range runes {
+		if r == '-' || r == ' ' {
+			r = '_'
+		}
+		if unicode.IsUpper(r) && idx > 0 && len(buf) > 0 && buf[len(buf)-1] != '_' {
+			prev := runes[idx-1]
+			nextLower := idx+1 < len(runes) && unicode.IsLower(runes[idx+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				buf = append(buf, '_')
+			}
+		}
+		buf = append(buf, unicode.ToLower(r))
+	}
+	return string(buf)
+}
+
+// Truncate the string values of the additional fields in obj that are longer
+// than maxBytes, ending them with an ellipsis. Returns true if any were
+// truncated.
+func truncateFields(obj map[string]interface{}, maxBytes int) bool {
+	truncated := false
+	for key, val := range obj {
+		str, ok := val.(string)
+		if !ok || !strings.HasPrefix(key, "_") || len(str) <= maxBytes {
+			continue
+		}
+		obj[key] = truncateString(str, maxBytes)
+		truncated = true
+	}
+	return truncated
+}
+
+// Replace the binary fields in obj with their base64 encoding, leaving out any
+// larger than maxBytes if it's more than 0. Returns true if any were left out.
+func encodeBinaryFields(obj map[string]interface{}, maxBytes int) bool {
+	dropped := false
+	for key, val := range obj {
+		data, ok := val.(binaryField)
+		if !ok {
+			continue
+		}
+		if maxBytes > 0 && len(data) > maxBytes {
+			delete(obj, key)
+			dropped = true
+			continue
+		}
+		obj[key] = base64.StdEncoding.EncodeToString(data)
+	}
+	return dropped
+}
+
+// The largest integer a float64 holds exactly, along with every integer
+// closer to 0
+const maxExactFloatInt = 1 << 53
+
+// Replace the integer additional fields in obj that are too large for a
+// float64 to hold exactly with their decimal strings
+func stringifyLargeInts(obj map[string]interface{}) {
+	for key, val := range obj {
+		if !strings.HasPrefix(key, "_") {
+			continue
+		}
+
+		var signed int64
+		var unsigned uint64
+		isSigned := true
+		switch v := val.(type) {
+		case int:
+			signed = int64(v)
+		case int64:
+			signed = v
+		case uint:
+			unsigned, isSigned = uint64(v), false
+		case uint64:
+			unsigned, isSigned = v, false
+		case uintptr:
+			unsigned, isSigned = uint64(v), false
+		default:
+			continue
+		}
+
+		if isSigned && (signed > maxExactFloatInt || signed < -maxExactFloatInt) {
+			obj[key] = strconv.FormatInt(signed, 10)
+		} else if !isSigned && unsigned > maxExactFloatInt {
+			obj[key] = strconv.FormatUint(unsigned, 10)
+		}
+	}
+}
+
+// Shorten str to at most maxBytes bytes, including a "..." on the end, without
+// splitting any UTF-8 characters
+func truncateString(str string, maxBytes int) string {
+	const ellipsis = "..."
+	if maxBytes <= len(ellipsis) {
+		return ellipsis[:maxBytes]
+	}
+
+	end := maxBytes - len(ellipsis)
+	for end > 0 && !utf8.RuneStart(str[end]) {
+		end--
+	}
+	return str[:end] + ellipsis
+}
+
+// Parse a GELF message as it was sent to the server, decompressing it first if
+// it's gzip or zlib compressed. Numeric attributes are parsed as float64
+// values. Chunked messages must be reassembled before they're parsed.
+func ParseMessage(data []byte) (*Message, error) {
+	raw, err := decompressMsg(data)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := make(map[string]interface{}, 0)
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	version, _ := obj["version"].(string)
+	msg := newMessageForVersion(version)
+	for key, val := range obj {
+		switch key {
+		case "version":
+		case "host":
+			msg.Hostname, _ = val.(string)
+		case "short_message":
+			msg.ShortMessage, _ = val.(string)
+		case "full_message":
+			msg.FullMessage, _ = val.(string)
+		case "level":
+			level, _ := val.(float64)
+			msg.Level = int(level)
+		case "timestamp":
+			ts, ok := val.(float64)
+			if !ok {
+				continue
+			}
+			sec, frac := math.Modf(ts)
+			msgTime := time.Unix(int64(sec), int64(frac*float64(time.Second)))
+			msg.Timestamp = &msgTime
+		default:
+			if strings.HasPrefix(key, "_") {
+				msg.Attrs[key[1:]] = val
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+func decompressMsg(data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0]&0x0f == 0x08 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer r.Close()
 
-	return string(data), nil
+	return ioutil.ReadAll(r)
 }
@@ -1,6 +1,14 @@
 package golf
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
 	"time"
 
 	"github.com/aphistic/sweet"
@@ -40,8 +48,8 @@ func (s *JSONSuite) TestJsonNoLogger(t sweet.T) {
 	msg.Attrs["attr1"] = "val1"
 	msg.Attrs["attr2"] = 1234
 
-	json, _ := generateMsgJson(msg)
-	Expect(json).To(Equal(`{` +
+	json, _ := generateMsgJson(msg, serializeOptions{})
+	Expect(string(json)).To(Equal(`{` +
 		`"_attr1":"val1","_attr2":1234,"full_message":"full_message",` +
 		`"host":"hostname","level":2,"short_message":"short_message",` +
 		`"timestamp":1440387554.671945,"version":"1.1"` +
@@ -67,11 +75,551 @@ func (s *JSONSuite) TestJsonWithLogger(t sweet.T) {
 	msg.Attrs["attr1"] = "val1"
 	msg.Attrs["attr2"] = 1234
 
-	json, _ := generateMsgJson(msg)
-	Expect(json).To(Equal(`{` +
+	json, _ := generateMsgJson(msg, serializeOptions{})
+	Expect(string(json)).To(Equal(`{` +
 		`"_attr1":"val1","_attr2":1234,"_attr3":"val3",` +
 		`"full_message":"full_message","host":"hostname","level":2,` +
 		`"short_message":"short_message","timestamp":1440387554.671945,` +
 		`"version":"1.1"` +
 		`}`))
 }
+
+func (s *JSONSuite) TestParseMessage(t sweet.T) {
+	msg, err := ParseMessage([]byte(`{` +
+		`"_attr1":"val1","_attr2":1234,"full_message":"full_message",` +
+		`"host":"hostname","level":2,"short_message":"short_message",` +
+		`"timestamp":1440387554.671945,"version":"1.1"` +
+		`}`))
+	Expect(err).To(BeNil())
+
+	Expect(msg.version).To(Equal("1.1"))
+	Expect(msg.Hostname).To(Equal("hostname"))
+	Expect(msg.Level).To(Equal(LEVEL_CRIT))
+	Expect(msg.ShortMessage).To(Equal("short_message"))
+	Expect(msg.FullMessage).To(Equal("full_message"))
+	Expect(msg.Timestamp.UnixNano() / 1000).To(BeNumerically("~", 1440387554671945, 1))
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"attr1": "val1",
+		"attr2": float64(1234),
+	}))
+}
+
+func (s *JSONSuite) TestParseMessageCompressed(t sweet.T) {
+	data := `{"short_message":"short_message","version":"1.1"}`
+
+	gzBuf := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write([]byte(data))
+	gz.Close()
+
+	zzBuf := &bytes.Buffer{}
+	zz := zlib.NewWriter(zzBuf)
+	zz.Write([]byte(data))
+	zz.Close()
+
+	for _, compressed := range [][]byte{gzBuf.Bytes(), zzBuf.Bytes()} {
+		msg, err := ParseMessage(compressed)
+		Expect(err).To(BeNil())
+		Expect(msg.ShortMessage).To(Equal("short_message"))
+	}
+}
+
+func (s *JSONSuite) TestParseMessageInvalid(t sweet.T) {
+	_, err := ParseMessage([]byte(`{"short_message":`))
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *JSONSuite) TestJsonSortedKeys(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	l := &Logger{attrs: map[string]interface{}{"zeta": 1, "alpha": 2}}
+
+	expected := `{"_alpha":2,"_beta":{"x":2,"y":1},"_delta":"d","_gamma":3,"_zeta":1,` +
+		`"host":"host","level":6,"short_message":"short","timestamp":1500000000.000000,"version":"1.1"}`
+
+	// Build the attrs in different orders each time and make sure the
+	// output is always the same
+	for idx := 0; idx < 20; idx++ {
+		msg := newMessage()
+		msg.logger = l
+		msg.Level = LEVEL_INFO
+		msg.Hostname = "host"
+		msg.ShortMessage = "short"
+		msg.Timestamp = &ts
+
+		if idx%2 == 0 {
+			msg.AddField("gamma", 3)
+			msg.AddField("delta", "d")
+			msg.AddField("beta", map[string]interface{}{"y": 1, "x": 2})
+		} else {
+			msg.AddField("beta", map[string]interface{}{"x": 2, "y": 1})
+			msg.AddField("delta", "d")
+			msg.AddField("gamma", 3)
+		}
+
+		data, err := generateMsgJson(msg, serializeOptions{})
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal(expected))
+	}
+}
+
+func (s *JSONSuite) TestJsonMaxFieldBytes(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.ShortMessage = strings.Repeat("s", 20)
+	msg.Timestamp = &ts
+	msg.AddField("short", "fits")
+	msg.AddField("long", "0123456789abcdef")
+	msg.AddField("unicode", "ééééééé")
+	msg.AddField("number", 1234567890123)
+
+	data, err := generateMsgJson(msg, serializeOptions{maxFieldBytes: 10})
+	Expect(err).To(BeNil())
+	parsed, err := ParseMessage(data)
+	Expect(err).To(BeNil())
+
+	// Only additional string fields are truncated
+	Expect(parsed.ShortMessage).To(Equal(msg.ShortMessage))
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"short":           "fits",
+		"long":            "0123456...",
+		"unicode":         "ééé...",
+		"number":          float64(1234567890123),
+		"field_truncated": true,
+	}))
+
+	// The message itself isn't changed
+	Expect(msg.Attrs["long"]).To(Equal("0123456789abcdef"))
+}
+
+func (s *JSONSuite) TestJsonMaxFieldBytesNotTruncated(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddField("short", "fits")
+
+	data, err := generateMsgJson(msg, serializeOptions{maxFieldBytes: 10})
+	Expect(err).To(BeNil())
+	Expect(string(data)).ToNot(ContainSubstring(FIELD_TRUNCATED_ATTR))
+}
+
+func (s *JSONSuite) TestJsonBinaryFields(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddField("digest", []byte{0xde, 0xad, 0xbe, 0xef})
+	msg.AddField("blob", make([]byte, 64))
+
+	// Not truncated by maxFieldBytes, which would break the encoding
+	data, err := generateMsgJson(msg, serializeOptions{maxFieldBytes: 4, maxBinaryFieldBytes: 32})
+	Expect(err).To(BeNil())
+	parsed, err := ParseMessage(data)
+	Expect(err).To(BeNil())
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"digest_base64":   "3q2+7w==",
+		"field_truncated": true,
+	}))
+
+	data, err = generateMsgJson(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	parsed, err = ParseMessage(data)
+	Expect(err).To(BeNil())
+	Expect(parsed.Attrs).To(HaveKey("blob_base64"))
+	Expect(parsed.Attrs).ToNot(HaveKey(FIELD_TRUNCATED_ATTR))
+}
+
+func (s *JSONSuite) TestTruncateString(t sweet.T) {
+	Expect(truncateString("abcdef", 5)).To(Equal("ab..."))
+	Expect(truncateString("abcdef", 2)).To(Equal(".."))
+	Expect(truncateString("日本語テキスト", 10)).To(Equal("日本..."))
+}
+
+func (s *JSONSuite) TestJsonFieldPrefix(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.ShortMessage = "short"
+	msg.FullMessage = "full"
+	msg.Level = LEVEL_INFO
+	msg.Timestamp = &ts
+	msg.AddField("user", "someone")
+	msg.AddField("svc_already", "prefixed")
+
+	data, err := generateMsgJson(msg, serializeOptions{
+		fieldPrefix:  "svc_",
+		defaultAttrs: map[string]interface{}{"pid": 1},
+	})
+	Expect(err).To(BeNil())
+
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(Equal(map[string]interface{}{
+		"version":       "1.1",
+		"host":          "",
+		"level":         float64(LEVEL_INFO),
+		"short_message": "short",
+		"full_message":  "full",
+		"timestamp":     float64(1500000000),
+		"_svc_user":     "someone",
+		"_svc_already":  "prefixed",
+		"_svc_pid":      float64(1),
+	}))
+}
+
+func (s *JSONSuite) TestJsonMatchesEncodingJSON(t sweet.T) {
+	ts := time.Unix(1500000000, 123456789)
+	msg := newMessage()
+	msg.Hostname = "host <&>"
+	msg.ShortMessage = "quote \" backslash \\ newline \n tab \t"
+	msg.FullMessage = "control \x01 invalid \xff separators \u2028\u2029 unicode 日本語"
+	msg.Timestamp = &ts
+	msg.AddFields(map[string]interface{}{
+		"nil":      nil,
+		"bool":     true,
+		"int":      -42,
+		"int32":    int32(7),
+		"int64":    int64(1) << 62,
+		"uint":     uint(3),
+		"uint32":   uint32(4),
+		"uint64":   uint64(1) << 63,
+		"float":    1.5,
+		"map":      map[string]interface{}{"b": 1, "a": "x"},
+		"slice":    []string{"a", "<b>"},
+		"esc\"ape": "key needs escaping",
+	})
+
+	data, err := generateMsgJson(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	expected, err := json.Marshal(msgFields(msg, serializeOptions{}))
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(string(expected)))
+}
+
+func (s *JSONSuite) TestJsonLargeIntsAsStrings(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Level = LEVEL_INFO
+	msg.Timestamp = &ts
+	msg.AddFields(map[string]interface{}{
+		"id":       uint64(18446744073709551615),
+		"nanos":    int64(1500000000123456789),
+		"negative": int64(-9007199254740993),
+		"exact":    int64(9007199254740992),
+		"small":    42,
+		"float":    1e20,
+		"name":     "someone",
+	})
+
+	data, err := generateMsgJson(msg, serializeOptions{largeIntsAsStrings: true})
+	Expect(err).To(BeNil())
+	str := string(data)
+	Expect(str).To(ContainSubstring(`"_id":"18446744073709551615"`))
+	Expect(str).To(ContainSubstring(`"_nanos":"1500000000123456789"`))
+	Expect(str).To(ContainSubstring(`"_negative":"-9007199254740993"`))
+	Expect(str).To(ContainSubstring(`"_exact":9007199254740992`))
+	Expect(str).To(ContainSubstring(`"_small":42`))
+	Expect(str).To(ContainSubstring(`"_float":100000000000000000000`))
+	Expect(str).To(ContainSubstring(`"level":6`))
+
+	data, err = generateMsgJson(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"_id":18446744073709551615`))
+}
+
+func BenchmarkGenerateMsgJsonLargeFields(b *testing.B) {
+	ts := time.Now()
+	msg := newMessage()
+	msg.Hostname = "hostname"
+	msg.ShortMessage = "benchmark message"
+	msg.Timestamp = &ts
+	for idx := 0; idx < 500; idx++ {
+		if idx%2 == 0 {
+			msg.AddField(fmt.Sprintf("field_%d", idx), fmt.Sprintf("value %d", idx))
+		} else {
+			msg.AddField(fmt.Sprintf("field_%d", idx), idx)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		generateMsgJson(msg, serializeOptions{})
+	}
+}
+
+func (s *JSONSuite) TestSnakeCaseKey(t sweet.T) {
+	names := map[string]string{
+		"userId":       "user_id",
+		"UserID":       "user_id",
+		"HTTPStatus":   "http_status",
+		"request-path": "request_path",
+		"already_fine": "already_fine",
+		"svc_userName": "svc_user_name",
+		"ipV4Addr":     "ip_v4_addr",
+		"http.method":  "http.method",
+		"Content-Type": "content_type",
+		"":             "",
+	}
+	for name, expected := range names {
+		Expect(SnakeCaseKey(name)).To(Equal(expected), name)
+	}
+}
+
+func (s *JSONSuite) TestJsonFieldKeyTransform(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.ShortMessage = "short"
+	msg.Timestamp = &ts
+	msg.AddField("userId", "someone")
+	msg.AddField("request-path", "/")
+
+	data, err := generateMsgJson(msg, serializeOptions{
+		fieldPrefix:  "svcName.",
+		keyTransform: SnakeCaseKey,
+	})
+	Expect(err).To(BeNil())
+
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(HaveKeyWithValue("_svc_name.user_id", "someone"))
+	Expect(obj).To(HaveKeyWithValue("_svc_name.request_path", "/"))
+	Expect(obj).To(HaveKeyWithValue("short_message", "short"))
+	Expect(obj).To(HaveLen(7))
+}
+
+func (s *JSONSuite) TestJsonFieldCollisions(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	logger := newLogger()
+	logger.SetAttr("service", "logger")
+	logger.SetAttr("env", "logger")
+	msg := newMessage()
+	msg.logger = logger
+	msg.Timestamp = &ts
+	msg.AddField("service", "message")
+	msg.AddFieldForLevels("env", "level", msg.Level)
+
+	opts := serializeOptions{
+		defaultAttrs: map[string]interface{}{"service": "default", "env": "default", "pid": 1},
+	}
+	fields := func(opts serializeOptions) map[string]interface{} {
+		data, err := generateMsgJson(msg, opts)
+		Expect(err).To(BeNil())
+		// Each field is only written once
+		Expect(bytes.Count(data, []byte(`"_service"`))).To(Equal(1))
+		Expect(bytes.Count(data, []byte(`"_env"`))).To(Equal(1))
+
+		obj := make(map[string]interface{})
+		Expect(json.Unmarshal(data, &obj)).To(BeNil())
+		return obj
+	}
+
+	obj := fields(opts)
+	Expect(obj).To(HaveKeyWithValue("_service", "message"))
+	Expect(obj).To(HaveKeyWithValue("_env", "level"))
+	Expect(obj).To(HaveKeyWithValue("_pid", float64(1)))
+
+	opts.fieldCollisions = FIELD_COLLISION_FIRST_WINS
+	obj = fields(opts)
+	Expect(obj).To(HaveKeyWithValue("_service", "default"))
+	Expect(obj).To(HaveKeyWithValue("_env", "default"))
+	Expect(obj).To(HaveKeyWithValue("_pid", float64(1)))
+}
+
+func (s *JSONSuite) TestJsonFieldKeyTransformCollisions(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddField("requestId", "renamed")
+	msg.AddField("request_id", "already snake case")
+	msg.AddField("userId", "second")
+	msg.AddField("userID", "first")
+
+	for idx := 0; idx < 20; idx++ {
+		data, err := generateMsgJson(msg, serializeOptions{keyTransform: SnakeCaseKey})
+		Expect(err).To(BeNil())
+
+		obj := make(map[string]interface{})
+		Expect(json.Unmarshal(data, &obj)).To(BeNil())
+		Expect(obj).To(HaveKeyWithValue("_request_id", "already snake case"))
+		Expect(obj).To(HaveKeyWithValue("_user_id", "first"))
+		Expect(obj).To(HaveLen(7))
+	}
+}
+
+func (s *JSONSuite) TestJsonFilterFields(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddFields(map[string]interface{}{
+		"user_id":    1,
+		"user_email": "someone@example.com",
+		"request":    "/",
+		"password":   "hunter2",
+	})
+
+	var stripped uint64
+	data, err := generateMsgJson(msg, serializeOptions{
+		denyFields:     []string{"password", "*_email"},
+		fieldsStripped: &stripped,
+	})
+	Expect(err).To(BeNil())
+	parsed, _ := ParseMessage(data)
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"user_id": float64(1),
+		"request": "/",
+	}))
+	Expect(stripped).To(Equal(uint64(2)))
+
+	// Denied fields are stripped even if they're allowed, and prefixing
+	// happens after the names are matched
+	data, err = generateMsgJson(msg, serializeOptions{
+		allowFields:    []string{"user_*"},
+		denyFields:     []string{"user_email"},
+		fieldPrefix:    "svc_",
+		fieldsStripped: &stripped,
+	})
+	Expect(err).To(BeNil())
+	parsed, _ = ParseMessage(data)
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{"svc_user_id": float64(1)}))
+	Expect(parsed.ShortMessage).To(Equal(""))
+	Expect(parsed.Timestamp).ToNot(BeNil())
+	Expect(stripped).To(Equal(uint64(5)))
+}
+
+func (s *JSONSuite) TestJsonMaxFields(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddFields(map[string]interface{}{
+		"d":        4,
+		"b":        2,
+		"password": "hunter2",
+		"c":        3,
+		"a":        1,
+	})
+
+	var truncated, stripped uint64
+	opts := serializeOptions{
+		maxFields:       2,
+		fieldsTruncated: &truncated,
+		denyFields:      []string{"password"},
+		fieldsStripped:  &stripped,
+	}
+	data, err := generateMsgJson(msg, opts)
+	Expect(err).To(BeNil())
+	parsed, _ := ParseMessage(data)
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"a":                   float64(1),
+		"b":                   float64(2),
+		FIELDS_TRUNCATED_ATTR: float64(2),
+	}))
+	Expect(truncated).To(Equal(uint64(2)))
+	Expect(stripped).To(Equal(uint64(1)))
+
+	// Messages within the limit are left alone
+	opts.maxFields = 4
+	data, err = generateMsgJson(msg, opts)
+	Expect(err).To(BeNil())
+	parsed, _ = ParseMessage(data)
+	Expect(parsed.Attrs).To(HaveLen(4))
+	Expect(parsed.Attrs).ToNot(HaveKey(FIELDS_TRUNCATED_ATTR))
+	Expect(truncated).To(Equal(uint64(2)))
+}
+
+func (s *JSONSuite) TestJsonFieldTypes(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := newMessage()
+	msg.Timestamp = &ts
+	msg.AddFields(map[string]interface{}{
+		"request_id":  42,
+		"user_id":     "1234",
+		"duration":    int64(25),
+		"ratio":       "0.5",
+		"whole":       3.0,
+		"fraction":    1.5,
+		"unparseable": "abc",
+		"untyped":     7,
+	})
+
+	var errs []error
+	opts := serializeOptions{
+		fieldTypes: map[string]int{
+			"request_id":  FIELD_TYPE_STRING,
+			"user_id":     FIELD_TYPE_INT,
+			"duration":    FIELD_TYPE_FLOAT,
+			"ratio":       FIELD_TYPE_FLOAT,
+			"whole":       FIELD_TYPE_INT,
+			"fraction":    FIELD_TYPE_INT,
+			"unparseable": FIELD_TYPE_FLOAT,
+			"missing":     FIELD_TYPE_STRING,
+		},
+		reportErr: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+	data, err := generateMsgJson(msg, opts)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"_request_id":"42"`))
+	Expect(string(data)).To(ContainSubstring(`"_user_id":1234,`))
+	Expect(string(data)).To(ContainSubstring(`"_duration":25,`))
+	Expect(string(data)).To(ContainSubstring(`"_ratio":0.5,`))
+	Expect(string(data)).To(ContainSubstring(`"_whole":3,`))
+	Expect(string(data)).To(ContainSubstring(`"_untyped":7,`))
+
+	// Values that can't be converted are left out and reported
+	Expect(string(data)).ToNot(ContainSubstring(`"_fraction"`))
+	Expect(string(data)).ToNot(ContainSubstring(`"_unparseable"`))
+	Expect(string(data)).ToNot(ContainSubstring(`"_missing"`))
+	Expect(errs).To(HaveLen(2))
+	Expect(errs).To(ContainElement(MatchError(`field "fraction" with the value 1.5 can't be sent as an int, it was left out`)))
+	Expect(errs).To(ContainElement(MatchError(`field "unparseable" with the value abc can't be sent as a float, it was left out`)))
+}
+
+func (s *JSONSuite) TestJsonFullMessageTemplate(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	tmpl := template.Must(template.New("full").Parse(
+		"{{.host}}: user {{.user_id}} did {{.action}} ({{.short_message}}, {{.password}})"))
+	msg := newMessage()
+	msg.Hostname = "host"
+	msg.ShortMessage = "short"
+	msg.Timestamp = &ts
+	msg.AddField("user_id", 42)
+	msg.AddField("action", "login")
+	msg.AddField("password", "hunter2")
+
+	opts := serializeOptions{
+		fullMessageTemplate: tmpl,
+		denyFields:          []string{"password"},
+	}
+	data, err := generateMsgJson(msg, opts)
+	Expect(err).To(BeNil())
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(HaveKeyWithValue("full_message", "host: user 42 did login (short, <no value>)"))
+
+	// A full message that's set isn't replaced
+	msg.FullMessage = "set"
+	data, err = generateMsgJson(msg, opts)
+	Expect(err).To(BeNil())
+	obj = make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(HaveKeyWithValue("full_message", "set"))
+}
+
+func (s *JSONSuite) TestJsonFullMessageTemplateError(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	tmpl := template.Must(template.New("full").Option("missingkey=error").Parse("{{.missing}}"))
+	msg := newMessage()
+	msg.Timestamp = &ts
+
+	var reported error
+	data, err := generateMsgJson(msg, serializeOptions{
+		fullMessageTemplate: tmpl,
+		reportErr:           func(err error) { reported = err },
+	})
+	Expect(err).To(BeNil())
+	Expect(reported).ToNot(BeNil())
+	Expect(reported.Error()).To(HavePrefix("failed to render full message: "))
+
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).ToNot(HaveKey("full_message"))
+}
@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package golf
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func getSockopt(conn net.Conn, level int, opt int) int {
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	Expect(err).To(BeNil())
+
+	var val int
+	raw.Control(func(fd uintptr) {
+		val, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	Expect(err).To(BeNil())
+	return val
+}
+
+func (s *GolfSuite) TestDialKeepAlive(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		KeepAlive:       true,
+		KeepAlivePeriod: 42 * time.Second,
+	})
+	err = c.Dial("tcp://" + listener.Addr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	conn := c.conn.(*reconnConn).current()
+	Expect(getSockopt(conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)).To(Equal(1))
+	Expect(getSockopt(conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)).To(Equal(42))
+}
+
+func (s *GolfSuite) TestDialKeepAliveReconnect(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		KeepAlive:       true,
+		KeepAlivePeriod: 42 * time.Second,
+	})
+	err = c.Dial("tcp://" + listener.Addr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	// The connection it's redialed with has keep-alives on too
+	rc := c.conn.(*reconnConn)
+	conn, err := rc.dial(context.Background())
+	Expect(err).To(BeNil())
+	defer conn.Close()
+	Expect(getSockopt(conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)).To(Equal(1))
+	Expect(getSockopt(conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)).To(Equal(42))
+}
@@ -0,0 +1,29 @@
+package golf
+
+// The short messages of the lifecycle messages when StartedMessage and
+// StoppingMessage aren't set
+const (
+	DEFAULT_STARTED_MESSAGE  = "client started"
+	DEFAULT_STOPPING_MESSAGE = "client stopping"
+)
+
+// Queue a lifecycle message with text as its short message, or defaultText if
+// it's empty, if LifecycleEvents is set
+func (c *Client) queueLifecycleMsg(text string, defaultText string) {
+	if !c.config.LifecycleEvents {
+		return
+	}
+
+	err := c.queueMsg(c.lifecycleMsg(text, defaultText), 0)
+	if err != nil && err != ErrClosing {
+		c.reportErr(err)
+	}
+}
+
+func (c *Client) lifecycleMsg(text string, defaultText string) *Message {
+	if text == "" {
+		text = defaultText
+	}
+
+	return c.markerMsg(text, c.config.LifecycleFields)
+}
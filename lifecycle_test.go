@@ -0,0 +1,49 @@
+package golf
+
+import (
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestLifecycleEvents(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		LifecycleEvents: true,
+		StoppingMessage: "going away",
+		LifecycleFields: map[string]interface{}{"service": "api"},
+	})
+	c.UseSink(sink)
+	c.Infof("working")
+	Expect(c.Close()).To(BeNil())
+
+	// The stopping message is sent with the rest of the queue before it's
+	// closed
+	Expect(sink.messages()).To(Equal([]string{
+		DEFAULT_STARTED_MESSAGE,
+		"working",
+		"going away",
+	}))
+
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			msg, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			if msg.ShortMessage != "working" {
+				Expect(msg.Level).To(Equal(LEVEL_INFO))
+				Expect(msg.Attrs).To(Equal(map[string]interface{}{"service": "api"}))
+			}
+		}
+	}
+}
+
+func (s *GolfSuite) TestLifecycleEventsDisabled(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	Expect(c.Close()).To(BeNil())
+
+	Consistently(sink.messages, 20*time.Millisecond).Should(HaveLen(0))
+}
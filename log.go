@@ -18,7 +18,7 @@ func (l *Logger) genMsg(attrs map[string]interface{}, level int, msg string, va
 
 func (l *Logger) logMsg(attrs map[string]interface{}, level int, msg string, va ...interface{}) error {
 	newMsg := l.genMsg(attrs, level, msg, va...)
-	return l.client.QueueMsg(newMsg)
+	return l.client.queueMsg(newMsg, 2)
 }
 
 // Dbg logs message 'msg' at LEVEL_DBG level
@@ -0,0 +1,68 @@
+package golf
+
+import (
+	"fmt"
+)
+
+func (c *Client) genMsg(level int, msg string, va ...interface{}) *Message {
+	newMsg := newMessage()
+	newMsg.Hostname = c.hostname
+	newMsg.Level = level
+	if len(va) > 0 {
+		newMsg.ShortMessage = fmt.Sprintf(msg, va...)
+	} else {
+		newMsg.ShortMessage = msg
+	}
+	return newMsg
+}
+
+func (c *Client) logMsg(level int, msg string, va ...interface{}) error {
+	newMsg := c.genMsg(level, msg, va...)
+	return c.queueMsg(newMsg, 2)
+}
+
+// Log a message at 'level' with 'format' populated with values from 'va'
+// directly on the Client, without the attributes of any Logger
+func (c *Client) Logf(level int, format string, va ...interface{}) error {
+	return c.logMsg(level, format, va...)
+}
+
+// Log a message at LEVEL_DBG with 'format' populated with values from 'va' on the Client
+func (c *Client) Dbgf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_DBG, format, va...)
+}
+
+// Log a message at LEVEL_INFO with 'format' populated with values from 'va' on the Client
+func (c *Client) Infof(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_INFO, format, va...)
+}
+
+// Log a message at LEVEL_NOTICE with 'format' populated with values from 'va' on the Client
+func (c *Client) Noticef(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_NOTICE, format, va...)
+}
+
+// Log a message at LEVEL_WARN with 'format' populated with values from 'va' on the Client
+func (c *Client) Warnf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_WARN, format, va...)
+}
+
+// Log a message at LEVEL_ERR with 'format' populated with values from 'va' on the Client
+func (c *Client) Errf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_ERR, format, va...)
+}
+
+// Log a message at LEVEL_CRIT with 'format' populated with values from 'va' on the Client
+func (c *Client) Critf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_CRIT, format, va...)
+}
+
+// Log a message at LEVEL_ALERT with 'format' populated with values from 'va' on the Client
+func (c *Client) Alertf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_ALERT, format, va...)
+}
+
+// Log a message at LEVEL_EMERG with 'format' populated with values from 'va' on the Client
+func (c *Client) Emergf(format string, va ...interface{}) error {
+	return c.logMsg(LEVEL_EMERG, format, va...)
+}
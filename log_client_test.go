@@ -0,0 +1,49 @@
+package golf
+
+import (
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestGenClientMessageWithFormatNoParams(t sweet.T) {
+	cl, err := NewClient()
+	Expect(err).To(BeNil())
+	Expect(cl).ToNot(BeNil())
+
+	// Tests to make sure a string won't be double-formatted if
+	// no paramters are passed to the format string
+	msg := cl.genMsg(1, "%2b")
+	Expect(msg.Level).To(Equal(1))
+	Expect(msg.ShortMessage).To(Equal("%2b"))
+	Expect(msg.Hostname).To(Equal(cl.hostname))
+	Expect(msg.logger).To(BeNil())
+}
+
+func (s *GolfSuite) TestGenClientMessageWithFormat(t sweet.T) {
+	cl, err := NewClient()
+	Expect(err).To(BeNil())
+	Expect(cl).ToNot(BeNil())
+
+	// Tests to make sure a string will be formatted if
+	// paramters are passed to the format string
+	msg := cl.genMsg(1, "%2b", true)
+	Expect(msg.Level).To(Equal(1))
+	Expect(msg.ShortMessage).To(Equal("%!b(bool=true)"))
+}
+
+func (s *GolfSuite) TestClientLogf(t sweet.T) {
+	cl, _ := NewClient()
+
+	cl.Logf(LEVEL_NOTICE, "test %d", 1)
+	cl.Errf("test %d", 2)
+
+	Expect(cl.queue).To(HaveLen(2))
+	msg := cl.queue[0]
+	Expect(msg.Level).To(Equal(LEVEL_NOTICE))
+	Expect(msg.ShortMessage).To(Equal("test 1"))
+	Expect(msg.Timestamp).ToNot(BeNil())
+
+	msg = cl.queue[1]
+	Expect(msg.Level).To(Equal(LEVEL_ERR))
+	Expect(msg.ShortMessage).To(Equal("test 2"))
+}
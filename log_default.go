@@ -31,7 +31,7 @@ func logDefaultMsg(attrs map[string]interface{}, level int, msg string, va ...in
 	}
 
 	newMsg := genDefaultMsg(attrs, level, msg, va...)
-	return defaultLogger.client.QueueMsg(newMsg)
+	return defaultLogger.client.queueMsg(newMsg, 2)
 }
 
 // Log a message 'msg' at LEVEL_DBG level on the default logger
@@ -12,7 +12,9 @@ func TestMain(m *testing.M) {
 
 	sweet.Run(m, func(s *sweet.S) {
 		s.AddSuite(&ChunkerSuite{})
+		s.AddSuite(&FramerSuite{})
 		s.AddSuite(&GolfSuite{})
+		s.AddSuite(&JSONSuite{})
 	})
 }
 
@@ -87,3 +89,24 @@ func (tw *testWriter) Write(p []byte) (int, error) {
 
 	return len(p), nil
 }
+
+// A writer that fails every write after the first 'succeed' writes
+type failWriter struct {
+	testWriter
+	succeed int
+	err     error
+}
+
+func newFailWriter(succeed int, err error) *failWriter {
+	return &failWriter{
+		testWriter: *newTestWriter(),
+		succeed:    succeed,
+		err:        err,
+	}
+}
+func (fw *failWriter) Write(p []byte) (int, error) {
+	if len(fw.Written) >= fw.succeed {
+		return 0, fw.err
+	}
+	return fw.testWriter.Write(p)
+}
@@ -1,6 +1,16 @@
 package golf
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,14 +27,147 @@ const (
 	LEVEL_DBG           // Debug
 )
 
+// Level names accepted by Message.SetLevelString, including common aliases
+var levelNames = map[string]int{
+	"emerg":         LEVEL_EMERG,
+	"emergency":     LEVEL_EMERG,
+	"panic":         LEVEL_EMERG,
+	"alert":         LEVEL_ALERT,
+	"crit":          LEVEL_CRIT,
+	"critical":      LEVEL_CRIT,
+	"fatal":         LEVEL_CRIT,
+	"err":           LEVEL_ERR,
+	"error":         LEVEL_ERR,
+	"warn":          LEVEL_WARN,
+	"warning":       LEVEL_WARN,
+	"notice":        LEVEL_NOTICE,
+	"info":          LEVEL_INFO,
+	"informational": LEVEL_INFO,
+	"dbg":           LEVEL_DBG,
+	"debug":         LEVEL_DBG,
+}
+
+// Name of the additional field used to hold the tenant of messages queued with
+// Client.QueueMsgTagged
+const TENANT_ATTR = "tenant"
+
+// Name of the additional field used to hold the sequence number of messages
+// sent by a client with AddSequenceNumbers
+const SEQUENCE_ATTR = "seq"
+
+// Name of the additional field set to true on messages with fields that were
+// truncated for ClientConfig.MaxFieldBytes
+const FIELD_TRUNCATED_ATTR = "field_truncated"
+
+// Name of the additional field set to the number of fields left out of a
+// message for ClientConfig.MaxFields
+const FIELDS_TRUNCATED_ATTR = "fields_truncated"
+
+// Longest short message set by Message.SetMessage, in bytes
+const SHORT_MESSAGE_MAX_BYTES = 250
+
+// Names of the additional fields used to hold the process ID and the time the
+// process started for a client with AddProcessFields
+const (
+	PID_ATTR           = "pid"
+	PROCESS_START_ATTR = "process_start"
+)
+
+// Suffix added to the name of []byte attributes added with AddField, which are
+// sent as base64 encoded strings
+const BINARY_FIELD_SUFFIX = "_base64"
+
+// A []byte attribute, base64 encoded when it's serialized
+type binaryField []byte
+
+// Name of the additional field used to hold the ID of the goroutine a message
+// was queued from for a client with IncludeGoroutineID
+const GOROUTINE_ATTR = "goroutine"
+
+// Name of the additional field used to hold the stack trace added with
+// Message.AddStackTrace
+const STACK_ATTR = "stack"
+
+// The deepest stack trace added by Message.AddStackTrace
+const maxStackDepth = 64
+
+// Name of the additional field set to the number of messages suppressed for
+// ClientConfig.RepeatWindow on the message sent in their place
+const SUPPRESSED_COUNT_ATTR = "suppressed_count"
+
+// Name of the additional field used to hold a message's dedup key. It's sent
+// as "_message_id" like any other attribute.
+const DEDUP_ATTR = "message_id"
+
+// Short names for each level used when messages are written as text
+var levelText = []string{"EMERG", "ALERT", "CRIT", "ERR", "WARN", "NOTICE", "INFO", "DEBUG"}
+
+// Get the short name of a level, such as "WARN" for LEVEL_WARN, or "LEVEL"
+// followed by the number for a level that isn't known
+func levelName(level int) string {
+	if level >= 0 && level < len(levelText) {
+		return levelText[level]
+	}
+	return "LEVEL" + strconv.Itoa(level)
+}
+
+// Set the message's level from a level name such as "warning" or "error",
+// ignoring case. Aliases like "warn", "err" and "fatal" (for LEVEL_CRIT) are
+// accepted too. Returns ErrUnknownLevel if the name isn't a known level, and
+// the level isn't changed.
+func (m *Message) SetLevelString(name string) error {
+	level, ok := levelNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return ErrUnknownLevel
+	}
+	m.Level = level
+	return nil
+}
+
+// Send the result of sending the message to its result channel, if it has one
+func (m *Message) resolve(err error) {
+	if m.result != nil {
+		m.result <- err
+		m.result = nil
+	}
+	if m.inFlight != nil {
+		<-m.inFlight
+		m.inFlight = nil
+	}
+}
+
 // A message to be serialized and sent to the GELF server
 type Message struct {
 	logger *Logger
 
+	callerFile string
+	callerLine int
+	goroutine  uint64
+	maxAge     time.Duration
+	tenant     string
+	seq        uint64
+	// Given when it's queued, for the client's OnBatchSent
+	id uint64
+	// The message's entry in the client's WAL, or 0 if it doesn't have
+	// one
+	walID uint64
+	// The GELF JSON a message recovered from the WAL or the overflow was
+	// stored as, with the client's filters and defaults already applied
+	stored []byte
+	// Attributes only sent for some levels, added with AddFieldForLevels
+	levelAttrs map[string]levelAttr
+	// Receives the result of sending the message if it was queued with
+	// QueueMsgWithResult
+	result chan error
+	// The client's MaxInFlight semaphore, released when it's resolved
+	inFlight chan struct{}
+	// Set for the summary of messages suppressed by RepeatWindow
+	repeatSummary bool
+
 	version      string                 // GELF version to serialize to
 	Level        int                    // Log level for the message (see LEVEL_DBG, etc)
-	Hostname     string                 // Hostname of the client
-	Timestamp    *time.Time             // Timestamp for the message. Populated automatically if left nil
+	Hostname     string                 // Host the message is from, the client's hostname if it's queued without one
+	Timestamp    *time.Time             // Timestamp for the message. Populated automatically if left nil or zero
 	ShortMessage string                 // Short log message
 	FullMessage  string                 // Full message (optional). Can be used for things like stack traces.
 	Attrs        map[string]interface{} // A list of attributes to add to the message
@@ -40,6 +183,388 @@ func (l *Logger) NewMessage() *Message {
 	return msg
 }
 
+// Create a copy of the message that can be changed without changing the
+// original, for deriving messages from a template. The Attrs map and the
+// Timestamp are copied rather than shared, but the values in Attrs are the
+// same values as in the original. The copy uses the same Logger as the
+// original.
+func (m *Message) Clone() *Message {
+	clone := *m
+	clone.result = nil
+	clone.inFlight = nil
+	clone.walID = 0
+
+	if m.Timestamp != nil {
+		ts := *m.Timestamp
+		clone.Timestamp = &ts
+	}
+	if m.Attrs != nil {
+		clone.Attrs = make(map[string]interface{}, len(m.Attrs))
+		for name, val := range m.Attrs {
+			clone.Attrs[name] = val
+		}
+	}
+	if m.levelAttrs != nil {
+		clone.levelAttrs = make(map[string]levelAttr, len(m.levelAttrs))
+		for name, attr := range m.levelAttrs {
+			clone.levelAttrs[name] = attr
+		}
+	}
+
+	return &clone
+}
+
+// Get the ID the message was given when it was queued, to match it up with
+// the IDs passed to the client's OnBatchSent. It's 0 if the message hasn't
+// been queued or the client doesn't have OnBatchSent set. The methods that
+// queue a copy of the message, such as QueueMsgTagged, give the ID to the
+// copy rather than the message itself.
+//
+// IDs start from 1 in the order messages are queued, but batches can be
+// confirmed out of order with more than one sender. Messages that fail to
+// send or are dropped are never confirmed, and neither are ones that went
+// through the OverflowDir since they lose their ID there.
+func (m *Message) ID() uint64 {
+	return m.id
+}
+
+// Set a key that identifies the event the message is for so the server can
+// be configured to deduplicate on it. The key is stored in the DEDUP_ATTR
+// attribute and is never changed by the client after it's set.
+//
+// Messages are delivered at least once, so if a message is retried after a
+// failed write it can reach the server more than once. Without a dedup key
+// each of those will show up as a separate message.
+func (m *Message) SetDedupKey(key string) {
+	if m.Attrs == nil {
+		m.Attrs = make(map[string]interface{}, 0)
+	}
+	m.Attrs[DEDUP_ATTR] = key
+}
+
+// Retrieve the dedup key set with SetDedupKey. Returns an empty string if
+// no key has been set.
+func (m *Message) DedupKey() string {
+	key, _ := m.Attrs[DEDUP_ATTR].(string)
+	return key
+}
+
+// Add an attribute named 'name' with the value 'val' to the message,
+// replacing any attribute with the same name. A []byte value, such as a hash
+// or an encoded protobuf, is sent as a base64 encoded string in an attribute
+// named 'name' with BINARY_FIELD_SUFFIX on the end, so "digest" is sent as
+// "_digest_base64".
+func (m *Message) AddField(name string, val interface{}) *Message {
+	if m.Attrs == nil {
+		m.Attrs = make(map[string]interface{}, 0)
+	}
+	if data, ok := val.([]byte); ok {
+		m.Attrs[name+BINARY_FIELD_SUFFIX] = binaryField(data)
+		return m
+	}
+	m.Attrs[name] = val
+	return m
+}
+
+// An attribute that's only sent with messages at one of its levels
+type levelAttr struct {
+	val    interface{}
+	levels []int
+}
+
+func (la levelAttr) hasLevel(level int) bool {
+	for _, l := range la.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Add an attribute named 'name' with the value 'val' that's only sent if the
+// message's level is one of 'levels' when it's serialized, such as extra
+// detail for LEVEL_DBG messages. It replaces an attribute with the same name
+// added with AddField while it's sent.
+func (m *Message) AddFieldForLevels(name string, val interface{}, levels ...int) *Message {
+	if m.levelAttrs == nil {
+		m.levelAttrs = make(map[string]levelAttr, 0)
+	}
+	m.levelAttrs[name] = levelAttr{
+		val:    val,
+		levels: levels,
+	}
+	return m
+}
+
+// Add all of the attributes in 'fields' to the message
+func (m *Message) AddFields(fields map[string]interface{}) *Message {
+	for name, val := range fields {
+		m.AddField(name, val)
+	}
+	return m
+}
+
+// Add an attribute named 'name' with the value 'val' to the message, flattening
+// any maps or slices in 'val' into separate attributes with dotted names. For
+// example a value of {"id": 1, "tags": ["a", "b"]} for "user" is added as the
+// "user.id", "user.tags.0" and "user.tags.1" attributes.
+//
+// At most 'maxDepth' levels are flattened, anything nested deeper is added
+// as-is. A 'maxDepth' of 0 or less has no limit.
+func (m *Message) AddFlatField(name string, val interface{}, maxDepth int) *Message {
+	m.addFlat(name, reflect.ValueOf(val), 0, maxDepth)
+	return m
+}
+
+// Add all of the attributes in 'fields' to the message, flattening them the
+// same way as AddFlatField
+func (m *Message) AddFlatFields(fields map[string]interface{}, maxDepth int) *Message {
+	for name, val := range fields {
+		m.AddFlatField(name, val, maxDepth)
+	}
+	return m
+}
+
+func (m *Message) addFlat(name string, val reflect.Value, depth int, maxDepth int) {
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			break
+		}
+		val = val.Elem()
+	}
+
+	if !val.IsValid() {
+		m.AddField(name, nil)
+		return
+	}
+	if maxDepth > 0 && depth >= maxDepth {
+		m.AddField(name, val.Interface())
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for _, key := range val.MapKeys() {
+			m.addFlat(name+"."+key.String(), val.MapIndex(key), depth+1, maxDepth)
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			// Leave byte slices alone, they're encoded as a single value
+			break
+		}
+		for idx := 0; idx < val.Len(); idx++ {
+			m.addFlat(name+"."+strconv.Itoa(idx), val.Index(idx), depth+1, maxDepth)
+		}
+		return
+	}
+
+	m.AddField(name, val.Interface())
+}
+
+// Add the exported fields of the struct 'v', or of the struct it points to, to
+// the message as attributes named 'prefix' and a dot followed by the field's
+// name, or just the field's name if 'prefix' is empty. A `gelf:"name"` tag on
+// a field changes its name and `gelf:"-"` leaves it out. Nested structs are
+// flattened into dotted names, except for ones like time.Time that encode
+// themselves, which are added as-is along with every other value. The fields
+// of an embedded struct are added as if they were the outer struct's, as long
+// as the embedded struct's type is exported since they can't be read
+// otherwise. Nothing is added if 'v' isn't a struct.
+func (m *Message) AddStruct(prefix string, v interface{}) *Message {
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Struct {
+		m.addStruct(prefix, val)
+	}
+	return m
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (m *Message) addStruct(prefix string, val reflect.Value) {
+	typ := val.Type()
+	for idx := 0; idx < typ.NumField(); idx++ {
+		field := typ.Field(idx)
+		if field.PkgPath != "" {
+			// Unexported
+			continue
+		}
+
+		tagName := strings.Split(field.Tag.Get("gelf"), ",")[0]
+		if tagName == "-" {
+			continue
+		}
+		name := field.Name
+		if tagName != "" {
+			name = tagName
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		fieldVal := val.Field(idx)
+		for fieldVal.Kind() == reflect.Ptr && !fieldVal.IsNil() && !encodesItself(fieldVal.Type()) {
+			fieldVal = fieldVal.Elem()
+		}
+		if fieldVal.Kind() == reflect.Struct && !encodesItself(fieldVal.Type()) {
+			if field.Anonymous && tagName == "" {
+				m.addStruct(prefix, fieldVal)
+			} else {
+				m.addStruct(name, fieldVal)
+			}
+			continue
+		}
+		if fieldVal.Kind() == reflect.Ptr && fieldVal.IsNil() {
+			m.AddField(name, nil)
+			continue
+		}
+		m.AddField(name, fieldVal.Interface())
+	}
+}
+
+// Check if values of typ are encoded as JSON by their own methods rather than
+// as their fields
+func encodesItself(typ reflect.Type) bool {
+	return typ.Implements(jsonMarshalerType) || typ.Implements(textMarshalerType) ||
+		reflect.PtrTo(typ).Implements(jsonMarshalerType) || reflect.PtrTo(typ).Implements(textMarshalerType)
+}
+
+// Add the current goroutine's stack trace to the message in the STACK_ATTR
+// ("_stack") attribute, starting from the function that called AddStackTrace.
+// 'skip' is the number of extra frames to leave off the top of the stack, for
+// code that wraps it. Each frame is formatted like a panic's stack trace, with
+// the function on one line followed by its file and line on the next.
+func (m *Message) AddStackTrace(skip int) *Message {
+	pcs := make([]uintptr, maxStackDepth)
+	// Skip runtime.Callers and AddStackTrace itself
+	n := runtime.Callers(skip+2, pcs)
+
+	buf := &bytes.Buffer{}
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(buf, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return m.AddField(STACK_ATTR, buf.String())
+}
+
+// Set the short message to the first line of text and the full message to all
+// of it, the way GELF messages are usually split. The first line is cut short
+// at SHORT_MESSAGE_MAX_BYTES, ending with "...". If text is a single line that
+// fits the full message is cleared since it would only repeat the short
+// message.
+func (m *Message) SetMessage(text string) {
+	first := text
+	if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+		first = text[:idx]
+	}
+	first = strings.TrimSuffix(first, "\r")
+
+	m.ShortMessage = first
+	m.FullMessage = ""
+	if len(first) > SHORT_MESSAGE_MAX_BYTES {
+		m.ShortMessage = truncateString(first, SHORT_MESSAGE_MAX_BYTES)
+		m.FullMessage = text
+	} else if first != text {
+		m.FullMessage = text
+	}
+}
+
+// Set the longest time after the message's Timestamp that it's still sent,
+// overriding the client's MaxAge. A message that's still queued when it's older
+// than this is dropped instead of being sent.
+func (m *Message) SetMaxAge(maxAge time.Duration) *Message {
+	m.maxAge = maxAge
+	return m
+}
+
+// Set the message's timestamp to 'offset' from 'base', for events with times
+// relative to some point such as when replaying them. A negative offset is
+// before base.
+func (m *Message) SetRelativeTimestamp(base time.Time, offset time.Duration) {
+	ts := base.Add(offset)
+	m.Timestamp = &ts
+}
+
+// Set the version the message is sent with if it hasn't been set
+func (m *Message) setVersion() {
+	if m.version == "" {
+		m.version = "1.1"
+	}
+}
+
+// Set the version and timestamp the message is sent with if they haven't
+// been set, using 'now' as the timestamp
+func (m *Message) setDefaults(now time.Time) {
+	m.setVersion()
+	// A zero time would be sent as the year 1, which servers misplace, so
+	// it's treated the same as no time
+	if m.Timestamp == nil || m.Timestamp.IsZero() {
+		// Copied so now is only moved to the heap when it's used, keeping
+		// messages that already have a timestamp from allocating
+		ts := now
+		m.Timestamp = &ts
+	}
+}
+
+// Serialize the message to the GELF JSON that would be sent for it, without
+// sending it. The same defaults are used as when it's queued, if the message
+// doesn't have a timestamp the current time is used and if it doesn't have a
+// Hostname the machine's hostname is. The message itself isn't modified.
+func (m *Message) JSON() ([]byte, error) {
+	msg := *m
+	msg.setDefaults(time.Now())
+	if msg.Hostname == "" {
+		msg.Hostname, _ = os.Hostname()
+	}
+
+	return serializeMsg(&msg, serializeOptions{})
+}
+
+// Write the GELF JSON for each of msgs to w followed by a newline, such as for
+// archiving messages as newline delimited GELF. The same defaults are used as
+// by JSON, messages without a timestamp are given the time EncodeBatch was
+// called, and the messages aren't modified. Returns ErrNilMessage if any of
+// the messages is nil, or the first error serializing or writing one, after
+// writing the messages before it.
+func EncodeBatch(w io.Writer, msgs []*Message) error {
+	now := time.Now()
+	hostname, _ := os.Hostname()
+	for _, m := range msgs {
+		if m == nil {
+			return ErrNilMessage
+		}
+		msg := *m
+		msg.setDefaults(now)
+		if msg.Hostname == "" {
+			msg.Hostname = hostname
+		}
+
+		data, err := serializeMsg(&msg, serializeOptions{})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func newMessage() *Message {
 	return newMessageForVersion("1.1")
 }
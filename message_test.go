@@ -0,0 +1,421 @@
+package golf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestMessageDedupKey(t sweet.T) {
+	msg := newMessage()
+	Expect(msg.DedupKey()).To(Equal(""))
+
+	msg.SetDedupKey("event-1234")
+	Expect(msg.DedupKey()).To(Equal("event-1234"))
+	Expect(msg.Attrs[DEDUP_ATTR]).To(Equal("event-1234"))
+
+	ts := time.Unix(0, 1440387554671944965)
+	msg.Timestamp = &ts
+
+	// Serializing the message again, like a retry would, must produce
+	// the same key
+	first, err := generateMsgJson(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	second, err := generateMsgJson(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	Expect(first).To(ContainSubstring(`"_message_id":"event-1234"`))
+	Expect(second).To(Equal(first))
+}
+
+func (s *GolfSuite) TestMessageDedupKeyNilAttrs(t sweet.T) {
+	msg := &Message{}
+	msg.SetDedupKey("event-1234")
+	Expect(msg.DedupKey()).To(Equal("event-1234"))
+}
+
+func (s *GolfSuite) TestMessageAddFields(t sweet.T) {
+	msg := &Message{}
+	msg.AddField("attr1", "val1").AddFields(map[string]interface{}{
+		"attr1": "newval1",
+		"attr2": 1234,
+	})
+
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"attr1": "newval1",
+		"attr2": 1234,
+	}))
+}
+
+func (s *GolfSuite) TestMessageAddFieldBinary(t sweet.T) {
+	msg := newMessage()
+	msg.AddField("digest", []byte{1, 2, 3})
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"digest" + BINARY_FIELD_SUFFIX: binaryField{1, 2, 3},
+	}))
+
+	data, err := msg.JSON()
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"_digest_base64":"AQID"`))
+}
+
+func (s *GolfSuite) TestMessageAddFlatFields(t sweet.T) {
+	msg := newMessage()
+	msg.AddFlatFields(map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":   1,
+			"name": "x",
+			"tags": []string{"a", "b"},
+			"address": map[string]interface{}{
+				"city": "y",
+			},
+		},
+		"count": 5,
+		"data":  []byte{1, 2},
+	}, 0)
+
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"user.id":           1,
+		"user.name":         "x",
+		"user.tags.0":       "a",
+		"user.tags.1":       "b",
+		"user.address.city": "y",
+		"count":             5,
+		"data_base64":       binaryField{1, 2},
+	}))
+}
+
+func (s *GolfSuite) TestMessageAddFlatFieldMaxDepth(t sweet.T) {
+	msg := newMessage()
+	msg.AddFlatField("user", map[string]interface{}{
+		"id": 1,
+		"address": map[string]interface{}{
+			"city": "y",
+		},
+	}, 1)
+
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"user.id": 1,
+		"user.address": map[string]interface{}{
+			"city": "y",
+		},
+	}))
+}
+
+type structTestAddress struct {
+	City string `gelf:"city"`
+}
+
+type StructTestBase struct {
+	ID int `gelf:"id"`
+}
+
+type structTestUser struct {
+	StructTestBase
+	Name     string `gelf:"name"`
+	Password string `gelf:"-"`
+	Admin    bool
+	Address  structTestAddress  `gelf:"address"`
+	Previous *structTestAddress `gelf:"previous"`
+	Created  time.Time          `gelf:"created"`
+	Tags     []string           `gelf:"tags,omitempty"`
+	internal string
+}
+
+func (s *GolfSuite) TestMessageAddStruct(t sweet.T) {
+	created := time.Unix(1500000000, 0)
+	user := &structTestUser{
+		StructTestBase: StructTestBase{ID: 1},
+		Name:           "x",
+		Password:       "hunter2",
+		Admin:          true,
+		Address:        structTestAddress{City: "y"},
+		Created:        created,
+		Tags:           []string{"a"},
+		internal:       "z",
+	}
+
+	msg := newMessage()
+	msg.AddStruct("user", user)
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{
+		"user.id":           1,
+		"user.name":         "x",
+		"user.Admin":        true,
+		"user.address.city": "y",
+		"user.previous":     nil,
+		"user.created":      created,
+		"user.tags":         []string{"a"},
+	}))
+
+	msg = newMessage()
+	msg.AddStruct("", structTestAddress{City: "y"})
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{"city": "y"}))
+
+	msg = newMessage()
+	msg.AddStruct("user", "not a struct")
+	msg.AddStruct("user", (*structTestUser)(nil))
+	Expect(msg.Attrs).To(HaveLen(0))
+}
+
+func (s *GolfSuite) TestMessageJSON(t sweet.T) {
+	ts := time.Unix(1500000000, 500000000)
+	msg := &Message{
+		Level:        LEVEL_WARN,
+		Hostname:     "host",
+		ShortMessage: "short",
+		Timestamp:    &ts,
+		Attrs:        map[string]interface{}{"key": "value"},
+	}
+
+	data, err := msg.JSON()
+	Expect(err).To(BeNil())
+
+	var obj map[string]interface{}
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(Equal(map[string]interface{}{
+		"version":       "1.1",
+		"host":          "host",
+		"level":         float64(LEVEL_WARN),
+		"short_message": "short",
+		"timestamp":     float64(1500000000.5),
+		"_key":          "value",
+	}))
+
+	// Defaults aren't set on the message itself
+	Expect(msg.version).To(Equal(""))
+}
+
+func (s *GolfSuite) TestEncodeBatch(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msgs := []*Message{
+		{ShortMessage: "first", Timestamp: &ts, Attrs: map[string]interface{}{"key": "value"}},
+		{ShortMessage: "second"},
+	}
+
+	buf := &bytes.Buffer{}
+	before := time.Now()
+	Expect(EncodeBatch(buf, msgs)).To(BeNil())
+	Expect(msgs[1].Timestamp).To(BeNil())
+
+	lines := strings.Split(buf.String(), "\n")
+	Expect(lines).To(HaveLen(3))
+	Expect(lines[2]).To(BeEmpty())
+
+	first, err := ParseMessage([]byte(lines[0]))
+	Expect(err).To(BeNil())
+	Expect(first.ShortMessage).To(Equal("first"))
+	Expect(first.Timestamp.Unix()).To(Equal(ts.Unix()))
+	Expect(first.Attrs).To(Equal(map[string]interface{}{"key": "value"}))
+
+	second, err := ParseMessage([]byte(lines[1]))
+	Expect(err).To(BeNil())
+	Expect(second.ShortMessage).To(Equal("second"))
+	Expect(*second.Timestamp).To(BeTemporally("~", before, time.Second))
+}
+
+func (s *GolfSuite) TestEncodeBatchErrors(t sweet.T) {
+	buf := &bytes.Buffer{}
+	err := EncodeBatch(buf, []*Message{{ShortMessage: "written"}, nil, {ShortMessage: "not written"}})
+	Expect(err).To(Equal(ErrNilMessage))
+	Expect(strings.Count(buf.String(), "\n")).To(Equal(1))
+
+	writeErr := errors.New("write failed")
+	Expect(EncodeBatch(newFailWriter(0, writeErr), []*Message{{ShortMessage: "failed"}})).To(Equal(writeErr))
+}
+
+func (s *GolfSuite) TestMessageJSONDefaultTimestamp(t sweet.T) {
+	msg := &Message{ShortMessage: "short"}
+
+	before := time.Now()
+	data, err := msg.JSON()
+	Expect(err).To(BeNil())
+	Expect(msg.Timestamp).To(BeNil())
+
+	parsed, err := ParseMessage(data)
+	Expect(err).To(BeNil())
+	Expect(parsed.Timestamp.Unix()).To(BeNumerically(">=", before.Unix()))
+}
+
+func (s *GolfSuite) TestMessageJSONMatchesSent(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	msg := &Message{ShortMessage: "short", Timestamp: &ts}
+	expected, err := msg.JSON()
+	Expect(err).To(BeNil())
+
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.QueueMsg(msg)
+	c.Flush()
+	Expect(sink.batches).To(HaveLen(1))
+	Expect(sink.batches[0][0]).To(MatchJSON(expected))
+}
+
+func (s *GolfSuite) TestMessageSetLevelString(t sweet.T) {
+	levels := map[string]int{
+		"emergency": LEVEL_EMERG,
+		"ALERT":     LEVEL_ALERT,
+		"fatal":     LEVEL_CRIT,
+		"critical":  LEVEL_CRIT,
+		"Err":       LEVEL_ERR,
+		"error":     LEVEL_ERR,
+		"warn":      LEVEL_WARN,
+		"WARNING":   LEVEL_WARN,
+		"notice":    LEVEL_NOTICE,
+		" info ":    LEVEL_INFO,
+		"debug":     LEVEL_DBG,
+	}
+	for name, level := range levels {
+		msg := &Message{}
+		Expect(msg.SetLevelString(name)).To(BeNil())
+		Expect(msg.Level).To(Equal(level))
+	}
+
+	msg := &Message{Level: LEVEL_INFO}
+	Expect(msg.SetLevelString("verbose")).To(Equal(ErrUnknownLevel))
+	Expect(msg.Level).To(Equal(LEVEL_INFO))
+}
+
+func (s *GolfSuite) TestMessageSetMessage(t sweet.T) {
+	msg := &Message{FullMessage: "left over"}
+	msg.SetMessage("single line")
+	Expect(msg.ShortMessage).To(Equal("single line"))
+	Expect(msg.FullMessage).To(Equal(""))
+
+	msg.SetMessage("panic: oops\r\ngoroutine 1 [running]:\n")
+	Expect(msg.ShortMessage).To(Equal("panic: oops"))
+	Expect(msg.FullMessage).To(Equal("panic: oops\r\ngoroutine 1 [running]:\n"))
+
+	long := strings.Repeat("x", SHORT_MESSAGE_MAX_BYTES+1)
+	msg.SetMessage(long)
+	Expect(msg.ShortMessage).To(HaveLen(SHORT_MESSAGE_MAX_BYTES))
+	Expect(msg.ShortMessage).To(HaveSuffix("..."))
+	Expect(msg.FullMessage).To(Equal(long))
+
+	msg.SetMessage(strings.Repeat("x", SHORT_MESSAGE_MAX_BYTES))
+	Expect(msg.FullMessage).To(Equal(""))
+}
+
+func (s *GolfSuite) TestMessageSetRelativeTimestamp(t sweet.T) {
+	base := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+
+	msg := &Message{}
+	msg.SetRelativeTimestamp(base, 90*time.Second)
+	Expect(*msg.Timestamp).To(Equal(base.Add(90 * time.Second)))
+
+	msg.SetRelativeTimestamp(base, -time.Hour)
+	Expect(*msg.Timestamp).To(Equal(base.Add(-time.Hour)))
+}
+
+func (s *GolfSuite) TestQueueMsgKeepsTimestamp(t sweet.T) {
+	base := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	msg := &Message{ShortMessage: "replayed"}
+	msg.SetRelativeTimestamp(base, 1500*time.Millisecond)
+
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	// The timestamp that was set is sent rather than when it was queued
+	c.QueueMsg(msg)
+	c.Flush()
+	parsed, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(parsed.Timestamp.UTC()).To(BeTemporally("~", base.Add(1500*time.Millisecond), time.Millisecond))
+}
+
+func (s *GolfSuite) TestMessageAddFieldForLevels(t sweet.T) {
+	ts := time.Now()
+	msg := &Message{Level: LEVEL_INFO, Timestamp: &ts}
+	msg.AddField("always", 1).
+		AddFieldForLevels("debug_detail", "detail", LEVEL_DBG).
+		AddFieldForLevels("always", 2, LEVEL_DBG, LEVEL_ERR)
+
+	data, err := msg.JSON()
+	Expect(err).To(BeNil())
+	parsed, _ := ParseMessage(data)
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{"always": float64(1)}))
+
+	// Checked when it's serialized so changing the level changes what's sent
+	msg.Level = LEVEL_DBG
+	data, err = msg.JSON()
+	Expect(err).To(BeNil())
+	parsed, _ = ParseMessage(data)
+	Expect(parsed.Attrs).To(Equal(map[string]interface{}{
+		"always":       float64(2),
+		"debug_detail": "detail",
+	}))
+}
+
+func stackTraceHelper(msg *Message) {
+	msg.AddStackTrace(1)
+}
+
+func (s *GolfSuite) TestMessageAddStackTrace(t sweet.T) {
+	msg := newMessage()
+	msg.AddStackTrace(0)
+
+	stack := msg.Attrs[STACK_ATTR].(string)
+	lines := strings.Split(stack, "\n")
+	Expect(lines[0]).To(HaveSuffix("TestMessageAddStackTrace"))
+	Expect(lines[1]).To(MatchRegexp(`^\t.*message_test.go:\d+$`))
+
+	// Skipping the helper's frame starts from the function that called it
+	msg = newMessage()
+	stackTraceHelper(msg)
+	stack = msg.Attrs[STACK_ATTR].(string)
+	Expect(stack).ToNot(ContainSubstring("stackTraceHelper"))
+	Expect(strings.Split(stack, "\n")[0]).To(HaveSuffix("TestMessageAddStackTrace"))
+}
+
+func (s *GolfSuite) TestMessageClone(t sweet.T) {
+	ts := time.Unix(1500000000, 0)
+	template := newMessage()
+	template.Level = LEVEL_WARN
+	template.Hostname = "host"
+	template.ShortMessage = "template"
+	template.Timestamp = &ts
+	template.AddField("service", "api")
+	template.AddFieldForLevels("debug", "detail", LEVEL_DBG)
+
+	clone := template.Clone()
+	Expect(clone).To(Equal(template))
+	Expect(clone.Timestamp).ToNot(BeIdenticalTo(template.Timestamp))
+
+	clone.ShortMessage = "event"
+	clone.AddField("service", "worker")
+	clone.AddField("user", "someone")
+	clone.AddFieldForLevels("debug", "other", LEVEL_DBG)
+	*clone.Timestamp = time.Unix(1600000000, 0)
+
+	Expect(template.ShortMessage).To(Equal("template"))
+	Expect(template.Attrs).To(Equal(map[string]interface{}{"service": "api"}))
+	Expect(template.levelAttrs["debug"].val).To(Equal("detail"))
+	Expect(*template.Timestamp).To(Equal(time.Unix(1500000000, 0)))
+}
+
+func (s *GolfSuite) TestMessageCloneConcurrent(t sweet.T) {
+	template := newMessage()
+	template.AddField("service", "api")
+
+	done := make(chan *Message)
+	for idx := 0; idx < 10; idx++ {
+		go func(idx int) {
+			msg := template.Clone()
+			msg.AddField("idx", idx)
+			done <- msg
+		}(idx)
+	}
+	for idx := 0; idx < 10; idx++ {
+		msg := <-done
+		Expect(msg.Attrs).To(HaveLen(2))
+	}
+	Expect(template.Attrs).To(HaveLen(1))
+}
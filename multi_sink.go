@@ -0,0 +1,92 @@
+package golf
+
+import (
+	"io"
+)
+
+// A MultiSink is a Sink that sends every batch to each of its sinks, such as
+// a FileSink for an archive along with a ClientSink for a GELF server, so a
+// client can log to several places at once. Each batch is sent to the sinks
+// one after the other, in the order they were given.
+//
+// If any of the sinks fail the batch is still sent to the rest of them, and
+// a *MultiSinkError with the error from each sink that failed is returned.
+// The client counts the batch as failed, and sends it to its FallbackSink if
+// it has one, even if other sinks sent it successfully.
+type MultiSink struct {
+	sinks []Sink
+}
+
+// Create a MultiSink that sends to all of the sinks
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{
+		sinks: sinks,
+	}
+}
+
+// Send the batch to each of the sinks
+func (ms *MultiSink) Send(batch [][]byte) error {
+	var errs map[int]error
+	for idx, sink := range ms.sinks {
+		err := sink.Send(batch)
+		if err != nil {
+			if errs == nil {
+				errs = make(map[int]error, 0)
+			}
+			errs[idx] = err
+		}
+	}
+	if errs != nil {
+		return &MultiSinkError{Errs: errs}
+	}
+	return nil
+}
+
+// Close each of the sinks that implement io.Closer, returning the first error
+func (ms *MultiSink) Close() error {
+	var firstErr error
+	for _, sink := range ms.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// A ClientSink is a Sink that queues each message on another Client, so the
+// messages are sent with that client's own connection and config, such as a
+// different Compression or Serializer. The messages are queued as they were
+// serialized by the client using the sink, with all of its attributes, and
+// the other client adds anything from its own config to them when it sends
+// them. Errors sending them are reported on the other client's Errors().
+type ClientSink struct {
+	client *Client
+}
+
+// Create a ClientSink that queues messages on c
+func NewClientSink(c *Client) *ClientSink {
+	return &ClientSink{
+		client: c,
+	}
+}
+
+// Queue each message in the batch on the sink's client
+func (cs *ClientSink) Send(batch [][]byte) error {
+	msgs := make([]*Message, 0, len(batch))
+	for _, data := range batch {
+		msg, err := ParseMessage(data)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	return cs.client.QueueMsgs(msgs)
+}
+
+// Close the sink's client, waiting for the messages queued on it to be sent
+func (cs *ClientSink) Close() error {
+	return cs.client.Close()
+}
@@ -0,0 +1,81 @@
+package golf
+
+import (
+	"bufio"
+	"errors"
+	"net"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestMultiSink(t sweet.T) {
+	first := &testSink{}
+	second := &testSink{}
+
+	c, _ := NewClient()
+	c.UseSink(NewMultiSink(first, second))
+	c.Infof("message 1")
+	c.Infof("message 2")
+	Expect(c.Close()).To(BeNil())
+
+	Expect(first.messages()).To(Equal([]string{"message 1", "message 2"}))
+	Expect(second.messages()).To(Equal([]string{"message 1", "message 2"}))
+	Expect(first.closed).To(BeTrue())
+	Expect(second.closed).To(BeTrue())
+}
+
+func (s *GolfSuite) TestMultiSinkErrors(t sweet.T) {
+	failing := &testSink{err: errors.New("sink failed")}
+	working := &testSink{}
+	sink := NewMultiSink(failing, working, &testSink{err: errors.New("also failed")})
+
+	err := sink.Send([][]byte{[]byte("data")})
+	Expect(err).To(Equal(&MultiSinkError{Errs: map[int]error{
+		0: errors.New("sink failed"),
+		2: errors.New("also failed"),
+	}}))
+	Expect(err.Error()).To(Equal("2 of the sinks failed: sink 0: sink failed; sink 2: also failed"))
+
+	// The sinks after the failed one are still sent to
+	Expect(working.batches).To(HaveLen(1))
+
+	Expect(NewMultiSink(working).Send([][]byte{[]byte("data")})).To(BeNil())
+}
+
+func (s *GolfSuite) TestClientSink(t sweet.T) {
+	// Sent to a tcp server as GELF and to another sink as JSON lines at
+	// the same time
+	tcpClient, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1420})
+	client, server := net.Pipe()
+	tcpClient.Use(client, "tcp")
+
+	linesClient, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		Serializer: JSONLinesSerializer{},
+	})
+	jsonSink := &testSink{}
+	linesClient.UseSink(jsonSink)
+
+	c, _ := NewClient()
+	c.UseSink(NewMultiSink(NewClientSink(tcpClient), NewClientSink(linesClient)))
+	defer c.Close()
+
+	msg := newMessage()
+	msg.ShortMessage = "fan out"
+	msg.AddField("user", "someone")
+	c.QueueMsg(msg)
+
+	data, err := bufio.NewReader(server).ReadBytes(0)
+	Expect(err).To(BeNil())
+	parsed, err := ParseMessage(data[:len(data)-1])
+	Expect(err).To(BeNil())
+	Expect(parsed.ShortMessage).To(Equal("fan out"))
+	Expect(parsed.Attrs["user"]).To(Equal("someone"))
+
+	c.Flush()
+	linesClient.Flush()
+	Expect(jsonSink.batches).To(HaveLen(1))
+	Expect(string(jsonSink.batches[0][0])).To(ContainSubstring(`"message":"fan out"`))
+	Expect(string(jsonSink.batches[0][0])).To(ContainSubstring(`"user":"someone"`))
+}
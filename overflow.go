@@ -0,0 +1,290 @@
+package golf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overflowed messages are split across this many segment files so the oldest
+// can be pruned without rewriting the rest
+const overflowSegments = 8
+
+// Size of each segment file when the overflow's size isn't limited
+const defaultOverflowSegmentSize = 1024 * 1024
+
+// Longest time after a message is written to the overflow before it's synced
+// to disk with FsyncOnOverflow
+const overflowSyncDelay = 100 * time.Millisecond
+
+// Prefix and suffix of the names of the overflow's segment files
+const (
+	overflowPrefix = "golf-overflow-"
+	overflowSuffix = ".log"
+)
+
+type overflowSegment struct {
+	path      string
+	size      int64
+	lastWrite time.Time
+}
+
+type overflowSegmentsByPath []*overflowSegment
+
+func (s overflowSegmentsByPath) Len() int           { return len(s) }
+func (s overflowSegmentsByPath) Less(i, j int) bool { return s[i].path < s[j].path }
+func (s overflowSegmentsByPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// An overflowQueue holds the messages that didn't fit in a client's queue in
+// segment files in a directory, as newline delimited GELF JSON. Once there's
+// more than maxBytes of messages, or the messages in a segment are older than
+// maxAge, the oldest segments are removed. The segments are left in the
+// directory when it's closed so they're picked up again by the next client
+// using it.
+type overflowQueue struct {
+	dir         string
+	maxBytes    int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	// Sync what's written to disk, at most overflowSyncDelay after it's
+	// written, reporting any errors to reportErr
+	fsync     bool
+	reportErr func(error)
+
+	segmentsMutex sync.Mutex
+	segments      []*overflowSegment
+	file          *os.File
+	nextSeq       int
+	// Set while there's a sync waiting to happen
+	syncPending bool
+}
+
+func newOverflowQueue(dir string, maxBytes int64, maxAge time.Duration) (*overflowQueue, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	oq := &overflowQueue{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		segmentSize: defaultOverflowSegmentSize,
+	}
+	if maxBytes > 0 {
+		oq.segmentSize = maxBytes / overflowSegments
+		if oq.segmentSize < 1 {
+			oq.segmentSize = 1
+		}
+	}
+
+	// Pick up any segments left behind by a previous client
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, overflowPrefix) || !strings.HasSuffix(name, overflowSuffix) {
+			continue
+		}
+
+		var seq int
+		_, err := fmt.Sscanf(strings.TrimPrefix(name, overflowPrefix), "%d", &seq)
+		if err != nil {
+			continue
+		}
+		if seq >= oq.nextSeq {
+			oq.nextSeq = seq + 1
+		}
+
+		oq.segments = append(oq.segments, &overflowSegment{
+			path:      filepath.Join(dir, name),
+			size:      info.Size(),
+			lastWrite: info.ModTime(),
+		})
+	}
+	sort.Sort(overflowSegmentsByPath(oq.segments))
+	oq.prune()
+
+	return oq, nil
+}
+
+// Check if there are any messages in the overflow
+func (oq *overflowQueue) empty() bool {
+	oq.segmentsMutex.Lock()
+	defer oq.segmentsMutex.Unlock()
+
+	return len(oq.segments) == 0
+}
+
+// Add the GELF JSON for a message to the end of the overflow
+func (oq *overflowQueue) push(data []byte) error {
+	oq.segmentsMutex.Lock()
+	defer oq.segmentsMutex.Unlock()
+
+	size := int64(len(data) + 1)
+	if oq.file == nil || oq.segments[len(oq.segments)-1].size+size > oq.segmentSize {
+		err := oq.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	line := make([]byte, 0, size)
+	line = append(line, data...)
+	line = append(line, '\n')
+	_, err := oq.file.Write(line)
+	if err != nil {
+		return err
+	}
+
+	seg := oq.segments[len(oq.segments)-1]
+	seg.size += size
+	seg.lastWrite = time.Now()
+
+	// Messages written close together are synced together
+	if oq.fsync && !oq.syncPending {
+		oq.syncPending = true
+		time.AfterFunc(overflowSyncDelay, oq.sync)
+	}
+
+	oq.prune()
+	return nil
+}
+
+// Sync the segment being written to disk
+func (oq *overflowQueue) sync() {
+	oq.segmentsMutex.Lock()
+	defer oq.segmentsMutex.Unlock()
+
+	oq.syncPending = false
+	oq.syncFile()
+}
+
+func (oq *overflowQueue) syncFile() {
+	if !oq.fsync || oq.file == nil {
+		return
+	}
+	err := oq.file.Sync()
+	if err != nil && oq.reportErr != nil {
+		oq.reportErr(err)
+	}
+}
+
+// Close the current segment and start writing to a new one
+func (oq *overflowQueue) rotate() error {
+	if oq.file != nil {
+		oq.syncFile()
+		oq.file.Close()
+		oq.file = nil
+	}
+
+	path := filepath.Join(oq.dir, fmt.Sprintf("%s%020d%s", overflowPrefix, oq.nextSeq, overflowSuffix))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	oq.nextSeq++
+
+	oq.file = file
+	oq.segments = append(oq.segments, &overflowSegment{
+		path:      path,
+		lastWrite: time.Now(),
+	})
+	return nil
+}
+
+// Remove the oldest segments while the overflow is larger than maxBytes, and
+// any segments that haven't been written to for longer than maxAge
+func (oq *overflowQueue) prune() {
+	if oq.maxBytes > 0 {
+		total := int64(0)
+		for _, seg := range oq.segments {
+			total += seg.size
+		}
+		for total > oq.maxBytes && len(oq.segments) > 1 {
+			total -= oq.segments[0].size
+			oq.removeOldest()
+		}
+	}
+
+	if oq.maxAge > 0 {
+		for len(oq.segments) > 0 && time.Since(oq.segments[0].lastWrite) > oq.maxAge {
+			oq.removeOldest()
+		}
+	}
+}
+
+func (oq *overflowQueue) removeOldest() {
+	if len(oq.segments) == 1 && oq.file != nil {
+		oq.file.Close()
+		oq.file = nil
+	}
+	os.Remove(oq.segments[0].path)
+	oq.segments = oq.segments[1:]
+}
+
+// Remove the oldest segment from the overflow and return the messages in it.
+// Returns nil if the overflow is empty.
+func (oq *overflowQueue) pop() ([][]byte, error) {
+	oq.segmentsMutex.Lock()
+	defer oq.segmentsMutex.Unlock()
+
+	oq.prune()
+	if len(oq.segments) == 0 {
+		return nil, nil
+	}
+
+	if len(oq.segments) == 1 && oq.file != nil {
+		oq.file.Close()
+		oq.file = nil
+	}
+	seg := oq.segments[0]
+	oq.segments = oq.segments[1:]
+
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(seg.path)
+	defer file.Close()
+
+	msgs := make([][]byte, 0)
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 1 && line[len(line)-1] == '\n' {
+			msgs = append(msgs, line[:len(line)-1])
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return msgs, err
+		}
+	}
+
+	return msgs, nil
+}
+
+// Close the segment being written to, leaving the segments in the directory
+func (oq *overflowQueue) close() error {
+	oq.segmentsMutex.Lock()
+	defer oq.segmentsMutex.Unlock()
+
+	if oq.file == nil {
+		return nil
+	}
+	oq.syncFile()
+	err := oq.file.Close()
+	oq.file = nil
+	return err
+}
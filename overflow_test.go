@@ -0,0 +1,214 @@
+package golf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestOverflowQueue(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	// Each segment holds 12 bytes, two of the messages
+	oq, err := newOverflowQueue(dir, 96, 0)
+	Expect(err).To(BeNil())
+	Expect(oq.empty()).To(BeTrue())
+
+	for idx := 0; idx < 5; idx++ {
+		Expect(oq.push([]byte(fmt.Sprintf("msg %d", idx)))).To(BeNil())
+	}
+	Expect(oq.empty()).To(BeFalse())
+	Expect(oq.segments).To(HaveLen(3))
+
+	msgs, err := oq.pop()
+	Expect(err).To(BeNil())
+	Expect(msgs).To(Equal([][]byte{[]byte("msg 0"), []byte("msg 1")}))
+
+	// Segments are picked up by a new queue using the same directory
+	Expect(oq.close()).To(BeNil())
+	oq, err = newOverflowQueue(dir, 96, 0)
+	Expect(err).To(BeNil())
+	Expect(oq.push([]byte("msg 5"))).To(BeNil())
+
+	all := make([]string, 0)
+	for !oq.empty() {
+		msgs, err := oq.pop()
+		Expect(err).To(BeNil())
+		for _, msg := range msgs {
+			all = append(all, string(msg))
+		}
+	}
+	Expect(all).To(Equal([]string{"msg 2", "msg 3", "msg 4", "msg 5"}))
+}
+
+func (s *GolfSuite) TestOverflowQueueMaxBytes(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	oq, err := newOverflowQueue(dir, 96, 0)
+	Expect(err).To(BeNil())
+	for idx := 0; idx < 100; idx++ {
+		Expect(oq.push([]byte(fmt.Sprintf("msg %d", idx%10)))).To(BeNil())
+	}
+
+	// Only the newest 96 bytes are kept
+	count := 0
+	for !oq.empty() {
+		msgs, _ := oq.pop()
+		count += len(msgs)
+	}
+	Expect(count).To(Equal(16))
+}
+
+func (s *GolfSuite) TestOverflowQueueMaxAge(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	oq, err := newOverflowQueue(dir, 0, 50*time.Millisecond)
+	Expect(err).To(BeNil())
+	Expect(oq.push([]byte("old"))).To(BeNil())
+	time.Sleep(100 * time.Millisecond)
+
+	msgs, err := oq.pop()
+	Expect(err).To(BeNil())
+	Expect(msgs).To(BeNil())
+	Expect(oq.empty()).To(BeTrue())
+}
+
+func (s *GolfSuite) TestOverflowQueueFsync(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	oq, err := newOverflowQueue(dir, 0, 0)
+	Expect(err).To(BeNil())
+	defer oq.close()
+	errs := make(chan error, 1)
+	oq.fsync = true
+	oq.reportErr = func(err error) { errs <- err }
+
+	// Pushes close together share a sync
+	Expect(oq.push([]byte("msg 0"))).To(BeNil())
+	Expect(oq.push([]byte("msg 1"))).To(BeNil())
+	oq.segmentsMutex.Lock()
+	Expect(oq.syncPending).To(BeTrue())
+	oq.segmentsMutex.Unlock()
+	Eventually(func() bool {
+		oq.segmentsMutex.Lock()
+		defer oq.segmentsMutex.Unlock()
+		return oq.syncPending
+	}).Should(BeFalse())
+	Expect(errs).ToNot(Receive())
+
+	// Errors syncing are reported
+	oq.segmentsMutex.Lock()
+	oq.file.Close()
+	oq.segmentsMutex.Unlock()
+	Expect(oq.push([]byte("msg 2"))).ToNot(BeNil())
+	oq.segmentsMutex.Lock()
+	oq.syncFile()
+	oq.segmentsMutex.Unlock()
+	Expect(errs).To(Receive())
+}
+
+func (s *GolfSuite) TestMaxQueueSize(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 5,
+	})
+
+	for idx := 0; idx < 5; idx++ {
+		Expect(c.Infof("message %d", idx)).To(BeNil())
+	}
+	Expect(c.Infof("dropped")).To(Equal(ErrQueueFull))
+
+	msgs := []*Message{{ShortMessage: "dropped"}}
+	Expect(c.QueueMsgs(msgs)).To(Equal(ErrQueueFull))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	Expect(c.Flush()).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(5))
+}
+
+func (s *GolfSuite) TestOverflowDir(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	c, err := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 5,
+		OverflowDir:  dir,
+	})
+	Expect(err).To(BeNil())
+
+	for idx := 0; idx < 20; idx++ {
+		Expect(c.Infof("message %d", idx)).To(BeNil())
+	}
+	Expect(c.overflow.empty()).To(BeFalse())
+
+	// Messages waiting for a result aren't overflowed
+	result := c.QueueMsgWithResult(&Message{ShortMessage: "dropped"})
+	Expect(result).To(Receive(Equal(ErrQueueFull)))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	Expect(c.Flush()).To(BeNil())
+
+	msgs := sink.messages()
+	Expect(msgs).To(HaveLen(20))
+	for idx, msg := range msgs {
+		Expect(msg).To(Equal(fmt.Sprintf("message %d", idx)))
+	}
+	Expect(c.overflow.empty()).To(BeTrue())
+}
+
+func (s *GolfSuite) TestOverflowDirAsStored(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	c, err := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 1,
+		OverflowDir:  dir,
+		FieldPrefix:  "app_",
+		AllowFields:  []string{"user", "count"},
+	})
+	Expect(err).To(BeNil())
+
+	c.Infof("queued")
+	msg := &Message{Level: LEVEL_INFO, ShortMessage: "overflowed"}
+	msg.AddField("user", "bob")
+	msg.AddField("count", uint64(1<<60+1))
+	Expect(c.QueueMsg(msg)).To(BeNil())
+	Expect(c.overflow.empty()).To(BeFalse())
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	Expect(c.Flush()).To(BeNil())
+
+	// The filters and prefix aren't applied a second time, and the
+	// integer isn't turned into a float
+	Expect(sink.messages()).To(Equal([]string{"queued", "overflowed"}))
+	var data string
+	for _, batch := range sink.batches {
+		for _, msgData := range batch {
+			data = string(msgData)
+		}
+	}
+	Expect(data).To(ContainSubstring(`"_app_user":"bob"`))
+	Expect(data).To(ContainSubstring(`"_app_count":1152921504606846977`))
+}
@@ -0,0 +1,64 @@
+package golf
+
+import (
+	"sync"
+)
+
+// A recentRing keeps the last messages sent by a client, overwriting the
+// oldest message once it's full
+type recentRing struct {
+	msgsMutex sync.Mutex
+	msgs      [][]byte
+	// Index the next message is stored at, and whether msgs has wrapped
+	// around yet
+	next    int
+	wrapped bool
+}
+
+func newRecentRing(size int) *recentRing {
+	return &recentRing{
+		msgs: make([][]byte, size),
+	}
+}
+
+func (rr *recentRing) add(batch [][]byte) {
+	rr.msgsMutex.Lock()
+	defer rr.msgsMutex.Unlock()
+
+	for _, data := range batch {
+		rr.msgs[rr.next] = data
+		rr.next++
+		if rr.next == len(rr.msgs) {
+			rr.next = 0
+			rr.wrapped = true
+		}
+	}
+}
+
+// Get copies of the messages in the ring, oldest first
+func (rr *recentRing) get() [][]byte {
+	rr.msgsMutex.Lock()
+	defer rr.msgsMutex.Unlock()
+
+	ordered := rr.msgs[:rr.next]
+	if rr.wrapped {
+		ordered = append(append([][]byte{}, rr.msgs[rr.next:]...), ordered...)
+	}
+
+	msgs := make([][]byte, len(ordered))
+	for idx, data := range ordered {
+		msgs[idx] = append([]byte(nil), data...)
+	}
+	return msgs
+}
+
+// Get the GELF JSON of the last messages sent by the client, oldest first, up
+// to RecentMessagesSize of them. Returns nil if RecentMessagesSize isn't set.
+// It's meant for debugging, to check whether a message that doesn't show up on
+// the server was actually sent.
+func (c *Client) RecentMessages() [][]byte {
+	if c.recent == nil {
+		return nil
+	}
+	return c.recent.get()
+}
@@ -0,0 +1,74 @@
+package golf
+
+import (
+	"errors"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestRecentRing(t sweet.T) {
+	rr := newRecentRing(3)
+	Expect(rr.get()).To(HaveLen(0))
+
+	rr.add([][]byte{[]byte("1"), []byte("2")})
+	Expect(rr.get()).To(Equal([][]byte{[]byte("1"), []byte("2")}))
+
+	rr.add([][]byte{[]byte("3"), []byte("4")})
+	Expect(rr.get()).To(Equal([][]byte{[]byte("2"), []byte("3"), []byte("4")}))
+
+	rr.add([][]byte{[]byte("5"), []byte("6"), []byte("7")})
+	Expect(rr.get()).To(Equal([][]byte{[]byte("5"), []byte("6"), []byte("7")}))
+
+	// The copies can be changed without changing the ring
+	got := rr.get()
+	got[0][0] = 'x'
+	Expect(rr.get()[0]).To(Equal([]byte("5")))
+}
+
+func (s *GolfSuite) TestRecentMessages(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		RecentMessagesSize: 2,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("first")
+	c.Infof("second")
+	c.Infof("third")
+	c.Flush()
+
+	recent := c.RecentMessages()
+	Expect(recent).To(HaveLen(2))
+	msg, err := ParseMessage(recent[0])
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("second"))
+	msg, err = ParseMessage(recent[1])
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("third"))
+}
+
+func (s *GolfSuite) TestRecentMessagesFailed(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		RecentMessagesSize: 2,
+	})
+	c.UseSink(&testSink{err: errors.New("send failed")})
+	defer c.Close()
+
+	c.Infof("failed")
+	c.Flush()
+	Expect(c.RecentMessages()).To(HaveLen(0))
+}
+
+func (s *GolfSuite) TestRecentMessagesDisabled(t sweet.T) {
+	c, _ := NewClient()
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(c.RecentMessages()).To(BeNil())
+}
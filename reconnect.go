@@ -0,0 +1,311 @@
+package golf
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Delays between attempts to reconnect. The delay doubles after each failed
+// attempt up to maxReconnectDelay.
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+// A reconnConn is a connection that's redialed when writing to it fails, so
+// a server restarting or a dropped connection doesn't stop messages from
+// being sent. The write that failed is retried on the new connection.
+type reconnConn struct {
+	dial        func(ctx context.Context) (net.Conn, error)
+	maxAttempts int
+	// Called after each successful reconnect, if it's set
+	onReconnect func()
+	// Logs diagnostics about reconnecting, if it's set
+	logf func(format string, v ...interface{})
+
+	// Held while reconnecting so only one write redials, without holding
+	// connMutex while it does
+	reconnectMutex sync.Mutex
+
+	connMutex sync.Mutex
+	conn      net.Conn
+	failed    bool
+	stopped   bool
+	// Done once it's stopped, cancelling any dial in progress
+	stopCtx  context.Context
+	stopDial context.CancelFunc
+}
+
+func newReconnConn(conn net.Conn, dial func(ctx context.Context) (net.Conn, error), maxAttempts int) *reconnConn {
+	stopCtx, stopDial := context.WithCancel(context.Background())
+	return &reconnConn{
+		dial:        dial,
+		maxAttempts: maxAttempts,
+		conn:        conn,
+		stopCtx:     stopCtx,
+		stopDial:    stopDial,
+	}
+}
+
+func (rc *reconnConn) current() net.Conn {
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+
+	return rc.conn
+}
+
+func (rc *reconnConn) Write(p []byte) (int, error) {
+	conn := rc.current()
+	n, err := conn.Write(p)
+	if err == nil {
+		return n, nil
+	}
+
+	conn, err = rc.reconnect(conn, err)
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+// Replace the connection 'failed' with a new one, unless another write has
+// already replaced it. Returns ErrReconnectFailed if it's given up
+// reconnecting after the maximum number of attempts, or writeErr if it's
+// been stopped from reconnecting.
+func (rc *reconnConn) reconnect(failed net.Conn, writeErr error) (net.Conn, error) {
+	rc.reconnectMutex.Lock()
+	defer rc.reconnectMutex.Unlock()
+
+	rc.connMutex.Lock()
+	if rc.failed {
+		rc.connMutex.Unlock()
+		return nil, ErrReconnectFailed
+	}
+	if rc.stopped {
+		rc.connMutex.Unlock()
+		return nil, writeErr
+	}
+	if rc.conn != failed {
+		conn := rc.conn
+		rc.connMutex.Unlock()
+		return conn, nil
+	}
+	rc.conn.Close()
+	rc.connMutex.Unlock()
+
+	// Dialing and waiting are done unlocked so stopping isn't blocked by
+	// them, and stopping cancels the dial
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		conn, err := rc.dial(rc.stopCtx)
+		rc.connMutex.Lock()
+		if rc.stopped {
+			rc.connMutex.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return nil, writeErr
+		}
+		if err == nil {
+			rc.conn = conn
+			rc.connMutex.Unlock()
+			if rc.onReconnect != nil {
+				rc.onReconnect()
+			}
+			rc.log("golf: reconnected to %s after %d attempts", conn.RemoteAddr(), attempt)
+			return conn, nil
+		}
+		rc.log("golf: reconnect attempt %d failed: %v", attempt, err)
+
+		if rc.maxAttempts > 0 && attempt >= rc.maxAttempts {
+			rc.failed = true
+			rc.connMutex.Unlock()
+			return nil, ErrReconnectFailed
+		}
+		rc.connMutex.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-rc.stopCtx.Done():
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// Stop reconnecting when writes fail, any writes that fail after this
+// return their error instead of being retried
+func (rc *reconnConn) stop() {
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+
+	if !rc.stopped {
+		rc.stopped = true
+		rc.stopDial()
+	}
+}
+
+func (rc *reconnConn) log(format string, v ...interface{}) {
+	if rc.logf != nil {
+		rc.logf(format, v...)
+	}
+}
+
+func (rc *reconnConn) Read(p []byte) (int, error) {
+	return rc.current().Read(p)
+}
+
+func (rc *reconnConn) Close() error {
+	rc.stop()
+
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+
+	if rc.failed {
+		// The last connection was already closed before giving up
+		return nil
+	}
+	return rc.conn.Close()
+}
+
+func (rc *reconnConn) LocalAddr() net.Addr {
+	return rc.current().LocalAddr()
+}
+
+func (rc *reconnConn) RemoteAddr() net.Addr {
+	return rc.current().RemoteAddr()
+}
+
+func (rc *reconnConn) SetDeadline(t time.Time) error {
+	return rc.current().SetDeadline(t)
+}
+
+func (rc *reconnConn) SetReadDeadline(t time.Time) error {
+	return rc.current().SetReadDeadline(t)
+}
+
+func (rc *reconnConn) SetWriteDeadline(t time.Time) error {
+	return rc.current().SetWriteDeadline(t)
+}
+
+// A redialConn is a connected udp socket that's redialed once when a write
+// is refused. A connected udp socket reports the icmp errors sent back by an
+// earlier write on a later one, so when the receiver is restarted the first
+// write after it comes back fails even though it would be received. The
+// endpoint is dialed again, re-resolving its hostname in case it moved, and
+// the write is retried once. Unlike a reconnConn it never waits or gives up,
+// if the retry fails too its error is returned.
+type redialConn struct {
+	dial func(ctx context.Context) (net.Conn, error)
+	// Called after each successful redial, if it's set
+	onRedial func()
+	// Logs diagnostics about redialing, if it's set
+	logf func(format string, v ...interface{})
+
+	connMutex sync.Mutex
+	conn      net.Conn
+}
+
+func newRedialConn(conn net.Conn, dial func(ctx context.Context) (net.Conn, error)) *redialConn {
+	return &redialConn{
+		dial: dial,
+		conn: conn,
+	}
+}
+
+func (rc *redialConn) current() net.Conn {
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+
+	return rc.conn
+}
+
+func (rc *redialConn) Write(p []byte) (int, error) {
+	conn := rc.current()
+	n, err := conn.Write(p)
+	if err == nil || !isConnRefused(err) {
+		return n, err
+	}
+
+	conn, dialErr := rc.redial(conn)
+	if dialErr != nil {
+		rc.log("golf: redialing %s failed: %v", rc.current().RemoteAddr(), dialErr)
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+// Replace the connection 'failed' with a new one, unless another write has
+// already replaced it
+func (rc *redialConn) redial(failed net.Conn) (net.Conn, error) {
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+
+	if rc.conn != failed {
+		return rc.conn, nil
+	}
+
+	conn, err := rc.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	rc.conn.Close()
+	rc.conn = conn
+	if rc.onRedial != nil {
+		rc.onRedial()
+	}
+	rc.log("golf: redialed %s after a refused write", conn.RemoteAddr())
+	return conn, nil
+}
+
+func (rc *redialConn) log(format string, v ...interface{}) {
+	if rc.logf != nil {
+		rc.logf(format, v...)
+	}
+}
+
+// Whether err is a write being refused because nothing was listening
+func isConnRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+func (rc *redialConn) Read(p []byte) (int, error) {
+	return rc.current().Read(p)
+}
+
+func (rc *redialConn) Close() error {
+	return rc.current().Close()
+}
+
+func (rc *redialConn) LocalAddr() net.Addr {
+	return rc.current().LocalAddr()
+}
+
+func (rc *redialConn) RemoteAddr() net.Addr {
+	return rc.current().RemoteAddr()
+}
+
+func (rc *redialConn) SetDeadline(t time.Time) error {
+	return rc.current().SetDeadline(t)
+}
+
+func (rc *redialConn) SetReadDeadline(t time.Time) error {
+	return rc.current().SetReadDeadline(t)
+}
+
+func (rc *redialConn) SetWriteDeadline(t time.Time) error {
+	return rc.current().SetWriteDeadline(t)
+}
@@ -0,0 +1,184 @@
+package golf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestDialReconnects(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	received := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				r := bufio.NewReader(conn)
+				for {
+					data, err := r.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					var obj map[string]interface{}
+					json.Unmarshal(data[:len(data)-1], &obj)
+					received <- obj["short_message"].(string)
+				}
+			}()
+		}
+	}()
+
+	c, _ := NewClient()
+	err = c.Dial("tcp://" + listener.Addr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	rc, ok := c.conn.(*reconnConn)
+	Expect(ok).To(BeTrue())
+	first := rc.current()
+
+	c.Infof("before")
+	Eventually(received).Should(Receive(Equal("before")))
+
+	// Writes can still succeed for a while after the other end closes so
+	// keep sending until one arrives on the new connection
+	first.Close()
+	Eventually(func() bool {
+		c.Infof("after")
+		c.Flush()
+		select {
+		case msg := <-received:
+			return msg == "after"
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second).Should(BeTrue())
+
+	Expect(rc.current()).ToNot(Equal(first))
+	Expect(c.Connected()).To(BeTrue())
+
+	stats := c.Stats()
+	Expect(stats.Reconnects).To(BeNumerically(">=", 1))
+	Expect(stats.LastReconnect).ToNot(BeZero())
+}
+
+func (s *GolfSuite) TestDialReconnectGivesUp(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:            1420,
+		MaxReconnectAttempts: 2,
+	})
+	err = c.Dial("tcp://" + listener.Addr().String())
+	Expect(err).To(BeNil())
+
+	conn, err := listener.Accept()
+	Expect(err).To(BeNil())
+	listener.Close()
+	conn.Close()
+
+	Eventually(func() error {
+		c.Infof("message")
+		select {
+		case err := <-c.Errors():
+			return err
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	}, 5*time.Second).Should(Equal(ErrReconnectFailed))
+
+	Expect(c.Connected()).To(BeFalse())
+	Expect(c.Stats().LastError).To(Equal(ErrReconnectFailed))
+	Expect(c.Stats().Reconnects).To(Equal(uint64(0)))
+	Expect(c.QueueMsg(&Message{ShortMessage: "dropped"})).To(Equal(ErrReconnectFailed))
+	Expect(c.Flush()).To(Equal(ErrNotConnected))
+
+	err = c.Close()
+	Expect(err).To(BeNil())
+	Expect(c.conn).To(BeNil())
+}
+
+func (s *GolfSuite) TestReconnConnStopCancelsDial(t sweet.T) {
+	client, server := net.Pipe()
+	server.Close()
+
+	// A dial that never connects, such as to a server that's gone
+	dialing := make(chan int, 1)
+	dial := func(ctx context.Context) (net.Conn, error) {
+		dialing <- 1
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	rc := newReconnConn(client, dial, 0)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := rc.Write([]byte("message"))
+		writeErr <- err
+	}()
+	Eventually(dialing).Should(Receive())
+
+	// It can be used and closed while it's dialing, which stops the dial
+	Expect(rc.current()).To(Equal(client))
+	closed := make(chan error, 1)
+	go func() {
+		closed <- rc.Close()
+	}()
+	Eventually(closed).Should(Receive(BeNil()))
+	Eventually(writeErr).Should(Receive(Equal(io.ErrClosedPipe)))
+}
+
+func (s *GolfSuite) TestDialRedialsUdp(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := listener.LocalAddr().String()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_NONE,
+	})
+	err = c.Dial("udp://" + addr)
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	buf := make([]byte, 2048)
+	receive := func(listener net.PacketConn) string {
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+
+		var obj map[string]interface{}
+		Expect(json.Unmarshal(buf[12:n], &obj)).To(BeNil())
+		return obj["short_message"].(string)
+	}
+
+	c.Infof("before")
+	Expect(receive(listener)).To(Equal("before"))
+
+	// Writing while the receiver is down makes the next write fail with
+	// the connection being refused, even once it's back
+	listener.Close()
+	c.Infof("while down")
+	c.Flush()
+
+	listener, err = net.ListenPacket("udp", addr)
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c.Infof("after")
+	Expect(receive(listener)).To(Equal("after"))
+	Expect(c.Connected()).To(BeTrue())
+	Expect(c.Stats().Reconnects).To(Equal(uint64(1)))
+}
@@ -0,0 +1,146 @@
+package golf
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A repeatFilter suppresses messages that are the same as the one queued
+// before them, for RepeatWindow
+type repeatFilter struct {
+	mutex  sync.Mutex
+	window time.Duration
+	// The level and short message of the last message let through, and
+	// the last message suppressed since then along with how many were
+	key   string
+	last  *Message
+	count int
+	timer *time.Timer
+	// Incremented each time a new window starts, so a timer for a window
+	// that's already over doesn't end the current one
+	gen int
+}
+
+func newRepeatFilter(window time.Duration) *repeatFilter {
+	return &repeatFilter{window: window}
+}
+
+// Check whether msg repeats the last message and should be suppressed. If it
+// doesn't, a new window starts with it and the summary of the window before,
+// if anything was suppressed in it, is returned to be queued before msg.
+// onEnd is called with the window's generation if it ends before a different
+// message is queued.
+func (rf *repeatFilter) check(msg *Message, onEnd func(gen int)) (bool, *Message) {
+	key := strconv.Itoa(msg.Level) + " " + msg.ShortMessage
+
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.key == key {
+		// Copied since the caller can reuse msg once it's queued
+		rf.last = msg.Clone()
+		rf.count++
+		return true, nil
+	}
+
+	summary := rf.takeSummary()
+	rf.key = key
+	rf.gen++
+	gen := rf.gen
+	if rf.timer != nil {
+		rf.timer.Stop()
+	}
+	rf.timer = time.AfterFunc(rf.window, func() { onEnd(gen) })
+	return false, summary
+}
+
+// End the window with the generation gen if it's still the current one,
+// returning the summary of what was suppressed in it, if anything was
+func (rf *repeatFilter) end(gen int) *Message {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if gen != rf.gen {
+		return nil
+	}
+	rf.key = ""
+	return rf.takeSummary()
+}
+
+// End the current window, returning its summary, and stop its timer
+func (rf *repeatFilter) stop() *Message {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.timer != nil {
+		rf.timer.Stop()
+		rf.timer = nil
+	}
+	rf.gen++
+	rf.key = ""
+	return rf.takeSummary()
+}
+
+// Get a copy of the last message suppressed with SUPPRESSED_COUNT_ATTR set to
+// the number that were, or nil if none were, and start counting again. The
+// mutex must be held.
+func (rf *repeatFilter) takeSummary() *Message {
+	if rf.count == 0 {
+		return nil
+	}
+
+	summary := rf.last
+	summary.AddField(SUPPRESSED_COUNT_ATTR, rf.count)
+	summary.repeatSummary = true
+	rf.last = nil
+	rf.count = 0
+	return summary
+}
+
+// Check whether msg should be suppressed for repeating the last message,
+// counting it as dropped if it is, and get the summary to queue before it if
+// it isn't
+func (c *Client) suppressRepeat(msg *Message) (bool, *Message) {
+	if c.repeats == nil || msg.repeatSummary {
+		return false, nil
+	}
+
+	suppressed, summary := c.repeats.check(msg, c.endRepeats)
+	if suppressed {
+		msg.resolve(ErrMessageDropped)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		c.reportDropped(msg, DROP_REPEATED)
+	}
+	return suppressed, summary
+}
+
+// Queue the summary for a RepeatWindow that's ended without a different
+// message being queued
+func (c *Client) endRepeats(gen int) {
+	summary := c.repeats.end(gen)
+	if summary == nil {
+		return
+	}
+	err := c.queueMsg(summary, 0)
+	if err != nil && err != ErrClosing {
+		c.reportErr(err)
+	}
+}
+
+// Queue the summary for the current RepeatWindow, if anything's been
+// suppressed in it, before the client closes
+func (c *Client) flushRepeats() {
+	if c.repeats == nil {
+		return
+	}
+	summary := c.repeats.stop()
+	if summary == nil {
+		return
+	}
+	err := c.queueMsg(summary, 0)
+	if err != nil && err != ErrClosing {
+		c.reportErr(err)
+	}
+}
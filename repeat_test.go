@@ -0,0 +1,98 @@
+package golf
+
+import (
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// Get the SUPPRESSED_COUNT_ATTR of each message sent to the sink, or 0 for
+// ones that weren't summaries
+func suppressedCounts(sink *testSink) []int {
+	sink.batchesMutex.Lock()
+	defer sink.batchesMutex.Unlock()
+
+	counts := make([]int, 0)
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			msg, _ := ParseMessage(data)
+			count, _ := msg.Attrs[SUPPRESSED_COUNT_ATTR].(float64)
+			counts = append(counts, int(count))
+		}
+	}
+	return counts
+}
+
+func (s *GolfSuite) TestRepeatWindow(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		RepeatWindow: time.Minute,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Errf("failed")
+	c.Errf("failed")
+	c.Errf("failed")
+	c.Infof("failed")
+	c.Infof("different")
+	c.Flush()
+
+	Expect(sink.messages()).To(Equal([]string{"failed", "failed", "failed", "different"}))
+	Expect(suppressedCounts(sink)).To(Equal([]int{0, 2, 0, 0}))
+	Expect(c.Stats().Dropped).To(Equal(uint64(2)))
+
+	summary, _ := ParseMessage(sink.batches[0][1])
+	Expect(summary.Level).To(Equal(LEVEL_ERR))
+}
+
+func (s *GolfSuite) TestRepeatWindowEnds(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		RepeatWindow: 20 * time.Millisecond,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.QueueMsgs([]*Message{
+		{ShortMessage: "looping"},
+		{ShortMessage: "looping"},
+		{ShortMessage: "looping"},
+	})
+	Eventually(sink.messages).Should(HaveLen(2))
+	Expect(suppressedCounts(sink)).To(Equal([]int{0, 2}))
+
+	// The next one starts a new window
+	c.QueueMsg(&Message{ShortMessage: "looping"})
+	Eventually(sink.messages).Should(HaveLen(3))
+	Expect(suppressedCounts(sink)).To(Equal([]int{0, 2, 0}))
+}
+
+func (s *GolfSuite) TestRepeatWindowClose(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		RepeatWindow: time.Minute,
+	})
+	c.UseSink(sink)
+
+	c.Infof("looping")
+	c.Infof("looping")
+	Expect(c.Close()).To(BeNil())
+	Expect(suppressedCounts(sink)).To(Equal([]int{0, 1}))
+}
+
+func (s *GolfSuite) TestRepeatWindowDisabled(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("looping")
+	c.Infof("looping")
+	c.Flush()
+	Expect(sink.messages()).To(HaveLen(2))
+}
@@ -0,0 +1,146 @@
+package golf
+
+import (
+	"io"
+	"time"
+)
+
+// Send a message immediately from the calling goroutine instead of queueing
+// it, returning the result of sending it. It's sent the same way queued
+// messages are, with the same defaults, Transform and stats, but without
+// waiting behind any messages that are already queued, so it can be sent
+// before them. Returns ErrNotConnected if the client isn't connected.
+//
+// Messages sent by a Sink given to UseSink are sent from the calling
+// goroutine at the same time as the queued messages, so the sink must be
+// safe to use concurrently.
+func (c *Client) SendMsg(msg *Message) error {
+	return c.sendMsg(msg, 1, 1)
+}
+
+// Send a message like SendMsg, retrying it up to 'attempts' times in total if
+// it fails to send, with the same delays between attempts as reconnecting a
+// tcp connection. A dialed tcp connection is reconnected by the failed write
+// before it's retried. Returns the error from the last attempt if none of
+// them succeed, or ErrReconnectFailed as soon as the client gives up
+// reconnecting. The message is only serialized once, and FallbackSink and
+// FallbackWriter are only used if the last attempt fails.
+func (c *Client) SendMsgRetry(msg *Message, attempts int) error {
+	return c.sendMsg(msg, attempts, 1)
+}
+
+// Send the message synchronously, where skip is the number of stack frames
+// between sendMsg and the code that sent the message
+func (c *Client) sendMsg(msg *Message, attempts int, skip int) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+	err := c.acquireInFlight()
+	if err != nil {
+		return err
+	}
+	if c.inFlight != nil {
+		defer func() { <-c.inFlight }()
+	}
+
+	c.acceptMutex.RLock()
+	defer c.acceptMutex.RUnlock()
+	err = c.acceptErr()
+	if err != nil {
+		return err
+	}
+
+	var sink Sink
+	c.connMutex.Lock()
+	if (c.conn != nil || c.sink != nil) && len(c.sinks) > 0 {
+		sink = c.sinks[0]
+	}
+	c.connMutex.Unlock()
+	if sink == nil {
+		return ErrNotConnected
+	}
+	if attempts > 1 {
+		sink = &retrySink{sink: sink, attempts: attempts}
+	}
+
+	if c.filteredOut(msg) {
+		return nil
+	}
+	c.setDefaults(msg, c.now())
+	if c.config.IncludeCaller {
+		c.setCaller(msg, skip+1)
+	}
+	if c.config.IncludeGoroutineID {
+		msg.goroutine = goroutineID()
+	}
+
+	result := make(chan error, 1)
+	withResult := *msg
+	withResult.result = result
+	c.sendBatch(sink, []*Message{&withResult})
+	return <-result
+}
+
+// Write a message to w exactly as it would be written to a connection for
+// 'scheme', such as "udp" or "tcp", serialized, compressed and then chunked or
+// framed the same way with the client's current config, so golf's output can
+// be compared byte for byte with a golden file. The message is written
+// straight away, whether or not the client is connected, and isn't counted in
+// the client's Stats. Defaults are set on a copy of it, the message itself
+// isn't changed, but filters like MinLevel and Transform aren't applied.
+// Chunked messages have random ids unless ChunkIDFunc is set, and messages
+// without a Timestamp are sent with the current time, so both need setting
+// for output that's the same every time.
+func (c *Client) WriteMsgTo(w io.Writer, scheme string, msg *Message) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+	transport := schemeTransport(scheme)
+	sndr, err := c.newSender(w, transport, c.ChunkSize())
+	if err != nil {
+		return err
+	}
+	// Nothing written here is counted in the stats
+	sndr.client = nil
+	if chnk, ok := sndr.msgw.(*chunker); ok {
+		chnk.onChunks = nil
+	}
+
+	written := *msg
+	c.setDefaults(&written, c.now())
+	data, err := c.serializer(transport)(&written, c.serializeOptions())
+	if err != nil {
+		return err
+	}
+
+	c.configMutex.RLock()
+	compression := c.config.Compression
+	level := c.config.CompressionLevel
+	c.configMutex.RUnlock()
+	if !c.canCompress(transport) {
+		compression = COMP_NONE
+	}
+	return sndr.writeMsg(data, compression, level)
+}
+
+// A retrySink sends each batch to another Sink, retrying it if it fails
+type retrySink struct {
+	sink     Sink
+	attempts int
+}
+
+func (rs *retrySink) Send(batch [][]byte) error {
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		err := rs.sink.Send(batch)
+		if err == nil || err == ErrReconnectFailed || attempt >= rs.attempts {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package golf
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// A Sink that fails the first 'fail' batches sent to it
+type flakySink struct {
+	testSink
+	fail int
+}
+
+func (fs *flakySink) Send(batch [][]byte) error {
+	fs.batchesMutex.Lock()
+	failed := fs.fail > 0
+	if failed {
+		fs.fail--
+	}
+	fs.batchesMutex.Unlock()
+	if failed {
+		return errors.New("send failed")
+	}
+	return fs.testSink.Send(batch)
+}
+
+func (s *GolfSuite) TestSendMsg(t sweet.T) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	// Sent straight away without waiting for the queue
+	c.Infof("queued")
+	sent := make(chan error)
+	go func() {
+		sent <- c.SendMsg(&Message{ShortMessage: "sent"})
+	}()
+	sink.release <- 1
+	sink.release <- 1
+	Eventually(sent).Should(Receive(BeNil()))
+	// The queued message can still be on its way to the sink
+	Eventually(sink.messages).Should(ConsistOf("queued", "sent"))
+	Eventually(func() uint64 { return c.Stats().Sent }).Should(Equal(uint64(2)))
+}
+
+func (s *GolfSuite) TestSendMsgErrors(t sweet.T) {
+	c, _ := NewClient()
+	Expect(c.SendMsg(nil)).To(Equal(ErrNilMessage))
+	Expect(c.SendMsg(&Message{ShortMessage: "not connected"})).To(Equal(ErrNotConnected))
+
+	sendErr := errors.New("send failed")
+	c.UseSink(&testSink{err: sendErr})
+	defer c.Close()
+	Expect(c.SendMsg(&Message{ShortMessage: "failed"})).To(Equal(sendErr))
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestSendMsgConnection(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClient()
+	err = c.Dial("udp://" + listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(c.SendMsg(&Message{ShortMessage: "sent"})).To(BeNil())
+
+	buf := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	msg, err := ParseMessage(buf[12:n])
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("sent"))
+}
+
+func (s *GolfSuite) TestSendMsgRetry(t sweet.T) {
+	fallback := &testSink{}
+	sink := &flakySink{fail: 2}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		AddSequenceNumbers: true,
+		FallbackSink:       fallback,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	Expect(c.SendMsgRetry(&Message{ShortMessage: "retried"}, 3)).To(BeNil())
+	Expect(sink.messages()).To(Equal([]string{"retried"}))
+	Expect(fallback.messages()).To(HaveLen(0))
+
+	// Serialized once, so it keeps the same sequence number
+	msg, err := ParseMessage(sink.batches[0][0])
+	Expect(err).To(BeNil())
+	Expect(msg.Attrs[SEQUENCE_ATTR]).To(Equal(float64(1)))
+
+	stats := c.Stats()
+	Expect(stats.Sent).To(Equal(uint64(1)))
+	Expect(stats.Failed).To(Equal(uint64(0)))
+}
+
+func (s *GolfSuite) TestSendMsgRetryFails(t sweet.T) {
+	fallback := &testSink{}
+	sink := &flakySink{fail: 2}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		FallbackSink: fallback,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	// The result is from the fallback once every attempt fails
+	err := c.SendMsgRetry(&Message{ShortMessage: "failed"}, 2)
+	Expect(err).To(BeNil())
+	Expect(sink.messages()).To(HaveLen(0))
+	Expect(fallback.messages()).To(Equal([]string{"failed"}))
+	Expect(c.Errors()).To(Receive(WithTransform(sendErrCause, MatchError("send failed"))))
+}
+
+func (s *GolfSuite) TestMaxInFlight(t sweet.T) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		MaxInFlight: 2,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	first := c.QueueMsgWithResult(&Message{ShortMessage: "first"})
+	second := c.QueueMsgWithResult(&Message{ShortMessage: "second"})
+
+	// Blocked until one of the others has been sent
+	third := make(chan (<-chan error))
+	go func() {
+		third <- c.QueueMsgWithResult(&Message{ShortMessage: "third"})
+	}()
+	Consistently(third).ShouldNot(Receive())
+
+	// Fire and forget messages aren't limited
+	Expect(c.QueueMsg(&Message{ShortMessage: "not confirmed"})).To(BeNil())
+
+	close(sink.release)
+	Eventually(first).Should(Receive(BeNil()))
+	Eventually(second).Should(Receive(BeNil()))
+	var result <-chan error
+	Eventually(third).Should(Receive(&result))
+	Eventually(result).Should(Receive(BeNil()))
+}
+
+func (s *GolfSuite) TestMaxInFlightWait(t sweet.T) {
+	sink := &blockingSink{release: make(chan int)}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		MaxInFlight:     1,
+		MaxInFlightWait: 10 * time.Millisecond,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+	defer close(sink.release)
+
+	c.QueueMsgWithResult(&Message{ShortMessage: "first"})
+	Expect(c.QueueMsgWithResult(&Message{ShortMessage: "second"})).To(Receive(Equal(ErrTooManyInFlight)))
+	Expect(c.SendMsg(&Message{ShortMessage: "third"})).To(Equal(ErrTooManyInFlight))
+}
+
+func (s *GolfSuite) TestWriteMsgTo(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_NONE,
+	})
+	ts := time.Unix(1500000000, 0)
+	msg := &Message{Hostname: "host", Level: LEVEL_WARN, ShortMessage: "golden", Timestamp: &ts}
+
+	buf := &bytes.Buffer{}
+	Expect(c.WriteMsgTo(buf, "tcp", msg)).To(BeNil())
+	Expect(buf.String()).To(Equal(
+		`{"host":"host","level":4,"short_message":"golden","timestamp":1500000000.000000,"version":"1.1"}` + "\x00"))
+
+	// Defaults are set on a copy
+	noTime := &Message{ShortMessage: "no time"}
+	Expect(c.WriteMsgTo(buf, "tcp", noTime)).To(BeNil())
+	Expect(noTime.Timestamp).To(BeNil())
+	Expect(c.Stats().Sent).To(BeZero())
+	Expect(c.WriteMsgTo(buf, "http", msg)).To(Equal(ErrUnsupportedScheme))
+	Expect(c.WriteMsgTo(buf, "tcp", nil)).To(Equal(ErrNilMessage))
+}
+
+func (s *GolfSuite) TestWriteMsgToChunked(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   900,
+		Compression: COMP_NONE,
+		ChunkIDFunc: func() [8]byte { return [8]byte{1, 2, 3, 4, 5, 6, 7, 8} },
+	})
+	ts := time.Unix(1500000000, 0)
+	msg := &Message{ShortMessage: "golden", Timestamp: &ts}
+	msg.AddField("random", strings.Repeat("x", 4000))
+
+	first := &bytes.Buffer{}
+	Expect(c.WriteMsgTo(first, "udp", msg)).To(BeNil())
+	Expect(first.Bytes()[:2]).To(Equal([]byte{0x1e, 0x0f}))
+	Expect(first.Bytes()[2:10]).To(Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+
+	// The same message is always written the same way
+	second := &bytes.Buffer{}
+	Expect(c.WriteMsgTo(second, "udp", msg)).To(BeNil())
+	Expect(second.Bytes()).To(Equal(first.Bytes()))
+	Expect(c.Stats().ChunkCounts).To(BeEmpty())
+}
@@ -0,0 +1,815 @@
+package golf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A msgWriter buffers the data for a single message and writes it to the
+// connection, framed for the transport being used, when flushed.
+type msgWriter interface {
+	io.Writer
+	Flush() error
+	reset()
+}
+
+// A sender is the Sink used for messages sent to a connection. Each message
+// in a batch is compressed and written to the connection separately. Each
+// sender has its own msgWriter and compression writers so any number of
+// senders can write to the same connection at once. Messages written to the
+// same sender from multiple goroutines are written one at a time so their
+// data is never mixed together.
+type sender struct {
+	// UnixNano time a message or probe was last written
+	lastWrite int64
+
+	client     *Client
+	writeMutex sync.Mutex
+	msgw       msgWriter
+	// Set once the sender has warned about falling back to another
+	// compression level
+	warnedFallback bool
+
+	gz *writerCache
+	zz *writerCache
+	// Counts the compressed bytes written to msgw by gz and zz
+	compressed *countingWriter
+	// The message being written, for the client's OnChunking
+	chunkMsg *Message
+	// Set to pick the compression for each message from the client's
+	// CompressionBuckets
+	bucketed bool
+}
+
+// How long writeMsg spent compressing a message and writing it
+type writeTiming struct {
+	compress time.Duration
+	write    time.Duration
+}
+
+// A countingWriter counts the bytes written through it to w
+type countingWriter struct {
+	w       io.Writer
+	written int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.written += n
+	return n, err
+}
+
+func (c *Client) newSender(w io.Writer, scheme string, chunkSize int) (*sender, error) {
+	var msgw msgWriter
+	switch scheme {
+	case "udp":
+		chnk, err := newChunker(w, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		chnk.idFunc = c.config.ChunkIDFunc
+		chnk.rand = c.randSource
+		chnk.reportErr = c.reportErr
+		chnk.onChunks = c.stats.chunked
+		msgw = chnk
+	case "tcp":
+		switch c.config.TCPFraming {
+		case TCP_FRAME_LENGTH:
+			msgw = newLengthFramer(w)
+		case TCP_FRAME_CHECKSUM:
+			msgw = newChecksumFramer(w)
+		default:
+			frmr := newFramer(w)
+			frmr.delimiter = c.config.TCPDelimiter
+			msgw = frmr
+		}
+	case "syslog":
+		msgw = newDatagramWriter(w)
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+
+	s := newSenderForWriter(msgw)
+	s.client = c
+	s.bucketed = len(c.config.CompressionBuckets) > 0 && c.canCompress(scheme)
+	if chnk, ok := msgw.(*chunker); ok {
+		chnk.checkChunks = s.checkChunks
+	}
+	if c.config.PrewarmCompression {
+		s.prewarm()
+	}
+	return s, nil
+}
+
+// Change the chunk size of the sender's chunker, if it has one, once it's
+// finished writing the current message
+func (s *sender) setChunkSize(chunkSize int) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if chnk, ok := s.msgw.(*chunker); ok {
+		chnk.setChunkSize(chunkSize)
+	}
+}
+
+// Create the compression writer for the client's compression and level and
+// put it in the cache, ready for the first message
+func (s *sender) prewarm() {
+	s.client.configMutex.RLock()
+	compression := s.client.config.Compression
+	level := s.client.config.CompressionLevel
+	s.client.configMutex.RUnlock()
+
+	var cache *writerCache
+	switch compression {
+	case COMP_GZIP, COMP_AUTO:
+		cache = s.gz
+	case COMP_ZLIB:
+		cache = s.zz
+	default:
+		return
+	}
+
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	cw := cache.Get(level)
+	if cw == nil {
+		// Invalid levels fall back to the default when they're used
+		level = gzip.DefaultCompression
+		cw = cache.Get(level)
+	}
+	cache.Put(level, cw)
+}
+
+func newSenderForWriter(msgw msgWriter) *sender {
+	compressed := &countingWriter{w: msgw}
+	s := &sender{
+		lastWrite:  time.Now().UnixNano(),
+		msgw:       msgw,
+		gz:         newGzipCache(compressed),
+		zz:         newZlibCache(compressed),
+		compressed: compressed,
+	}
+	return s
+}
+
+// A msgWriter that can write a frame without a message in it
+type emptyFrameWriter interface {
+	writeEmpty() error
+}
+
+// Write an empty frame if nothing has been written for at least idle,
+// returning whether one was written. Nothing's written if the sender's
+// msgWriter can't write empty frames.
+func (s *sender) probe(idle time.Duration) (bool, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	efw, ok := s.msgw.(emptyFrameWriter)
+	if !ok {
+		return false, nil
+	}
+	now := time.Now()
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastWrite))) < idle {
+		return false, nil
+	}
+
+	atomic.StoreInt64(&s.lastWrite, now.UnixNano())
+	return true, efw.writeEmpty()
+}
+
+// Send batches of up to batchSize messages from the queue to the sink until
+// the client is closed, or until stop is closed
+func (c *Client) msgSender(sink Sink, batchSize int, stop chan int) {
+	defer c.senderWg.Done()
+
+	for {
+		if c.hasFailed() {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		c.queueMutex.Lock()
+		if len(c.queue) > 0 && !c.paused {
+			count := len(c.queue)
+			if count > batchSize {
+				count = batchSize
+			}
+			msgs := make([]*Message, count)
+			copy(msgs, c.queue)
+			c.queue = c.queue[count:]
+			more := len(c.queue) > 0
+			c.queueMutex.Unlock()
+
+			// Let any other senders know there's more to send
+			if more {
+				c.signalQueue()
+			}
+
+			c.sendBatch(sink, msgs)
+
+			c.queueMutex.Lock()
+			c.pending -= len(msgs)
+			c.crossedHighWater()
+			c.sentCond.Broadcast()
+			c.queueMutex.Unlock()
+			continue
+		}
+		// Send anything that overflowed once everything in memory has
+		// been sent
+		idle := c.pending == 0 && !c.paused
+		c.queueMutex.Unlock()
+		if idle && c.overflow != nil && !c.overflow.empty() {
+			c.refillFromOverflow()
+			continue
+		}
+
+		select {
+		case <-c.queueSignal:
+		case <-stop:
+			return
+		case <-c.senderQuit:
+			// Only quit once the queue is empty, otherwise keep
+			// sending until it is
+			c.queueMutex.Lock()
+			empty := len(c.queue) == 0
+			c.queueMutex.Unlock()
+			if empty {
+				return
+			}
+		}
+	}
+}
+
+// Serialize a batch of messages from the queue and send them to the sink,
+// reporting any errors. A panic while sending is recovered and reported so
+// the batch is dropped instead of the sender being stopped. The result of
+// sending each message is sent to its result channel, if it has one.
+func (c *Client) sendBatch(sink Sink, msgs []*Message) {
+	// Number of messages that have been counted in the stats, so any that
+	// haven't can be counted as failed after a panic
+	counted := 0
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic while sending message: %v", r)
+			c.reportErr(err)
+			for _, msg := range msgs {
+				msg.resolve(err)
+			}
+			atomic.AddUint64(&c.stats.failed, uint64(len(msgs)-counted))
+		}
+	}()
+
+	opts := c.serializeOptions()
+	serialize := c.serializer(c.scheme)
+	// Stored messages can be sent as they are if nothing would change them
+	sendStored := c.config.Transform == nil && c.scheme != "syslog" &&
+		c.config.Serializer == nil && !c.config.AddSequenceNumbers
+	batch := make([][]byte, 0, len(msgs))
+	batchMsgs := make([]*Message, 0, len(msgs))
+	// The messages after Transform, for DeliverChan
+	sendMsgs := make([]*Message, 0, len(msgs))
+	// How long each message took to serialize, for OnSendTiming
+	timing := c.config.OnSendTiming != nil
+	var serializeTimes []time.Duration
+	now := c.now()
+	for _, msg := range msgs {
+		if c.expired(msg, now) {
+			msg.resolve(ErrMessageExpired)
+			atomic.AddUint64(&c.stats.expired, 1)
+			atomic.AddUint64(&c.stats.dropped, 1)
+			c.reportDropped(msg, DROP_EXPIRED)
+			counted++
+			continue
+		}
+
+		sendMsg := msg
+		if c.config.Transform != nil {
+			sendMsg = c.config.Transform(msg)
+			if sendMsg == nil {
+				msg.resolve(ErrMessageDropped)
+				atomic.AddUint64(&c.stats.dropped, 1)
+				c.reportDropped(msg, DROP_TRANSFORM)
+				counted++
+				continue
+			}
+		}
+
+		if c.config.AddSequenceNumbers {
+			sendMsg.seq = atomic.AddUint64(&c.stats.seq, 1)
+		}
+
+		var serializeStart, serialized time.Time
+		if timing {
+			serializeStart = time.Now()
+		}
+		var data []byte
+		var err error
+		if msg.stored != nil && sendStored {
+			data = msg.stored
+		} else {
+			msgOpts := msgSerializeOptions(sendMsg, opts)
+			data, err = serialize(sendMsg, msgOpts)
+			for attempt := 1; err != nil && attempt < c.config.SerializeAttempts; attempt++ {
+				data, err = serialize(sendMsg, msgOpts)
+			}
+		}
+		if timing {
+			serialized = time.Now()
+		}
+		if err != nil {
+			c.reportErr(&SendError{Msg: msg.Clone(), Op: SEND_OP_SERIALIZE, Err: err})
+			msg.resolve(err)
+			// It would fail the same way if it was recovered
+			c.removeFromWAL(msg)
+			atomic.AddUint64(&c.stats.failed, 1)
+			counted++
+			continue
+		}
+		if c.limiter != nil && !c.throttle(len(data)) {
+			msg.resolve(ErrThrottled)
+			atomic.AddUint64(&c.stats.dropped, 1)
+			c.reportDropped(msg, DROP_THROTTLED)
+			counted++
+			continue
+		}
+		batch = append(batch, data)
+		batchMsgs = append(batchMsgs, msg)
+		sendMsgs = append(sendMsgs, sendMsg)
+		if timing {
+			serializeTimes = append(serializeTimes, serialized.Sub(serializeStart))
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	var sendStart time.Time
+	if timing {
+		sendStart = time.Now()
+	}
+	// How long the sender took compressing and writing each message,
+	// for OnSendTiming
+	var writeTimings []writeTiming
+	if timing {
+		writeTimings = make([]writeTiming, len(batch))
+	}
+	var err error
+	if sndr, ok := sink.(*sender); ok {
+		err = sndr.sendMsgs(batch, sendMsgs, writeTimings)
+	} else {
+		err = sink.Send(batch)
+	}
+	if err == errChunkingDrop || err == errChunkingFallback {
+		// Messages are sent to udp connections one at a time, so it's
+		// the only message in the batch
+		c.declineChunking(batch, batchMsgs, err)
+		return
+	}
+	op := SEND_OP_WRITE
+	if sendErr, ok := err.(*SendError); ok {
+		// The sender's own errors say whether compressing or writing
+		// the message failed
+		op = sendErr.Op
+		err = sendErr.Err
+	}
+	if timing {
+		c.reportTimings(sink, sendMsgs, serializeTimes, writeTimings, time.Since(sendStart), err)
+	}
+	if c.config.DeliverChan != nil {
+		for idx, data := range batch {
+			c.deliver(sendMsgs[idx], data, opts)
+		}
+	}
+	if err == ErrReconnectFailed {
+		c.fail(err)
+	} else if err != nil {
+		for _, msg := range batchMsgs {
+			c.reportErr(&SendError{Msg: msg.Clone(), Op: op, Err: err})
+		}
+	}
+	if err != nil && c.config.FallbackSink != nil {
+		err = c.config.FallbackSink.Send(batch)
+		if err != nil {
+			c.reportErr(err)
+		}
+	}
+	exporting := err != nil && c.config.CloseExportWriter != nil && c.isClosing()
+	for idx, msg := range batchMsgs {
+		msg.resolve(err)
+		if exporting {
+			data, gelfErr := c.gelfData(sendMsgs[idx], batch[idx], opts)
+			if gelfErr == nil {
+				c.exportMsg(data)
+			}
+		}
+		if err != nil && c.config.FallbackWriter != nil {
+			// Write what would've been sent, after Transform
+			failed, parseErr := ParseMessage(batch[idx])
+			if parseErr == nil {
+				c.writeFallback(failed)
+			}
+		}
+	}
+	if err == nil {
+		if c.recent != nil {
+			c.recent.add(batch)
+		}
+		atomic.AddUint64(&c.stats.sent, uint64(len(batch)))
+		if c.wal != nil {
+			for _, msg := range batchMsgs {
+				c.removeFromWAL(msg)
+			}
+		}
+		if c.config.OnBatchSent != nil {
+			c.confirmBatch(batchMsgs)
+		}
+	} else {
+		atomic.AddUint64(&c.stats.failed, uint64(len(batch)))
+	}
+}
+
+// Pass the IDs of a batch of messages that's been sent to OnBatchSent
+func (c *Client) confirmBatch(msgs []*Message) {
+	ids := make([]uint64, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.id != 0 {
+			ids = append(ids, msg.id)
+		}
+	}
+	if len(ids) > 0 {
+		c.config.OnBatchSent(ids)
+	}
+}
+
+// Drop the batch, or send it to the FallbackSink, when OnChunking decided not
+// to send it to the server. It's dropped if there's no FallbackSink.
+func (c *Client) declineChunking(batch [][]byte, msgs []*Message, decision error) {
+	if decision == errChunkingFallback && c.config.FallbackSink != nil {
+		err := c.config.FallbackSink.Send(batch)
+		if err != nil {
+			c.reportErr(err)
+			atomic.AddUint64(&c.stats.failed, uint64(len(batch)))
+		} else {
+			atomic.AddUint64(&c.stats.sent, uint64(len(batch)))
+		}
+		for _, msg := range msgs {
+			msg.resolve(err)
+		}
+		return
+	}
+
+	atomic.AddUint64(&c.stats.dropped, uint64(len(msgs)))
+	for _, msg := range msgs {
+		msg.resolve(ErrMessageDropped)
+		c.reportDropped(msg, DROP_CHUNKED)
+	}
+}
+
+// Deliver a message that was sent as data to the DeliverChan, without waiting
+// if the channel is full
+func (c *Client) deliver(msg *Message, data []byte, opts serializeOptions) {
+	data, err := c.gelfData(msg, data, opts)
+	if err != nil {
+		return
+	}
+	delivered, err := ParseMessage(data)
+	if err != nil {
+		return
+	}
+
+	select {
+	case c.config.DeliverChan <- delivered:
+	default:
+		atomic.AddUint64(&c.stats.deliverDropped, 1)
+	}
+}
+
+// Pass how long each message in a batch took to send to OnSendTiming. The
+// compress and write times come from the sender if the sink is one, otherwise
+// the time sending the batch took is used as the write time.
+func (c *Client) reportTimings(sink Sink, msgs []*Message, serializeTimes []time.Duration, writeTimings []writeTiming, sendTime time.Duration, err error) {
+	_, isSender := sink.(*sender)
+	for idx, msg := range msgs {
+		timing := SendTiming{
+			Msg:       msg,
+			Serialize: serializeTimes[idx],
+			Write:     sendTime,
+			Err:       err,
+		}
+		if isSender {
+			timing.Compress = writeTimings[idx].compress
+			timing.Write = writeTimings[idx].write
+		}
+		c.config.OnSendTiming(timing)
+	}
+}
+
+// Get the function messages are serialized with for sending over transport
+func (c *Client) serializer(transport string) func(*Message, serializeOptions) ([]byte, error) {
+	if transport == "syslog" {
+		return serializeSyslog
+	} else if c.config.Serializer != nil {
+		return func(msg *Message, opts serializeOptions) ([]byte, error) {
+			return serializeWith(c.config.Serializer, msg, opts)
+		}
+	}
+	return serializeMsg
+}
+
+// Get the GELF for msg, given the data it was serialized to for sending
+func (c *Client) gelfData(msg *Message, data []byte, opts serializeOptions) ([]byte, error) {
+	if c.scheme == "syslog" || c.config.Serializer != nil {
+		// It wasn't sent as GELF
+		return generateMsgJson(msg, msgSerializeOptions(msg, opts))
+	}
+	return data, nil
+}
+
+// Get the options to serialize msg with, leaving out the filters, defaults
+// and limits that were already applied to a stored message
+func msgSerializeOptions(msg *Message, opts serializeOptions) serializeOptions {
+	if msg.stored == nil {
+		return opts
+	}
+	return serializeOptions{
+		unencodable: opts.unencodable,
+		reportErr:   opts.reportErr,
+	}
+}
+
+// Write the GELF for a message that failed to send while closing to the
+// CloseExportWriter as a line of its own
+func (c *Client) exportMsg(data []byte) {
+	line := make([]byte, len(data), len(data)+1)
+	copy(line, data)
+	line = append(line, '\n')
+
+	c.exportMutex.Lock()
+	defer c.exportMutex.Unlock()
+	_, err := c.config.CloseExportWriter.Write(line)
+	if err != nil {
+		c.reportErr(err)
+		return
+	}
+	atomic.AddUint64(&c.stats.exported, 1)
+}
+
+// Wait until size bytes can be sent without going over BytesPerSecond, or
+// with DropThrottled check if they can be sent now, returning false if the
+// message should be dropped instead
+func (c *Client) throttle(size int) bool {
+	now := time.Now()
+	if c.config.DropThrottled {
+		if c.limiter.allow(size, now) {
+			return true
+		}
+		atomic.AddUint64(&c.stats.throttled, 1)
+		return false
+	}
+
+	wait := c.limiter.reserve(size, now)
+	if wait > 0 {
+		atomic.AddUint64(&c.stats.throttled, 1)
+		atomic.AddUint64(&c.stats.throttleWait, uint64(wait))
+		time.Sleep(wait)
+	}
+	return true
+}
+
+// Check if msg is older than its max age, or the client's MaxAge if it doesn't
+// have one
+func (c *Client) expired(msg *Message, now time.Time) bool {
+	maxAge := msg.maxAge
+	if maxAge == 0 {
+		maxAge = c.config.MaxAge
+	}
+	return maxAge > 0 && msg.Timestamp != nil && now.Sub(*msg.Timestamp) > maxAge
+}
+
+// Compress and write each message in the batch to the connection, returning
+// the first error encountered
+func (s *sender) Send(batch [][]byte) error {
+	return s.sendMsgs(batch, nil, nil)
+}
+
+// Send a batch like Send, where msgs are the messages the batch was
+// serialized from, for OnChunking. If timings isn't nil it has room for
+// each message in the batch and is filled in with how long each one took.
+func (s *sender) sendMsgs(batch [][]byte, msgs []*Message, timings []writeTiming) error {
+	s.client.configMutex.RLock()
+	compression := s.client.config.Compression
+	level := s.client.config.CompressionLevel
+	s.client.configMutex.RUnlock()
+
+	var firstErr error
+	for idx, data := range batch {
+		var msg *Message
+		if idx < len(msgs) {
+			msg = msgs[idx]
+		}
+		bucket, msgCompression := -1, compression
+		if s.bucketed {
+			bucket, msgCompression = s.client.compressionBucket(len(data), compression)
+		}
+		var timing *writeTiming
+		if timings != nil {
+			timing = &timings[idx]
+		}
+		err := s.writeMsgFor(data, msg, msgCompression, level, timing)
+		if err == nil && bucket >= 0 {
+			atomic.AddUint64(&s.client.stats.sentByBucket[bucket], 1)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Compress data and write it to the connection as a single message. Data
+// that isn't compressed is written as it is, without being copied.
+func (s *sender) writeMsg(data []byte, compression int, level int) error {
+	return s.writeMsgFor(data, nil, compression, level, nil)
+}
+
+// Write data like writeMsg, where msg is the message it was serialized from
+// if it's known, setting timing to how long it took if it isn't nil
+func (s *sender) writeMsgFor(data []byte, msg *Message, compression int, level int, timing *writeTiming) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.chunkMsg = msg
+	defer func() { s.chunkMsg = nil }()
+	start := time.Now()
+	atomic.StoreInt64(&s.lastWrite, start.UnixNano())
+
+	defer func() {
+		// Don't leave part of the message behind to be sent with the
+		// next one
+		if r := recover(); r != nil {
+			s.msgw.reset()
+			panic(r)
+		}
+	}()
+
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	// Size of the data after it's compressed, if it is, and the
+	// compression it was actually sent with
+	compressedSize := -1
+	s.compressed.written = 0
+	used := COMP_NONE
+
+	var err error
+	switch compression {
+	case COMP_GZIP:
+		var gz compressWriter
+		gz, level, err = s.getWriter(s.gz, level)
+		if err != nil {
+			return compressErr(err)
+		}
+		_, err = gz.Write(data)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		s.gz.Put(level, gz)
+		if err != nil {
+			err = compressErr(err)
+		}
+		compressedSize = s.compressed.written
+		used = COMP_GZIP
+	case COMP_ZLIB:
+		var zz compressWriter
+		zz, level, err = s.getWriter(s.zz, level)
+		if err != nil {
+			return compressErr(err)
+		}
+		_, err = zz.Write(data)
+		if closeErr := zz.Close(); err == nil {
+			err = closeErr
+		}
+		s.zz.Put(level, zz)
+		if err != nil {
+			err = compressErr(err)
+		}
+		compressedSize = s.compressed.written
+		used = COMP_ZLIB
+	case COMP_AUTO:
+		var gz compressWriter
+		gz, level, err = s.getWriter(s.gz, level)
+		if err != nil {
+			return compressErr(err)
+		}
+		buf := &bytes.Buffer{}
+		gz.Reset(buf)
+		_, err = gz.Write(data)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		s.gz.Put(level, gz)
+		if err != nil {
+			err = compressErr(err)
+		}
+
+		// The server detects compression from the data itself so
+		// whichever is smaller can be sent
+		if err == nil {
+			if buf.Len() < len(data) {
+				_, err = s.msgw.Write(buf.Bytes())
+				compressedSize = buf.Len()
+				used = COMP_GZIP
+			} else {
+				_, err = s.msgw.Write(data)
+				compressedSize = len(data)
+			}
+		}
+	default:
+		_, err = s.msgw.Write(data)
+	}
+
+	if err == nil && compressedSize >= 0 && s.client != nil {
+		atomic.AddUint64(&s.client.stats.uncompressed, uint64(len(data)))
+		atomic.AddUint64(&s.client.stats.compressed, uint64(compressedSize))
+	}
+
+	var compressed time.Time
+	if timing != nil {
+		compressed = time.Now()
+	}
+	flushErr := s.msgw.Flush()
+	if timing != nil {
+		*timing = writeTiming{
+			compress: compressed.Sub(start),
+			write:    time.Since(compressed),
+		}
+	}
+	if _, ok := flushErr.(*PartialSendError); ok && s.client != nil {
+		atomic.AddUint64(&s.client.stats.partial, 1)
+	}
+	if err != nil {
+		return err
+	}
+	if flushErr == nil && s.client != nil {
+		atomic.AddUint64(&s.client.stats.sentByCompression[used], 1)
+	}
+	return flushErr
+}
+
+// Ask the client's OnChunking what to do with the message being written now
+// that it needs to be split into count chunks, returning errChunkingDrop or
+// errChunkingFallback if it shouldn't be written. The write mutex must be
+// held.
+func (s *sender) checkChunks(count int) error {
+	if s.client == nil || s.client.config.OnChunking == nil || s.chunkMsg == nil {
+		return nil
+	}
+
+	switch s.client.config.OnChunking(s.chunkMsg, count) {
+	case CHUNKING_DROP:
+		return errChunkingDrop
+	case CHUNKING_FALLBACK:
+		return errChunkingFallback
+	}
+	return nil
+}
+
+// Wrap an error compressing a message so sendBatch reports it as one
+func compressErr(err error) error {
+	return &SendError{Op: SEND_OP_COMPRESS, Err: err}
+}
+
+// Get a compression writer for the level from the cache. If the level isn't valid
+// the default level is used instead, and ErrCompressionFallback is reported
+// the first time it happens so the fallback isn't a surprise. Returns
+// ErrCompressionLevel instead of falling back in StrictMode. The level of the
+// writer returned is the one it should be put back to the cache with.
+func (s *sender) getWriter(cache *writerCache, level int) (compressWriter, int, error) {
+	cw := cache.Get(level)
+	if cw != nil {
+		return cw, level, nil
+	}
+
+	if s.client != nil {
+		if s.client.config.StrictMode {
+			return nil, level, ErrCompressionLevel
+		}
+		if !s.warnedFallback {
+			s.client.reportErr(ErrCompressionFallback)
+		}
+	}
+	s.warnedFallback = true
+
+	return cache.Get(gzip.DefaultCompression), gzip.DefaultCompression, nil
+}
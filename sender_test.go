@@ -0,0 +1,762 @@
+package golf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestSenderConcurrency(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		Compression:       COMP_NONE,
+		SenderConcurrency: 4,
+	})
+	err = c.Use(conn, "udp")
+	Expect(err).To(BeNil())
+	Expect(c.sinks).To(HaveLen(4))
+
+	for idx := 0; idx < 100; idx++ {
+		c.Infof("message %d", idx)
+	}
+
+	received := make(map[string]bool, 0)
+	buf := make([]byte, 2048)
+	for idx := 0; idx < 100; idx++ {
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+
+		msg, err := ParseMessage(buf[12:n])
+		Expect(err).To(BeNil())
+		received[msg.ShortMessage] = true
+	}
+	Expect(received).To(HaveLen(100))
+
+	err = c.Close()
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestSenderConcurrencyTcp(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		SenderConcurrency: 4,
+	})
+
+	client, _ := net.Pipe()
+	err := c.Use(client, "tcp")
+	Expect(err).To(BeNil())
+	defer c.Close()
+
+	Expect(c.sinks).To(HaveLen(1))
+}
+
+func (s *GolfSuite) TestSenderPrewarmCompression(t sweet.T) {
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		Compression:        COMP_ZLIB,
+		CompressionLevel:   3,
+		SenderConcurrency:  2,
+		PrewarmCompression: true,
+	})
+	Expect(c.Use(conn, "udp")).To(BeNil())
+	defer c.Close()
+
+	for _, sink := range c.sinks {
+		sndr := sink.(*sender)
+		Expect(sndr.zz.writers).To(HaveKey(3))
+		Expect(sndr.gz.writers).To(BeEmpty())
+	}
+}
+
+func (s *GolfSuite) TestSenderNoPrewarmCompression(t sweet.T) {
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+	})
+	Expect(c.Use(conn, "udp")).To(BeNil())
+	defer c.Close()
+
+	Expect(c.sinks[0].(*sender).gz.writers).To(BeEmpty())
+}
+
+func BenchmarkSenderConcurrency(b *testing.B) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			_, _, err := listener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("senders-%d", concurrency), func(b *testing.B) {
+			c, _ := NewClientWithConfig(ClientConfig{
+				ChunkSize:         1420,
+				Compression:       COMP_GZIP,
+				SenderConcurrency: concurrency,
+			})
+
+			msgs := make([]*Message, b.N)
+			for idx := range msgs {
+				msgs[idx] = c.genMsg(LEVEL_INFO, "benchmark message %d", idx)
+				msgs[idx].Attrs["attr1"] = "val1"
+			}
+			c.QueueMsgs(msgs)
+
+			conn, _ := net.Dial("udp", listener.LocalAddr().String())
+
+			b.ResetTimer()
+			c.Use(conn, "udp")
+			c.Close()
+		})
+	}
+}
+
+func (s *GolfSuite) TestSenderTransform(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		Transform: func(msg *Message) *Message {
+			if msg.ShortMessage == "drop" {
+				return nil
+			}
+			if _, ok := msg.Attrs["token"]; ok {
+				msg.Attrs["token"] = "REDACTED"
+			}
+			return msg
+		},
+	})
+
+	client, server := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	secret := newMessage()
+	secret.ShortMessage = "secret"
+	secret.Attrs["token"] = "abc123"
+	dropped := newMessage()
+	dropped.ShortMessage = "drop"
+	kept := newMessage()
+	kept.ShortMessage = "kept"
+	c.QueueMsgs([]*Message{secret, dropped, kept})
+
+	r := bufio.NewReader(server)
+	data, err := r.ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"_token":"REDACTED"`))
+	Expect(string(data)).ToNot(ContainSubstring("abc123"))
+
+	data, err = r.ReadBytes(0)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(ContainSubstring(`"short_message":"kept"`))
+}
+
+// An io.Writer that records each write, safe for concurrent use. It yields
+// after each write to give other goroutines a chance to run mid-message.
+type lockedWriter struct {
+	writtenMutex sync.Mutex
+	written      [][]byte
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.writtenMutex.Lock()
+	defer lw.writtenMutex.Unlock()
+
+	data := make([]byte, len(p))
+	copy(data, p)
+	lw.written = append(lw.written, data)
+	runtime.Gosched()
+	return len(p), nil
+}
+
+func (s *GolfSuite) TestSenderWriteMsgConcurrent(t sweet.T) {
+	lw := &lockedWriter{}
+	chnk, err := newChunker(lw, 32)
+	Expect(err).To(BeNil())
+	sndr := newSenderForWriter(chnk)
+
+	var wg sync.WaitGroup
+	expected := make(map[string]bool)
+	for g := 0; g < 20; g++ {
+		for idx := 0; idx < 50; idx++ {
+			expected[fmt.Sprintf("goroutine %d sending message number %d", g, idx)] = true
+		}
+
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for idx := 0; idx < 50; idx++ {
+				msg := fmt.Sprintf("goroutine %d sending message number %d", g, idx)
+				sndr.writeMsg([]byte(msg), COMP_NONE, 0)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Reassemble the chunks for each message id in the order they were
+	// written and make sure none of them were mixed with another message
+	msgs := make(map[string][]byte)
+	for _, chunk := range lw.written {
+		Expect(chunk[0:2]).To(Equal([]byte{0x1e, 0x0f}))
+		id := string(chunk[2:10])
+		msgs[id] = append(msgs[id], chunk[12:]...)
+	}
+	Expect(msgs).To(HaveLen(len(expected)))
+	for _, data := range msgs {
+		Expect(expected).To(HaveKey(string(data)))
+		delete(expected, string(data))
+	}
+}
+
+// An io.Writer that discards everything written to it
+type discardWriter struct{}
+
+func (dw discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func BenchmarkSenderWriteMsgNoCompression(b *testing.B) {
+	chnk, _ := newChunker(discardWriter{}, 1420)
+	sndr := newSenderForWriter(chnk)
+
+	msg := newMessage()
+	msg.Hostname = "hostname"
+	msg.ShortMessage = strings.Repeat("benchmark message ", 20)
+	msg.Attrs["attr1"] = "val1"
+	ts := time.Now()
+	msg.Timestamp = &ts
+	data, _ := serializeMsg(msg, serializeOptions{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		sndr.writeMsg(data, COMP_NONE, 0)
+	}
+}
+
+func (s *GolfSuite) TestSenderSequenceNumbers(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		AddSequenceNumbers: true,
+		Transform: func(msg *Message) *Message {
+			if msg.ShortMessage == "dropped" {
+				return nil
+			}
+			return msg
+		},
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("first")
+	c.Infof("dropped")
+	c.Infof("second")
+	c.Infof("third")
+	c.Flush()
+
+	seqs := make([]float64, 0)
+	for _, batch := range sink.batches {
+		for _, data := range batch {
+			msg, err := ParseMessage(data)
+			Expect(err).To(BeNil())
+			seqs = append(seqs, msg.Attrs[SEQUENCE_ATTR].(float64))
+		}
+	}
+	Expect(seqs).To(Equal([]float64{1, 2, 3}))
+}
+
+func (s *GolfSuite) TestSenderNoSequenceNumbers(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(string(sink.batches[0][0])).ToNot(ContainSubstring(`"_seq"`))
+}
+
+func BenchmarkSenderWriteMsgGzip(b *testing.B) {
+	msg := newMessage()
+	msg.Hostname = "hostname"
+	msg.ShortMessage = strings.Repeat("benchmark message ", 20)
+	msg.Attrs["attr1"] = "val1"
+	ts := time.Now()
+	msg.Timestamp = &ts
+	data, _ := serializeMsg(msg, serializeOptions{})
+
+	// Each goroutine has its own sender, like with SenderConcurrency
+	b.ReportAllocs()
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		chnk, _ := newChunker(discardWriter{}, 1420)
+		sndr := newSenderForWriter(chnk)
+		for pb.Next() {
+			sndr.writeMsg(data, COMP_GZIP, 0)
+		}
+	})
+}
+
+func (s *GolfSuite) TestSenderMaxAge(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		MaxAge:    time.Minute,
+	})
+
+	old := time.Now().Add(-time.Hour)
+	stale := &Message{ShortMessage: "stale", Timestamp: &old}
+	kept := (&Message{ShortMessage: "kept own max age", Timestamp: &old}).SetMaxAge(2 * time.Hour)
+	recent := time.Now().Add(-time.Second)
+	short := (&Message{ShortMessage: "short max age", Timestamp: &recent}).SetMaxAge(time.Millisecond)
+	result := c.QueueMsgWithResult(stale)
+	c.QueueMsgs([]*Message{kept, short, {ShortMessage: "fresh"}})
+
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+
+	Expect(result).To(Receive(Equal(ErrMessageExpired)))
+	Expect(sink.messages()).To(ConsistOf("kept own max age", "fresh"))
+	stats := c.Stats()
+	Expect(stats.Expired).To(Equal(uint64(2)))
+	Expect(stats.Dropped).To(Equal(uint64(2)))
+	Expect(stats.Sent).To(Equal(uint64(2)))
+}
+
+func (s *GolfSuite) TestSenderBytesPerSecond(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		BytesPerSecond: 2000,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	msgs := make([]*Message, 0)
+	for idx := 0; idx < 4; idx++ {
+		msgs = append(msgs, &Message{ShortMessage: strings.Repeat("x", 900)})
+	}
+	start := time.Now()
+	c.QueueMsgs(msgs)
+	c.Flush()
+
+	// Two messages fit in the burst, the other two wait for the bucket to
+	// refill
+	Expect(time.Since(start)).To(BeNumerically(">=", 500*time.Millisecond))
+	Expect(sink.messages()).To(HaveLen(4))
+	stats := c.Stats()
+	Expect(stats.Throttled).To(BeNumerically(">=", 1))
+	Expect(stats.ThrottleWait).To(BeNumerically(">", 0))
+	Expect(stats.Dropped).To(Equal(uint64(0)))
+}
+
+func (s *GolfSuite) TestSenderDropThrottled(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:      1420,
+		BytesPerSecond: 1000,
+		DropThrottled:  true,
+	})
+
+	first := c.QueueMsgWithResult(&Message{ShortMessage: strings.Repeat("x", 800)})
+	second := c.QueueMsgWithResult(&Message{ShortMessage: strings.Repeat("y", 800)})
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+
+	Expect(first).To(Receive(BeNil()))
+	Expect(second).To(Receive(Equal(ErrThrottled)))
+	Expect(sink.messages()).To(HaveLen(1))
+	stats := c.Stats()
+	Expect(stats.Throttled).To(Equal(uint64(1)))
+	Expect(stats.Dropped).To(Equal(uint64(1)))
+	Expect(stats.ThrottleWait).To(Equal(time.Duration(0)))
+}
+
+func (s *GolfSuite) TestSenderRandSource(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	// Clients with the same seed send the same chunk ids
+	ids := make([][]byte, 0)
+	for idx := 0; idx < 2; idx++ {
+		conn, err := net.Dial("udp", listener.LocalAddr().String())
+		Expect(err).To(BeNil())
+		c, _ := NewClientWithConfig(ClientConfig{
+			ChunkSize:   1420,
+			Compression: COMP_NONE,
+			RandSource:  rand.New(rand.NewSource(1)),
+		})
+		Expect(c.Use(conn, "udp")).To(BeNil())
+		c.Infof("message")
+
+		buf := make([]byte, 2048)
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err = listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		ids = append(ids, buf[2:10])
+		c.Close()
+	}
+	Expect(ids[0]).To(Equal(ids[1]))
+
+	expected := make([]byte, 8)
+	rand.New(rand.NewSource(1)).Read(expected)
+	Expect(ids[0]).To(Equal(expected))
+}
+
+func (s *GolfSuite) TestSenderDeliverChan(t sweet.T) {
+	delivered := make(chan *Message, 2)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		FieldPrefix: "svc_",
+		DeliverChan: delivered,
+	})
+	sink := &testSink{err: errors.New("send failed")}
+	c.QueueMsgs([]*Message{
+		(&Message{ShortMessage: "first"}).AddField("user", "someone"),
+		{ShortMessage: "second"},
+		{ShortMessage: "channel full"},
+	})
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+
+	var msg *Message
+	Expect(delivered).To(Receive(&msg))
+	Expect(msg.ShortMessage).To(Equal("first"))
+	Expect(msg.Attrs).To(Equal(map[string]interface{}{"svc_user": "someone"}))
+	Expect(delivered).To(Receive(&msg))
+	Expect(msg.ShortMessage).To(Equal("second"))
+	Expect(delivered).ToNot(Receive())
+	Expect(c.Stats().DeliverDropped).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestSenderDeliverChanSerializer(t sweet.T) {
+	delivered := make(chan *Message, 1)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Serializer:  JSONLinesSerializer{},
+		DeliverChan: delivered,
+	})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	c.Infof("as json lines")
+	c.Flush()
+
+	var msg *Message
+	Expect(delivered).To(Receive(&msg))
+	Expect(msg.ShortMessage).To(Equal("as json lines"))
+	Expect(msg.Level).To(Equal(LEVEL_INFO))
+}
+
+func (s *GolfSuite) TestSenderTCPDelimiter(t sweet.T) {
+	_, err := NewClientWithConfig(ClientConfig{TCPDelimiter: '|'})
+	Expect(err).To(Equal(ErrInvalidDelimiter))
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		TCPDelimiter: '\n',
+	})
+	client, server := net.Pipe()
+	c.Use(client, "tcp")
+	defer c.Close()
+
+	c.Infof("first line\nsecond line")
+	line, err := bufio.NewReader(server).ReadString('\n')
+	Expect(err).To(BeNil())
+	Expect(line).To(HaveSuffix("}\n"))
+	msg, err := ParseMessage([]byte(line))
+	Expect(err).To(BeNil())
+	Expect(msg.ShortMessage).To(Equal("first line\nsecond line"))
+}
+
+func (s *GolfSuite) TestSenderOnSendTiming(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	var timingsMutex sync.Mutex
+	timings := make([]SendTiming, 0)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+		OnSendTiming: func(timing SendTiming) {
+			timingsMutex.Lock()
+			defer timingsMutex.Unlock()
+			timings = append(timings, timing)
+		},
+	})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	c.QueueMsgs([]*Message{{ShortMessage: "one"}, {ShortMessage: "two"}})
+	c.Flush()
+
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	Expect(timings).To(HaveLen(2))
+	for idx, text := range []string{"one", "two"} {
+		Expect(timings[idx].Msg.ShortMessage).To(Equal(text))
+		Expect(timings[idx].Serialize).To(BeNumerically(">", 0))
+		Expect(timings[idx].Compress).To(BeNumerically(">", 0))
+		Expect(timings[idx].Write).To(BeNumerically(">", 0))
+		Expect(timings[idx].Err).To(BeNil())
+	}
+}
+
+func (s *GolfSuite) TestSenderOnSendTimingSynchronous(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	timings := make(chan SendTiming, 80)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_GZIP,
+		Synchronous: true,
+		OnSendTiming: func(timing SendTiming) {
+			timings <- timing
+		},
+	})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	// Goroutines sending at once through the same sender each get the
+	// timings for their own message
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			for count := 0; count < 10; count++ {
+				c.Infof("goroutine %d", idx)
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	Expect(timings).To(HaveLen(80))
+	for idx := 0; idx < 80; idx++ {
+		timing := <-timings
+		Expect(timing.Compress).To(BeNumerically(">", 0))
+		Expect(timing.Err).To(BeNil())
+	}
+}
+
+func (s *GolfSuite) TestSenderOnSendTimingSink(t sweet.T) {
+	sendErr := errors.New("send failed")
+	timings := make(chan SendTiming, 10)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		OnSendTiming: func(timing SendTiming) {
+			timings <- timing
+		},
+	})
+	c.UseSink(&testSink{err: sendErr})
+	defer c.Close()
+
+	c.QueueMsg(&Message{ShortMessage: "failed"})
+	c.Flush()
+
+	var timing SendTiming
+	Expect(timings).To(Receive(&timing))
+	Expect(timing.Msg.ShortMessage).To(Equal("failed"))
+	Expect(timing.Compress).To(BeZero())
+	Expect(timing.Write).To(BeNumerically(">", 0))
+	Expect(timing.Err).To(Equal(sendErr))
+}
+
+func (s *GolfSuite) TestSenderOnBatchSent(t sweet.T) {
+	sink := &testSink{}
+	batches := make(chan []uint64, 10)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		OnBatchSent: func(ids []uint64) {
+			batches <- append([]uint64{}, ids...)
+		},
+	})
+	defer c.Close()
+
+	msgs := []*Message{{ShortMessage: "one"}, {ShortMessage: "two"}, {ShortMessage: "three"}}
+	Expect(c.QueueMsgs(msgs)).To(BeNil())
+	for idx, msg := range msgs {
+		Expect(msg.ID()).To(Equal(uint64(idx + 1)))
+	}
+	c.UseSink(sink)
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"one", "two", "three"}))
+	Expect(batches).To(Receive(Equal([]uint64{1, 2, 3})))
+
+	// Messages that fail to send aren't confirmed
+	sink.err = errors.New("send failed")
+	msg := &Message{ShortMessage: "failed"}
+	c.QueueMsg(msg)
+	c.Flush()
+	Expect(msg.ID()).To(Equal(uint64(4)))
+	Consistently(batches).ShouldNot(Receive())
+}
+
+func (s *GolfSuite) TestSenderOnBatchSentUnset(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1420})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	msg := &Message{ShortMessage: "not numbered"}
+	c.QueueMsg(msg)
+	c.Flush()
+	Expect(msg.ID()).To(BeZero())
+}
+
+func (s *GolfSuite) TestSenderCompressionBuckets(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, err := NewClientWithConfig(ClientConfig{
+		ChunkSize:   1420,
+		Compression: COMP_ZLIB,
+		CompressionBuckets: []CompressionBucket{
+			{MinBytes: 1000, Compression: COMP_GZIP},
+			{MinBytes: 200, Compression: COMP_NONE},
+		},
+	})
+	Expect(err).To(BeNil())
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	// Shorter than every bucket, in the 200 byte bucket and in the 1000
+	// byte bucket
+	c.QueueMsg(&Message{ShortMessage: "tiny"})
+	c.QueueMsg((&Message{ShortMessage: "medium"}).AddField("data", strings.Repeat("x", 300)))
+	c.QueueMsg((&Message{ShortMessage: "large"}).AddField("data", strings.Repeat("x", 1100)))
+	c.Flush()
+
+	buf := make([]byte, 2048)
+	starts := make([]byte, 0)
+	for idx := 0; idx < 3; idx++ {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		// After the chunk header
+		Expect(n).To(BeNumerically(">", 12))
+		starts = append(starts, buf[12])
+	}
+	// zlib, none and gzip
+	Expect(starts).To(Equal([]byte{0x78, '{', 0x1f}))
+
+	stats := c.Stats()
+	Expect(stats.SentByBucket).To(Equal(map[int]uint64{200: 1, 1000: 1}))
+	Expect(stats.SentZlib).To(Equal(uint64(1)))
+	Expect(stats.SentNone).To(Equal(uint64(1)))
+	Expect(stats.SentGzip).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestSenderCompressionBucketsInvalid(t sweet.T) {
+	_, err := NewClientWithConfig(ClientConfig{
+		ChunkSize:          1420,
+		CompressionBuckets: []CompressionBucket{{MinBytes: 100, Compression: 10}},
+	})
+	Expect(err).To(Equal(ErrUnknownCompression))
+}
+
+func (s *GolfSuite) TestSenderOnChunking(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	fallback := &testSink{}
+	var decisionMutex sync.Mutex
+	decision := CHUNKING_DROP
+	chunked := make(chan int, 10)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		Compression:  COMP_NONE,
+		FallbackSink: fallback,
+		OnChunking: func(msg *Message, chunks int) int {
+			Expect(msg.ShortMessage).To(Equal("big"))
+			chunked <- chunks
+			decisionMutex.Lock()
+			defer decisionMutex.Unlock()
+			return decision
+		},
+	})
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	defer c.Close()
+
+	big := func() *Message {
+		return (&Message{ShortMessage: "big"}).AddField("data", strings.Repeat("x", 3000))
+	}
+	c.QueueMsg(&Message{ShortMessage: "small"})
+	c.QueueMsg(big())
+	c.Flush()
+	Expect(chunked).To(Receive(Equal(3)))
+	Expect(c.Stats().Sent).To(Equal(uint64(1)))
+	Expect(c.Stats().Dropped).To(Equal(uint64(1)))
+	Expect(c.Stats().ChunkCounts).To(Equal(map[int]uint64{1: 1}))
+
+	decisionMutex.Lock()
+	decision = CHUNKING_FALLBACK
+	decisionMutex.Unlock()
+	c.QueueMsg(big())
+	c.Flush()
+	Expect(chunked).To(Receive(Equal(3)))
+	Expect(fallback.messages()).To(Equal([]string{"big"}))
+	Expect(c.Stats().Sent).To(Equal(uint64(2)))
+
+	decisionMutex.Lock()
+	decision = CHUNKING_SEND
+	decisionMutex.Unlock()
+	c.QueueMsg(big())
+	c.Flush()
+	Expect(chunked).To(Receive(Equal(3)))
+	Expect(c.Stats().ChunkCounts).To(Equal(map[int]uint64{1: 1, 3: 1}))
+	Expect(c.Stats().Sent).To(Equal(uint64(3)))
+
+	// Only the small message and the big one's chunks reached the server
+	buf := make([]byte, 2000)
+	packets := 0
+	for {
+		listener.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		packets++
+	}
+	Expect(packets).To(Equal(4))
+}
@@ -0,0 +1,171 @@
+package golf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Serializer encodes messages in the format they're sent in, instead of as
+// GELF, for sending messages to something other than a GELF server through
+// the same client. See ClientConfig.Serializer.
+type Serializer interface {
+	Serialize(msg *Message) ([]byte, error)
+}
+
+// A Serializer for GELF JSON, the format messages are sent in when no other
+// Serializer is set
+type GELFSerializer struct{}
+
+func (GELFSerializer) Serialize(msg *Message) ([]byte, error) {
+	return generateMsgJson(msg, serializeOptions{})
+}
+
+// A Serializer for a plain JSON object for each message, for JSON lines
+// receivers. The object has the message's "timestamp" (in RFC 3339 format),
+// "level" (the level's name, such as "WARN"), "host", "message" and
+// "full_message" (if it has one), along with its additional fields without
+// the leading underscore used by GELF. The transport or Sink the messages
+// are sent with is responsible for separating them, such as the newline
+// after each one written by a FileSink.
+type JSONLinesSerializer struct{}
+
+func (JSONLinesSerializer) Serialize(msg *Message) ([]byte, error) {
+	obj := make(map[string]interface{}, len(msg.Attrs)+5)
+	for name, val := range msg.Attrs {
+		obj[name] = val
+	}
+
+	if msg.Timestamp != nil {
+		obj["timestamp"] = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+	obj["level"] = levelName(msg.Level)
+	obj["host"] = msg.Hostname
+	obj["message"] = msg.ShortMessage
+	if msg.FullMessage != "" {
+		obj["full_message"] = msg.FullMessage
+	}
+
+	return json.Marshal(obj)
+}
+
+// A Serializer for ArcSight Common Event Format (CEF) events. The vendor,
+// product and version identify the application in the CEF header, and the
+// message's SignatureAttr attribute (or "-" if it doesn't have one) is used as
+// the event's signature id. The short message is the event's name, and the
+// level is mapped to a CEF severity from 0 (LEVEL_DBG) to 10 (LEVEL_EMERG).
+// The timestamp, host and full message are sent in the "rt", "dvchost" and
+// "msg" extensions, along with all of the additional fields.
+type CEFSerializer struct {
+	Vendor        string
+	Product       string
+	Version       string
+	SignatureAttr string
+}
+
+// CEF severities for each level
+var cefSeverities = []int{10, 9, 8, 7, 5, 3, 1, 0}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+func (cs CEFSerializer) Serialize(msg *Message) ([]byte, error) {
+	severity := 0
+	if msg.Level >= 0 && msg.Level < len(cefSeverities) {
+		severity = cefSeverities[msg.Level]
+	}
+
+	signature := "-"
+	if cs.SignatureAttr != "" {
+		if val, ok := msg.Attrs[cs.SignatureAttr]; ok {
+			signature = fmt.Sprint(val)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(cs.Vendor),
+		cefHeaderEscaper.Replace(cs.Product),
+		cefHeaderEscaper.Replace(cs.Version),
+		cefHeaderEscaper.Replace(signature),
+		cefHeaderEscaper.Replace(msg.ShortMessage),
+		severity)
+
+	ext := make(map[string]string, len(msg.Attrs)+3)
+	for name, val := range msg.Attrs {
+		if name != cs.SignatureAttr {
+			ext[name] = fmt.Sprint(val)
+		}
+	}
+	if msg.Timestamp != nil {
+		ext["rt"] = strconv.FormatInt(msg.Timestamp.UnixNano()/int64(time.Millisecond), 10)
+	}
+	if msg.Hostname != "" {
+		ext["dvchost"] = msg.Hostname
+	}
+	if msg.FullMessage != "" {
+		ext["msg"] = msg.FullMessage
+	}
+
+	names := make([]string, 0, len(ext))
+	for name := range ext {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for idx, name := range names {
+		if idx > 0 {
+			buf.WriteString(" ")
+		}
+		fmt.Fprintf(buf, "%s=%s", cefKey(name), cefValueEscaper.Replace(ext[name]))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Make name a valid CEF extension key, which can only contain letters and
+// numbers
+func cefKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// Get a copy of msg with all of the additional fields it would be sent with
+// as GELF in its Attrs, including ones from its Logger and the client, for
+// passing to a Serializer
+func flattenMsg(msg *Message, opts serializeOptions) *Message {
+	fields := msgFields(msg, opts)
+
+	flat := newMessageForVersion(msg.version)
+	flat.Level = msg.Level
+	flat.Hostname = msg.Hostname
+	flat.Timestamp = msg.Timestamp
+	flat.ShortMessage = msg.ShortMessage
+	flat.FullMessage = msg.FullMessage
+	for key, val := range fields {
+		if strings.HasPrefix(key, "_") {
+			flat.Attrs[key[1:]] = val
+		}
+	}
+	return flat
+}
+
+// Serialize msg with a Serializer, returning an error instead of panicking if
+// the Serializer panics
+func serializeWith(s Serializer, msg *Message, opts serializeOptions) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			data = nil
+			err = fmt.Errorf("panic while serializing message: %v", r)
+		}
+	}()
+
+	return s.Serialize(flattenMsg(msg, opts))
+}
@@ -0,0 +1,172 @@
+package golf
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func serializerTestMsg() *Message {
+	ts := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	msg := newMessage()
+	msg.Level = LEVEL_ERR
+	msg.Hostname = "host"
+	msg.ShortMessage = "short | message"
+	msg.FullMessage = "full\nmessage"
+	msg.Timestamp = &ts
+	msg.AddField("user", "a=b")
+	return msg
+}
+
+func (s *GolfSuite) TestGELFSerializer(t sweet.T) {
+	msg := serializerTestMsg()
+	data, err := GELFSerializer{}.Serialize(msg)
+	Expect(err).To(BeNil())
+
+	expected, _ := generateMsgJson(msg, serializeOptions{})
+	Expect(data).To(Equal(expected))
+}
+
+func (s *GolfSuite) TestJSONLinesSerializer(t sweet.T) {
+	data, err := JSONLinesSerializer{}.Serialize(serializerTestMsg())
+	Expect(err).To(BeNil())
+
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(data, &obj)).To(BeNil())
+	Expect(obj).To(Equal(map[string]interface{}{
+		"timestamp":    "2017-07-14T02:40:00Z",
+		"level":        "ERR",
+		"host":         "host",
+		"message":      "short | message",
+		"full_message": "full\nmessage",
+		"user":         "a=b",
+	}))
+}
+
+func (s *GolfSuite) TestCEFSerializer(t sweet.T) {
+	cef := CEFSerializer{
+		Vendor:        "Acme",
+		Product:       "api",
+		Version:       "1.0",
+		SignatureAttr: "event",
+	}
+
+	msg := serializerTestMsg()
+	msg.AddField("event", "login_failed")
+	msg.AddField("src_ip", "10.0.0.1")
+	data, err := cef.Serialize(msg)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(`CEF:0|Acme|api|1.0|login_failed|short \| message|7|` +
+		`dvchost=host msg=full\nmessage rt=1500000000000 srcip=10.0.0.1 user=a\=b`))
+
+	msg = serializerTestMsg()
+	msg.Level = LEVEL_DBG
+	data, err = cef.Serialize(msg)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(HavePrefix(`CEF:0|Acme|api|1.0|-|short \| message|0|`))
+}
+
+type panicSerializer struct{}
+
+// Fails the first failures times it's used
+type flakySerializer struct {
+	failures int
+	calls    int
+}
+
+func (fs *flakySerializer) Serialize(msg *Message) ([]byte, error) {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return nil, errors.New("not yet")
+	}
+	return GELFSerializer{}.Serialize(msg)
+}
+
+func (panicSerializer) Serialize(msg *Message) ([]byte, error) {
+	panic("serializer panicked")
+}
+
+func (s *GolfSuite) TestClientSerializer(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:        1420,
+		Serializer:       JSONLinesSerializer{},
+		AddProcessFields: true,
+		FieldPrefix:      "svc_",
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	l, _ := c.NewLogger()
+	l.SetAttr("logger_attr", "value")
+	l.Infof("message")
+	c.Flush()
+
+	obj := make(map[string]interface{})
+	Expect(json.Unmarshal(sink.batches[0][0], &obj)).To(BeNil())
+	Expect(obj["message"]).To(Equal("message"))
+	Expect(obj["level"]).To(Equal("INFO"))
+	Expect(obj["svc_logger_attr"]).To(Equal("value"))
+	Expect(obj).To(HaveKey("svc_" + PID_ATTR))
+}
+
+func (s *GolfSuite) TestClientSerializerPanic(t sweet.T) {
+	sink := &testSink{}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		Serializer: panicSerializer{},
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(c.Errors()).To(Receive(MatchError(`failed to serialize message "message": panic while serializing message: serializer panicked`)))
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestClientSerializeAttempts(t sweet.T) {
+	sink := &testSink{}
+	serializer := &flakySerializer{failures: 2}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:         1420,
+		Serializer:        serializer,
+		SerializeAttempts: 3,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("retried")
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"retried"}))
+	Expect(serializer.calls).To(Equal(3))
+	Expect(c.Errors()).ToNot(Receive())
+
+	// Given up on once it's out of attempts
+	serializer.calls = 0
+	serializer.failures = 3
+	c.Infof("failed")
+	c.Flush()
+	Expect(serializer.calls).To(Equal(3))
+	Expect(c.Errors()).To(Receive(MatchError(`failed to serialize message "failed": not yet`)))
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestClientSerializeAttemptsDefault(t sweet.T) {
+	sink := &testSink{}
+	serializer := &flakySerializer{failures: 1}
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:  1420,
+		Serializer: serializer,
+	})
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("message")
+	c.Flush()
+	Expect(serializer.calls).To(Equal(1))
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+}
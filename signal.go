@@ -0,0 +1,38 @@
+package golf
+
+import (
+	"os"
+	"os/signal"
+)
+
+// Close the client when any of the given signals are received, so messages
+// that are still queued are sent before the process exits. Once the client
+// is closed the signal is sent to the process again with the handler
+// removed, so the signal's normal behavior (such as exiting) still happens.
+//
+// Nothing is installed by default, this must be called to use it. The
+// returned function removes the handler without closing the client.
+func (c *Client) FlushOnSignal(signals ...os.Signal) func() {
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan int)
+	signal.Notify(sigChan, signals...)
+
+	go func() {
+		select {
+		case sig := <-sigChan:
+			c.Close()
+			signal.Stop(sigChan)
+
+			proc, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				proc.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package golf
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestFlushOnSignal(t sweet.T) {
+	// Catch the signal being sent again after the client is closed so it
+	// doesn't stop the tests
+	resent := make(chan os.Signal, 2)
+	signal.Notify(resent, syscall.SIGUSR1)
+	defer signal.Stop(resent)
+
+	sink := &testSink{}
+	c, _ := NewClient()
+	c.UseSink(sink)
+	cancel := c.FlushOnSignal(syscall.SIGUSR1)
+	defer cancel()
+
+	c.Infof("test message")
+
+	proc, _ := os.FindProcess(os.Getpid())
+	proc.Signal(syscall.SIGUSR1)
+
+	Eventually(c.Connected, 5*time.Second).Should(BeFalse())
+	Expect(sink.closed).To(BeTrue())
+	Expect(sink.messages()).To(Equal([]string{"test message"}))
+	Eventually(resent).Should(Receive())
+}
+
+func (s *GolfSuite) TestFlushOnSignalCancel(t sweet.T) {
+	resent := make(chan os.Signal, 2)
+	signal.Notify(resent, syscall.SIGUSR2)
+	defer signal.Stop(resent)
+
+	c, _ := NewClient()
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	cancel := c.FlushOnSignal(syscall.SIGUSR2)
+	cancel()
+
+	proc, _ := os.FindProcess(os.Getpid())
+	proc.Signal(syscall.SIGUSR2)
+
+	Eventually(resent).Should(Receive())
+	Consistently(c.Connected).Should(BeTrue())
+}
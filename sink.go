@@ -0,0 +1,15 @@
+package golf
+
+// The largest number of messages passed to a Sink in a batch
+const DEFAULT_BATCH_SIZE = 100
+
+// A Sink delivers the messages from a Client's queue. Each message in a batch
+// is the GELF JSON for a single message. Any error returned is reported on
+// the client's error channel.
+//
+// A Sink lets the client's queueing be used to deliver messages somewhere
+// other than a GELF server, see Client.UseSink. Messages sent over a connection
+// with Dial or Use are delivered by the client's own Sink for the connection.
+type Sink interface {
+	Send(batch [][]byte) error
+}
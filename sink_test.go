@@ -0,0 +1,88 @@
+package golf
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+type testSink struct {
+	batchesMutex sync.Mutex
+	batches      [][][]byte
+	err          error
+	closed       bool
+}
+
+func (ts *testSink) Send(batch [][]byte) error {
+	ts.batchesMutex.Lock()
+	defer ts.batchesMutex.Unlock()
+
+	ts.batches = append(ts.batches, batch)
+	return ts.err
+}
+
+func (ts *testSink) Close() error {
+	ts.closed = true
+	return nil
+}
+
+func (ts *testSink) messages() []string {
+	ts.batchesMutex.Lock()
+	defer ts.batchesMutex.Unlock()
+
+	msgs := make([]string, 0)
+	for _, batch := range ts.batches {
+		for _, data := range batch {
+			var obj map[string]interface{}
+			json.Unmarshal(data, &obj)
+			msgs = append(msgs, obj["short_message"].(string))
+		}
+	}
+	return msgs
+}
+
+func (s *GolfSuite) TestUseSink(t sweet.T) {
+	sink := &testSink{}
+
+	c, _ := NewClient()
+	msgs := make([]*Message, 250)
+	for idx := range msgs {
+		msgs[idx] = c.genMsg(LEVEL_INFO, "message %d", idx)
+	}
+	c.QueueMsgs(msgs)
+
+	err := c.UseSink(sink)
+	Expect(err).To(BeNil())
+	Expect(c.Connected()).To(BeTrue())
+
+	err = c.Close()
+	Expect(err).To(BeNil())
+	Expect(c.Connected()).To(BeFalse())
+	Expect(sink.closed).To(BeTrue())
+
+	Expect(sink.batches).To(HaveLen(3))
+	Expect(sink.batches[0]).To(HaveLen(DEFAULT_BATCH_SIZE))
+	Expect(sink.batches[1]).To(HaveLen(DEFAULT_BATCH_SIZE))
+	Expect(sink.batches[2]).To(HaveLen(50))
+
+	received := sink.messages()
+	Expect(received).To(HaveLen(250))
+	Expect(received[0]).To(Equal("message 0"))
+	Expect(received[249]).To(Equal("message 249"))
+}
+
+func (s *GolfSuite) TestUseSinkError(t sweet.T) {
+	sinkErr := errors.New("sink failed")
+	sink := &testSink{err: sinkErr}
+
+	c, _ := NewClient()
+	c.UseSink(sink)
+	defer c.Close()
+
+	c.Infof("test message")
+
+	Eventually(c.Errors()).Should(Receive(WithTransform(sendErrCause, Equal(sinkErr))))
+}
@@ -0,0 +1,78 @@
+package golf
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The scheme of Dial URIs naming a DNS SRV record to look the server up with,
+// such as "gelf+srv://_gelf._udp.example.com"
+const SRV_SCHEME = "gelf+srv"
+
+// Looks up the SRV records for a name, replaced by tests
+var lookupSRV = net.LookupSRV
+
+// Parse a Dial URI with SRV_SCHEME. The transport comes from the protocol
+// label of the record's name, "_udp" or "_tcp", and the address from the
+// records themselves when the server is dialed.
+func parseSRVURI(parsedUri *url.URL) (*dialURI, error) {
+	if parsedUri.Host == "" {
+		return nil, ErrMissingHost
+	}
+	if strings.Contains(parsedUri.Host, ":") {
+		// The port comes from the record
+		return nil, ErrInvalidPort
+	}
+
+	labels := strings.SplitN(parsedUri.Host, ".", 3)
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") {
+		return nil, ErrInvalidSRVName
+	}
+	var transport string
+	switch labels[1] {
+	case "_udp":
+		transport = "udp"
+	case "_tcp":
+		transport = "tcp"
+	default:
+		return nil, ErrInvalidSRVName
+	}
+
+	return &dialURI{
+		scheme:    transport,
+		transport: transport,
+		network:   transport,
+		srvName:   parsedUri.Host,
+	}, nil
+}
+
+// Look up the target's SRV record and connect to the first of its servers
+// that can be connected to, in the order LookupSRV sorts them: by priority,
+// and randomly by weight for servers with the same priority. It's looked up
+// again each time it's dialed, including when reconnecting, so changes to the
+// record are picked up.
+func (c *Client) dialSRV(ctx context.Context, target *dialURI) (net.Conn, error) {
+	_, addrs, err := lookupSRV("", "", target.srvName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ErrNoSRVRecords
+	for _, addr := range addrs {
+		server := *target
+		server.srvName = ""
+		server.address = net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port)))
+
+		var conn net.Conn
+		conn, err = c.dialTarget(ctx, &server)
+		if err == nil {
+			c.logf("golf: resolved %s to %s", target.srvName, server.address)
+			return conn, nil
+		}
+		c.logf("golf: connecting to %s from %s failed: %v", server.address, target.srvName, err)
+	}
+	return nil, err
+}
@@ -0,0 +1,87 @@
+package golf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// Replace lookupSRV with one returning addrs for name, returning the number of
+// times it's been called and a function that puts the real one back
+func fakeSRV(name string, addrs []*net.SRV) (*int, func()) {
+	lookups := 0
+	lookupSRV = func(service string, proto string, lookupName string) (string, []*net.SRV, error) {
+		lookups++
+		if lookupName != name {
+			return "", nil, errors.New("no such host")
+		}
+		return lookupName, addrs, nil
+	}
+	return &lookups, func() { lookupSRV = net.LookupSRV }
+}
+
+// Get the port of a local address as an SRV record's port
+func srvPort(addr net.Addr) uint16 {
+	_, port, _ := net.SplitHostPort(addr.String())
+	num, _ := strconv.Atoi(port)
+	return uint16(num)
+}
+
+func (s *GolfSuite) TestDialSRV(t sweet.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	received := receiveTCP(listener)
+
+	// Nothing's listening on the first server so the second is used
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	lookups, restore := fakeSRV("_gelf._tcp.example.com", []*net.SRV{
+		{Target: "127.0.0.1.", Port: srvPort(closed.Addr()), Priority: 1},
+		{Target: "127.0.0.1.", Port: srvPort(listener.Addr()), Priority: 2},
+	})
+	defer restore()
+
+	c, _ := NewClient()
+	Expect(c.Dial("gelf+srv://_gelf._tcp.example.com")).To(BeNil())
+	defer c.Close()
+	Expect(*lookups).To(Equal(1))
+
+	c.Infof("found it")
+	Eventually(received).Should(Receive(Equal("found it")))
+
+	// It's looked up again when reconnecting
+	conn, err := c.conn.(*reconnConn).dial(context.Background())
+	Expect(err).To(BeNil())
+	conn.Close()
+	Expect(*lookups).To(Equal(2))
+}
+
+func (s *GolfSuite) TestDialSRVUdp(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	_, restore := fakeSRV("_gelf._udp.example.com", []*net.SRV{
+		{Target: "127.0.0.1.", Port: srvPort(listener.LocalAddr())},
+	})
+	defer restore()
+
+	c, _ := NewClient()
+	Expect(c.Dial("gelf+srv://_gelf._udp.example.com")).To(BeNil())
+	defer c.Close()
+	Expect(c.conn.RemoteAddr().String()).To(Equal(listener.LocalAddr().String()))
+}
+
+func (s *GolfSuite) TestDialSRVErrors(t sweet.T) {
+	_, restore := fakeSRV("_gelf._udp.example.com", []*net.SRV{})
+	defer restore()
+
+	c, _ := NewClient()
+	Expect(c.Dial("gelf+srv://_gelf._udp.example.com")).To(Equal(ErrNoSRVRecords))
+	Expect(c.Dial("gelf+srv://_gelf._udp.missing.example.com")).To(MatchError("no such host"))
+	Expect(c.Connected()).To(BeFalse())
+}
@@ -0,0 +1,324 @@
+package golf
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics about the messages sent by a Client
+type Stats struct {
+	Sent    uint64 // Messages sent successfully
+	Failed  uint64 // Messages that couldn't be serialized or failed to send
+	Dropped uint64 // Messages dropped without being sent, such as when the queue is full
+	// Chunked messages that failed partway through being written, leaving
+	// the server with an incomplete set of chunks. They're also counted
+	// in Failed.
+	PartialSends uint64
+	// Messages dropped for being older than their max age when they were
+	// going to be sent, see ClientConfig.MaxAge. They're also counted in
+	// Dropped.
+	Expired uint64
+	// Messages that went over ClientConfig.BytesPerSecond, and the total
+	// time the senders spent waiting for them. With DropThrottled they're
+	// dropped instead of waiting, and also counted in Dropped.
+	Throttled    uint64
+	ThrottleWait time.Duration
+	// Additional fields left out of messages by ClientConfig.AllowFields
+	// and DenyFields, which apply to the client's own fields such as "_seq"
+	// too
+	FieldsStripped uint64
+	// Additional fields left out of messages for having more than
+	// ClientConfig.MaxFields
+	FieldsTruncated uint64
+	// Messages that weren't delivered to ClientConfig.DeliverChan because
+	// it was full
+	DeliverDropped uint64
+	// Messages written to ClientConfig.CloseExportWriter, which are also
+	// counted in Failed or Dropped
+	Exported uint64
+
+	// Number of messages sent over udp by the number of chunks they were
+	// split into, such as {1: 950, 2: 40, 5: 10}, for tuning ChunkSize.
+	// Most messages should fit in a single chunk, if a lot of them need
+	// more ChunkSize or MTU can be raised, or they can be sent over tcp
+	// instead. Messages with more than MAX_CHUNKS chunks are counted under
+	// MAX_CHUNKS, and counts of 0 are left out.
+	ChunkCounts map[int]uint64
+
+	// Messages dropped by ClientConfig.SampleRates by their level, with
+	// counts of 0 left out. They're also counted in Dropped.
+	SampledOut map[int]uint64
+
+	QueueDepth   int // Messages queued that haven't finished sending yet
+	MaxQueueSize int // The current limit on QueueDepth, or 0 for no limit
+
+	// Total size of the messages that were compressed, before and after
+	// they were compressed, and the ratio of the compressed size to the
+	// uncompressed size, or 0 if nothing has been compressed. A ratio of
+	// 0.25 means compression made the messages four times smaller. With
+	// COMP_AUTO the size after is the size of what was sent, which is the
+	// original message if compressing it didn't make it smaller.
+	UncompressedBytes uint64
+	CompressedBytes   uint64
+	CompressionRatio  float64
+	// Messages written by the compression they were actually sent with,
+	// so with COMP_AUTO the ones that were sent uncompressed because
+	// compressing them didn't make them smaller are counted in SentNone
+	SentNone uint64
+	SentGzip uint64
+	SentZlib uint64
+	// Messages sent by the minimum size of the ClientConfig.CompressionBuckets
+	// bucket they were in, with counts of 0 left out. Messages that were
+	// shorter than every bucket aren't counted.
+	SentByBucket map[int]uint64
+
+	Reconnects    uint64    // Times the connection has been reconnected
+	LastReconnect time.Time // When the connection was last reconnected
+
+	LastError     error     // The last error reported to Errors()
+	LastErrorTime time.Time // When LastError was reported
+}
+
+// Reasons a message can be dropped, for DropRecord
+const (
+	DROP_QUEUE_FULL       = "queue_full"       // It didn't fit in the queue or the overflow
+	DROP_MIN_LEVEL        = "min_level"        // It was less severe than MinLevel
+	DROP_EXPIRED          = "expired"          // It was older than its max age
+	DROP_OVERFLOW_EXPIRED = "overflow_expired" // It was in the overflow for longer than OverflowMaxAge
+	DROP_TRANSFORM        = "transform"        // Transform returned nil for it
+	DROP_THROTTLED        = "throttled"        // It went over BytesPerSecond with DropThrottled
+	DROP_OLDER_THAN       = "older_than"       // It was removed from the queue by DropOlderThan
+	DROP_RECONNECT_FAILED = "reconnect_failed" // It was queued when the client gave up reconnecting
+	DROP_SAMPLED          = "sampled"          // It wasn't picked by SampleRates
+	DROP_ABORTED          = "aborted"          // It was still queued when Abort was called
+	DROP_REPEATED         = "repeated"         // It repeated the message before it within RepeatWindow
+	DROP_CHUNKED          = "chunked"          // OnChunking decided not to send it
+	DROP_EVICTED          = "evicted"          // It was the oldest in a full queue with QUEUE_DROP_OLDEST
+)
+
+// How each of the reasons for dropping a message is logged
+var dropDescriptions = map[string]string{
+	DROP_QUEUE_FULL:       "it didn't fit in the queue",
+	DROP_MIN_LEVEL:        "it was less severe than MinLevel",
+	DROP_EXPIRED:          "it was older than its max age",
+	DROP_OVERFLOW_EXPIRED: "it was too old in the overflow",
+	DROP_TRANSFORM:        "Transform returned nil",
+	DROP_THROTTLED:        "it went over BytesPerSecond",
+	DROP_OLDER_THAN:       "DropOlderThan was called",
+	DROP_RECONNECT_FAILED: "gave up reconnecting",
+	DROP_SAMPLED:          "it wasn't sampled",
+	DROP_ABORTED:          "the client was aborted",
+	DROP_REPEATED:         "it repeated the last message",
+	DROP_CHUNKED:          "OnChunking dropped it",
+	DROP_EVICTED:          "it was the oldest in the full queue",
+}
+
+// A record of a message that was dropped, for ClientConfig.DropAuditFunc
+type DropRecord struct {
+	Msg    *Message  // The message that was dropped
+	Reason string    // Why it was dropped, one of the DROP_* constants
+	Time   time.Time // When it was dropped
+}
+
+// How long each stage of sending a message took, for
+// ClientConfig.OnSendTiming
+type SendTiming struct {
+	Msg *Message // The message that was sent, after Transform
+	// Time spent serializing the message, compressing it and writing it to
+	// the connection. With TCPBufferSize the write is only to the buffer.
+	// A Sink given to UseSink or FallbackSink does its own compressing and
+	// writing, so the time it took to send the whole batch the message was
+	// in is counted in Write instead.
+	Serialize time.Duration
+	Compress  time.Duration
+	Write     time.Duration
+	Err       error // The error sending the message, if it failed
+}
+
+// Counters for Stats, updated atomically
+type clientStats struct {
+	sent       uint64
+	failed     uint64
+	dropped    uint64
+	partial    uint64
+	expired    uint64
+	reconnects uint64
+	// Messages that went over BytesPerSecond, and the nanoseconds spent
+	// waiting for them
+	throttled    uint64
+	throttleWait uint64
+	// Fields left out by AllowFields and DenyFields, and by MaxFields
+	stripped        uint64
+	fieldsTruncated uint64
+	// Messages DeliverChan was too full for
+	deliverDropped uint64
+	// Messages written to the CloseExportWriter
+	exported uint64
+	// Messages written by the compression they were sent with, indexed by
+	// COMP_NONE, COMP_GZIP and COMP_ZLIB
+	sentByCompression [COMP_ZLIB + 1]uint64
+	// Messages by the index of the CompressionBuckets bucket they were sent
+	// with
+	sentByBucket []uint64
+	// Messages by the number of chunks they were split into, with index 0
+	// unused
+	chunkCounts [MAX_CHUNKS + 1]uint64
+	// Messages dropped by sampling by their level
+	sampledOut [LEVEL_DBG + 1]uint64
+	// Bytes of the messages compressed before and after compressing them
+	uncompressed uint64
+	compressed   uint64
+	// The last sequence number given to a message for AddSequenceNumbers
+	seq uint64
+	// The last ID given to a message for OnBatchSent
+	lastID uint64
+
+	lastMutex     sync.Mutex
+	lastReconnect time.Time
+	lastErr       error
+	lastErrTime   time.Time
+}
+
+func (cs *clientStats) reconnected() {
+	atomic.AddUint64(&cs.reconnects, 1)
+
+	cs.lastMutex.Lock()
+	cs.lastReconnect = time.Now()
+	cs.lastMutex.Unlock()
+}
+
+func (cs *clientStats) chunked(count int) {
+	if count > MAX_CHUNKS {
+		count = MAX_CHUNKS
+	}
+	atomic.AddUint64(&cs.chunkCounts[count], 1)
+}
+
+func (cs *clientStats) errored(err error) {
+	cs.lastMutex.Lock()
+	cs.lastErr = err
+	cs.lastErrTime = time.Now()
+	cs.lastMutex.Unlock()
+}
+
+// Retrieve the current statistics for the messages sent by the client
+func (c *Client) Stats() Stats {
+	c.queueMutex.Lock()
+	depth := c.pending
+	maxSize := c.config.MaxQueueSize
+	c.queueMutex.Unlock()
+
+	uncompressed := atomic.LoadUint64(&c.stats.uncompressed)
+	compressed := atomic.LoadUint64(&c.stats.compressed)
+	ratio := float64(0)
+	if uncompressed > 0 {
+		ratio = float64(compressed) / float64(uncompressed)
+	}
+
+	chunkCounts := make(map[int]uint64)
+	for count := range c.stats.chunkCounts {
+		if n := atomic.LoadUint64(&c.stats.chunkCounts[count]); n > 0 {
+			chunkCounts[count] = n
+		}
+	}
+
+	sentByBucket := make(map[int]uint64)
+	for idx, bucket := range c.config.CompressionBuckets {
+		if n := atomic.LoadUint64(&c.stats.sentByBucket[idx]); n > 0 {
+			sentByBucket[bucket.MinBytes] = n
+		}
+	}
+
+	sampledOut := make(map[int]uint64)
+	for level := range c.stats.sampledOut {
+		if n := atomic.LoadUint64(&c.stats.sampledOut[level]); n > 0 {
+			sampledOut[level] = n
+		}
+	}
+
+	c.stats.lastMutex.Lock()
+	defer c.stats.lastMutex.Unlock()
+
+	return Stats{
+		Sent:    atomic.LoadUint64(&c.stats.sent),
+		Failed:  atomic.LoadUint64(&c.stats.failed),
+		Dropped: atomic.LoadUint64(&c.stats.dropped),
+
+		PartialSends: atomic.LoadUint64(&c.stats.partial),
+		Expired:      atomic.LoadUint64(&c.stats.expired),
+		Throttled:    atomic.LoadUint64(&c.stats.throttled),
+		ThrottleWait: time.Duration(atomic.LoadUint64(&c.stats.throttleWait)),
+
+		FieldsStripped:  atomic.LoadUint64(&c.stats.stripped),
+		FieldsTruncated: atomic.LoadUint64(&c.stats.fieldsTruncated),
+		DeliverDropped:  atomic.LoadUint64(&c.stats.deliverDropped),
+		Exported:        atomic.LoadUint64(&c.stats.exported),
+		ChunkCounts:     chunkCounts,
+		SampledOut:      sampledOut,
+
+		UncompressedBytes: uncompressed,
+		CompressedBytes:   compressed,
+		CompressionRatio:  ratio,
+		SentNone:          atomic.LoadUint64(&c.stats.sentByCompression[COMP_NONE]),
+		SentGzip:          atomic.LoadUint64(&c.stats.sentByCompression[COMP_GZIP]),
+		SentZlib:          atomic.LoadUint64(&c.stats.sentByCompression[COMP_ZLIB]),
+		SentByBucket:      sentByBucket,
+
+		QueueDepth:   depth,
+		MaxQueueSize: maxSize,
+
+		Reconnects:    atomic.LoadUint64(&c.stats.reconnects),
+		LastReconnect: c.stats.lastReconnect,
+
+		LastError:     c.stats.lastErr,
+		LastErrorTime: c.stats.lastErrTime,
+	}
+}
+
+// The status of a client as it's encoded by HealthJSON
+type healthStatus struct {
+	Connected  bool   `json:"connected"`
+	QueueDepth int    `json:"queue_depth"`
+	Dropped    uint64 `json:"dropped"`
+	Reconnects uint64 `json:"reconnects"`
+	LastError  string `json:"last_error,omitempty"`
+	// RFC 3339 time of the last error, if there was one
+	LastErrorTime string `json:"last_error_time,omitempty"`
+}
+
+// Get the client's status as a JSON object, to include in a service's health
+// check. It has the "connected", "queue_depth", "dropped" and "reconnects"
+// fields from Connected and Stats, and "last_error" and "last_error_time" if
+// an error has been reported to Errors().
+func (c *Client) HealthJSON() ([]byte, error) {
+	stats := c.Stats()
+	status := healthStatus{
+		Connected:  c.Connected(),
+		QueueDepth: stats.QueueDepth,
+		Dropped:    stats.Dropped,
+		Reconnects: stats.Reconnects,
+	}
+	if stats.LastError != nil {
+		status.LastError = stats.LastError.Error()
+		status.LastErrorTime = stats.LastErrorTime.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(status)
+}
+
+// Change the largest number of messages that can be queued while the client
+// is running, see ClientConfig.MaxQueueSize. If the queue already has more
+// messages than the new size they're still sent, it only applies to messages
+// queued after the call.
+func (c *Client) SetMaxQueueSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	c.queueMutex.Lock()
+	c.config.MaxQueueSize = n
+	// Anything waiting for room with BackpressureBlock might fit now
+	c.sentCond.Broadcast()
+	c.queueMutex.Unlock()
+}
@@ -0,0 +1,268 @@
+package golf
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestStats(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 3,
+		Transform: func(msg *Message) *Message {
+			if msg.ShortMessage == "transformed away" {
+				return nil
+			}
+			return msg
+		},
+	})
+
+	c.Infof("sent")
+	c.Infof("transformed away")
+	c.Infof("sent")
+	c.Infof("queue full")
+
+	stats := c.Stats()
+	Expect(stats.QueueDepth).To(Equal(3))
+	Expect(stats.MaxQueueSize).To(Equal(3))
+	Expect(stats.Dropped).To(Equal(uint64(1)))
+
+	c.UseSink(&testSink{})
+	defer c.Close()
+	c.Flush()
+
+	stats = c.Stats()
+	Expect(stats.Sent).To(Equal(uint64(2)))
+	Expect(stats.Failed).To(Equal(uint64(0)))
+	Expect(stats.Dropped).To(Equal(uint64(2)))
+	Expect(stats.QueueDepth).To(Equal(0))
+}
+
+func (s *GolfSuite) TestStatsFailed(t sweet.T) {
+	sendErr := errors.New("send failed")
+	c, _ := NewClient()
+	Expect(c.Stats().LastError).To(BeNil())
+	c.UseSink(&testSink{err: sendErr})
+	defer c.Close()
+
+	c.Infof("failed")
+	c.Flush()
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+	Expect(c.Stats().Sent).To(Equal(uint64(0)))
+	Expect(c.Stats().LastError).To(WithTransform(sendErrCause, Equal(sendErr)))
+	Expect(c.Stats().LastErrorTime).ToNot(BeZero())
+}
+
+func (s *GolfSuite) TestSetMaxQueueSize(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 2,
+	})
+
+	Expect(c.Infof("message")).To(BeNil())
+	Expect(c.Infof("message")).To(BeNil())
+	Expect(c.Infof("message")).To(Equal(ErrQueueFull))
+
+	c.SetMaxQueueSize(4)
+	Expect(c.Stats().MaxQueueSize).To(Equal(4))
+	Expect(c.Infof("message")).To(BeNil())
+	Expect(c.Infof("message")).To(BeNil())
+	Expect(c.Infof("message")).To(Equal(ErrQueueFull))
+
+	// Lowering it doesn't drop what's already queued
+	c.SetMaxQueueSize(1)
+	Expect(c.Stats().QueueDepth).To(Equal(4))
+	Expect(c.Infof("message")).To(Equal(ErrQueueFull))
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+	Expect(sink.messages()).To(HaveLen(4))
+
+	// No limit
+	c.SetMaxQueueSize(0)
+	Expect(c.Stats().MaxQueueSize).To(Equal(0))
+}
+
+// A net.Conn that fails every write after the first 'succeed' writes
+type failAfterConn struct {
+	net.Conn
+	succeed int
+	writes  int
+}
+
+func (fc *failAfterConn) Write(p []byte) (int, error) {
+	fc.writes++
+	if fc.writes > fc.succeed {
+		return 0, errors.New("write failed")
+	}
+	return len(p), nil
+}
+
+func (s *GolfSuite) TestStatsPartialSends(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   100,
+		Compression: COMP_NONE,
+	})
+	client, _ := net.Pipe()
+	c.Use(&failAfterConn{Conn: client, succeed: 1}, "udp")
+	defer c.Close()
+
+	c.Infof("%s", strings.Repeat("a long message ", 20))
+	c.Flush()
+
+	var sendErr error
+	Eventually(c.Errors()).Should(Receive(&sendErr))
+	partialErr, ok := sendErrCause(sendErr).(*PartialSendError)
+	Expect(ok).To(BeTrue())
+	Expect(partialErr.Sent).To(Equal(1))
+	Expect(partialErr.Total).To(BeNumerically(">", 1))
+
+	Expect(c.Stats().PartialSends).To(Equal(uint64(1)))
+	Expect(c.Stats().Failed).To(Equal(uint64(1)))
+}
+
+func (s *GolfSuite) TestStatsCompression(t sweet.T) {
+	c, _ := NewClient()
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 8192)
+	sndr := newSenderForWriter(chnk)
+	sndr.client = c
+
+	Expect(c.Stats().CompressionRatio).To(Equal(float64(0)))
+
+	data := []byte(strings.Repeat(`{"short_message":"compressible"}`, 50))
+	Expect(sndr.writeMsg(data, COMP_GZIP, 0)).To(BeNil())
+	stats := c.Stats()
+	Expect(stats.UncompressedBytes).To(Equal(uint64(len(data))))
+	Expect(stats.CompressedBytes).To(Equal(uint64(len(w.Written[0]) - 12)))
+	Expect(stats.CompressionRatio).To(BeNumerically("<", 0.2))
+
+	// Messages that aren't compressed aren't counted
+	Expect(sndr.writeMsg(data, COMP_NONE, 0)).To(BeNil())
+	Expect(c.Stats().UncompressedBytes).To(Equal(uint64(len(data))))
+
+	// With COMP_AUTO the size sent is counted
+	small := []byte(`{}`)
+	Expect(sndr.writeMsg(small, COMP_AUTO, 0)).To(BeNil())
+	stats = c.Stats()
+	Expect(stats.UncompressedBytes).To(Equal(uint64(len(data) + len(small))))
+	Expect(stats.CompressedBytes).To(Equal(uint64(len(w.Written[0]) - 12 + len(small))))
+}
+
+func (s *GolfSuite) TestStatsSentByCompression(t sweet.T) {
+	c, _ := NewClient()
+	w := newTestWriter()
+	chnk, _ := newChunker(w, 8192)
+	sndr := newSenderForWriter(chnk)
+	sndr.client = c
+
+	data := []byte(strings.Repeat(`{"short_message":"compressible"}`, 50))
+	Expect(sndr.writeMsg(data, COMP_GZIP, 0)).To(BeNil())
+	Expect(sndr.writeMsg(data, COMP_ZLIB, 0)).To(BeNil())
+	Expect(sndr.writeMsg(data, COMP_NONE, 0)).To(BeNil())
+
+	// COMP_AUTO is counted as whichever was actually sent
+	Expect(sndr.writeMsg(data, COMP_AUTO, 0)).To(BeNil())
+	Expect(sndr.writeMsg([]byte(`{}`), COMP_AUTO, 0)).To(BeNil())
+
+	stats := c.Stats()
+	Expect(stats.SentGzip).To(Equal(uint64(2)))
+	Expect(stats.SentZlib).To(Equal(uint64(1)))
+	Expect(stats.SentNone).To(Equal(uint64(2)))
+}
+
+func (s *GolfSuite) TestHealthJSON(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1420, MaxQueueSize: 1})
+	c.Infof("queued")
+	c.Infof("queue full")
+
+	data, err := c.HealthJSON()
+	Expect(err).To(BeNil())
+	Expect(data).To(MatchJSON(`{"connected":false,"queue_depth":1,"dropped":1,"reconnects":0}`))
+
+	c.UseSink(&testSink{err: errors.New("send failed")})
+	defer c.Close()
+	c.Flush()
+
+	data, err = c.HealthJSON()
+	Expect(err).To(BeNil())
+	var status map[string]interface{}
+	Expect(json.Unmarshal(data, &status)).To(BeNil())
+	Expect(status).To(HaveKeyWithValue("connected", true))
+	Expect(status).To(HaveKeyWithValue("queue_depth", float64(0)))
+	Expect(status).To(HaveKeyWithValue("last_error", `failed to write message "queued": send failed`))
+	Expect(status).To(HaveKey("last_error_time"))
+}
+
+func (s *GolfSuite) TestStatsChunkCounts(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:   500,
+		Compression: COMP_NONE,
+	})
+	Expect(c.Stats().ChunkCounts).To(BeEmpty())
+	c.Use(conn, "udp")
+	defer c.Close()
+
+	c.Infof("short")
+	c.Infof("short")
+	c.Infof("%s", strings.Repeat("x", 1200))
+	c.Flush()
+
+	Expect(c.Stats().ChunkCounts).To(Equal(map[int]uint64{1: 2, 3: 1}))
+}
+
+func (s *GolfSuite) TestDropAuditFunc(t sweet.T) {
+	var recordsMutex sync.Mutex
+	records := make(map[string]string)
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:    1420,
+		MaxQueueSize: 2,
+		MinLevel:     LEVEL_INFO,
+		Transform: func(msg *Message) *Message {
+			if msg.ShortMessage == "transformed away" {
+				return nil
+			}
+			return msg
+		},
+		DropAuditFunc: func(record DropRecord) {
+			Expect(record.Time).ToNot(BeZero())
+			recordsMutex.Lock()
+			records[record.Msg.ShortMessage] = record.Reason
+			recordsMutex.Unlock()
+		},
+	})
+
+	c.Dbgf("too verbose")
+	c.Infof("transformed away")
+	c.Infof("sent")
+	c.Infof("queue full")
+
+	sink := &testSink{}
+	c.UseSink(sink)
+	defer c.Close()
+	c.Flush()
+
+	recordsMutex.Lock()
+	defer recordsMutex.Unlock()
+	Expect(records).To(Equal(map[string]string{
+		"too verbose":      DROP_MIN_LEVEL,
+		"transformed away": DROP_TRANSFORM,
+		"queue full":       DROP_QUEUE_FULL,
+	}))
+	Expect(c.Stats().Dropped).To(Equal(uint64(len(records))))
+	Expect(sink.messages()).To(Equal([]string{"sent"}))
+}
@@ -0,0 +1,151 @@
+package golf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The facility messages are sent to syslog with, user-level messages
+const syslogFacility = 1
+
+// The SD-ID of the structured data element holding a message's additional
+// fields. 32473 is the enterprise number reserved for examples, since the
+// fields aren't defined by any registered one.
+const syslogSDID = "gelf@32473"
+
+// Longest SD-NAME allowed for a structured data parameter
+const maxSyslogParamName = 32
+
+// Serialize msg as an RFC 5424 syslog message instead of GELF. The GELF level
+// is used as the severity since GELF levels are syslog severities, and the
+// additional fields, along with the full message, are sent as the parameters
+// of a single structured data element.
+func serializeSyslog(msg *Message, opts serializeOptions) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			data = nil
+			err = fmt.Errorf("panic while serializing message: %v", r)
+		}
+	}()
+
+	fields := msgFields(msg, opts)
+
+	hostname := msg.Hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	// The nil value lets the syslog server use the time it was received
+	timestamp := "-"
+	if msg.Timestamp != nil {
+		timestamp = msg.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s - - - ",
+		syslogFacility*8+msg.Level,
+		timestamp,
+		syslogHeaderValue(hostname))
+
+	params := make(map[string]interface{}, len(fields))
+	for key, val := range fields {
+		if strings.HasPrefix(key, "_") {
+			params[key[1:]] = val
+		}
+	}
+	if msg.FullMessage != "" {
+		params["full_message"] = msg.FullMessage
+	}
+
+	if len(params) == 0 {
+		buf.WriteString("-")
+	} else {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		buf.WriteString("[" + syslogSDID)
+		for _, name := range names {
+			fmt.Fprintf(buf, ` %s="%s"`, syslogParamName(name), syslogParamValue(fmt.Sprint(params[name])))
+		}
+		buf.WriteString("]")
+	}
+
+	if msg.ShortMessage != "" {
+		buf.WriteString(" " + msg.ShortMessage)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Replace anything that isn't printable ASCII in a syslog header field, which
+// can't contain spaces either
+func syslogHeaderValue(val string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, val)
+}
+
+// Make name a valid SD-NAME, which is printable ASCII other than '=', ' ', ']'
+// and '"', and at most 32 characters
+func syslogParamName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > maxSyslogParamName {
+		name = name[:maxSyslogParamName]
+	}
+	return name
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Escape the characters that aren't allowed in a structured data parameter's
+// value
+func syslogParamValue(val string) string {
+	return syslogParamEscaper.Replace(val)
+}
+
+// A datagramWriter buffers a message and writes it to w with a single write
+// when flushed, for transports that send each message in one datagram
+// without chunking it.
+type datagramWriter struct {
+	w    io.Writer
+	buff bytes.Buffer
+}
+
+func newDatagramWriter(w io.Writer) *datagramWriter {
+	return &datagramWriter{w: w}
+}
+
+func (dw *datagramWriter) Write(p []byte) (int, error) {
+	return dw.buff.Write(p)
+}
+
+// Write the buffered message to the underlying io.Writer. Nothing is written
+// if there's no data buffered. The buffer is always reset, even if the write
+// fails.
+func (dw *datagramWriter) Flush() error {
+	defer dw.reset()
+
+	if dw.buff.Len() == 0 {
+		return nil
+	}
+	_, err := dw.w.Write(dw.buff.Bytes())
+	return err
+}
+
+func (dw *datagramWriter) reset() {
+	dw.buff.Reset()
+}
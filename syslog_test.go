@@ -0,0 +1,86 @@
+package golf
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestSerializeSyslog(t sweet.T) {
+	ts := time.Date(2017, 7, 14, 2, 40, 0, 123456000, time.UTC)
+	msg := newMessage()
+	msg.Level = LEVEL_WARN
+	msg.Hostname = "my host"
+	msg.ShortMessage = "short message"
+	msg.FullMessage = "full message"
+	msg.Timestamp = &ts
+	msg.AddField("quoted", `say "hi" [now]\`)
+	msg.AddField("number", 42)
+
+	data, err := serializeSyslog(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(`<12>1 2017-07-14T02:40:00.123456Z my_host - - - ` +
+		`[gelf@32473 full_message="full message" number="42" quoted="say \"hi\" [now\]\\"] ` +
+		`short message`))
+}
+
+func (s *GolfSuite) TestSerializeSyslogNoFields(t sweet.T) {
+	ts := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	msg := newMessage()
+	msg.Level = LEVEL_EMERG
+	msg.ShortMessage = "short message"
+	msg.Timestamp = &ts
+
+	data, err := serializeSyslog(msg, serializeOptions{})
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal(`<8>1 2017-07-14T02:40:00.000000Z - - - - - short message`))
+}
+
+func (s *GolfSuite) TestSyslogParamName(t sweet.T) {
+	Expect(syslogParamName("a=b c]d\"e")).To(Equal("a_b_c_d_e"))
+	Expect(syslogParamName(strings.Repeat("x", 40))).To(HaveLen(32))
+}
+
+func (s *GolfSuite) TestDialSyslog(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClient()
+	err = c.Dial("syslog+udp://" + listener.LocalAddr().String())
+	Expect(err).To(BeNil())
+	defer c.Close()
+	Expect(c.config.Compression).To(Equal(COMP_NONE))
+	Expect(c.SetCompression(COMP_GZIP)).To(Equal(ErrCompressionNotSupported))
+
+	msg := newMessage()
+	msg.Level = LEVEL_INFO
+	msg.ShortMessage = strings.Repeat("a long message ", 200)
+	msg.AddField("attr", "value")
+	c.QueueMsg(msg)
+
+	// Sent in a single datagram even though it's larger than the chunk size
+	buf := make([]byte, 65536)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	Expect(string(buf[:n])).To(HavePrefix("<14>1 "))
+	Expect(string(buf[:n])).To(ContainSubstring(`[gelf@32473 attr="value"]`))
+	Expect(string(buf[:n])).To(HaveSuffix(msg.ShortMessage))
+}
+
+func (s *GolfSuite) TestDatagramWriter(t sweet.T) {
+	w := newTestWriter()
+	dw := newDatagramWriter(w)
+
+	Expect(dw.Flush()).To(BeNil())
+	Expect(w.Written).To(HaveLen(0))
+
+	dw.Write([]byte("first "))
+	dw.Write([]byte("message"))
+	Expect(dw.Flush()).To(BeNil())
+	Expect(w.Written).To(Equal([][]byte{[]byte("first message")}))
+}
@@ -0,0 +1,71 @@
+package golf
+
+import (
+	"sync"
+	"time"
+)
+
+// A byteLimiter is a token bucket limiting the number of bytes sent each
+// second. It's shared by all of a client's senders.
+type byteLimiter struct {
+	tokensMutex sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+}
+
+func newByteLimiter(rate int, burst int) *byteLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &byteLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Add the tokens earned since the bucket was last refilled, up to its burst
+func (bl *byteLimiter) refill(now time.Time) {
+	bl.tokens += now.Sub(bl.last).Seconds() * bl.rate
+	if bl.tokens > bl.burst {
+		bl.tokens = bl.burst
+	}
+	bl.last = now
+}
+
+// Take n bytes from the bucket, returning how long to wait before sending
+// them. The bucket can go into debt so a message larger than the burst is
+// still sent once the bucket has been full, it just makes the ones after it
+// wait longer.
+func (bl *byteLimiter) reserve(n int, now time.Time) time.Duration {
+	bl.tokensMutex.Lock()
+	defer bl.tokensMutex.Unlock()
+
+	bl.refill(now)
+	bl.tokens -= float64(n)
+	if bl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bl.tokens / bl.rate * float64(time.Second))
+}
+
+// Take n bytes from the bucket if it has them, without waiting. A message
+// larger than the burst can be sent when the bucket is full.
+func (bl *byteLimiter) allow(n int, now time.Time) bool {
+	bl.tokensMutex.Lock()
+	defer bl.tokensMutex.Unlock()
+
+	bl.refill(now)
+	need := float64(n)
+	if need > bl.burst {
+		need = bl.burst
+	}
+	if bl.tokens < need {
+		return false
+	}
+	bl.tokens -= float64(n)
+	return true
+}
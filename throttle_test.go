@@ -0,0 +1,41 @@
+package golf
+
+import (
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestByteLimiterReserve(t sweet.T) {
+	bl := newByteLimiter(1000, 0)
+	now := bl.last
+
+	// The bucket starts full
+	Expect(bl.reserve(600, now)).To(Equal(time.Duration(0)))
+	Expect(bl.reserve(400, now)).To(Equal(time.Duration(0)))
+	Expect(bl.reserve(500, now)).To(Equal(500 * time.Millisecond))
+
+	// It refills at the rate, paying off the debt first
+	Expect(bl.reserve(500, now.Add(time.Second))).To(Equal(time.Duration(0)))
+
+	// It never holds more than the burst
+	later := now.Add(time.Hour)
+	Expect(bl.reserve(1000, later)).To(Equal(time.Duration(0)))
+	Expect(bl.reserve(1, later)).To(Equal(time.Millisecond))
+}
+
+func (s *GolfSuite) TestByteLimiterAllow(t sweet.T) {
+	bl := newByteLimiter(1000, 2000)
+	now := bl.last
+
+	Expect(bl.allow(1500, now)).To(BeTrue())
+	Expect(bl.allow(1000, now)).To(BeFalse())
+	Expect(bl.allow(500, now)).To(BeTrue())
+	Expect(bl.allow(1, now)).To(BeFalse())
+
+	// Messages larger than the burst are allowed once the bucket is full
+	Expect(bl.allow(3000, now.Add(time.Second))).To(BeFalse())
+	Expect(bl.allow(3000, now.Add(2*time.Second))).To(BeTrue())
+	Expect(bl.allow(1, now.Add(2*time.Second))).To(BeFalse())
+}
@@ -0,0 +1,21 @@
+package golf
+
+import (
+	"net"
+	"time"
+)
+
+// Send an empty datagram on the connected udp socket conn and wait up to
+// timeout for it to be refused. Returns false if it was, which means
+// nothing is listening at the address. Anything else, including no reply,
+// counts as reachable since GELF servers never reply.
+func probeUDP(conn net.Conn, timeout time.Duration) bool {
+	_, err := conn.Write([]byte{})
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, err = conn.Read(make([]byte, 1))
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	return err == nil || !isConnRefused(err)
+}
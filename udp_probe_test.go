@@ -0,0 +1,71 @@
+package golf
+
+import (
+	"net"
+	"time"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+// Get the address of a udp port that nothing is listening on
+func closedUDPAddr() string {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	addr := listener.LocalAddr().String()
+	listener.Close()
+	return addr
+}
+
+func (s *GolfSuite) TestDialUDPProbeListening(t sweet.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		UDPProbeTimeout: 50 * time.Millisecond,
+	})
+	defer c.Close()
+
+	Expect(c.Dial("udp://" + listener.LocalAddr().String())).To(BeNil())
+	Consistently(c.Errors()).ShouldNot(Receive())
+
+	// Messages are still sent after the probe
+	c.Infof("after the probe")
+	buf := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	Expect(err).To(BeNil())
+	if n == 0 {
+		// The probe itself
+		n, _, err = listener.ReadFrom(buf)
+		Expect(err).To(BeNil())
+	}
+	Expect(n).To(BeNumerically(">", 0))
+}
+
+func (s *GolfSuite) TestDialUDPProbeUnreachable(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		UDPProbeTimeout: time.Second,
+	})
+	defer c.Close()
+
+	// It's only a warning, the client is still connected
+	Expect(c.Dial("udp://" + closedUDPAddr())).To(BeNil())
+	Expect(c.Errors()).To(Receive(Equal(ErrUDPUnreachable)))
+	Expect(c.Connected()).To(BeTrue())
+}
+
+func (s *GolfSuite) TestDialUDPProbeStrict(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{
+		ChunkSize:       1420,
+		UDPProbeTimeout: time.Second,
+		StrictMode:      true,
+	})
+	defer c.Close()
+
+	Expect(c.Dial("udp://" + closedUDPAddr())).To(Equal(ErrUDPUnreachable))
+	Expect(c.Connected()).To(BeFalse())
+}
@@ -0,0 +1,323 @@
+package golf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Longest time after an entry is written to the WAL before it's synced to
+// disk with FsyncWAL
+const walSyncDelay = 100 * time.Millisecond
+
+// The WAL is compacted once it's larger than this and more than half of it
+// is entries that have been removed
+const walCompactBytes = 1024 * 1024
+
+// A message in the WAL that hasn't been sent
+type walEntry struct {
+	id   uint64
+	data []byte
+}
+
+type walEntriesByID []walEntry
+
+func (e walEntriesByID) Len() int           { return len(e) }
+func (e walEntriesByID) Less(i, j int) bool { return e[i].id < e[j].id }
+func (e walEntriesByID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// A writeAheadLog is a file holding the GELF JSON of the messages a client
+// has queued but not sent yet, so they can be queued again by the next
+// client using it if the process stops before they're sent. Each message
+// is added with a "+<id> <json>" line and removed with a "-<id>" line. The
+// file is truncated whenever every message in it has been removed, and
+// rewritten with only the remaining messages once it's mostly removed ones.
+type writeAheadLog struct {
+	path     string
+	maxBytes int64
+
+	// Sync what's written to disk, at most walSyncDelay after it's
+	// written, reporting any errors to reportErr
+	fsync     bool
+	reportErr func(error)
+
+	entriesMutex sync.Mutex
+	file         *os.File
+	nextID       uint64
+	// The messages that haven't been removed, by ID, and the size of
+	// their JSON
+	entries      map[uint64][]byte
+	entriesBytes int64
+	// The size of the file
+	size int64
+	// The messages left in the file by a previous client, until they're
+	// taken by RecoverWAL
+	recovered []walEntry
+	// Set while there's a sync waiting to happen
+	syncPending bool
+}
+
+func openWAL(path string, maxBytes int64) (*writeAheadLog, error) {
+	wal := &writeAheadLog{
+		path:     path,
+		maxBytes: maxBytes,
+		nextID:   1,
+		entries:  make(map[uint64][]byte),
+	}
+
+	// Pick up the messages left behind by a previous client
+	file, err := os.Open(path)
+	if err == nil {
+		err = wal.read(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for id, data := range wal.entries {
+		wal.recovered = append(wal.recovered, walEntry{id: id, data: data})
+		wal.entriesBytes += int64(len(data))
+		if id >= wal.nextID {
+			wal.nextID = id + 1
+		}
+	}
+	sort.Sort(walEntriesByID(wal.recovered))
+
+	err = wal.compact()
+	if err != nil {
+		return nil, err
+	}
+	return wal, nil
+}
+
+// Read the entries in the WAL's file, ignoring a partly written last line
+// from a crash
+func (wal *writeAheadLog) read(file io.Reader) error {
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			continue
+		}
+
+		idText := line[1:]
+		var data []byte
+		if line[0] == '+' {
+			space := bytes.IndexByte(line, ' ')
+			if space < 0 {
+				return fmt.Errorf("invalid WAL entry %q", line)
+			}
+			idText, data = line[1:space], line[space+1:]
+		}
+		id, parseErr := strconv.ParseUint(string(idText), 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid WAL entry %q", line)
+		}
+		switch line[0] {
+		case '+':
+			wal.entries[id] = data
+		case '-':
+			delete(wal.entries, id)
+		default:
+			return fmt.Errorf("invalid WAL entry %q", line)
+		}
+	}
+}
+
+// Take the messages left behind by a previous client, in the order they
+// were added. They stay in the WAL until they're removed.
+func (wal *writeAheadLog) takeRecovered() []walEntry {
+	wal.entriesMutex.Lock()
+	defer wal.entriesMutex.Unlock()
+
+	recovered := wal.recovered
+	wal.recovered = nil
+	return recovered
+}
+
+// Add the GELF JSON for a message to the WAL, returning its ID. Returns
+// ErrWALFull if it would take the messages in the WAL over maxBytes.
+func (wal *writeAheadLog) add(data []byte) (uint64, error) {
+	wal.entriesMutex.Lock()
+	defer wal.entriesMutex.Unlock()
+
+	if wal.maxBytes > 0 && wal.entriesBytes+int64(len(data)) > wal.maxBytes {
+		return 0, ErrWALFull
+	}
+
+	id := wal.nextID
+	line := make([]byte, 0, len(data)+22)
+	line = append(line, '+')
+	line = strconv.AppendUint(line, id, 10)
+	line = append(line, ' ')
+	line = append(line, data...)
+	line = append(line, '\n')
+	err := wal.write(line)
+	if err != nil {
+		return 0, err
+	}
+
+	wal.nextID++
+	wal.entries[id] = data
+	wal.entriesBytes += int64(len(data))
+	return id, nil
+}
+
+// Remove the message with the ID from the WAL once it's been sent
+func (wal *writeAheadLog) remove(id uint64) {
+	wal.entriesMutex.Lock()
+	defer wal.entriesMutex.Unlock()
+
+	data, ok := wal.entries[id]
+	if !ok {
+		return
+	}
+	delete(wal.entries, id)
+	wal.entriesBytes -= int64(len(data))
+
+	var err error
+	if len(wal.entries) == 0 {
+		// Everything that was added has been sent, so nothing in the
+		// file needs keeping
+		err = wal.open()
+		if err == nil {
+			err = wal.file.Truncate(0)
+		}
+		if err == nil {
+			wal.size = 0
+		}
+	} else if wal.size > walCompactBytes && wal.size > 2*wal.entriesBytes {
+		err = wal.compact()
+	} else {
+		line := make([]byte, 0, 22)
+		line = append(line, '-')
+		line = strconv.AppendUint(line, id, 10)
+		line = append(line, '\n')
+		err = wal.write(line)
+	}
+	if err != nil && wal.reportErr != nil {
+		wal.reportErr(err)
+	}
+}
+
+func (wal *writeAheadLog) write(line []byte) error {
+	err := wal.open()
+	if err != nil {
+		return err
+	}
+	_, err = wal.file.Write(line)
+	if err != nil {
+		return err
+	}
+	wal.size += int64(len(line))
+
+	// Entries written close together are synced together
+	if wal.fsync && !wal.syncPending {
+		wal.syncPending = true
+		time.AfterFunc(walSyncDelay, wal.sync)
+	}
+	return nil
+}
+
+// Rewrite the WAL's file with only the messages that haven't been removed,
+// replacing it once the new one's been written
+func (wal *writeAheadLog) compact() error {
+	entries := make([]walEntry, 0, len(wal.entries))
+	for id, data := range wal.entries {
+		entries = append(entries, walEntry{id: id, data: data})
+	}
+	sort.Sort(walEntriesByID(entries))
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "+%d %s\n", entry.id, entry.data)
+	}
+
+	tmpPath := wal.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, wal.path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// The file's reopened for appending so later writes go on the end
+	file.Close()
+	if wal.file != nil {
+		wal.file.Close()
+		wal.file = nil
+	}
+	wal.size = int64(buf.Len())
+	return wal.open()
+}
+
+// Open the WAL's file for appending if it isn't open, such as after the
+// client using it was closed and it's being used again
+func (wal *writeAheadLog) open() error {
+	if wal.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(wal.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	wal.file = file
+	return nil
+}
+
+// Sync the WAL's file to disk
+func (wal *writeAheadLog) sync() {
+	wal.entriesMutex.Lock()
+	defer wal.entriesMutex.Unlock()
+
+	wal.syncPending = false
+	wal.syncFile()
+}
+
+func (wal *writeAheadLog) syncFile() {
+	if !wal.fsync || wal.file == nil {
+		return
+	}
+	err := wal.file.Sync()
+	if err != nil && wal.reportErr != nil {
+		wal.reportErr(err)
+	}
+}
+
+// Close the WAL's file, leaving the messages that haven't been sent in it
+func (wal *writeAheadLog) close() error {
+	wal.entriesMutex.Lock()
+	defer wal.entriesMutex.Unlock()
+
+	if wal.file == nil {
+		return nil
+	}
+	wal.syncFile()
+	err := wal.file.Close()
+	wal.file = nil
+	return err
+}
@@ -0,0 +1,186 @@
+package golf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aphistic/sweet"
+	. "github.com/onsi/gomega"
+)
+
+func (s *GolfSuite) TestWAL(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golf.wal")
+
+	wal, err := openWAL(path, 0)
+	Expect(err).To(BeNil())
+	Expect(wal.takeRecovered()).To(BeEmpty())
+	for _, data := range []string{"one", "two", "three"} {
+		_, err := wal.add([]byte(data))
+		Expect(err).To(BeNil())
+	}
+	wal.remove(2)
+	Expect(wal.close()).To(BeNil())
+
+	data, err := ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("+1 one\n+2 two\n+3 three\n-2\n"))
+
+	// What wasn't removed is picked up by the next WAL using the file, and
+	// it's compacted
+	wal, err = openWAL(path, 0)
+	Expect(err).To(BeNil())
+	Expect(wal.takeRecovered()).To(Equal([]walEntry{
+		{id: 1, data: []byte("one")},
+		{id: 3, data: []byte("three")},
+	}))
+	Expect(wal.takeRecovered()).To(BeEmpty())
+	data, err = ioutil.ReadFile(path)
+	Expect(err).To(BeNil())
+	Expect(string(data)).To(Equal("+1 one\n+3 three\n"))
+
+	id, err := wal.add([]byte("four"))
+	Expect(err).To(BeNil())
+	Expect(id).To(Equal(uint64(4)))
+
+	// The file is emptied once everything's been removed
+	wal.remove(1)
+	wal.remove(3)
+	wal.remove(4)
+	Expect(wal.close()).To(BeNil())
+	info, err := os.Stat(path)
+	Expect(err).To(BeNil())
+	Expect(info.Size()).To(BeZero())
+}
+
+func (s *GolfSuite) TestWALPartialEntry(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golf.wal")
+
+	err = ioutil.WriteFile(path, []byte("+1 one\n+2 tw"), 0644)
+	Expect(err).To(BeNil())
+	wal, err := openWAL(path, 0)
+	Expect(err).To(BeNil())
+	defer wal.close()
+	Expect(wal.takeRecovered()).To(Equal([]walEntry{{id: 1, data: []byte("one")}}))
+
+	err = ioutil.WriteFile(path, []byte("not an entry\n"), 0644)
+	Expect(err).To(BeNil())
+	_, err = openWAL(path, 0)
+	Expect(err).ToNot(BeNil())
+}
+
+func (s *GolfSuite) TestWALMaxBytes(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+
+	wal, err := openWAL(filepath.Join(dir, "golf.wal"), 10)
+	Expect(err).To(BeNil())
+	defer wal.close()
+
+	_, err = wal.add([]byte("12345"))
+	Expect(err).To(BeNil())
+	_, err = wal.add([]byte("123456"))
+	Expect(err).To(Equal(ErrWALFull))
+
+	// Removing messages makes room again
+	wal.remove(1)
+	_, err = wal.add([]byte("123456"))
+	Expect(err).To(BeNil())
+}
+
+func (s *GolfSuite) TestRecoverWAL(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golf.wal")
+
+	// The messages that fail to send stay in the WAL
+	c, err := NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		WALPath:   path,
+	})
+	Expect(err).To(BeNil())
+	failing := &testSink{err: errors.New("server unreachable")}
+	c.UseSink(failing)
+	c.Infof("first")
+	c.Infof("second")
+	c.Flush()
+	c.Close()
+	Expect(failing.messages()).To(HaveLen(2))
+
+	sink := &testSink{}
+	c, err = NewClientWithConfig(ClientConfig{
+		ChunkSize: 1420,
+		WALPath:   path,
+	})
+	Expect(err).To(BeNil())
+	c.UseSink(sink)
+	defer c.Close()
+
+	Expect(c.RecoverWAL()).To(BeNil())
+	c.Flush()
+	Expect(sink.messages()).To(Equal([]string{"first", "second"}))
+
+	// They're removed once they've been sent
+	info, err := os.Stat(path)
+	Expect(err).To(BeNil())
+	Expect(info.Size()).To(BeZero())
+	Expect(c.RecoverWAL()).To(BeNil())
+	c.Flush()
+	Expect(sink.messages()).To(HaveLen(2))
+}
+
+func (s *GolfSuite) TestRecoverWALAsStored(t sweet.T) {
+	dir, err := ioutil.TempDir("", "golf")
+	Expect(err).To(BeNil())
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golf.wal")
+
+	config := ClientConfig{
+		ChunkSize:   1420,
+		WALPath:     path,
+		FieldPrefix: "app_",
+		AllowFields: []string{"user", "count"},
+	}
+	c, err := NewClientWithConfig(config)
+	Expect(err).To(BeNil())
+	failing := &testSink{err: errors.New("server unreachable")}
+	c.UseSink(failing)
+	msg := &Message{Level: LEVEL_INFO, ShortMessage: "stored"}
+	msg.AddField("user", "bob")
+	msg.AddField("count", uint64(1<<60+1))
+	c.QueueMsg(msg)
+	c.Flush()
+	c.Close()
+
+	// The filters and prefix aren't applied a second time, and the
+	// integer isn't turned into a float
+	sink := &testSink{}
+	c, err = NewClientWithConfig(config)
+	Expect(err).To(BeNil())
+	c.UseSink(sink)
+	defer c.Close()
+	Expect(c.RecoverWAL()).To(BeNil())
+	c.Flush()
+	Expect(sink.batches).To(HaveLen(1))
+	data := string(sink.batches[0][0])
+	Expect(data).To(ContainSubstring(`"_app_user":"bob"`))
+	Expect(data).To(ContainSubstring(`"_app_count":1152921504606846977`))
+	Expect(data).To(Equal(string(failing.batches[0][0])))
+}
+
+func (s *GolfSuite) TestRecoverWALUnset(t sweet.T) {
+	c, _ := NewClientWithConfig(ClientConfig{ChunkSize: 1420})
+	c.UseSink(&testSink{})
+	defer c.Close()
+
+	Expect(c.RecoverWAL()).To(BeNil())
+}